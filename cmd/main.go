@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -59,8 +60,11 @@ func init() {
 
 func main() {
 	var debugLogging bool
+	var payloadDir string
 
 	flag.BoolVar(&debugLogging, "debugLogging", false, "Log debug messages")
+	flag.StringVar(&payloadDir, "payloadDir", "",
+		"Directory of the payload files, overriding the payload mounted in the operator image")
 
 	// Add flags registered by imported packages (e.g. glog and
 	// controller-runtime)
@@ -111,31 +115,66 @@ func main() {
 		os.Exit(1)
 	}
 
+	if payloadDir != "" {
+		if err := payload.SetBaseDir(payloadDir); err != nil {
+			setupLog.Error(err, "invalid payload directory")
+			os.Exit(1)
+		}
+		setupLog.Info("using payload directory", "directory", payload.BaseDir())
+	}
+
 	// Checking if required files exist before starting the operator
-	requiredFiles := []string{
-		payload.HostLocalCNIPlugin,
-		payload.WinBridgeCNIPlugin,
-		payload.WinOverlayCNIPlugin,
-		payload.HybridOverlayPath,
-		payload.KubeletPath,
-		payload.KubeProxyPath,
-		payload.KubeLogRunnerPath,
-		payload.GcpGetValidHostnameScriptPath,
-		payload.WICDPath,
-		payload.HNSPSModule,
-		payload.WindowsExporterPath,
-		payload.AzureCloudNodeManagerPath,
-	}
-	if err := checkIfRequiredFilesExist(requiredFiles); err != nil {
+	if err := validatePayload(); err != nil {
 		setupLog.Error(err, "could not start the operator")
 		os.Exit(1)
 	}
 
-	if err := payload.PopulateNetworkConfScript(clusterConfig.Network().GetServiceCIDR(), windows.OVNKubeOverlayNetwork,
-		windows.HNSPSModule, windows.CniConfDir+"\\cni.conf"); err != nil {
+	// the generated scripts are written where the payload transfer expects them, within the writable generated
+	// directory of the payload
+	scriptOpts := []payload.Option{payload.WithOperatorVersion(version.Get())}
+	script, changed, err := payload.WriteCNIConfScript(payload.CNIConfigurationScript,
+		clusterConfig.Network().GetServiceCIDR(), windows.OVNKubeOverlayNetwork, windows.HNSPSModule,
+		windows.CniConfDir+"\\cni.conf",
+		payload.CNIConfSettings{LogDir: windows.NetworkScriptLogDir, Debug: debugLogging}, scriptOpts...)
+	if err != nil {
 		setupLog.Error(err, "unable to generate CNI config script")
 		os.Exit(1)
 	}
+	setupLog.V(1).Info("generated CNI config script", "path", script.Path, "checksum", script.Checksum(),
+		"changed", changed)
+	script, changed, err = payload.WriteKubeProxyPrepScript(payload.KubeProxyPrepScript,
+		windows.OVNKubeOverlayNetwork, windows.HNSPSModule,
+		payload.KubeProxyPrepSettings{LogDir: windows.NetworkScriptLogDir}, scriptOpts...)
+	if err != nil {
+		setupLog.Error(err, "unable to generate kube-proxy preparation script")
+		os.Exit(1)
+	}
+	setupLog.V(1).Info("generated kube-proxy preparation script", "path", script.Path, "checksum",
+		script.Checksum(), "changed", changed)
+	script, changed, err = payload.WritePreflightScript(payload.PreflightScript, windows.OVNKubeOverlayNetwork,
+		windows.HNSPSModule, payload.PreflightSettings{LogDir: windows.NetworkScriptLogDir}, scriptOpts...)
+	if err != nil {
+		setupLog.Error(err, "unable to generate network preflight script")
+		os.Exit(1)
+	}
+	setupLog.V(1).Info("generated network preflight script", "path", script.Path, "checksum", script.Checksum(),
+		"changed", changed)
+	// the HNS network is removed separately during deconfiguration, as removing it drops the SSH connection
+	script, changed, err = payload.PopulateHNSCleanupScript(windows.OVNKubeOverlayNetwork, windows.HNSPSModule,
+		payload.HNSCleanupSettings{}, scriptOpts...)
+	if err != nil {
+		setupLog.Error(err, "unable to generate HNS cleanup script")
+		os.Exit(1)
+	}
+	setupLog.V(1).Info("generated HNS cleanup script", "path", script.Path, "checksum", script.Checksum(),
+		"changed", changed)
+	script, changed, err = payload.PopulateGCPHostnameScript(payload.GCPHostnameSettings{}, scriptOpts...)
+	if err != nil {
+		setupLog.Error(err, "unable to generate GCP hostname script")
+		os.Exit(1)
+	}
+	setupLog.V(1).Info("generated GCP hostname script", "path", script.Path, "checksum", script.Checksum(),
+		"changed", changed)
 
 	ctx := context.TODO()
 	// Become the leader before proceeding
@@ -161,6 +200,18 @@ func main() {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
+	// The payload files built for each architecture are only required for the architectures of the Windows Machines.
+	// The API reader is used, as the cache of the manager is not started yet. Clusters without the Machine API are
+	// handled by WindowsMachineArchitectures, which returns the default architecture for them.
+	archs, err := controllers.WindowsMachineArchitectures(ctx, mgr.GetAPIReader())
+	if err != nil {
+		setupLog.Error(err, "unable to determine the architectures of the Windows Machines")
+		os.Exit(1)
+	}
+	if err := payload.ValidateArchs(archs); err != nil {
+		setupLog.Error(err, "could not start the operator")
+		os.Exit(1)
+	}
 
 	// Get the watched namespace. This is originally sourced from from the OperatorGroup associated with the CSV.
 	// Because the WMCO CSV only supports the OwnNamespace InstallMode, the watch namespace will always be the namespace
@@ -278,20 +329,18 @@ func main() {
 	}
 }
 
-// checkIfRequiredFilesExist checks for the existence of required files and binaries before starting WMCO
-// sample error message: errors encountered with required files: could not stat /payload/hybrid-overlay-node.exe:
-// stat /payload/hybrid-overlay-node.exe: no such file or directory
-func checkIfRequiredFilesExist(requiredFiles []string) error {
-	var errorMessages []string
-	// Iterating through file paths and checking if they are present
-	for _, file := range requiredFiles {
-		if _, err := os.Stat(file); err != nil {
-			errorMessages = append(errorMessages, fmt.Sprintf("could not stat %s: %v", file, err))
-		}
+// validatePayload checks that the payload files required by the operator exist and match the payload manifest. As
+// developer builds may not include a manifest, a missing manifest is only logged unless it is required.
+func validatePayload(opts ...payload.Option) error {
+	if err := payload.Validate(opts...); err != nil {
+		return err
 	}
-
-	if len(errorMessages) > 0 {
-		return fmt.Errorf("errors encountered with required files: %s", strings.Join(errorMessages, ", "))
+	if err := payload.VerifyManifest(opts...); err != nil {
+		if !errors.Is(err, payload.ErrManifestNotFound) {
+			return fmt.Errorf("payload files do not match the manifest: %w", err)
+		}
+		setupLog.Info("payload manifest not found, payload files cannot be verified",
+			"manifest", payload.ManifestPath)
 	}
 	return nil
 }
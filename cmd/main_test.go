@@ -1,25 +1,84 @@
 package main
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 )
 
-// TestCheckIfRequiredFilesExist tests if checkIfRequiredFilesExist function is throwing appropriate error when some
-// of the files required by WMCO are missing
-func TestCheckIfRequiredFilesExist(t *testing.T) {
-	// required files of WMCO that are missing
-	var missingRequiredFiles = []string{
-		"/payload/file-1",
-		"/payload/file-2",
+// TestValidatePayload tests if validatePayload returns an appropriate error when files required by WMCO are missing
+// or do not match the payload manifest
+func TestValidatePayload(t *testing.T) {
+	// payloadFS returns a filesystem rooted at "/" holding every file shipped in the payload, except the given one
+	payloadFS := func(missing string) fstest.MapFS {
+		fsys := fstest.MapFS{}
+		for category, files := range payload.FilesByCategory() {
+			if category == payload.CategoryGenerated {
+				continue
+			}
+			for _, file := range files {
+				if file != missing {
+					fsys[strings.TrimPrefix(file, "/")] = &fstest.MapFile{Data: []byte(file)}
+				}
+			}
+		}
+		return fsys
+	}
+	// withManifest adds a manifest matching the current contents of the given filesystem, then sets the contents of
+	// the given file to the given value
+	withManifest := func(fsys fstest.MapFS, changed, contents string) fstest.MapFS {
+		manifest, err := payload.GenerateManifest(payload.WithFS(fsys))
+		require.NoError(t, err)
+		data, err := json.Marshal(manifest)
+		require.NoError(t, err)
+		fsys[strings.TrimPrefix(payload.ManifestPath, "/")] = &fstest.MapFile{Data: data}
+		if changed != "" {
+			fsys[strings.TrimPrefix(changed, "/")] = &fstest.MapFile{Data: []byte(contents)}
+		}
+		return fsys
 	}
-	err := checkIfRequiredFilesExist(missingRequiredFiles)
-	require.Error(t, err, "Function checkIfRequiredFilesExist did not throw an error when it was expected to")
-	assert.Contains(t, err.Error(), "could not stat /payload/file-1: stat /payload/file-1: no such file or directory",
-		"Expected error message is absent")
-	assert.Contains(t, err.Error(), "could not stat /payload/file-2: stat /payload/file-2: no such file or directory",
-		"Expected error message is absent")
 
+	testCases := []struct {
+		name           string
+		fsys           fstest.MapFS
+		expectedErrors []string
+	}{
+		{
+			name: "all files present without manifest",
+			fsys: payloadFS(""),
+		},
+		{
+			name: "all files present and matching the manifest",
+			fsys: withManifest(payloadFS(""), "", ""),
+		},
+		{
+			name:           "missing file",
+			fsys:           payloadFS(payload.KubeletPath),
+			expectedErrors: []string{"could not stat " + payload.KubeletPath},
+		},
+		{
+			name:           "file not matching the manifest",
+			fsys:           withManifest(payloadFS(""), payload.KubeletPath, "modified"),
+			expectedErrors: []string{"payload files do not match the manifest", payload.KubeletPath},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := validatePayload(payload.WithFS(test.fsys))
+			if len(test.expectedErrors) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			for _, expected := range test.expectedErrors {
+				assert.Contains(t, err.Error(), expected)
+			}
+		})
+	}
 }
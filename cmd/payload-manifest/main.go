@@ -0,0 +1,54 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// payload-manifest writes the manifest of the payload files present in the operator image. It is run at image build
+// time, once all payload files have been copied into place, so that the operator can detect a corrupted payload.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+)
+
+func main() {
+	output := flag.String("output", payload.ManifestPath, "Path the manifest is written to")
+	flag.Parse()
+
+	if err := writeManifest(*output); err != nil {
+		fmt.Fprintf(os.Stderr, "error generating payload manifest: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// writeManifest generates the payload manifest and writes it to the given path
+func writeManifest(path string) error {
+	manifest, err := payload.GenerateManifest()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode manifest: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("could not write manifest to %s: %w", path, err)
+	}
+	return nil
+}
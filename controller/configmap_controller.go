@@ -49,6 +49,7 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 	"github.com/openshift/windows-machine-config-operator/pkg/patch"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 	"github.com/openshift/windows-machine-config-operator/pkg/services"
@@ -104,14 +105,19 @@ func NewConfigMapReconciler(mgr manager.Manager, clusterConfig cluster.Config, w
 	if err != nil {
 		return nil, err
 	}
-	ign, err := ignition.New(directClient)
+	ign, err := ignition.New(context.TODO(), directClient)
 	if err != nil {
 		return nil, fmt.Errorf("error creating ignition object: %w", err)
 	}
-	argsFromIgnition, err := ign.GetKubeletArgs()
+	kubeletArgs, unresolved, err := ign.GetKubeletArgs()
 	if err != nil {
 		return nil, err
 	}
+	if len(unresolved) > 0 {
+		ctrl.Log.WithName("controllers").WithName(ConfigMapController).Info(
+			"kubelet args reference variables which could not be resolved", "variables", unresolved)
+	}
+	argsFromIgnition := ignition.FilterArgs(kubeletArgs, ignition.KubeletArgsOfInterest...)
 	oc, err := openshiftClient.NewForConfig(mgr.GetConfig())
 	if err != nil {
 		return nil, fmt.Errorf("unable to create openshift client-go client: %w", err)
@@ -121,7 +127,8 @@ func NewConfigMapReconciler(mgr manager.Manager, clusterConfig cluster.Config, w
 		return nil, fmt.Errorf("error determining if CCM owns cloud controller: %w", err)
 	}
 	svcData, err := services.GenerateManifest(argsFromIgnition, clusterConfig.Network().VXLANPort(),
-		clusterConfig.Platform(), ccmEnabled, ctrl.Log.V(1).Enabled())
+		services.DefaultKubeProxyOptions(), payload.ExporterConfig{}, clusterConfig.Platform(), ccmEnabled,
+		ctrl.Log.V(1).Enabled())
 	if err != nil {
 		return nil, fmt.Errorf("error generating expected Windows service state: %w", err)
 	}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 
 	oconfig "github.com/openshift/api/config/v1"
@@ -12,6 +13,7 @@ import (
 	mclient "github.com/openshift/client-go/machine/clientset/versioned/typed/machine/v1beta1"
 	core "k8s.io/api/core/v1"
 	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	kubeTypes "k8s.io/apimachinery/pkg/types"
@@ -32,6 +34,7 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
 	"github.com/openshift/windows-machine-config-operator/pkg/metrics"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
 	"github.com/openshift/windows-machine-config-operator/pkg/signer"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
@@ -538,3 +541,42 @@ func getInternalIPAddress(addresses []core.NodeAddress) (string, error) {
 	}
 	return "", fmt.Errorf("no internal IP address associated")
 }
+
+// WindowsMachineArchitectures returns the architectures of the Windows Machines, sorted. The architecture of a Machine
+// is the one of its Node, or the one of the labels its Node is created with if it has no Node yet. Machines with
+// neither are assumed to be amd64, the only architecture Windows Machines were supported on before. On clusters without
+// the Machine API, which can only have BYOH instances, the default architecture is returned.
+func WindowsMachineArchitectures(ctx context.Context, c client.Reader) ([]string, error) {
+	machines := &mapi.MachineList{}
+	if err := c.List(ctx, machines, client.InNamespace(cluster.MachineAPINamespace),
+		client.MatchingLabels{MachineOSLabel: "Windows"}); err != nil {
+		if apimeta.IsNoMatchError(err) || k8sapierrors.IsNotFound(err) {
+			return []string{payload.ArchAMD64}, nil
+		}
+		return nil, fmt.Errorf("cannot list Machines: %w", err)
+	}
+	archs := make(map[string]bool)
+	for _, machine := range machines.Items {
+		arch := machine.Spec.ObjectMeta.Labels[core.LabelArchStable]
+		if machine.Status.NodeRef != nil {
+			node := &core.Node{}
+			err := c.Get(ctx, kubeTypes.NamespacedName{Name: machine.Status.NodeRef.Name}, node)
+			if err != nil && !k8sapierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("cannot get Node of Machine %s: %w", machine.Name, err)
+			}
+			if nodeArch, ok := node.Labels[core.LabelArchStable]; ok {
+				arch = nodeArch
+			}
+		}
+		if arch == "" {
+			arch = payload.ArchAMD64
+		}
+		archs[arch] = true
+	}
+	var sorted []string
+	for arch := range archs {
+		sorted = append(sorted, arch)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
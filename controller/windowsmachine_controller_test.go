@@ -1,14 +1,26 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
 	mapi "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 )
 
 func strToPtr(str string) *string {
@@ -63,3 +75,110 @@ func TestIsValidMachine(t *testing.T) {
 	}
 
 }
+
+func TestWindowsMachineArchitectures(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, mapi.AddToScheme(scheme))
+	machine := func(name, os string, nodeLabels map[string]string, nodeName string) *mapi.Machine {
+		m := &mapi.Machine{ObjectMeta: meta.ObjectMeta{Name: name, Namespace: cluster.MachineAPINamespace,
+			Labels: map[string]string{MachineOSLabel: os}}}
+		m.Spec.ObjectMeta.Labels = nodeLabels
+		if nodeName != "" {
+			m.Status.NodeRef = &core.ObjectReference{Kind: "Node", Name: nodeName}
+		}
+		return m
+	}
+	node := func(name, arch string) *core.Node {
+		return &core.Node{ObjectMeta: meta.ObjectMeta{Name: name, Labels: map[string]string{core.LabelArchStable: arch}}}
+	}
+
+	testCases := []struct {
+		name     string
+		objects  []client.Object
+		expected []string
+	}{
+		{
+			name: "no Windows Machines",
+			objects: []client.Object{machine("linux", "Linux", map[string]string{core.LabelArchStable: "arm64"},
+				"")},
+		},
+		{
+			name:     "Machine without Node or architecture label",
+			objects:  []client.Object{machine("windows", "Windows", nil, "")},
+			expected: []string{"amd64"},
+		},
+		{
+			name: "architectures of Nodes and Node labels",
+			objects: []client.Object{
+				machine("provisioning", "Windows", map[string]string{core.LabelArchStable: "arm64"}, ""),
+				machine("running", "Windows", nil, "running-node"),
+				node("running-node", "amd64"),
+				machine("deleted-node", "Windows", map[string]string{core.LabelArchStable: "arm64"}, "missing"),
+			},
+			expected: []string{"amd64", "arm64"},
+		},
+		{
+			name: "Node architecture preferred over the Node labels of the Machine",
+			objects: []client.Object{
+				machine("running", "Windows", map[string]string{core.LabelArchStable: "arm64"}, "running-node"),
+				node("running-node", "amd64"),
+			},
+			expected: []string{"amd64"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			c := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(test.objects...).Build()
+			archs, err := WindowsMachineArchitectures(context.Background(), c)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, archs)
+		})
+	}
+}
+
+func TestWindowsMachineArchitecturesWithoutMachineAPI(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, mapi.AddToScheme(scheme))
+	machineGK := schema.GroupKind{Group: mapi.GroupVersion.Group, Kind: "Machine"}
+
+	testCases := []struct {
+		name          string
+		listErr       error
+		expected      []string
+		expectedError bool
+	}{
+		{
+			name:     "Machine kind not served",
+			listErr:  &apimeta.NoKindMatchError{GroupKind: machineGK, SearchedVersions: []string{"v1beta1"}},
+			expected: []string{"amd64"},
+		},
+		{
+			name:     "Machine resource not found",
+			listErr:  k8sapierrors.NewNotFound(schema.GroupResource{Group: machineGK.Group, Resource: "machines"}, ""),
+			expected: []string{"amd64"},
+		},
+		{
+			name:          "other errors",
+			listErr:       k8sapierrors.NewForbidden(schema.GroupResource{Resource: "machines"}, "", nil),
+			expectedError: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			c := clientfake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+				List: func(context.Context, client.WithWatch, client.ObjectList, ...client.ListOption) error {
+					return test.listErr
+				},
+			}).Build()
+			archs, err := WindowsMachineArchitectures(context.Background(), c)
+			if test.expectedError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, archs)
+		})
+	}
+}
@@ -57,6 +57,7 @@ import (
 	"github.com/openshift/windows-machine-config-operator/pkg/daemon/powershell"
 	"github.com/openshift/windows-machine-config-operator/pkg/daemon/winsvc"
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
@@ -283,6 +284,11 @@ func (sc *ServiceController) Reconcile(_ context.Context, req ctrl.Request) (res
 	}
 	// Reconcile state of Windows services with the ConfigMap data
 	if err = sc.reconcileServices(cmData.Services); err != nil {
+		var scriptErr *payload.NetworkScriptError
+		if errors.As(err, &scriptErr) {
+			// network scripts fail when a precondition of the node's network is not met, which must be reported
+			sc.recorder.Event(&node, core.EventTypeWarning, scriptErr.Reason(), scriptErr.Error())
+		}
 		return ctrl.Result{}, err
 	}
 
@@ -490,17 +496,38 @@ func (sc *ServiceController) resolveNodeVariables(svc servicescm.Service) (map[s
 func (sc *ServiceController) resolvePowershellVariables(svc servicescm.Service) (map[string]string, error) {
 	vars := make(map[string]string)
 	for _, script := range svc.PowershellPreScripts {
-		out, err := sc.psCmdRunner.Run(script.Path)
+		value, err := sc.runPowershellPreScript(script.Path)
 		if err != nil {
 			return nil, fmt.Errorf("could not resolve PowerShell variable %s: %w", script.VariableName, err)
 		}
 		if script.VariableName != "" {
-			vars[script.VariableName] = strings.TrimSpace(out)
+			vars[script.VariableName] = value
 		}
 	}
 	return vars, nil
 }
 
+// runPowershellPreScript runs the given script, returning the value it resolves to. Generated network scripts report a
+// structured result, which is returned as a *payload.NetworkScriptError if the script failed. The output of any other
+// script is the value itself.
+func (sc *ServiceController) runPowershellPreScript(path string) (string, error) {
+	out, runErr := sc.psCmdRunner.Run(path)
+	result, err := payload.ParseNetworkScriptResult(out)
+	if err != nil {
+		if runErr != nil {
+			return "", runErr
+		}
+		return strings.TrimSpace(out), nil
+	}
+	if err := result.Err(); err != nil {
+		return "", err
+	}
+	if runErr != nil {
+		return "", runErr
+	}
+	return result.SourceVIP, nil
+}
+
 // waitUntilNodeReady waits until the Node being configured is ready. Returns an error on timeout.
 func (sc *ServiceController) waitUntilNodeReady() error {
 	return wait.PollUntilContextTimeout(sc.ctx, 5*time.Second, time.Minute, true,
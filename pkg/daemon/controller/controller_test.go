@@ -16,6 +16,7 @@ import (
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -194,6 +195,27 @@ func TestResolvePowershellVariables(t *testing.T) {
 			expected:  map[string]string{"CMD_REPLACE1": "127.0.0.1", "CMD_REPLACE2": "test-output"},
 			expectErr: false,
 		},
+		{
+			name: "Network script result",
+			service: servicescm.Service{
+				PowershellPreScripts: []servicescm.PowershellPreScript{{
+					VariableName: "ENDPOINT_IP",
+					Path:         "c:\\k\\kube-proxy-prep.ps1",
+				}},
+			},
+			expected:  map[string]string{"ENDPOINT_IP": "10.132.0.2"},
+			expectErr: false,
+		},
+		{
+			name: "Failed network script result",
+			service: servicescm.Service{
+				PowershellPreScripts: []servicescm.PowershellPreScript{{
+					VariableName: "ENDPOINT_IP",
+					Path:         "c:\\k\\failed-kube-proxy-prep.ps1",
+				}},
+			},
+			expectErr: true,
+		},
 	}
 	for _, test := range testIO {
 		t.Run(test.name, func(t *testing.T) {
@@ -204,6 +226,10 @@ func TestResolvePowershellVariables(t *testing.T) {
 					map[string]string{
 						"c:\\k\\script.ps1": "127.0.0.1",
 						"c:\\k\\test.ps1":   "test-output",
+						"c:\\k\\kube-proxy-prep.ps1": "Attaching endpoint\r\n" +
+							`{"status":"Succeeded","failedStep":"","message":"","sourceVip":"10.132.0.2"}`,
+						"c:\\k\\failed-kube-proxy-prep.ps1": `{"status":"Failed","failedStep":"CreateEndpoint",` +
+							`"message":"access denied","sourceVip":""}`,
 					},
 				},
 			})
@@ -737,6 +763,43 @@ func TestReconcile(t *testing.T) {
 	}
 }
 
+// TestReconcileNetworkScriptFailure ensures a failed network script is reported by an event identifying the failure
+func TestReconcileNetworkScriptFailure(t *testing.T) {
+	desiredVersion := "testversion"
+	cm, err := servicescm.Generate(servicescm.NamePrefix+desiredVersion, wmcoNamespace, &servicescm.Data{
+		Services: []servicescm.Service{{
+			Name:                 "kube-proxy",
+			Command:              "kube-proxy.exe",
+			PowershellPreScripts: []servicescm.PowershellPreScript{{Path: "c:\\k\\network-preflight.ps1"}},
+		}},
+		Files: []servicescm.FileInfo{},
+	})
+	require.NoError(t, err)
+	node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "node",
+		Annotations: map[string]string{metadata.DesiredVersionAnnotation: desiredVersion}}}
+	recorder := record.NewFakeRecorder(1)
+	c, err := NewServiceController(context.TODO(), "node", wmcoNamespace, Options{
+		Client: clientfake.NewClientBuilder().WithObjects(node, cm).Build(),
+		Mgr:    fake.NewTestMgr(nil),
+		cmdRunner: &fakePSCmdRunner{
+			map[string]string{
+				"c:\\k\\network-preflight.ps1": `{"status":"Failed","failedStep":"CheckManagementIP",` +
+					`"message":"no management IP","sourceVip":""}`,
+				"[Environment]::GetEnvironmentVariable('HTTP_PROXY', 'Process')":  "",
+				"[Environment]::GetEnvironmentVariable('HTTPS_PROXY', 'Process')": "",
+				"[Environment]::GetEnvironmentVariable('NO_PROXY', 'Process')":    "",
+			},
+		},
+		recorder: recorder,
+	})
+	require.NoError(t, err)
+	_, err = c.Reconcile(context.TODO(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "node"}})
+	require.Error(t, err)
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Warning ManagementIPMissing network script failed at step CheckManagementIP: no management IP",
+		<-recorder.Events)
+}
+
 // testServicesCreatedAsExpected tests that the created services are running and configured as expected
 func testServicesCreatedAsExpected(t *testing.T, createdServices map[string]fake.FakeService,
 	expectedServicesNameCmdPairs map[string]string) {
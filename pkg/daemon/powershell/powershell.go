@@ -13,11 +13,11 @@ type CommandRunner interface {
 // commandRunner implements the CommandRunner interface
 type commandRunner struct{}
 
-// Run runs the command with the PowerShell on PATH
+// Run runs the command with the PowerShell on PATH. The output is returned even if the command fails.
 func (r *commandRunner) Run(cmd string) (string, error) {
 	out, err := exec.Command("powershell", "/c", cmd).CombinedOutput()
 	if err != nil {
-		return "", fmt.Errorf("error running command with output %s: %w", string(out), err)
+		return string(out), fmt.Errorf("error running command with output %s: %w", string(out), err)
 	}
 	return string(out), nil
 }
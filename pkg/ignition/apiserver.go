@@ -0,0 +1,150 @@
+package ignition
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+)
+
+const (
+	// APIServerURLEnvPath is the path to the environment file holding the internal API server URL as defined in ignition
+	APIServerURLEnvPath = "/etc/kubernetes/apiserver-url.env"
+	// apiServerURLVar is the variable of the API server URL environment file holding the full URL
+	apiServerURLVar = "API_SERVER_URL"
+	// serviceHostVar and servicePortVar are the variables of the API server URL environment file holding the host and
+	// port of the API server, as set for in-cluster clients
+	serviceHostVar = "KUBERNETES_SERVICE_HOST"
+	servicePortVar = "KUBERNETES_SERVICE_PORT"
+	// defaultServicePort is the port of the API server if the environment file only sets its host
+	defaultServicePort = "443"
+	// bootstrapKubeconfigUser is the name of the user and context of the bootstrap kubeconfig
+	bootstrapKubeconfigUser = "kubelet"
+	// KubeletCAFilename is the name of the kubelet CA file within the kubernetes directory of Windows nodes
+	KubeletCAFilename = "kubelet-ca.crt"
+	// BootstrapKubeconfigFilename is the name of the bootstrap kubeconfig within the kubernetes directory of Windows
+	// nodes
+	BootstrapKubeconfigFilename = "bootstrap-kubeconfig"
+)
+
+// windowsDirRegex matches absolute Windows directory paths starting with a drive letter
+var windowsDirRegex = regexp.MustCompile(`^[A-Za-z]:\\`)
+
+// BootstrapFiles holds the contents of the files the kubelet of Windows nodes bootstraps with
+type BootstrapFiles struct {
+	// KubeletCA is the contents of the kubelet CA file, the PEM bundle of the API server serving CA
+	KubeletCA []byte
+	// BootstrapKubeconfig is the contents of the bootstrap kubeconfig
+	BootstrapKubeconfig []byte
+}
+
+// Files returns the contents of the bootstrap files keyed by their path on Windows nodes, within the given kubernetes
+// directory
+func (b BootstrapFiles) Files(k8sDir string) (map[string][]byte, error) {
+	if !windowsDirRegex.MatchString(k8sDir) {
+		return nil, fmt.Errorf("kubernetes directory %q is not an absolute Windows path", k8sDir)
+	}
+	k8sDir = strings.TrimRight(k8sDir, "\\")
+	return map[string][]byte{
+		k8sDir + "\\" + KubeletCAFilename:           b.KubeletCA,
+		k8sDir + "\\" + BootstrapKubeconfigFilename: b.BootstrapKubeconfig,
+	}, nil
+}
+
+// RenderBootstrapFiles returns the contents of the kubelet CA file and of the bootstrap kubeconfig authenticating to
+// the API server at the given URL with the given token, trusting the given PEM encoded CA bundle. On clusters, the
+// bootstrap kubeconfig trusts the CA of the MCS bootstrap ServiceAccount token, like the kubeconfig of Linux nodes.
+// The kubelet CA is sanitized with GetSanitizedKubeletCA, so the contents are the same for the same kubelet CA
+// certificates and inputs, and can be hashed. ErrKubeletCANotFound is returned if the kubelet CA has no valid
+// certificate.
+func (ign *Ignition) RenderBootstrapFiles(caData []byte, apiServerURL, token string) (BootstrapFiles, error) {
+	if token == "" || strings.ContainsAny(token, " \t\r\n") {
+		return BootstrapFiles{}, fmt.Errorf("bootstrap token must be non-empty, without whitespace")
+	}
+	kubeletCA := ign.GetSanitizedKubeletCA()
+	if len(kubeletCA) == 0 {
+		return BootstrapFiles{}, fmt.Errorf("could not render kubelet CA: %w", ErrKubeletCANotFound)
+	}
+	kubeconfig, err := buildKubeconfig(caData, apiServerURL, token)
+	if err != nil {
+		return BootstrapFiles{}, err
+	}
+	return BootstrapFiles{KubeletCA: kubeletCA, BootstrapKubeconfig: kubeconfig}, nil
+}
+
+// GetAPIServerURL returns the internal API server URL set by the API server URL environment file within the ignition
+// spec, either as a full URL, or as the host and port set for in-cluster clients. The URL must use the https scheme.
+// ErrFileNotFound is returned if the ignition spec does not contain the file, so the caller can discover the URL by
+// other means.
+func (ign *Ignition) GetAPIServerURL() (string, error) {
+	contents, err := ign.GetFileContents(APIServerURLEnvPath)
+	if err != nil {
+		return "", err
+	}
+	environment := parseEnvironmentFile(string(contents))
+	apiServerURL := environment[apiServerURLVar]
+	if apiServerURL == "" {
+		host := environment[serviceHostVar]
+		if host == "" {
+			return "", fmt.Errorf("%s sets neither %s nor %s", APIServerURLEnvPath, apiServerURLVar, serviceHostVar)
+		}
+		port := environment[servicePortVar]
+		if port == "" {
+			port = defaultServicePort
+		}
+		apiServerURL = "https://" + net.JoinHostPort(host, port)
+	}
+	parsed, err := url.Parse(apiServerURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid API server URL %q in %s: %w", apiServerURL, APIServerURLEnvPath, err)
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return "", fmt.Errorf("API server URL %q in %s is not an https URL", apiServerURL, APIServerURLEnvPath)
+	}
+	return apiServerURL, nil
+}
+
+// buildKubeconfig returns a serialized kubeconfig for the kubelet to initially communicate with the API server at the
+// given URL, trusting the given PEM encoded CA bundle, which may include intermediate certificates, and authenticating
+// with the given token
+func buildKubeconfig(caData []byte, apiServerURL, token string) ([]byte, error) {
+	if certs, _ := parseCertificates(caData); len(certs) == 0 {
+		return nil, fmt.Errorf("CA data contains no valid PEM encoded certificate")
+	}
+	if parsed, err := url.Parse(apiServerURL); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("API server URL %q is not an https URL", apiServerURL)
+	}
+	return json.Marshal(NewKubeconfig(caData, token, apiServerURL, bootstrapKubeconfigUser))
+}
+
+// NewKubeconfig returns a kubeconfig spec for the given user to communicate with the API server at the given URL,
+// trusting the given CA data and authenticating with the given token
+func NewKubeconfig(caData []byte, token, apiServerURL, username string) clientcmdv1.Config {
+	return clientcmdv1.Config{
+		Clusters: []clientcmdv1.NamedCluster{{
+			Name: "local",
+			Cluster: clientcmdv1.Cluster{
+				Server:                   apiServerURL,
+				CertificateAuthorityData: caData,
+			}},
+		},
+		AuthInfos: []clientcmdv1.NamedAuthInfo{{
+			Name: username,
+			AuthInfo: clientcmdv1.AuthInfo{
+				Token: token,
+			},
+		}},
+		Contexts: []clientcmdv1.NamedContext{{
+			Name: username,
+			Context: clientcmdv1.Context{
+				Cluster:  "local",
+				AuthInfo: username,
+			},
+		}},
+		CurrentContext: username,
+	}
+}
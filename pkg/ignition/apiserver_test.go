@@ -0,0 +1,188 @@
+package ignition
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/utils/ptr"
+)
+
+func TestGetAPIServerURL(t *testing.T) {
+	envFile := func(contents string) []ignCfgTypes.File {
+		file := ignCfgTypes.File{Node: ignCfgTypes.Node{Path: APIServerURLEnvPath}}
+		file.Contents.Source = ptr.To("data:," + url.PathEscape(contents))
+		return []ignCfgTypes.File{file}
+	}
+	testCases := []struct {
+		name          string
+		files         []ignCfgTypes.File
+		expected      string
+		expectedErr   bool
+		expectMissing bool
+	}{
+		{
+			name:     "service host and port",
+			files:    envFile("KUBERNETES_SERVICE_HOST='api-int.cluster.example.com'\nKUBERNETES_SERVICE_PORT='6443'\n"),
+			expected: "https://api-int.cluster.example.com:6443",
+		},
+		{
+			name:     "IPv6 service host without port",
+			files:    envFile("KUBERNETES_SERVICE_HOST=fd00::1\n"),
+			expected: "https://[fd00::1]:443",
+		},
+		{
+			name:     "full URL",
+			files:    envFile("API_SERVER_URL=https://api-int.cluster.example.com:6443\n"),
+			expected: "https://api-int.cluster.example.com:6443",
+		},
+		{
+			name:        "http URL",
+			files:       envFile("API_SERVER_URL=http://api-int.cluster.example.com:6443\n"),
+			expectedErr: true,
+		},
+		{
+			name:        "no URL",
+			files:       envFile("# empty\n"),
+			expectedErr: true,
+		},
+		{
+			name:          "missing file",
+			expectedErr:   true,
+			expectMissing: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{config: ignCfgTypes.Config{Storage: ignCfgTypes.Storage{Files: test.files}}}
+			apiServerURL, err := ign.GetAPIServerURL()
+			if test.expectedErr {
+				require.Error(t, err)
+				assert.Equal(t, test.expectMissing, errors.Is(err, ErrFileNotFound))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, apiServerURL)
+		})
+	}
+}
+
+func TestRenderBootstrapFiles(t *testing.T) {
+	rootCA := generateCertificate(t, "root-ca")
+	intermediateCA := generateCertificate(t, "intermediate-ca")
+	bundle := append(append([]byte{}, rootCA...), intermediateCA...)
+	// the CA of the bootstrap ServiceAccount token, trusted by the bootstrap kubeconfig
+	serviceAccountCA := generateCertificate(t, "service-account-ca")
+	testCases := []struct {
+		name         string
+		caData       []byte
+		kubeconfigCA []byte
+		apiServerURL string
+		token        string
+		expectedErr  bool
+		// expectedErrIs is the error the returned error must wrap, if set
+		expectedErrIs error
+	}{
+		{
+			name:         "CA bundle",
+			caData:       bundle,
+			kubeconfigCA: serviceAccountCA,
+			apiServerURL: "https://api-int.cluster.example.com:6443",
+			token:        "abcdef.0123456789abcdef",
+		},
+		{
+			name:         "CA bundle with duplicates and CRLF line endings",
+			caData:       []byte(strings.ReplaceAll(string(append(bundle, rootCA...)), "\n", "\r\n")),
+			kubeconfigCA: serviceAccountCA,
+			apiServerURL: "https://api-int.cluster.example.com:6443",
+			token:        "abcdef.0123456789abcdef",
+		},
+		{
+			name:          "no kubelet CA",
+			kubeconfigCA:  serviceAccountCA,
+			apiServerURL:  "https://api-int.cluster.example.com:6443",
+			token:         "abcdef.0123456789abcdef",
+			expectedErr:   true,
+			expectedErrIs: ErrKubeletCANotFound,
+		},
+		{
+			name:         "no kubeconfig CA",
+			caData:       bundle,
+			apiServerURL: "https://api-int.cluster.example.com:6443",
+			token:        "abcdef.0123456789abcdef",
+			expectedErr:  true,
+		},
+		{
+			name:         "invalid URL",
+			caData:       bundle,
+			kubeconfigCA: serviceAccountCA,
+			apiServerURL: "http://api-int.cluster.example.com:6443",
+			token:        "abcdef.0123456789abcdef",
+			expectedErr:  true,
+		},
+		{
+			name:         "no token",
+			caData:       bundle,
+			kubeconfigCA: serviceAccountCA,
+			apiServerURL: "https://api-int.cluster.example.com:6443",
+			expectedErr:  true,
+		},
+		{
+			name:         "token with newline",
+			caData:       bundle,
+			kubeconfigCA: serviceAccountCA,
+			apiServerURL: "https://api-int.cluster.example.com:6443",
+			token:        "abcdef.0123456789abcdef\nuser: admin",
+			expectedErr:  true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{kubeletCAData: test.caData, kubeletCACertificates: getKubeletCACertificates(test.caData)}
+			files, err := ign.RenderBootstrapFiles(test.kubeconfigCA, test.apiServerURL, test.token)
+			if test.expectedErr {
+				require.Error(t, err)
+				if test.expectedErrIs != nil {
+					assert.ErrorIs(t, err, test.expectedErrIs)
+				}
+				return
+			}
+			require.NoError(t, err)
+			// the kubelet CA holds each certificate of the input bundle once
+			assert.Equal(t, bundle, files.KubeletCA)
+
+			kubeconfig, err := clientcmd.Load(files.BootstrapKubeconfig)
+			require.NoError(t, err)
+			require.NoError(t, clientcmd.Validate(*kubeconfig))
+			restConfig, err := clientcmd.NewDefaultClientConfig(*kubeconfig, nil).ClientConfig()
+			require.NoError(t, err)
+			assert.Equal(t, test.apiServerURL, restConfig.Host)
+			assert.Equal(t, test.token, restConfig.BearerToken)
+			assert.Equal(t, test.kubeconfigCA, restConfig.TLSClientConfig.CAData)
+
+			// the contents only depend on the inputs, so they can be hashed
+			again, err := ign.RenderBootstrapFiles(test.kubeconfigCA, test.apiServerURL, test.token)
+			require.NoError(t, err)
+			assert.Equal(t, files, again)
+		})
+	}
+}
+
+func TestBootstrapFilesFiles(t *testing.T) {
+	files := BootstrapFiles{KubeletCA: []byte("ca"), BootstrapKubeconfig: []byte("kubeconfig")}
+	for _, k8sDir := range []string{`C:\k`, `C:\k\`} {
+		paths, err := files.Files(k8sDir)
+		require.NoError(t, err)
+		assert.Equal(t, map[string][]byte{
+			`C:\k\kubelet-ca.crt`:       []byte("ca"),
+			`C:\k\bootstrap-kubeconfig`: []byte("kubeconfig"),
+		}, paths)
+	}
+	_, err := files.Files("/etc/kubernetes")
+	assert.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package ignition
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// ChronyConfigPath is the path to the chrony configuration file as defined in ignition
+	ChronyConfigPath = "/etc/chrony.conf"
+	// chronyDropinPattern matches the chrony configuration drop-ins, which are read after the main file
+	chronyDropinPattern = "/etc/chrony.d/*.conf"
+)
+
+// GetNTPServers returns the NTP servers and pools configured by the server and pool directives of the chrony
+// configuration within the ignition spec, in the order chrony reads them: the main configuration file first, followed
+// by the drop-ins in lexicographic order. Duplicates are removed. An empty slice is returned if the ignition spec does
+// not contain any chrony configuration.
+func (ign *Ignition) GetNTPServers() ([]string, error) {
+	dropins, err := ign.FilesMatching(chronyDropinPattern)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{ChronyConfigPath}
+	for _, dropin := range dropins {
+		paths = append(paths, dropin.Node.Path)
+	}
+
+	servers := []string{}
+	for _, path := range paths {
+		contents, err := ign.GetFileContents(path)
+		if err != nil {
+			if errors.Is(err, ErrFileNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("error reading chrony configuration: %w", err)
+		}
+		for _, server := range parseChronyServers(string(contents)) {
+			if !slices.Contains(servers, server) {
+				servers = append(servers, server)
+			}
+		}
+	}
+	return servers, nil
+}
+
+// parseChronyServers returns the hosts of the server and pool directives of the given chrony configuration, in order
+func parseChronyServers(contents string) []string {
+	var servers []string
+	for _, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		// comments start with any of the characters below, and directives are followed by the host and its options
+		if len(fields) < 2 || strings.ContainsAny(fields[0][:1], "#!;%") {
+			continue
+		}
+		if directive := strings.ToLower(fields[0]); directive == "server" || directive == "pool" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
@@ -0,0 +1,73 @@
+package ignition
+
+import (
+	"net/url"
+	"testing"
+
+	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+)
+
+func TestGetNTPServers(t *testing.T) {
+	chronyFile := func(path, contents string) ignCfgTypes.File {
+		file := ignCfgTypes.File{Node: ignCfgTypes.Node{Path: path}}
+		file.Contents.Source = ptr.To("data:," + url.PathEscape(contents))
+		return file
+	}
+	testCases := []struct {
+		name        string
+		files       []ignCfgTypes.File
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			name:     "no chrony configuration",
+			files:    []ignCfgTypes.File{chronyFile("/etc/kubernetes/cloud.conf", "server a.example.com\n")},
+			expected: []string{},
+		},
+		{
+			name: "servers and pools",
+			files: []ignCfgTypes.File{chronyFile(ChronyConfigPath, `# Use public servers from the pool.ntp.org project.
+pool 2.rhel.pool.ntp.org iburst
+server 169.254.169.123 prefer iburst minpoll 4 maxpoll 4
+! server commented.example.com
+;server commented.example.com
+driftfile /var/lib/chrony/drift
+makestep 1.0 3
+server
+`)},
+			expected: []string{"2.rhel.pool.ntp.org", "169.254.169.123"},
+		},
+		{
+			name: "drop-ins read in order and de-duplicated",
+			files: []ignCfgTypes.File{
+				chronyFile("/etc/chrony.d/20-b.conf", "server b.example.com iburst\nserver a.example.com\n"),
+				chronyFile("/etc/chrony.d/10-a.conf", "server a.example.com iburst\n"),
+				chronyFile("/etc/chrony.d/README", "server ignored.example.com\n"),
+				chronyFile(ChronyConfigPath, "server main.example.com\n"),
+			},
+			expected: []string{"main.example.com", "a.example.com", "b.example.com"},
+		},
+		{
+			name: "undecodable configuration",
+			files: []ignCfgTypes.File{{Node: ignCfgTypes.Node{Path: ChronyConfigPath},
+				FileEmbedded1: ignCfgTypes.FileEmbedded1{Contents: ignCfgTypes.Resource{
+					Source: ptr.To("https://example.com/chrony.conf")}}}},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{config: ignCfgTypes.Config{Storage: ignCfgTypes.Storage{Files: test.files}}}
+			servers, err := ign.GetNTPServers()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, servers)
+		})
+	}
+}
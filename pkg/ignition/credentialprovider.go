@@ -0,0 +1,77 @@
+package ignition
+
+import (
+	"bytes"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+	kubeletconfig "k8s.io/kubelet/config/v1beta1"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+const (
+	// ECRCredentialProviderPath is the path to the ECR image credential provider configuration file as defined in
+	// ignition, which is only present on AWS clusters
+	ECRCredentialProviderPath = "/etc/kubernetes/credential-providers/ecr-credential-provider.yaml"
+	// credentialProviderConfigV1 is the apiVersion of the CredentialProviderConfig expected by the kubelet shipped for
+	// Windows nodes
+	credentialProviderConfigV1 = "kubelet.config.k8s.io/v1"
+	// credentialProviderConfigV1Alpha1 and credentialProviderConfigV1Beta1 are the apiVersions of the
+	// CredentialProviderConfig used by older cluster versions
+	credentialProviderConfigV1Alpha1 = "kubelet.config.k8s.io/v1alpha1"
+	credentialProviderConfigV1Beta1  = "kubelet.config.k8s.io/v1beta1"
+	// credentialProviderV1 is the version of the CredentialProviderRequest and CredentialProviderResponse exchanged
+	// between the kubelet and a provider using a v1 CredentialProviderConfig
+	credentialProviderV1 = "credentialprovider.kubelet.k8s.io/v1"
+	// credentialProviderV1Alpha1 and credentialProviderV1Beta1 are the versions of the CredentialProviderRequest and
+	// CredentialProviderResponse exchanged between the kubelet and a provider using an older CredentialProviderConfig
+	credentialProviderV1Alpha1 = "credentialprovider.kubelet.k8s.io/v1alpha1"
+	credentialProviderV1Beta1  = "credentialprovider.kubelet.k8s.io/v1beta1"
+)
+
+// GetCredentialProviderConfig returns the ECR image credential provider configuration from the ignition spec,
+// converted to the v1 API if needed, along with its YAML serialization. The v1 API shares the structure of the
+// vendored v1beta1 type, which is used to hold it. ErrFileNotFound is returned if the ignition spec does not contain
+// the file, as is the case on other platforms than AWS.
+func (ign *Ignition) GetCredentialProviderConfig() (*kubeletconfig.CredentialProviderConfig, []byte, error) {
+	contents, err := ign.GetFileContents(ECRCredentialProviderPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &kubeletconfig.CredentialProviderConfig{}
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(contents), kubeletConfigDecoderBufferSize)
+	if err := decoder.Decode(config); err != nil {
+		return nil, nil, fmt.Errorf("error decoding %s: %w", ECRCredentialProviderPath, err)
+	}
+	if err := convertCredentialProviderConfig(config); err != nil {
+		return nil, nil, fmt.Errorf("error converting %s: %w", ECRCredentialProviderPath, err)
+	}
+	data, err := sigsyaml.Marshal(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error serializing %s: %w", ECRCredentialProviderPath, err)
+	}
+	return config, data, nil
+}
+
+// convertCredentialProviderConfig converts the given v1alpha1 or v1beta1 CredentialProviderConfig to the v1 API in
+// place, including the API version its providers exchange with the kubelet. v1 configurations are left as is.
+func convertCredentialProviderConfig(config *kubeletconfig.CredentialProviderConfig) error {
+	switch config.APIVersion {
+	case credentialProviderConfigV1:
+	case credentialProviderConfigV1Alpha1, credentialProviderConfigV1Beta1:
+		config.APIVersion = credentialProviderConfigV1
+	default:
+		return fmt.Errorf("unsupported apiVersion %q", config.APIVersion)
+	}
+	for i := range config.Providers {
+		switch config.Providers[i].APIVersion {
+		case credentialProviderV1:
+		case credentialProviderV1Alpha1, credentialProviderV1Beta1:
+			config.Providers[i].APIVersion = credentialProviderV1
+		default:
+			return fmt.Errorf("unsupported apiVersion %q of provider %s", config.Providers[i].APIVersion,
+				config.Providers[i].Name)
+		}
+	}
+	return nil
+}
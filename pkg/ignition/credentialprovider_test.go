@@ -0,0 +1,105 @@
+package ignition
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeletconfig "k8s.io/kubelet/config/v1beta1"
+	"k8s.io/utils/ptr"
+)
+
+func TestGetCredentialProviderConfig(t *testing.T) {
+	credentialProviderFile := func(contents string) []ignCfgTypes.File {
+		file := ignCfgTypes.File{Node: ignCfgTypes.Node{Path: ECRCredentialProviderPath}}
+		file.Contents.Source = ptr.To("data:," + url.PathEscape(contents))
+		return []ignCfgTypes.File{file}
+	}
+	expectedV1 := &kubeletconfig.CredentialProviderConfig{
+		TypeMeta: metav1.TypeMeta{Kind: "CredentialProviderConfig", APIVersion: "kubelet.config.k8s.io/v1"},
+		Providers: []kubeletconfig.CredentialProvider{{
+			Name:                 "ecr-credential-provider",
+			MatchImages:          []string{"*.dkr.ecr.*.amazonaws.com", "*.dkr.ecr.*.amazonaws.com.cn"},
+			DefaultCacheDuration: &metav1.Duration{Duration: 12 * time.Hour},
+			APIVersion:           "credentialprovider.kubelet.k8s.io/v1",
+		}},
+	}
+	testCases := []struct {
+		name          string
+		files         []ignCfgTypes.File
+		expected      *kubeletconfig.CredentialProviderConfig
+		expectedErr   bool
+		expectMissing bool
+	}{
+		{
+			name: "v1alpha1 converted",
+			files: credentialProviderFile(`apiVersion: kubelet.config.k8s.io/v1alpha1
+kind: CredentialProviderConfig
+providers:
+  - name: ecr-credential-provider
+    matchImages:
+      - "*.dkr.ecr.*.amazonaws.com"
+      - "*.dkr.ecr.*.amazonaws.com.cn"
+    defaultCacheDuration: "12h"
+    apiVersion: credentialprovider.kubelet.k8s.io/v1alpha1
+`),
+			expected: expectedV1,
+		},
+		{
+			name: "v1 kept",
+			files: credentialProviderFile(`apiVersion: kubelet.config.k8s.io/v1
+kind: CredentialProviderConfig
+providers:
+  - name: ecr-credential-provider
+    matchImages:
+      - "*.dkr.ecr.*.amazonaws.com"
+      - "*.dkr.ecr.*.amazonaws.com.cn"
+    defaultCacheDuration: "12h"
+    apiVersion: credentialprovider.kubelet.k8s.io/v1
+`),
+			expected: expectedV1,
+		},
+		{
+			name:          "missing file",
+			expectedErr:   true,
+			expectMissing: true,
+		},
+		{
+			name:        "malformed yaml",
+			files:       credentialProviderFile("apiVersion: kubelet.config.k8s.io/v1\nproviders: {name: [\n"),
+			expectedErr: true,
+		},
+		{
+			name:        "unsupported apiVersion",
+			files:       credentialProviderFile("apiVersion: kubelet.config.k8s.io/v2\nkind: CredentialProviderConfig\n"),
+			expectedErr: true,
+		},
+		{
+			name: "unsupported provider apiVersion",
+			files: credentialProviderFile("apiVersion: kubelet.config.k8s.io/v1\nkind: CredentialProviderConfig\n" +
+				"providers:\n  - name: ecr-credential-provider\n    apiVersion: credentialprovider.kubelet.k8s.io/v2\n"),
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{config: ignCfgTypes.Config{Storage: ignCfgTypes.Storage{Files: test.files}}}
+			config, data, err := ign.GetCredentialProviderConfig()
+			if test.expectedErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), ECRCredentialProviderPath)
+				assert.Equal(t, test.expectMissing, errors.Is(err, ErrFileNotFound))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, config)
+			assert.Contains(t, string(data), "apiVersion: kubelet.config.k8s.io/v1\n")
+			assert.Contains(t, string(data), "apiVersion: credentialprovider.kubelet.k8s.io/v1\n")
+		})
+	}
+}
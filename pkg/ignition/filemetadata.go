@@ -0,0 +1,91 @@
+package ignition
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+
+	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+)
+
+// defaultFileMode is the mode ignition creates files with when the spec does not set one
+const defaultFileMode fs.FileMode = 0644
+
+// FileAccess is the access files copied to Windows nodes should be restricted to
+type FileAccess string
+
+const (
+	// FileAccessAdminOnly restricts the file to the Administrators group, as it may contain credentials
+	FileAccessAdminOnly FileAccess = "AdminOnly"
+	// FileAccessWorldReadable allows every user of the Windows node to read the file
+	FileAccessWorldReadable FileAccess = "WorldReadable"
+)
+
+// FileMetadata is the ownership and permission metadata of a file within the ignition spec
+type FileMetadata struct {
+	// Path is the path of the file
+	Path string
+	// Mode is the mode of the file. It is nil if the spec does not set it, in which case ignition uses
+	// defaultFileMode.
+	Mode *int
+	// User is the name of the user owning the file, or its ID if only that is set. It is empty if the spec does not
+	// set it, in which case the file is owned by root.
+	User string
+	// Group is the name of the group owning the file, or its ID if only that is set. It is empty if the spec does not
+	// set it, in which case the file is owned by the root group.
+	Group string
+}
+
+// GetFileMetadata returns the ownership and permission metadata of the file at the given path within the ignition
+// spec. ErrFileNotFound is returned if the ignition spec does not contain the file.
+func (ign *Ignition) GetFileMetadata(path string) (FileMetadata, error) {
+	for _, file := range ign.GetFiles() {
+		if file.Node.Path == path {
+			return newFileMetadata(file), nil
+		}
+	}
+	return FileMetadata{}, fmt.Errorf("%s: %w", path, ErrFileNotFound)
+}
+
+// newFileMetadata returns the metadata of the given ignition file
+func newFileMetadata(file ignCfgTypes.File) FileMetadata {
+	metadata := FileMetadata{Path: file.Node.Path, Mode: file.Mode}
+	metadata.User = ownerName(file.Node.User.Name, file.Node.User.ID)
+	metadata.Group = ownerName(file.Node.Group.Name, file.Node.Group.ID)
+	return metadata
+}
+
+// ownerName returns the given name of a user or group, or its ID if the name is not set
+func ownerName(name *string, id *int) string {
+	if name != nil && *name != "" {
+		return *name
+	}
+	if id != nil {
+		return strconv.Itoa(*id)
+	}
+	return ""
+}
+
+// WindowsAccess returns the access the file should be restricted to once copied to Windows nodes, along with warnings
+// about modes which are unexpected for files used by the kubelet. Files readable by other users than their owner and
+// group are world-readable, and any other file is restricted to the Administrators group, which is also the case of
+// files whose mode is not valid, so that a broken spec never exposes credentials.
+func (m FileMetadata) WindowsAccess() (FileAccess, []string) {
+	mode := defaultFileMode
+	if m.Mode != nil {
+		if *m.Mode < 0 || *m.Mode > 07777 {
+			return FileAccessAdminOnly, []string{fmt.Sprintf("%s has invalid mode %#o, restricting it to "+
+				"administrators", m.Path, *m.Mode)}
+		}
+		mode = fs.FileMode(*m.Mode) & fs.ModePerm
+	}
+	var warnings []string
+	if mode&0002 != 0 {
+		warnings = append(warnings, fmt.Sprintf("%s is world-writable with mode %#o, which is never granted on "+
+			"Windows nodes", m.Path, uint32(mode)))
+	}
+	if mode&0004 != 0 {
+		return FileAccessWorldReadable, warnings
+	}
+	return FileAccessAdminOnly, warnings
+}
@@ -0,0 +1,100 @@
+package ignition
+
+import (
+	"testing"
+
+	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+)
+
+func TestGetFileMetadata(t *testing.T) {
+	credentialProviderPath := "/etc/kubernetes/credential-providers/ecr-credential-provider.yaml"
+	credentialProvider := ignCfgTypes.File{Node: ignCfgTypes.Node{Path: credentialProviderPath,
+		User: ignCfgTypes.NodeUser{Name: ptr.To("root")}, Group: ignCfgTypes.NodeGroup{ID: ptr.To(0)}}}
+	credentialProvider.Mode = ptr.To(0600)
+	cloudConfig := ignCfgTypes.File{Node: ignCfgTypes.Node{Path: CloudConfigPath}}
+	ign := &Ignition{config: ignCfgTypes.Config{Storage: ignCfgTypes.Storage{
+		Files: []ignCfgTypes.File{credentialProvider, cloudConfig}}}}
+
+	metadata, err := ign.GetFileMetadata(credentialProviderPath)
+	require.NoError(t, err)
+	assert.Equal(t, FileMetadata{Path: credentialProviderPath, Mode: ptr.To(0600), User: "root", Group: "0"},
+		metadata)
+
+	metadata, err = ign.GetFileMetadata(CloudConfigPath)
+	require.NoError(t, err)
+	assert.Equal(t, FileMetadata{Path: CloudConfigPath}, metadata)
+
+	_, err = ign.GetFileMetadata("/etc/kubernetes/missing.conf")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestWindowsAccess(t *testing.T) {
+	testCases := []struct {
+		name             string
+		mode             *int
+		expected         FileAccess
+		expectedWarnings int
+	}{
+		{
+			name:     "mode absent",
+			expected: FileAccessWorldReadable,
+		},
+		{
+			name:     "owner only",
+			mode:     ptr.To(0600),
+			expected: FileAccessAdminOnly,
+		},
+		{
+			name:     "group readable",
+			mode:     ptr.To(0640),
+			expected: FileAccessAdminOnly,
+		},
+		{
+			name:     "world-readable",
+			mode:     ptr.To(0644),
+			expected: FileAccessWorldReadable,
+		},
+		{
+			name:     "world-readable with setuid bit",
+			mode:     ptr.To(04755),
+			expected: FileAccessWorldReadable,
+		},
+		{
+			name:             "world-writable",
+			mode:             ptr.To(0666),
+			expected:         FileAccessWorldReadable,
+			expectedWarnings: 1,
+		},
+		{
+			name:             "world-writable but not readable",
+			mode:             ptr.To(0602),
+			expected:         FileAccessAdminOnly,
+			expectedWarnings: 1,
+		},
+		{
+			name:             "negative mode",
+			mode:             ptr.To(-1),
+			expected:         FileAccessAdminOnly,
+			expectedWarnings: 1,
+		},
+		{
+			name:             "mode beyond permission bits",
+			mode:             ptr.To(0100644),
+			expected:         FileAccessAdminOnly,
+			expectedWarnings: 1,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			access, warnings := FileMetadata{Path: CloudConfigPath, Mode: test.mode}.WindowsAccess()
+			assert.Equal(t, test.expected, access)
+			assert.Len(t, warnings, test.expectedWarnings)
+			for _, warning := range warnings {
+				assert.Contains(t, warning, CloudConfigPath)
+			}
+		})
+	}
+}
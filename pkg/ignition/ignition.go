@@ -1,168 +1,859 @@
 package ignition
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
-	"sort"
+	"io"
+	"maps"
+	"path"
+	"slices"
 	"strings"
+	"sync"
 
+	ignCfgUtil "github.com/coreos/ignition/v2/config/util"
 	ignCfg "github.com/coreos/ignition/v2/config/v3_4"
 	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/go-logr/logr"
 	mcfg "github.com/openshift/api/machineconfiguration/v1"
+	"github.com/vincent-petithory/dataurl"
+	k8sapierrors "k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // Watch permission are needed in order to populate the cache. We use a cached client to list machineconfig and
 // controllerconfig resources.
-//+kubebuilder:rbac:groups="machineconfiguration.openshift.io",resources=machineconfigs,verbs=list;watch
+//+kubebuilder:rbac:groups="machineconfiguration.openshift.io",resources=machineconfigs,verbs=get;list;watch
 //+kubebuilder:rbac:groups="machineconfiguration.openshift.io",resources=controllerconfigs,verbs=list;watch
+//+kubebuilder:rbac:groups="machineconfiguration.openshift.io",resources=machineconfigpools,verbs=list;watch
 
 const (
 	// kubeletSystemdName is the name of the systemd service that the kubelet runs under,
 	// this is used to parse the kubelet args
 	kubeletSystemdName = "kubelet.service"
+	// CRIOSystemdName is the name of the systemd service that CRI-O runs under
+	CRIOSystemdName = "crio.service"
 	// CloudConfigOption is the kubelet CLI option for the cloud configuration file
 	CloudConfigOption = "cloud-config"
 	// CloudProviderOption is the kubelet CLI option for cloud provider
 	CloudProviderOption = "cloud-provider"
 	// RenderedWorkerPrefix allows identification of the rendered worker MachineConfig, the combination of all worker
 	// MachineConfigs.
-	RenderedWorkerPrefix = "rendered-worker-"
+	RenderedWorkerPrefix = renderedPrefix + workerPoolName + "-"
+	// workerPoolName is the name of the MachineConfigPool of the Linux worker nodes
+	workerPoolName = "worker"
+	// renderedPrefix is the prefix of all rendered MachineConfigs, followed by the name of the MachineConfigPool
+	renderedPrefix = "rendered-"
 	// CloudConfigPath is the path to the cloud config file as defined in ignition
 	CloudConfigPath = "/etc/kubernetes/cloud.conf"
+	// serviceSection is the name of the systemd unit section holding the command of the service
+	serviceSection = "Service"
+	// execStartOption is the name of the systemd unit option holding the command of the service
+	execStartOption = "ExecStart"
+	// environmentOption is the name of the systemd unit option setting environment variables of the service
+	environmentOption = "Environment"
+	// environmentFileOption is the name of the systemd unit option setting environment variables of the service from
+	// a file
+	environmentFileOption = "EnvironmentFile"
+	// controllerConfigName is the name of the ControllerConfig managed by the MCO
+	controllerConfigName = "machine-config-controller"
+	// DefaultRegistryHost is the containerd certs.d host directory whose configuration applies to all registries
+	DefaultRegistryHost = "_default"
+	// gzipCompression is the compression value of gzip compressed file contents
+	gzipCompression = "gzip"
+	// maxConfigReferenceDepth is the maximum number of nested ignition.config directives resolved when parsing a spec
+	maxConfigReferenceDepth = 10
+)
+
+// gzipMagic are the first bytes of gzip compressed data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+var (
+	// ErrFileNotFound is returned when the ignition spec does not contain the requested file
+	ErrFileNotFound = errors.New("file not found in ignition")
+	// ErrRenderedConfigNotFound is returned when the rendered MachineConfig to parse the ignition spec from does not
+	// exist
+	ErrRenderedConfigNotFound = errors.New("rendered MachineConfig not found")
+	// ErrListTimedOut is returned when the context passed by the caller expires while reading the MachineConfig
+	// resources
+	ErrListTimedOut = errors.New("timed out reading MachineConfig resources")
+	// ErrKubeletCANotFound is returned when none of the ControllerConfigs has kubelet CA data
+	ErrKubeletCANotFound = errors.New("kubelet-ca not found")
+)
+
+// KubeletArgsOfInterest are the names of the kubelet arguments from the ignition spec which are used to configure the
+// kubelet on Windows nodes
+var KubeletArgsOfInterest = []string{CloudProviderOption, CloudConfigOption}
+
+// LinuxOnlyKubeletArgs are the names of the kubelet arguments from the ignition spec which must never be inherited by
+// the kubelet on Windows nodes, as they point at Linux paths, configure Linux only components, or are set by WMCO
+var LinuxOnlyKubeletArgs = []string{
+	"bootstrap-kubeconfig",
+	"cert-dir",
+	"config",
+	"container-runtime",
+	"container-runtime-endpoint",
+	"hostname-override",
+	"kubeconfig",
+	"node-ip",
+	"node-labels",
+	"pod-infra-container-image",
+	"provider-id",
+	"runtime-cgroups",
+	"system-reserved",
+	"volume-plugin-dir",
+}
+
+// RenderedConfigSource describes how the rendered MachineConfig an Ignition is parsed from was selected
+type RenderedConfigSource string
+
+const (
+	// RenderedConfigSourcePoolSpec indicates the rendered MachineConfig is the one named by the MachineConfigPool's
+	// spec.configuration, which is the configuration the pool is rolling out
+	RenderedConfigSourcePoolSpec RenderedConfigSource = "MachineConfigPoolSpec"
+	// RenderedConfigSourceCreationTimestamp indicates the rendered MachineConfig is the most recently created one with
+	// the pool's prefix, as the MachineConfigPool or its configuration was not available
+	RenderedConfigSourceCreationTimestamp RenderedConfigSource = "CreationTimestamp"
 )
 
 // Ignition is a representation of an Ignition resource
 type Ignition struct {
+	// lock guards the fields below, which are updated by Refresh
+	lock          sync.RWMutex
 	config        ignCfgTypes.Config
 	kubeletCAData []byte
+	// kubeletCACertificates are the distinct certificates of the kubelet CA data, in order
+	kubeletCACertificates []*x509.Certificate
+	// imageRegistryCAs are the PEM encoded CAs trusted for image registries, keyed by registry host
+	imageRegistryCAs map[string][]byte
+	// parseWarnings are the non-fatal entries of the report of parsing the config
+	parseWarnings []string
+	// renderedConfigName is the name of the rendered MachineConfig the config was parsed from
+	renderedConfigName string
+	// renderedConfigResourceVersion is the resourceVersion of the rendered MachineConfig the config was parsed from
+	renderedConfigResourceVersion string
+	// renderedConfigGeneration is the generation of the rendered MachineConfig the config was parsed from
+	renderedConfigGeneration int64
+	// configHash is the hex encoded sha256 hash of the raw ignition spec the config was parsed from
+	configHash string
+	// renderedConfigSource describes how the rendered MachineConfig was selected
+	renderedConfigSource RenderedConfigSource
+	// poolName is the name of the MachineConfigPool whose rendered MachineConfig is used
+	poolName string
+	// allowMissingKubeletCA allows the kubelet CA data to be absent from the ControllerConfigs
+	allowMissingKubeletCA bool
 }
 
-// New returns a new instance of Ignition
-func New(c client.Client) (*Ignition, error) {
+// Option configures how New selects the rendered MachineConfig
+type Option func(*options)
+
+// options holds the configuration set by the Option functions
+type options struct {
+	// poolName is the name of the MachineConfigPool whose rendered MachineConfig is used
+	poolName string
+	// allowMissingKubeletCA allows the kubelet CA data to be absent from the ControllerConfigs
+	allowMissingKubeletCA bool
+}
+
+// WithMachineConfigPool selects the rendered MachineConfig of the given MachineConfigPool instead of the one of the
+// worker pool
+func WithMachineConfigPool(name string) Option {
+	return func(o *options) {
+		o.poolName = name
+	}
+}
+
+// AllowMissingKubeletCA constructs the Ignition without kubelet CA data if none of the ControllerConfigs has it yet,
+// instead of failing. Callers should check HasKubeletCA before any step which depends on the kubelet CA.
+func AllowMissingKubeletCA() Option {
+	return func(o *options) {
+		o.allowMissingKubeletCA = true
+	}
+}
+
+// New returns a new instance of Ignition. The MachineConfig resources are read with the given context, so a caller
+// supplied deadline bounds the construction, failing with ErrListTimedOut once it expires.
+func New(ctx context.Context, c client.Client, opts ...Option) (*Ignition, error) {
+	o := options{poolName: workerPoolName}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ign := &Ignition{poolName: o.poolName, allowMissingKubeletCA: o.allowMissingKubeletCA}
+	if _, err := ign.Refresh(ctx, c); err != nil {
+		return nil, err
+	}
+	// a missing cloud config is only fatal on the platforms which require it, which is decided by the caller
+	if _, err := ign.ValidateCloudConfig(); err != nil {
+		ctrl.Log.WithName("ignition").Error(err, "invalid cloud config")
+	}
+	return ign, nil
+}
+
+// Refresh selects the rendered MachineConfig and kubelet CA again, re-parsing the ignition spec only if the rendered
+// MachineConfig name or resourceVersion differ from the ones it was last parsed from. Returns true if the ignition
+// spec or the kubelet CA changed. Refresh is safe to call concurrently with the getters.
+func (ign *Ignition) Refresh(ctx context.Context, c client.Client) (bool, error) {
 	log := ctrl.Log.WithName("ignition")
-	machineConfigs := &mcfg.MachineConfigList{}
-	err := c.List(context.TODO(), machineConfigs)
+	source := RenderedConfigSourcePoolSpec
+	configurationName, err := getPoolConfigurationName(ctx, c, ign.poolName)
+	if errors.Is(err, ErrListTimedOut) {
+		return false, err
+	}
 	if err != nil {
-		return nil, err
+		log.Info("falling back to the latest rendered MachineConfig", "machineconfigpool", ign.poolName,
+			"reason", err.Error())
+		source = RenderedConfigSourceCreationTimestamp
 	}
-	renderedWorker, err := getLatestRenderedWorker(machineConfigs.Items)
+	renderedWorker, err := getRenderedMachineConfig(ctx, c, renderedPrefix+ign.poolName+"-", configurationName)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	ccList := mcfg.ControllerConfigList{}
+	if err := c.List(ctx, &ccList); err != nil {
+		return false, listError(ctx, err, "error listing ControllerConfigs")
+	}
+	kubeletCAData, err := getKubeletCAData(ccList.Items)
+	if err != nil {
+		if !ign.allowMissingKubeletCA || !errors.Is(err, ErrKubeletCANotFound) {
+			return false, err
+		}
+		log.Error(err, "continuing without kubelet CA")
+	}
+	imageRegistryCAs := getImageRegistryCAs(ccList.Items)
+
+	ign.lock.RLock()
+	configChanged := renderedWorker.GetName() != ign.renderedConfigName ||
+		renderedWorker.GetResourceVersion() != ign.renderedConfigResourceVersion
+	kubeletCAChanged := !bytes.Equal(kubeletCAData, ign.kubeletCAData)
+	caChanged := kubeletCAChanged || !maps.EqualFunc(imageRegistryCAs, ign.imageRegistryCAs, bytes.Equal)
+	ign.lock.RUnlock()
+
+	var kubeletCACertificates []*x509.Certificate
+	if kubeletCAChanged {
+		kubeletCACertificates = getKubeletCACertificates(kubeletCAData)
+	}
+
+	var configuration ignCfgTypes.Config
+	var parseWarnings []string
+	var configHash string
+	if configChanged {
+		configHash = fmt.Sprintf("%x", sha256.Sum256(renderedWorker.Spec.Config.Raw))
+		var version string
+		configLog := log.WithValues("machineconfig", renderedWorker.GetName())
+		configuration, version, parseWarnings, err = parseConfig(renderedWorker.Spec.Config.Raw, configLog)
+		if err != nil {
+			return false, err
+		}
+		configLog.V(1).Info("parsed", "source", source, "ignition version", version,
+			"using ignition version", configuration.Ignition.Version)
+		if len(parseWarnings) > 0 {
+			configLog.Info("ignition parsed with warnings", "count", len(parseWarnings))
+		}
 	}
 
-	configuration, report, err := ignCfg.ParseCompatibleVersion(renderedWorker.Spec.Config.Raw)
+	ign.lock.Lock()
+	defer ign.lock.Unlock()
+	if configChanged {
+		// updates of the metadata of the rendered MachineConfig leave the ignition spec as is
+		configChanged = configHash != ign.configHash
+		ign.config = configuration
+		ign.parseWarnings = parseWarnings
+		ign.renderedConfigName = renderedWorker.GetName()
+		ign.renderedConfigResourceVersion = renderedWorker.GetResourceVersion()
+		ign.renderedConfigGeneration = renderedWorker.GetGeneration()
+		ign.configHash = configHash
+	}
+	ign.renderedConfigSource = source
+	// set kubelet-ca raw data
+	if kubeletCAChanged {
+		ign.kubeletCAData = kubeletCAData
+		ign.kubeletCACertificates = kubeletCACertificates
+	}
+	ign.imageRegistryCAs = imageRegistryCAs
+	return configChanged || caChanged, nil
+}
+
+// parseConfig parses the given raw ignition spec, translating specs of older versions to the supported version. Gzip
+// compressed specs are decompressed, and the configs referenced by ignition.config.replace and ignition.config.merge
+// are resolved as ignition would, as long as they are embedded as data URLs. Returns the version of the given spec,
+// along with the non-fatal entries of the parsing reports, each of which is logged with the given logger at V(1).
+func parseConfig(raw []byte, log logr.Logger) (ignCfgTypes.Config, string, []string, error) {
+	return parseConfigAtDepth(raw, log, 0)
+}
+
+// parseConfigAtDepth parses the given raw ignition spec, which is referenced through the given number of
+// ignition.config directives, as described by parseConfig
+func parseConfigAtDepth(raw []byte, log logr.Logger, depth int) (ignCfgTypes.Config, string, []string, error) {
+	if bytes.HasPrefix(raw, gzipMagic) {
+		var err error
+		if raw, err = decompress(raw); err != nil {
+			return ignCfgTypes.Config{}, "", nil, fmt.Errorf("failed to decompress MachineConfig ignition: %w", err)
+		}
+	}
+	version, _, err := ignCfgUtil.GetConfigVersion(raw)
+	if err != nil {
+		return ignCfgTypes.Config{}, "", nil, fmt.Errorf("failed to detect MachineConfig ignition version: %w", err)
+	}
+	configuration, report, err := ignCfg.ParseCompatibleVersion(raw)
 	if err != nil || report.IsFatal() {
-		return nil, fmt.Errorf("failed to parse MachineConfig ignition: %v\nReport: %v", err, report)
+		return ignCfgTypes.Config{}, "", nil, fmt.Errorf("failed to parse MachineConfig ignition version %s: %v\n"+
+			"Report: %v", version.String(), err, report)
 	}
-	ign := &Ignition{
-		config: configuration,
+	var warnings []string
+	for _, entry := range report.Entries {
+		log.V(1).Info("ignition parse report entry", "kind", entry.Kind.String(), "path", entry.Context.String(),
+			"message", entry.Message)
+		warnings = append(warnings, entry.String())
 	}
-	log.V(1).Info("parsed", "machineconfig", renderedWorker.GetName(), "using ignition version",
-		configuration.Ignition.Version)
+	configuration, referenceWarnings, err := resolveConfigReferences(configuration, log, depth)
+	if err != nil {
+		return ignCfgTypes.Config{}, "", nil, err
+	}
+	return configuration, version.String(), append(warnings, referenceWarnings...), nil
+}
 
-	ccList := mcfg.ControllerConfigList{}
-	if err := c.List(context.TODO(), &ccList); err != nil {
-		return nil, err
+// resolveConfigReferences returns the given config with the configs referenced by its ignition.config section
+// applied. As done by ignition, a replacement config takes the place of the given config, ignoring its merges, while
+// the configs to be merged are merged in order on top of the given config. Only configs embedded as data URLs are
+// supported, as remote sources cannot be fetched from the cluster. Returns the warnings of parsing the referenced
+// configs.
+func resolveConfigReferences(configuration ignCfgTypes.Config, log logr.Logger,
+	depth int) (ignCfgTypes.Config, []string, error) {
+	references := configuration.Ignition.Config
+	if references.Replace.Source == nil && len(references.Merge) == 0 {
+		return configuration, nil, nil
+	}
+	if depth >= maxConfigReferenceDepth {
+		return ignCfgTypes.Config{}, nil, fmt.Errorf("ignition configs referenced through more than %d levels",
+			maxConfigReferenceDepth)
+	}
+	if references.Replace.Source != nil {
+		replacement, _, warnings, err := parseReferencedConfig(references.Replace, log, depth+1)
+		if err != nil {
+			return ignCfgTypes.Config{}, nil, fmt.Errorf("error resolving ignition.config.replace: %w", err)
+		}
+		return replacement, warnings, nil
+	}
+	configuration.Ignition.Config = ignCfgTypes.IgnitionConfig{}
+	var warnings []string
+	for i, reference := range references.Merge {
+		child, _, childWarnings, err := parseReferencedConfig(reference, log, depth+1)
+		if err != nil {
+			return ignCfgTypes.Config{}, nil, fmt.Errorf("error resolving ignition.config.merge.%d: %w", i, err)
+		}
+		configuration = ignCfg.Merge(configuration, child)
+		warnings = append(warnings, childWarnings...)
+	}
+	return configuration, warnings, nil
+}
+
+// parseReferencedConfig parses the config embedded within the given resource, referenced through the given number of
+// ignition.config directives
+func parseReferencedConfig(reference ignCfgTypes.Resource, log logr.Logger,
+	depth int) (ignCfgTypes.Config, string, []string, error) {
+	raw, err := decodeFileContents(reference)
+	if err != nil {
+		return ignCfgTypes.Config{}, "", nil, err
+	}
+	return parseConfigAtDepth(raw, log, depth)
+}
+
+// getKubeletCAData returns the kubelet CA raw data from the given ControllerConfigs. The ControllerConfig with the
+// well-known name is used if present. Otherwise, all ControllerConfigs with kubelet CA data must agree on it.
+func getKubeletCAData(controllerConfigs []mcfg.ControllerConfig) ([]byte, error) {
+	for _, item := range controllerConfigs {
+		if item.Name == controllerConfigName {
+			return validateKubeletCAData(item.Spec.KubeAPIServerServingCAData, item.Name)
+		}
 	}
 	var kubeletCAData []byte
-	for _, item := range ccList.Items {
-		if item.Spec.KubeAPIServerServingCAData != nil {
-			log.V(1).Info("processing kubelet-ca", "ControllerConfig", item.Name)
-			kubeletCAData = item.Spec.KubeAPIServerServingCAData
-			break
+	var candidates []string
+	for _, item := range controllerConfigs {
+		if len(item.Spec.KubeAPIServerServingCAData) == 0 {
+			continue
+		}
+		candidates = append(candidates, item.Name)
+		if len(kubeletCAData) > 0 && !bytes.Equal(kubeletCAData, item.Spec.KubeAPIServerServingCAData) {
+			return nil, fmt.Errorf("ControllerConfigs %s have differing kubelet-ca", strings.Join(candidates, ", "))
+		}
+		kubeletCAData = item.Spec.KubeAPIServerServingCAData
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w in any ControllerConfig", ErrKubeletCANotFound)
+	}
+	return validateKubeletCAData(kubeletCAData, candidates...)
+}
+
+// getKubeletCACertificates returns the distinct certificates of the given kubelet CA raw data, in the order they
+// appear in. Blocks which are not valid certificates are skipped with a warning.
+func getKubeletCACertificates(kubeletCAData []byte) []*x509.Certificate {
+	certs, err := parseCertificates(kubeletCAData)
+	if err != nil {
+		ctrl.Log.WithName("ignition").Error(err, "skipping invalid kubelet CA blocks")
+	}
+	var distinct []*x509.Certificate
+	for _, cert := range certs {
+		if !slices.ContainsFunc(distinct, cert.Equal) {
+			distinct = append(distinct, cert)
 		}
 	}
+	return distinct
+}
+
+// validateKubeletCAData returns the given kubelet CA raw data of the given ControllerConfigs if it contains at least
+// one valid PEM encoded certificate
+func validateKubeletCAData(kubeletCAData []byte, controllerConfigs ...string) ([]byte, error) {
+	names := strings.Join(controllerConfigs, ", ")
 	if len(kubeletCAData) == 0 {
-		return nil, fmt.Errorf("cannot find kubelet-ca")
+		return nil, fmt.Errorf("%w in ControllerConfig %s", ErrKubeletCANotFound, names)
 	}
-	// set kubelet-ca raw data
-	ign.kubeletCAData = kubeletCAData
+	if certs, _ := parseCertificates(kubeletCAData); len(certs) == 0 {
+		return nil, fmt.Errorf("kubelet-ca of ControllerConfig %s contains no valid PEM encoded certificate", names)
+	}
+	ctrl.Log.WithName("ignition").V(1).Info("processing kubelet-ca", "ControllerConfig", names)
+	return kubeletCAData, nil
+}
 
-	return ign, nil
+// getImageRegistryCAs returns the image registry CAs of the given ControllerConfigs, keyed by registry host. The
+// ControllerConfig with the well-known name is used if present, otherwise the CAs of all ControllerConfigs are merged.
+// The additional trust bundle is trusted for all registries, and is keyed by DefaultRegistryHost.
+func getImageRegistryCAs(controllerConfigs []mcfg.ControllerConfig) map[string][]byte {
+	if i := slices.IndexFunc(controllerConfigs, func(cc mcfg.ControllerConfig) bool {
+		return cc.Name == controllerConfigName
+	}); i != -1 {
+		controllerConfigs = controllerConfigs[i : i+1]
+	}
+	certsByHost := make(map[string][]*x509.Certificate)
+	for _, cc := range controllerConfigs {
+		addRegistryCerts(certsByHost, DefaultRegistryHost, cc.Spec.AdditionalTrustBundle, cc.Name)
+		for _, bundles := range [][]mcfg.ImageRegistryBundle{cc.Spec.ImageRegistryBundleData,
+			cc.Spec.ImageRegistryBundleUserData} {
+			for _, bundle := range bundles {
+				// the MCO replaces the colon separating the host from the port with two dots within file names
+				addRegistryCerts(certsByHost, strings.ReplaceAll(bundle.File, "..", ":"), bundle.Data, cc.Name)
+			}
+		}
+	}
+	imageRegistryCAs := make(map[string][]byte)
+	for host, certs := range certsByHost {
+		var data []byte
+		for _, cert := range certs {
+			data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+		}
+		imageRegistryCAs[host] = data
+	}
+	return imageRegistryCAs
+}
+
+// addRegistryCerts adds the certificates parsed from the given PEM encoded data to the ones of the given host, skipping
+// duplicates. Data which cannot be parsed is skipped with a warning.
+func addRegistryCerts(certsByHost map[string][]*x509.Certificate, host string, data []byte, controllerConfig string) {
+	if len(data) == 0 {
+		return
+	}
+	certs, err := parseCertificates(data)
+	if err != nil {
+		ctrl.Log.WithName("ignition").Error(err, "skipping invalid image registry CA", "host", host,
+			"ControllerConfig", controllerConfig)
+	}
+	for _, cert := range certs {
+		if !slices.ContainsFunc(certsByHost[host], cert.Equal) {
+			certsByHost[host] = append(certsByHost[host], cert)
+		}
+	}
+}
+
+// parseCertificates returns the certificates which can be parsed from the given PEM encoded data, along with an error
+// describing the PEM blocks which could not be parsed
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	var errs []error
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			if len(bytes.TrimSpace(data)) > 0 {
+				errs = append(errs, fmt.Errorf("data is not PEM encoded"))
+			}
+			return certs, errors.Join(errs...)
+		}
+		if block.Type != "CERTIFICATE" {
+			errs = append(errs, fmt.Errorf("unexpected PEM block type %s", block.Type))
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		certs = append(certs, cert)
+	}
 }
 
 // GetKubeletCAData is a getter for kubelet CA raw data
 func (ign *Ignition) GetKubeletCAData() []byte {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
 	return ign.kubeletCAData
 }
 
+// HasKubeletCA returns true if the kubelet CA data was found. It is only false for an Ignition constructed with
+// AllowMissingKubeletCA.
+func (ign *Ignition) HasKubeletCA() bool {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
+	return len(ign.kubeletCAData) > 0
+}
+
+// GetSanitizedKubeletCA returns the kubelet CA as a PEM bundle holding only its distinct valid certificates, in order,
+// with LF line endings and without comments. This is the kubelet CA data to write to nodes.
+func (ign *Ignition) GetSanitizedKubeletCA() []byte {
+	var data []byte
+	for _, cert := range ign.GetKubeletCACertificates() {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return data
+}
+
+// GetKubeletCACertificates returns the distinct valid certificates of the kubelet CA, in order. Their NotAfter values
+// can be used to warn about the imminent expiry of the kubelet CA.
+func (ign *Ignition) GetKubeletCACertificates() []*x509.Certificate {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
+	return ign.kubeletCACertificates
+}
+
+// KubeletCAHash returns the hex encoded sha256 hash of the normalized kubelet CA data, which is stable across
+// reorderings of the PEM blocks within the bundle and changes in surrounding whitespace
+func (ign *Ignition) KubeletCAHash() string {
+	return hashCAData(ign.GetKubeletCAData())
+}
+
+// CompareCA returns true if the given previously stored hash matches the hash of the current kubelet CA data, as
+// returned by KubeletCAHash
+func (ign *Ignition) CompareCA(hash string) bool {
+	return hash == ign.KubeletCAHash()
+}
+
+// hashCAData returns the hex encoded sha256 hash of the given PEM encoded CA data. The PEM blocks are re-encoded and
+// sorted, so that bundles containing the same certificates in a different order or with different whitespace hash
+// equal. Any data which is not PEM encoded is hashed with its whitespace stripped.
+func hashCAData(data []byte) string {
+	// strip the whitespace surrounding each line, as PEM blocks are only decoded if they begin at the start of a line
+	lines := bytes.Split(data, []byte("\n"))
+	for i := range lines {
+		lines[i] = bytes.TrimSpace(lines[i])
+	}
+	data = bytes.Join(lines, []byte("\n"))
+	var blocks [][]byte
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, pem.EncodeToMemory(block))
+	}
+	slices.SortFunc(blocks, bytes.Compare)
+	if rest := bytes.Join(bytes.Fields(data), nil); len(rest) > 0 {
+		blocks = append(blocks, rest)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(bytes.Join(blocks, nil)))
+}
+
+// GetImageRegistryCAs returns the PEM encoded CAs trusted for image registries, keyed by registry host, as
+// hostname[:port], following the layout of containerd's certs.d directory. The CAs trusted for all registries are keyed
+// by DefaultRegistryHost.
+func (ign *Ignition) GetImageRegistryCAs() map[string][]byte {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
+	return ign.imageRegistryCAs
+}
+
+// GetParseWarnings returns the non-fatal warnings reported when parsing and translating the ignition spec
+func (ign *Ignition) GetParseWarnings() []string {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
+	return ign.parseWarnings
+}
+
+// GetRenderedConfigName returns the name of the rendered MachineConfig the ignition spec was parsed from
+func (ign *Ignition) GetRenderedConfigName() string {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
+	return ign.renderedConfigName
+}
+
+// GetRenderedConfigGeneration returns the generation of the rendered MachineConfig the ignition spec was parsed from
+func (ign *Ignition) GetRenderedConfigGeneration() int64 {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
+	return ign.renderedConfigGeneration
+}
+
+// GetConfigHash returns the hex encoded sha256 hash of the raw ignition spec of the rendered MachineConfig, which
+// changes only when the ignition spec does, unlike the resourceVersion of the MachineConfig. Along with
+// GetRenderedConfigName and GetRenderedConfigGeneration, it identifies the configuration a node was configured from,
+// and is meant to be recorded in node annotations by nodeconfig so that nodes configured from an outdated rendered
+// MachineConfig can be told apart.
+func (ign *Ignition) GetConfigHash() string {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
+	return ign.configHash
+}
+
+// GetRenderedConfigSource returns how the rendered MachineConfig the ignition spec was parsed from was selected
+func (ign *Ignition) GetRenderedConfigSource() RenderedConfigSource {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
+	return ign.renderedConfigSource
+}
+
 // GetFiles is a getter for the files embedded within the ignition spec
 func (ign *Ignition) GetFiles() []ignCfgTypes.File {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
 	return ign.config.Storage.Files
 }
 
-// GetKubeletArgs returns a set of arguments for kubelet.exe, as specified in the ignition file
-func (ign *Ignition) GetKubeletArgs() (map[string]string, error) {
-	var kubeletUnit ignCfgTypes.Unit
-	for _, unit := range ign.config.Systemd.Units {
-		if unit.Name == kubeletSystemdName {
-			kubeletUnit = unit
-			break
+// FileExists returns true if the ignition spec contains a file at the given path. Paths are compared once cleaned, so
+// a trailing slash is ignored.
+func (ign *Ignition) FileExists(filePath string) bool {
+	filePath = path.Clean(filePath)
+	return slices.ContainsFunc(ign.GetFiles(), func(file ignCfgTypes.File) bool {
+		return path.Clean(file.Node.Path) == filePath
+	})
+}
+
+// FilesWithPrefix returns the files of the ignition spec which are at the given path or beneath it when it is a
+// directory, ordered by path. Paths are compared once cleaned, so /etc/kubernetes and /etc/kubernetes/ both match
+// /etc/kubernetes/kubelet.conf, while neither matches /etc/kubernetes-old/kubelet.conf.
+func (ign *Ignition) FilesWithPrefix(prefix string) []ignCfgTypes.File {
+	prefix = path.Clean(prefix)
+	dir := strings.TrimSuffix(prefix, "/") + "/"
+	return ign.filterFiles(func(filePath string) bool {
+		return filePath == prefix || strings.HasPrefix(filePath, dir)
+	})
+}
+
+// FilesMatching returns the files of the ignition spec whose path matches the given pattern, ordered by path. The
+// pattern syntax is the one of path.Match, such as /etc/kubernetes/manifests/*.yaml, where * does not match slashes.
+func (ign *Ignition) FilesMatching(pattern string) ([]ignCfgTypes.File, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return ign.filterFiles(func(filePath string) bool {
+		// the pattern is known to be valid, so no error can be returned
+		matched, _ := path.Match(pattern, filePath)
+		return matched
+	}), nil
+}
+
+// filterFiles returns the files of the ignition spec whose cleaned path is accepted by the given function, ordered by
+// path
+func (ign *Ignition) filterFiles(accept func(filePath string) bool) []ignCfgTypes.File {
+	var files []ignCfgTypes.File
+	for _, file := range ign.GetFiles() {
+		if accept(path.Clean(file.Node.Path)) {
+			files = append(files, file)
 		}
 	}
-	if kubeletUnit.Contents == nil {
-		return nil, fmt.Errorf("ignition missing kubelet systemd unit file")
+	slices.SortFunc(files, func(a, b ignCfgTypes.File) int {
+		return strings.Compare(a.Node.Path, b.Node.Path)
+	})
+	return files
+}
+
+// GetFileContents returns the decoded contents of the file at the given path within the ignition spec. Only contents
+// embedded as data URLs are supported, and gzip compressed contents are decompressed. ErrFileNotFound is returned if
+// the ignition spec does not contain the file.
+func (ign *Ignition) GetFileContents(path string) ([]byte, error) {
+	for _, file := range ign.GetFiles() {
+		if file.Node.Path == path {
+			contents, err := decodeFileContents(file.Contents)
+			if err != nil {
+				return nil, fmt.Errorf("could not decode %s: %w", path, err)
+			}
+			return contents, nil
+		}
 	}
-	argsFromIgnition, err := parseKubeletArgs(*kubeletUnit.Contents)
+	return nil, fmt.Errorf("%s: %w", path, ErrFileNotFound)
+}
+
+// GetCloudConfigContents returns the decoded contents of the cloud config file within the ignition spec
+func (ign *Ignition) GetCloudConfigContents() ([]byte, error) {
+	return ign.GetFileContents(CloudConfigPath)
+}
+
+// ValidateCloudConfig returns the decoded contents of the cloud config file referenced by the kubelet args, checking
+// the ignition spec contains it. Returns nil contents if the kubelet args do not reference a cloud config file. The
+// returned error wraps ErrFileNotFound if the referenced file is missing, and lists the files which do exist within
+// the directory of the cloud config file.
+func (ign *Ignition) ValidateCloudConfig() ([]byte, error) {
+	kubeletArgs, _, err := ign.GetKubeletArgs()
 	if err != nil {
-		return nil, fmt.Errorf("error parsing kubelet systemd unit args: %w", err)
+		return nil, err
 	}
-	return argsFromIgnition, nil
+	cloudConfigPath, ok := kubeletArgs[CloudConfigOption]
+	if !ok {
+		return nil, nil
+	}
+	contents, err := ign.GetFileContents(cloudConfigPath)
+	if err != nil {
+		if !errors.Is(err, ErrFileNotFound) {
+			return nil, err
+		}
+		dir := path.Dir(cloudConfigPath) + "/"
+		var existing []string
+		for _, file := range ign.FilesWithPrefix(dir) {
+			existing = append(existing, file.Node.Path)
+		}
+		return nil, fmt.Errorf("kubelet arg %s references %s, files within %s are [%s]: %w", CloudConfigOption,
+			cloudConfigPath, dir, strings.Join(existing, ", "), ErrFileNotFound)
+	}
+	return contents, nil
 }
 
-// getLatestRenderedWorker returns the most recently created rendered worker MachineConfig
-func getLatestRenderedWorker(machineConfigs []mcfg.MachineConfig) (*mcfg.MachineConfig, error) {
-	// Grab the latest rendered-worker MachineConfig by sorting the MachineConfig list by the latest creation
-	// timestamp first.
-	sort.Slice(machineConfigs, func(i, j int) bool {
-		iTimestamp := machineConfigs[i].GetCreationTimestamp()
-		jTimestamp := machineConfigs[j].GetCreationTimestamp()
-		return jTimestamp.Before(&iTimestamp)
-	})
-	for _, mc := range machineConfigs {
-		if strings.HasPrefix(mc.Name, RenderedWorkerPrefix) {
-			if len(mc.Spec.Config.Raw) == 0 {
-				continue
-			}
-			return &mc, nil
-		}
-	}
-	return nil, fmt.Errorf("rendered worker MachineConfig not found")
-}
-
-// parseKubeletArgs parses a systemd unit file, returning the kubelet args WMCO is interested in
-func parseKubeletArgs(unitContents string) (map[string]string, error) {
-	// Remove everything before the ExecStart section of the unit file, which contains the command and args of the unit.
-	// See unit test file for example systemd unit file
-	execSplit := strings.SplitN(unitContents, "ExecStart=", 2)
-	if len(execSplit) != 2 {
-		return nil, fmt.Errorf("unit missing ExecStart")
-	}
-	// The ExecStart section is completed with a double newline, so using this as a split string, we can reduce the
-	// scope of what we are looking at to everything inside the ExecStart section.
-	cmdEndSplit := strings.SplitN(execSplit[1], "\n\n", 2)
-	// Each part of the command is separated by an escaped newline
-	argumentSplit := strings.Split(cmdEndSplit[0], "\\\n")
-	kubeletArgs := make(map[string]string)
-	// Skipping the first line, which indicates the binary, look at all the arguments which are key value pairs.
-	// As WMCO currently is, we don't need to find any flags (--windows-service, for example), so we can ignore that
-	// case. If there was a need for that, this logic would need to be expanded to cover that.
-	windowsArgs := []string{CloudProviderOption, CloudConfigOption}
-	for _, arg := range argumentSplit[1:] {
-		arg = strings.TrimSpace(arg)
-		arg = strings.TrimPrefix(arg, "--")
-		keyValue := strings.SplitN(arg, "=", 2)
-		if len(keyValue) != 2 {
-			// Not a key value pair, continue
+// decodeFileContents returns the data of the given file contents, which must be a data URL
+func decodeFileContents(contents ignCfgTypes.Resource) ([]byte, error) {
+	if contents.Source == nil {
+		return nil, fmt.Errorf("file is empty")
+	}
+	if !strings.HasPrefix(*contents.Source, "data:") {
+		return nil, fmt.Errorf("contents source is not a data URL, remote sources are not supported")
+	}
+	decoded, err := dataurl.DecodeString(*contents.Source)
+	if err != nil {
+		return nil, err
+	}
+	if contents.Compression == nil || *contents.Compression == "" {
+		return decoded.Data, nil
+	}
+	if *contents.Compression != gzipCompression {
+		return nil, fmt.Errorf("unsupported compression %q", *contents.Compression)
+	}
+	data, err := decompress(decoded.Data)
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress contents: %w", err)
+	}
+	return data, nil
+}
+
+// decompress returns the given gzip compressed data decompressed
+func decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// GetKubeletArgs returns the arguments of the kubelet, as specified in the ignition file, keyed by flag name. Flags
+// without a value are returned with an empty value. FilterArgs can be used to select the arguments relevant to
+// kubelet.exe. See GetUnitArgs for how variables referenced by the arguments are resolved.
+func (ign *Ignition) GetKubeletArgs() (map[string]string, []string, error) {
+	return ign.GetUnitArgs(kubeletSystemdName)
+}
+
+// getPoolConfigurationName returns the name of the rendered MachineConfig the given MachineConfigPool is rolling out.
+// The spec names the configuration the pool has adopted, while a more recently created rendered MachineConfig may not
+// have been adopted yet, and the status lags behind until all of the pool's nodes have been updated.
+func getPoolConfigurationName(ctx context.Context, c client.Client, poolName string) (string, error) {
+	pools := &mcfg.MachineConfigPoolList{}
+	if err := c.List(ctx, pools); err != nil {
+		return "", listError(ctx, err, "error listing MachineConfigPools")
+	}
+	for _, pool := range pools.Items {
+		if pool.GetName() != poolName {
 			continue
 		}
-		for _, windowsArg := range windowsArgs {
-			if windowsArg == keyValue[0] {
-				kubeletArgs[keyValue[0]] = keyValue[1]
-			}
+		if pool.Spec.Configuration.Name == "" {
+			return "", fmt.Errorf("MachineConfigPool %s has no rendered configuration", poolName)
+		}
+		return pool.Spec.Configuration.Name, nil
+	}
+	return "", fmt.Errorf("MachineConfigPool %s not found", poolName)
+}
+
+// getRenderedMachineConfig returns the rendered MachineConfig with the given name. If name is empty, the most recently
+// created MachineConfig with the given prefix is returned instead.
+func getRenderedMachineConfig(ctx context.Context, c client.Client, prefix,
+	name string) (*mcfg.MachineConfig, error) {
+	if name == "" {
+		machineConfigs := &mcfg.MachineConfigList{}
+		// the MachineConfigs are only read to select one of them, which is copied, so the listed objects are not
+		// copied out of the cache
+		if err := c.List(ctx, machineConfigs, client.UnsafeDisableDeepCopy); err != nil {
+			return nil, listError(ctx, err, "error listing MachineConfigs")
+		}
+		return getLatestRendered(machineConfigs.Items, prefix)
+	}
+	mc := &mcfg.MachineConfig{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, mc); err != nil {
+		if k8sapierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: %s", ErrRenderedConfigNotFound, name)
+		}
+		return nil, listError(ctx, err, "error getting rendered MachineConfig "+name)
+	}
+	if len(mc.Spec.Config.Raw) == 0 {
+		return nil, fmt.Errorf("rendered MachineConfig %s has no ignition spec", name)
+	}
+	return mc, nil
+}
+
+// getLatestRendered returns a copy of the most recently created rendered MachineConfig with the given prefix. The given
+// MachineConfigs are neither modified nor retained.
+func getLatestRendered(machineConfigs []mcfg.MachineConfig, prefix string) (*mcfg.MachineConfig, error) {
+	var latest *mcfg.MachineConfig
+	for i := range machineConfigs {
+		mc := &machineConfigs[i]
+		if !strings.HasPrefix(mc.Name, prefix) || len(mc.Spec.Config.Raw) == 0 {
+			continue
+		}
+		if latest == nil || latest.CreationTimestamp.Before(&mc.CreationTimestamp) {
+			latest = mc
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("%w with prefix %s", ErrRenderedConfigNotFound, prefix)
+	}
+	return latest.DeepCopy(), nil
+}
+
+// listError wraps the given error of reading MachineConfig resources, with ErrListTimedOut if the given context
+// expired or the request itself timed out
+func listError(ctx context.Context, err error, msg string) error {
+	if ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s: %w", ErrListTimedOut, msg, err)
+	}
+	return fmt.Errorf("%s: %w", msg, err)
+}
+
+// FilterArgs returns the arguments of the given set with one of the given names
+func FilterArgs(args map[string]string, names ...string) map[string]string {
+	filtered := make(map[string]string)
+	for _, name := range names {
+		if value, ok := args[name]; ok {
+			filtered[name] = value
+		}
+	}
+	return filtered
+}
+
+// ExcludeArgs returns the arguments of the given set without the ones with one of the given names
+func ExcludeArgs(args map[string]string, names ...string) map[string]string {
+	excluded := make(map[string]string)
+	for name, value := range args {
+		if !slices.Contains(names, name) {
+			excluded[name] = value
 		}
 	}
-	return kubeletArgs, nil
+	return excluded
 }
@@ -1,10 +1,36 @@
 package ignition
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/go-logr/logr"
+	mcfg "github.com/openshift/api/machineconfiguration/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clientfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 )
 
 func TestParseKubeletArgs(t *testing.T) {
@@ -51,11 +77,1368 @@ RestartSec=10
 [Install]
 WantedBy=multi-user.target
 `
-	args, err := parseKubeletArgs(unitContents)
+	args, unresolved, err := parseUnitArgs(nil, unitContents)
 	require.NoError(t, err)
+	assert.Equal(t, []string{"ID", "KUBELET_LOG_LEVEL", "KUBELET_NODE_IP", "KUBELET_NODE_NAME", "KUBELET_PROVIDERID",
+		"SYSTEM_RESERVED_CPU", "SYSTEM_RESERVED_MEMORY"}, unresolved)
 	require.Contains(t, args, CloudProviderOption)
 	assert.Equal(t, "azure", args[CloudProviderOption])
 	require.Contains(t, args, CloudConfigOption)
 	assert.Equal(t, "/etc/kubernetes/cloud.conf", args[CloudConfigOption])
+	assert.Equal(t, "${KUBELET_NODE_IP}", args["node-ip"])
+	assert.Equal(t, "${KUBELET_LOG_LEVEL}", args["v"])
 
 }
+
+func TestParseKubeletArgsUnitSyntax(t *testing.T) {
+	testCases := []struct {
+		name         string
+		unitContents string
+		expected     map[string]string
+		expectedErr  bool
+	}{
+		{
+			name: "trailing whitespace after continuation",
+			unitContents: "[Service]\nType=notify\nExecStart=/usr/bin/kubelet \\  \n" +
+				"      --cloud-provider=aws \\\t\n      --cloud-config=/etc/kubernetes/cloud.conf \\ \n" +
+				"      --v=2\n\nRestart=always\n",
+			expected: map[string]string{CloudProviderOption: "aws", CloudConfigOption: "/etc/kubernetes/cloud.conf"},
+		},
+		{
+			name: "comments",
+			unitContents: `# kubelet unit rendered by the MCO
+[Service]
+; the command is wrapped
+ExecStart=/usr/local/bin/kubenswrapper \
+    /usr/bin/kubelet \
+# cloud provider configuration
+      --cloud-provider=azure \
+      ; cloud config
+      --cloud-config=/etc/kubernetes/cloud.conf \
+      --v=2
+`,
+			expected: map[string]string{CloudProviderOption: "azure", CloudConfigOption: "/etc/kubernetes/cloud.conf"},
+		},
+		{
+			name: "reset and set ExecStart",
+			unitContents: `[Service]
+ExecStart=/usr/bin/kubelet --cloud-provider=gce
+ExecStart=
+ExecStart=/usr/bin/kubelet \
+      --cloud-provider=external \
+      --cloud-config=/etc/kubernetes/cloud.conf
+`,
+			expected: map[string]string{CloudProviderOption: "external", CloudConfigOption: "/etc/kubernetes/cloud.conf"},
+		},
+		{
+			name: "quoted arguments",
+			unitContents: `[Service]
+ExecStart=/usr/bin/kubelet \
+      --node-labels="node-role.kubernetes.io/worker,node.openshift.io/os_id=${ID}" \
+      "--cloud-config=/etc/kubernetes/cloud config.conf" \
+      --cloud-provider='vsphere'
+`,
+			expected: map[string]string{CloudProviderOption: "vsphere",
+				CloudConfigOption: "/etc/kubernetes/cloud config.conf"},
+		},
+		{
+			name:         "ExecStart in another section",
+			unitContents: "[Unit]\nExecStart=/usr/bin/kubelet --cloud-provider=aws\n[Service]\nType=notify\n",
+			expectedErr:  true,
+		},
+		{
+			name:         "ExecStart reset",
+			unitContents: "[Service]\nExecStart=/usr/bin/kubelet --cloud-provider=aws\nExecStart=\n",
+			expectedErr:  true,
+		},
+		{
+			name:         "unterminated quote",
+			unitContents: "[Service]\nExecStart=/usr/bin/kubelet \"--cloud-provider=aws\n",
+			expectedErr:  true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			args, _, err := parseUnitArgs(nil, test.unitContents)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, FilterArgs(args, CloudProviderOption, CloudConfigOption))
+		})
+	}
+}
+
+func TestParseKubeletArgsFlags(t *testing.T) {
+	unitContents := `[Service]
+ExecStart=/usr/local/bin/kubenswrapper \
+    /usr/bin/kubelet \
+      --config=/etc/kubernetes/kubelet.conf \
+      --register-with-taints node-role.kubernetes.io/infra=reserved:NoSchedule \
+      --fail-swap-on \
+      -v=2 \
+      --cloud-provider=aws \
+      --node-labels= \
+      --anonymous-auth
+`
+	args, unresolved, err := parseUnitArgs(nil, unitContents)
+	require.NoError(t, err)
+	assert.Empty(t, unresolved)
+	assert.Equal(t, map[string]string{
+		"config":               "/etc/kubernetes/kubelet.conf",
+		"register-with-taints": "node-role.kubernetes.io/infra=reserved:NoSchedule",
+		"fail-swap-on":         "",
+		"v":                    "2",
+		CloudProviderOption:    "aws",
+		"node-labels":          "",
+		"anonymous-auth":       "",
+	}, args)
+}
+
+func TestGetKubeletArgsDropins(t *testing.T) {
+	unitContents := `[Unit]
+Description=Kubernetes Kubelet
+
+[Service]
+Type=notify
+Environment="CLOUD_PROVIDER=azure"
+ExecStart=/usr/bin/kubelet \
+      --config=/etc/kubernetes/kubelet.conf \
+      --cloud-provider=${CLOUD_PROVIDER} \
+      --cloud-config=/etc/kubernetes/cloud.conf \
+      --node-ip=${KUBELET_NODE_IP}
+
+[Install]
+WantedBy=multi-user.target
+`
+	overrideExecStart := `[Service]
+ExecStart=
+ExecStart=/usr/bin/kubelet \
+      --config=/etc/kubernetes/kubelet.conf \
+      --cloud-provider=$CLOUD_PROVIDER \
+      --cloud-config=${CLOUD_CONFIG} \
+      --node-ip=${KUBELET_NODE_IP}
+`
+	testCases := []struct {
+		name        string
+		dropins     []ignCfgTypes.Dropin
+		expected    map[string]string
+		expectedErr bool
+	}{
+		{
+			name: "no drop-ins",
+			expected: map[string]string{CloudProviderOption: "azure", CloudConfigOption: "/etc/kubernetes/cloud.conf",
+				"config": "/etc/kubernetes/kubelet.conf", "node-ip": "${KUBELET_NODE_IP}"},
+		},
+		{
+			name: "drop-in overriding the cloud provider",
+			dropins: []ignCfgTypes.Dropin{
+				{Name: "10-cloud-provider.conf", Contents: ptr.To("[Service]\nEnvironment=\"CLOUD_PROVIDER=external\"\n")},
+			},
+			expected: map[string]string{CloudProviderOption: "external", CloudConfigOption: "/etc/kubernetes/cloud.conf",
+				"config": "/etc/kubernetes/kubelet.conf", "node-ip": "${KUBELET_NODE_IP}"},
+		},
+		{
+			name: "drop-ins applied in name order",
+			dropins: []ignCfgTypes.Dropin{
+				{Name: "20-cloud-config.conf", Contents: ptr.To("[Service]\nEnvironment=CLOUD_CONFIG=/etc/cloud.conf\n")},
+				{Name: "30-exec-start.conf", Contents: ptr.To(overrideExecStart)},
+				{Name: "10-environment.conf", Contents: ptr.To("[Service]\nEnvironment=\"CLOUD_PROVIDER=gce\" " +
+					"CLOUD_CONFIG=/etc/kubernetes/gce.conf\n")},
+				{Name: "15-empty.conf"},
+			},
+			expected: map[string]string{CloudProviderOption: "gce", CloudConfigOption: "/etc/cloud.conf",
+				"config": "/etc/kubernetes/kubelet.conf", "node-ip": "${KUBELET_NODE_IP}"},
+		},
+		{
+			name: "drop-in resetting the environment",
+			dropins: []ignCfgTypes.Dropin{
+				{Name: "10-reset.conf", Contents: ptr.To("[Service]\nEnvironment=\n")},
+			},
+			expected: map[string]string{CloudProviderOption: "${CLOUD_PROVIDER}",
+				CloudConfigOption: "/etc/kubernetes/cloud.conf", "config": "/etc/kubernetes/kubelet.conf",
+				"node-ip": "${KUBELET_NODE_IP}"},
+		},
+		{
+			name: "drop-in resetting ExecStart",
+			dropins: []ignCfgTypes.Dropin{
+				{Name: "10-reset.conf", Contents: ptr.To("[Service]\nExecStart=\n")},
+			},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{config: ignCfgTypes.Config{Systemd: ignCfgTypes.Systemd{Units: []ignCfgTypes.Unit{
+				{Name: "crio.service", Contents: ptr.To("[Service]\nExecStart=/usr/bin/crio\n")},
+				{Name: kubeletSystemdName, Contents: ptr.To(unitContents), Dropins: test.dropins},
+			}}}}
+			args, _, err := ign.GetKubeletArgs()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, args)
+		})
+	}
+}
+
+func TestGetKubeletArgsEnvironment(t *testing.T) {
+	unitContents := `[Service]
+Environment="CLOUD_PROVIDER=azure" "CLOUD_CONFIG=/etc/kubernetes/azure.conf"
+EnvironmentFile=/etc/os-release
+EnvironmentFile=-/etc/kubernetes/kubelet-env
+EnvironmentFile=-/etc/kubernetes/kubelet-workaround
+ExecStart=/usr/bin/kubelet \
+      --cloud-provider=${CLOUD_PROVIDER} \
+      --cloud-config=${CLOUD_CONFIG} \
+      --node-labels=node.openshift.io/os_id=${ID} \
+      --node-ip=${KUBELET_NODE_IP}
+`
+	kubeletEnv := `# set by the installer
+CLOUD_CONFIG="/etc/kubernetes/cloud.conf"
+
+KUBELET_NODE_IP = '10.0.0.4'
+`
+	testCases := []struct {
+		name               string
+		files              []ignCfgTypes.File
+		expected           map[string]string
+		expectedUnresolved []string
+		expectedErr        bool
+	}{
+		{
+			name: "no environment files",
+			expected: map[string]string{CloudProviderOption: "azure", CloudConfigOption: "/etc/kubernetes/azure.conf",
+				"node-labels": "node.openshift.io/os_id=${ID}", "node-ip": "${KUBELET_NODE_IP}"},
+			expectedUnresolved: []string{"ID", "KUBELET_NODE_IP"},
+		},
+		{
+			name: "environment file overriding Environment",
+			files: []ignCfgTypes.File{
+				{
+					Node: ignCfgTypes.Node{Path: "/etc/kubernetes/kubelet-env"},
+					FileEmbedded1: ignCfgTypes.FileEmbedded1{Contents: ignCfgTypes.Resource{
+						Source: ptr.To("data:," + url.PathEscape(kubeletEnv))}},
+				},
+			},
+			expected: map[string]string{CloudProviderOption: "azure", CloudConfigOption: "/etc/kubernetes/cloud.conf",
+				"node-labels": "node.openshift.io/os_id=${ID}", "node-ip": "10.0.0.4"},
+			expectedUnresolved: []string{"ID"},
+		},
+		{
+			name: "undecodable environment file",
+			files: []ignCfgTypes.File{
+				{
+					Node: ignCfgTypes.Node{Path: "/etc/kubernetes/kubelet-workaround"},
+					FileEmbedded1: ignCfgTypes.FileEmbedded1{Contents: ignCfgTypes.Resource{
+						Source: ptr.To("https://example.com/kubelet-workaround")}},
+				},
+			},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{config: ignCfgTypes.Config{
+				Storage: ignCfgTypes.Storage{Files: test.files},
+				Systemd: ignCfgTypes.Systemd{Units: []ignCfgTypes.Unit{
+					{Name: kubeletSystemdName, Contents: ptr.To(unitContents)},
+				}},
+			}}
+			args, unresolved, err := ign.GetKubeletArgs()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, args)
+			assert.Equal(t, test.expectedUnresolved, unresolved)
+		})
+	}
+}
+
+func TestFilterArgs(t *testing.T) {
+	args := map[string]string{
+		CloudProviderOption: "external",
+		"fail-swap-on":      "",
+		"v":                 "2",
+	}
+	testCases := []struct {
+		name     string
+		names    []string
+		expected map[string]string
+	}{
+		{
+			name:     "cloud options",
+			names:    []string{CloudProviderOption, CloudConfigOption},
+			expected: map[string]string{CloudProviderOption: "external"},
+		},
+		{
+			name:     "flag without value",
+			names:    []string{"fail-swap-on"},
+			expected: map[string]string{"fail-swap-on": ""},
+		},
+		{
+			name:     "no names",
+			expected: map[string]string{},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, FilterArgs(args, test.names...))
+		})
+	}
+}
+
+func TestGetFileContents(t *testing.T) {
+	cloudConf := "[Global]\nzone = \"us-east-1a\"\n"
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte(cloudConf))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	gzipBase64 := "data:;base64," + base64.StdEncoding.EncodeToString(compressed.Bytes())
+
+	testCases := []struct {
+		name        string
+		contents    ignCfgTypes.Resource
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "plain",
+			contents: ignCfgTypes.Resource{Source: ptr.To("data:," + url.PathEscape(cloudConf))},
+			expected: cloudConf,
+		},
+		{
+			name: "base64",
+			contents: ignCfgTypes.Resource{Source: ptr.To("data:text/plain;charset=utf-8;base64," +
+				base64.StdEncoding.EncodeToString([]byte(cloudConf)))},
+			expected: cloudConf,
+		},
+		{
+			name:     "gzip and base64",
+			contents: ignCfgTypes.Resource{Source: ptr.To(gzipBase64), Compression: ptr.To("gzip")},
+			expected: cloudConf,
+		},
+		{
+			name:     "empty compression",
+			contents: ignCfgTypes.Resource{Source: ptr.To("data:,zone"), Compression: ptr.To("")},
+			expected: "zone",
+		},
+		{
+			name:        "no source",
+			contents:    ignCfgTypes.Resource{},
+			expectedErr: true,
+		},
+		{
+			name:        "remote source",
+			contents:    ignCfgTypes.Resource{Source: ptr.To("https://example.com/cloud.conf")},
+			expectedErr: true,
+		},
+		{
+			name:        "unsupported compression",
+			contents:    ignCfgTypes.Resource{Source: ptr.To("data:,zone"), Compression: ptr.To("xz")},
+			expectedErr: true,
+		},
+		{
+			name:        "gzip compression of uncompressed data",
+			contents:    ignCfgTypes.Resource{Source: ptr.To("data:,zone"), Compression: ptr.To("gzip")},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			file := ignCfgTypes.File{Node: ignCfgTypes.Node{Path: CloudConfigPath}}
+			file.Contents = test.contents
+			ign := &Ignition{config: ignCfgTypes.Config{Storage: ignCfgTypes.Storage{Files: []ignCfgTypes.File{file}}}}
+			contents, err := ign.GetCloudConfigContents()
+			if test.expectedErr {
+				assert.Error(t, err)
+				assert.NotErrorIs(t, err, ErrFileNotFound)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(contents))
+		})
+	}
+
+	// a file missing from the ignition spec is distinguished from one which cannot be decoded
+	ign := &Ignition{}
+	_, err = ign.GetFileContents(CloudConfigPath)
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestFileListing(t *testing.T) {
+	ign := &Ignition{config: ignCfgTypes.Config{Storage: ignCfgTypes.Storage{Files: []ignCfgTypes.File{
+		{Node: ignCfgTypes.Node{Path: "/etc/kubernetes/manifests/b.yaml"}},
+		{Node: ignCfgTypes.Node{Path: "/etc/kubernetes/kubelet.conf"}},
+		{Node: ignCfgTypes.Node{Path: "/etc/kubernetes-old/kubelet.conf"}},
+		{Node: ignCfgTypes.Node{Path: "/etc/kubernetes/manifests/a.yaml"}},
+		{Node: ignCfgTypes.Node{Path: "/etc/kubernetes/manifests/a.json"}},
+		{Node: ignCfgTypes.Node{Path: "/etc/kubernetes/manifests/nested/c.yaml"}},
+	}}}}
+	paths := func(files []ignCfgTypes.File) []string {
+		var filePaths []string
+		for _, file := range files {
+			filePaths = append(filePaths, file.Node.Path)
+		}
+		return filePaths
+	}
+
+	t.Run("FilesWithPrefix", func(t *testing.T) {
+		testCases := []struct {
+			name     string
+			prefix   string
+			expected []string
+		}{
+			{
+				name:   "directory",
+				prefix: "/etc/kubernetes/manifests",
+				expected: []string{"/etc/kubernetes/manifests/a.json", "/etc/kubernetes/manifests/a.yaml",
+					"/etc/kubernetes/manifests/b.yaml", "/etc/kubernetes/manifests/nested/c.yaml"},
+			},
+			{
+				name:   "directory with trailing slash",
+				prefix: "/etc/kubernetes/manifests/",
+				expected: []string{"/etc/kubernetes/manifests/a.json", "/etc/kubernetes/manifests/a.yaml",
+					"/etc/kubernetes/manifests/b.yaml", "/etc/kubernetes/manifests/nested/c.yaml"},
+			},
+			{
+				name:     "file",
+				prefix:   "/etc/kubernetes/kubelet.conf",
+				expected: []string{"/etc/kubernetes/kubelet.conf"},
+			},
+			{
+				name:   "partial directory name",
+				prefix: "/etc/kube",
+			},
+			{
+				name:     "sibling directory",
+				prefix:   "/etc/kubernetes-old/",
+				expected: []string{"/etc/kubernetes-old/kubelet.conf"},
+			},
+		}
+		for _, test := range testCases {
+			t.Run(test.name, func(t *testing.T) {
+				assert.Equal(t, test.expected, paths(ign.FilesWithPrefix(test.prefix)))
+			})
+		}
+	})
+
+	t.Run("FilesMatching", func(t *testing.T) {
+		files, err := ign.FilesMatching("/etc/kubernetes/manifests/*.yaml")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/etc/kubernetes/manifests/a.yaml", "/etc/kubernetes/manifests/b.yaml"}, paths(files))
+		_, err = ign.FilesMatching("/etc/kubernetes/[")
+		assert.Error(t, err)
+	})
+
+	t.Run("FileExists", func(t *testing.T) {
+		assert.True(t, ign.FileExists("/etc/kubernetes/kubelet.conf"))
+		assert.True(t, ign.FileExists("/etc/kubernetes/kubelet.conf/"))
+		assert.False(t, ign.FileExists("/etc/kubernetes"))
+		assert.False(t, ign.FileExists("/etc/kubernetes/kubelet"))
+	})
+}
+
+func TestValidateCloudConfig(t *testing.T) {
+	file := func(path, contents string) ignCfgTypes.File {
+		f := ignCfgTypes.File{Node: ignCfgTypes.Node{Path: path}}
+		f.Contents.Source = ptr.To("data:," + url.PathEscape(contents))
+		return f
+	}
+	testCases := []struct {
+		name          string
+		execStart     string
+		files         []ignCfgTypes.File
+		expected      []byte
+		expectedErr   string
+		expectMissing bool
+	}{
+		{
+			name:      "no cloud config arg",
+			execStart: "/usr/bin/kubelet --cloud-provider=external",
+			files:     []ignCfgTypes.File{file(CloudConfigPath, "[Global]\n")},
+		},
+		{
+			name:      "cloud config present",
+			execStart: "/usr/bin/kubelet --cloud-provider=vsphere --cloud-config=/etc/kubernetes/cloud.conf",
+			files:     []ignCfgTypes.File{file(CloudConfigPath, "[Global]\n")},
+			expected:  []byte("[Global]\n"),
+		},
+		{
+			name:      "cloud config missing",
+			execStart: "/usr/bin/kubelet --cloud-provider=vsphere --cloud-config=/etc/kubernetes/cloud.conf",
+			files: []ignCfgTypes.File{file("/etc/kubernetes/kubelet.conf", "{}"),
+				file("/etc/kubernetes/ca.crt", ""), file("/etc/chrony.conf", "")},
+			expectedErr: "kubelet arg cloud-config references /etc/kubernetes/cloud.conf, files within /etc/kubernetes/ " +
+				"are [/etc/kubernetes/ca.crt, /etc/kubernetes/kubelet.conf]: file not found in ignition",
+			expectMissing: true,
+		},
+		{
+			name:      "cloud config undecodable",
+			execStart: "/usr/bin/kubelet --cloud-config=/etc/kubernetes/cloud.conf",
+			files: []ignCfgTypes.File{{Node: ignCfgTypes.Node{Path: CloudConfigPath},
+				FileEmbedded1: ignCfgTypes.FileEmbedded1{Contents: ignCfgTypes.Resource{
+					Source: ptr.To("https://example.com/cloud.conf")}}}},
+			expectedErr: "could not decode /etc/kubernetes/cloud.conf: contents source is not a data URL, remote " +
+				"sources are not supported",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{config: ignCfgTypes.Config{
+				Storage: ignCfgTypes.Storage{Files: test.files},
+				Systemd: ignCfgTypes.Systemd{Units: []ignCfgTypes.Unit{
+					{Name: kubeletSystemdName, Contents: ptr.To("[Service]\nExecStart=" + test.execStart + "\n")},
+				}},
+			}}
+			contents, err := ign.ValidateCloudConfig()
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				assert.Equal(t, test.expectMissing, errors.Is(err, ErrFileNotFound))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, contents)
+		})
+	}
+}
+
+// generateCertificate returns a PEM encoded self-signed CA certificate with the given common name
+func generateCertificate(t *testing.T, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// renderedConfigNamePath is the path of a file holding the name of the MachineConfig in test ignition specs
+const renderedConfigNamePath = "/etc/rendered-config-name"
+
+// renderedConfig returns a rendered MachineConfig with the given name, created the given number of minutes after
+// the reference time. The ignition spec contains a file holding the name of the MachineConfig.
+func renderedConfig(name string, minutes int) *mcfg.MachineConfig {
+	raw := fmt.Sprintf(`{"ignition":{"version":"3.4.0"},`+
+		`"storage":{"files":[{"path":%q,"contents":{"source":"data:,%s"}}]}}`, renderedConfigNamePath, name)
+	return &mcfg.MachineConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(time.Date(2024, 1, 1, 0, minutes, 0, 0, time.UTC)),
+		},
+		Spec: mcfg.MachineConfigSpec{
+			Config: runtime.RawExtension{Raw: []byte(raw)},
+		},
+	}
+}
+
+// pool returns a MachineConfigPool with the given name, which is rolling out the spec configuration and whose nodes
+// are using the status configuration
+func pool(name, spec, status string) *mcfg.MachineConfigPool {
+	return &mcfg.MachineConfigPool{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: mcfg.MachineConfigPoolSpec{
+			Configuration: mcfg.MachineConfigPoolStatusConfiguration{
+				ObjectReference: core.ObjectReference{Name: spec},
+			},
+		},
+		Status: mcfg.MachineConfigPoolStatus{
+			Configuration: mcfg.MachineConfigPoolStatusConfiguration{
+				ObjectReference: core.ObjectReference{Name: status},
+			},
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	ca := generateCertificate(t, "kubelet-ca")
+	controllerConfig := &mcfg.ControllerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-config-controller"},
+		Spec:       mcfg.ControllerConfigSpec{KubeAPIServerServingCAData: ca},
+	}
+	machineConfigs := []client.Object{
+		renderedConfig("rendered-worker-a", 0),
+		renderedConfig("rendered-worker-b", 1),
+		renderedConfig("rendered-windows-a", 2),
+		renderedConfig("rendered-windows-b", 3),
+		renderedConfig("rendered-master-a", 4),
+		// rendered by the MCO, but not yet adopted by the worker pool
+		renderedConfig("rendered-worker-c", 5),
+	}
+
+	testCases := []struct {
+		name           string
+		pools          []client.Object
+		opts           []Option
+		expected       string
+		expectedSource RenderedConfigSource
+		expectedErr    error
+	}{
+		{
+			name:           "worker pool",
+			pools:          []client.Object{pool("worker", "rendered-worker-b", "rendered-worker-b")},
+			expected:       "rendered-worker-b",
+			expectedSource: RenderedConfigSourcePoolSpec,
+		},
+		{
+			name:           "worker pool mid-rollout",
+			pools:          []client.Object{pool("worker", "rendered-worker-b", "rendered-worker-a")},
+			expected:       "rendered-worker-b",
+			expectedSource: RenderedConfigSourcePoolSpec,
+		},
+		{
+			name:           "worker pool missing",
+			pools:          []client.Object{pool("windows", "rendered-windows-a", "rendered-windows-a")},
+			expected:       "rendered-worker-c",
+			expectedSource: RenderedConfigSourceCreationTimestamp,
+		},
+		{
+			name:           "worker pool without configuration",
+			pools:          []client.Object{pool("worker", "", "")},
+			expected:       "rendered-worker-c",
+			expectedSource: RenderedConfigSourceCreationTimestamp,
+		},
+		{
+			name:        "worker pool configuration not found",
+			pools:       []client.Object{pool("worker", "rendered-worker-d", "rendered-worker-c")},
+			expectedErr: ErrRenderedConfigNotFound,
+		},
+		{
+			name:           "custom pool",
+			pools:          []client.Object{pool("windows", "rendered-windows-a", "rendered-windows-a")},
+			opts:           []Option{WithMachineConfigPool("windows")},
+			expected:       "rendered-windows-a",
+			expectedSource: RenderedConfigSourcePoolSpec,
+		},
+		{
+			name:           "custom pool mid-rollout",
+			pools:          []client.Object{pool("windows", "rendered-windows-b", "rendered-windows-a")},
+			opts:           []Option{WithMachineConfigPool("windows")},
+			expected:       "rendered-windows-b",
+			expectedSource: RenderedConfigSourcePoolSpec,
+		},
+		{
+			name:           "custom pool missing",
+			pools:          []client.Object{pool("worker", "rendered-worker-b", "rendered-worker-b")},
+			opts:           []Option{WithMachineConfigPool("windows")},
+			expected:       "rendered-windows-b",
+			expectedSource: RenderedConfigSourceCreationTimestamp,
+		},
+		{
+			name:        "no rendered config for pool",
+			opts:        []Option{WithMachineConfigPool("infra")},
+			expectedErr: ErrRenderedConfigNotFound,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, mcfg.Install(scheme))
+			objects := append([]client.Object{controllerConfig}, machineConfigs...)
+			objects = append(objects, test.pools...)
+			c := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+			ign, err := New(context.Background(), c, test.opts...)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, ign.GetRenderedConfigName())
+			assert.Equal(t, test.expectedSource, ign.GetRenderedConfigSource())
+			assert.Empty(t, ign.GetParseWarnings())
+			name, err := ign.GetFileContents(renderedConfigNamePath)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(name))
+			assert.Equal(t, ca, ign.GetKubeletCAData())
+		})
+	}
+}
+
+func TestNewTimeout(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, mcfg.Install(scheme))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	// the fake client does not honor the context, so block the first list until the deadline expires
+	c := clientfake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		List: func(ctx context.Context, _ client.WithWatch, _ client.ObjectList, _ ...client.ListOption) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}).Build()
+
+	_, err := New(ctx, c)
+	assert.ErrorIs(t, err, ErrListTimedOut)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotErrorIs(t, err, ErrRenderedConfigNotFound)
+}
+
+func TestNewMissingKubeletCA(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, mcfg.Install(scheme))
+	ca := generateCertificate(t, "kubelet-ca")
+	controllerConfig := &mcfg.ControllerConfig{ObjectMeta: metav1.ObjectMeta{Name: "machine-config-controller"}}
+	c := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(controllerConfig,
+		pool("worker", "rendered-worker-a", "rendered-worker-a"), renderedConfig("rendered-worker-a", 0)).Build()
+	ctx := context.Background()
+
+	_, err := New(ctx, c)
+	assert.ErrorIs(t, err, ErrKubeletCANotFound)
+
+	ign, err := New(ctx, c, AllowMissingKubeletCA())
+	require.NoError(t, err)
+	assert.False(t, ign.HasKubeletCA())
+	assert.Empty(t, ign.GetSanitizedKubeletCA())
+	assert.Equal(t, "rendered-worker-a", ign.GetRenderedConfigName())
+
+	// the kubelet CA is picked up once the MCO has populated it
+	controllerConfig.Spec.KubeAPIServerServingCAData = ca
+	require.NoError(t, c.Update(ctx, controllerConfig))
+	changed, err := ign.Refresh(ctx, c)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.True(t, ign.HasKubeletCA())
+	assert.Equal(t, ca, ign.GetKubeletCAData())
+}
+
+func TestRefresh(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, mcfg.Install(scheme))
+	ca := generateCertificate(t, "kubelet-ca")
+	rotatedCA := generateCertificate(t, "rotated-kubelet-ca")
+	controllerConfig := &mcfg.ControllerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "machine-config-controller"},
+		Spec:       mcfg.ControllerConfigSpec{KubeAPIServerServingCAData: ca},
+	}
+	workerPool := pool("worker", "rendered-worker-a", "rendered-worker-a")
+	c := clientfake.NewClientBuilder().WithScheme(scheme).WithObjects(controllerConfig, workerPool,
+		renderedConfig("rendered-worker-a", 0), renderedConfig("rendered-worker-b", 1)).Build()
+	ctx := context.Background()
+	ign, err := New(ctx, c)
+	require.NoError(t, err)
+	require.Equal(t, "rendered-worker-a", ign.GetRenderedConfigName())
+
+	// getters are safe to call while the ignition is being refreshed
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				ign.GetRenderedConfigName()
+				ign.GetKubeletCAData()
+				_, _ = ign.GetFileContents(renderedConfigNamePath)
+			}
+		}
+	}()
+
+	testCases := []struct {
+		name                string
+		update              func(t *testing.T)
+		expectedChanged     bool
+		expectedHashChanged bool
+		expectedName        string
+		expectedCA          []byte
+	}{
+		{
+			name:         "nothing changed",
+			update:       func(t *testing.T) {},
+			expectedName: "rendered-worker-a",
+			expectedCA:   ca,
+		},
+		{
+			name: "pool rolling out a new rendered config",
+			update: func(t *testing.T) {
+				workerPool.Spec.Configuration.Name = "rendered-worker-b"
+				require.NoError(t, c.Update(ctx, workerPool))
+			},
+			expectedChanged:     true,
+			expectedHashChanged: true,
+			expectedName:        "rendered-worker-b",
+			expectedCA:          ca,
+		},
+		{
+			name: "rendered config updated in place",
+			update: func(t *testing.T) {
+				mc := &mcfg.MachineConfig{}
+				require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "rendered-worker-b"}, mc))
+				mc.Spec.Config.Raw = renderedConfig("rendered-worker-b-updated", 1).Spec.Config.Raw
+				require.NoError(t, c.Update(ctx, mc))
+			},
+			expectedChanged:     true,
+			expectedHashChanged: true,
+			expectedName:        "rendered-worker-b",
+			expectedCA:          ca,
+		},
+		{
+			name: "rendered config metadata updated",
+			update: func(t *testing.T) {
+				mc := &mcfg.MachineConfig{}
+				require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "rendered-worker-b"}, mc))
+				mc.Labels = map[string]string{"example.com/label": "value"}
+				require.NoError(t, c.Update(ctx, mc))
+			},
+			expectedName: "rendered-worker-b",
+			expectedCA:   ca,
+		},
+		{
+			name: "kubelet CA rotated",
+			update: func(t *testing.T) {
+				controllerConfig.Spec.KubeAPIServerServingCAData = rotatedCA
+				require.NoError(t, c.Update(ctx, controllerConfig))
+			},
+			expectedChanged: true,
+			expectedName:    "rendered-worker-b",
+			expectedCA:      rotatedCA,
+		},
+		{
+			name: "image registry CA added",
+			update: func(t *testing.T) {
+				controllerConfig.Spec.ImageRegistryBundleUserData = []mcfg.ImageRegistryBundle{
+					{File: "mirror.example.com", Data: ca},
+				}
+				require.NoError(t, c.Update(ctx, controllerConfig))
+			},
+			expectedChanged: true,
+			expectedName:    "rendered-worker-b",
+			expectedCA:      rotatedCA,
+		},
+	}
+	// test cases build on each other, and are run in order
+	previousHash := ign.GetConfigHash()
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			test.update(t)
+			changed, err := ign.Refresh(ctx, c)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedChanged, changed)
+			assert.Equal(t, test.expectedName, ign.GetRenderedConfigName())
+			assert.Equal(t, test.expectedCA, ign.GetKubeletCAData())
+			assert.Equal(t, test.expectedCA, ign.GetSanitizedKubeletCA())
+			assert.Equal(t, test.expectedHashChanged, previousHash != ign.GetConfigHash())
+			previousHash = ign.GetConfigHash()
+		})
+	}
+	contents, err := ign.GetFileContents(renderedConfigNamePath)
+	require.NoError(t, err)
+	assert.Equal(t, "rendered-worker-b-updated", string(contents))
+	assert.Equal(t, map[string][]byte{"mirror.example.com": ca}, ign.GetImageRegistryCAs())
+}
+
+func TestExcludeArgs(t *testing.T) {
+	args := map[string]string{
+		CloudProviderOption: "external",
+		"fail-swap-on":      "",
+		"v":                 "2",
+	}
+	testCases := []struct {
+		name     string
+		names    []string
+		expected map[string]string
+	}{
+		{
+			name:     "cloud options",
+			names:    []string{CloudProviderOption, CloudConfigOption},
+			expected: map[string]string{"fail-swap-on": "", "v": "2"},
+		},
+		{
+			name:     "no names",
+			expected: args,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ExcludeArgs(args, test.names...))
+		})
+	}
+}
+
+func TestKubeletArgsFilters(t *testing.T) {
+	unitContents := `[Service]
+ExecStart=/usr/local/bin/kubenswrapper \
+    /usr/bin/kubelet \
+      --config=/etc/kubernetes/kubelet.conf \
+      --bootstrap-kubeconfig=/etc/kubernetes/kubeconfig \
+      --kubeconfig=/var/lib/kubelet/kubeconfig \
+      --container-runtime-endpoint=/var/run/crio/crio.sock \
+      --runtime-cgroups=/system.slice/crio.service \
+      --node-labels=node-role.kubernetes.io/worker \
+      --node-ip=10.0.0.4 \
+      --volume-plugin-dir=/etc/kubernetes/kubelet-plugins/volume/exec \
+      --cloud-provider=external \
+      --cloud-config=/etc/kubernetes/cloud.conf \
+      --image-credential-provider-config=/etc/kubernetes/credential-providers/ecr-credential-provider.yaml \
+      --register-with-taints=node-role.kubernetes.io/infra=reserved:NoSchedule \
+      --v=2
+`
+	args, _, err := parseUnitArgs(nil, unitContents)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		CloudProviderOption: "external",
+		CloudConfigOption:   "/etc/kubernetes/cloud.conf",
+	}, FilterArgs(args, KubeletArgsOfInterest...))
+	assert.Equal(t, map[string]string{
+		CloudProviderOption:                "external",
+		CloudConfigOption:                  "/etc/kubernetes/cloud.conf",
+		"image-credential-provider-config": "/etc/kubernetes/credential-providers/ecr-credential-provider.yaml",
+		"register-with-taints":             "node-role.kubernetes.io/infra=reserved:NoSchedule",
+		"v":                                "2",
+	}, ExcludeArgs(args, LinuxOnlyKubeletArgs...))
+	// the arguments of interest are never Linux only
+	for _, name := range KubeletArgsOfInterest {
+		assert.NotContains(t, LinuxOnlyKubeletArgs, name)
+	}
+}
+
+func TestGetKubeletCAData(t *testing.T) {
+	ca := generateCertificate(t, "kubelet-ca")
+	otherCA := generateCertificate(t, "other-kubelet-ca")
+	controllerConfig := func(name string, data []byte) mcfg.ControllerConfig {
+		return mcfg.ControllerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       mcfg.ControllerConfigSpec{KubeAPIServerServingCAData: data},
+		}
+	}
+	testCases := []struct {
+		name              string
+		controllerConfigs []mcfg.ControllerConfig
+		expected          []byte
+		expectedErr       string
+	}{
+		{
+			name: "well-known ControllerConfig preferred",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("another", otherCA),
+				controllerConfig("machine-config-controller", ca), controllerConfig("yet-another", otherCA)},
+			expected: ca,
+		},
+		{
+			name: "well-known ControllerConfig without CA",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("machine-config-controller", nil),
+				controllerConfig("another", otherCA)},
+			expectedErr: "kubelet-ca not found in ControllerConfig machine-config-controller",
+		},
+		{
+			name:              "single ControllerConfig",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("another", ca), controllerConfig("empty", nil)},
+			expected:          ca,
+		},
+		{
+			name:              "agreeing ControllerConfigs",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("a", ca), controllerConfig("b", ca)},
+			expected:          ca,
+		},
+		{
+			name:              "disagreeing ControllerConfigs",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("a", ca), controllerConfig("b", otherCA)},
+			expectedErr:       "ControllerConfigs a, b have differing kubelet-ca",
+		},
+		{
+			name:              "no ControllerConfig with CA",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("empty", nil)},
+			expectedErr:       "kubelet-ca not found in any ControllerConfig",
+		},
+		{
+			name: "CA without certificate",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("machine-config-controller",
+				pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("invalid")}))},
+			expectedErr: "kubelet-ca of ControllerConfig machine-config-controller contains no valid PEM encoded " +
+				"certificate",
+		},
+		{
+			name: "CA with a valid certificate among invalid blocks",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("machine-config-controller",
+				append([]byte("garbage\n"), ca...))},
+			expected: append([]byte("garbage\n"), ca...),
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := getKubeletCAData(test.controllerConfigs)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, data)
+		})
+	}
+}
+
+func TestGetSanitizedKubeletCA(t *testing.T) {
+	ca := generateCertificate(t, "kubelet-ca")
+	rotatedCA := generateCertificate(t, "rotated-kubelet-ca")
+	crlf := func(data []byte) []byte {
+		return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+	}
+	testCases := []struct {
+		name          string
+		data          []byte
+		expected      []byte
+		expectedNames []string
+	}{
+		{
+			name:          "clean bundle",
+			data:          append(append([]byte{}, ca...), rotatedCA...),
+			expected:      append(append([]byte{}, ca...), rotatedCA...),
+			expectedNames: []string{"kubelet-ca", "rotated-kubelet-ca"},
+		},
+		{
+			name: "comments, CRLF line endings and duplicates",
+			data: bytes.Join([][]byte{[]byte("# kubelet-ca\r\n"), crlf(ca), crlf(rotatedCA), []byte("# overlap\n"),
+				ca}, nil),
+			expected:      append(append([]byte{}, ca...), rotatedCA...),
+			expectedNames: []string{"kubelet-ca", "rotated-kubelet-ca"},
+		},
+		{
+			name: "invalid blocks skipped",
+			data: bytes.Join([][]byte{pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}),
+				pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("invalid")}), rotatedCA}, nil),
+			expected:      rotatedCA,
+			expectedNames: []string{"rotated-kubelet-ca"},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{kubeletCAData: test.data, kubeletCACertificates: getKubeletCACertificates(test.data)}
+			assert.Equal(t, test.expected, ign.GetSanitizedKubeletCA())
+			var names []string
+			for _, cert := range ign.GetKubeletCACertificates() {
+				names = append(names, cert.Subject.CommonName)
+				assert.False(t, cert.NotAfter.IsZero())
+			}
+			assert.Equal(t, test.expectedNames, names)
+		})
+	}
+}
+
+func TestKubeletCAHash(t *testing.T) {
+	ca := generateCertificate(t, "kubelet-ca")
+	otherCA := generateCertificate(t, "other-kubelet-ca")
+	bundle := append(append([]byte{}, ca...), otherCA...)
+	ign := &Ignition{kubeletCAData: bundle}
+	hash := ign.KubeletCAHash()
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		expected bool
+	}{
+		{
+			name:     "same bundle",
+			data:     bundle,
+			expected: true,
+		},
+		{
+			name:     "reordered bundle",
+			data:     append(append([]byte{}, otherCA...), ca...),
+			expected: true,
+		},
+		{
+			name:     "different whitespace",
+			data:     []byte("\n" + string(ca) + "\n\n  " + strings.ReplaceAll(string(otherCA), "\n", "\r\n")),
+			expected: true,
+		},
+		{
+			name:     "rotated CA",
+			data:     append(append([]byte{}, ca...), generateCertificate(t, "rotated-kubelet-ca")...),
+			expected: false,
+		},
+		{
+			name:     "removed CA",
+			data:     ca,
+			expected: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			current := &Ignition{kubeletCAData: test.data}
+			assert.Equal(t, test.expected, current.CompareCA(hash))
+			assert.Len(t, current.KubeletCAHash(), 64)
+		})
+	}
+}
+
+func TestGetImageRegistryCAs(t *testing.T) {
+	registryCA := generateCertificate(t, "registry-ca")
+	mirrorCA := generateCertificate(t, "mirror-ca")
+	proxyCA := generateCertificate(t, "proxy-ca")
+	invalid := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("invalid")})
+	concat := func(data ...[]byte) []byte {
+		return bytes.Join(data, nil)
+	}
+	controllerConfig := func(name string, additionalTrustBundle []byte, data,
+		userData []mcfg.ImageRegistryBundle) mcfg.ControllerConfig {
+		return mcfg.ControllerConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: mcfg.ControllerConfigSpec{
+				AdditionalTrustBundle:       additionalTrustBundle,
+				ImageRegistryBundleData:     data,
+				ImageRegistryBundleUserData: userData,
+			},
+		}
+	}
+	testCases := []struct {
+		name              string
+		controllerConfigs []mcfg.ControllerConfig
+		expected          map[string][]byte
+	}{
+		{
+			name:              "no CAs",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("machine-config-controller", nil, nil, nil)},
+			expected:          map[string][]byte{},
+		},
+		{
+			name: "registry CAs and additional trust bundle",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("machine-config-controller", proxyCA,
+				[]mcfg.ImageRegistryBundle{
+					{File: "image-registry.openshift-image-registry.svc..5000", Data: registryCA},
+				},
+				[]mcfg.ImageRegistryBundle{
+					{File: "mirror.example.com", Data: mirrorCA},
+				})},
+			expected: map[string][]byte{
+				DefaultRegistryHost: proxyCA,
+				"image-registry.openshift-image-registry.svc:5000": registryCA,
+				"mirror.example.com": mirrorCA,
+			},
+		},
+		{
+			name: "duplicate certificates",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("machine-config-controller", nil,
+				[]mcfg.ImageRegistryBundle{
+					{File: "mirror.example.com", Data: concat(mirrorCA, mirrorCA)},
+				},
+				[]mcfg.ImageRegistryBundle{
+					{File: "mirror.example.com", Data: concat(registryCA, mirrorCA)},
+				})},
+			expected: map[string][]byte{"mirror.example.com": concat(mirrorCA, registryCA)},
+		},
+		{
+			name: "invalid certificates skipped",
+			controllerConfigs: []mcfg.ControllerConfig{controllerConfig("machine-config-controller",
+				[]byte("not PEM encoded"),
+				[]mcfg.ImageRegistryBundle{
+					{File: "invalid.example.com", Data: invalid},
+					{File: "mirror.example.com", Data: concat(invalid, mirrorCA)},
+				}, nil)},
+			expected: map[string][]byte{"mirror.example.com": mirrorCA},
+		},
+		{
+			name: "well-known ControllerConfig preferred",
+			controllerConfigs: []mcfg.ControllerConfig{
+				controllerConfig("another", proxyCA, nil, nil),
+				controllerConfig("machine-config-controller", nil,
+					[]mcfg.ImageRegistryBundle{{File: "mirror.example.com", Data: mirrorCA}}, nil),
+			},
+			expected: map[string][]byte{"mirror.example.com": mirrorCA},
+		},
+		{
+			name: "ControllerConfigs merged",
+			controllerConfigs: []mcfg.ControllerConfig{
+				controllerConfig("a", proxyCA, nil, nil),
+				controllerConfig("b", proxyCA, []mcfg.ImageRegistryBundle{{File: "mirror.example.com", Data: mirrorCA}},
+					nil),
+			},
+			expected: map[string][]byte{DefaultRegistryHost: proxyCA, "mirror.example.com": mirrorCA},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, getImageRegistryCAs(test.controllerConfigs))
+		})
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	dataURL := func(contents string) string {
+		return "data:," + url.PathEscape(contents)
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err := writer.Write([]byte(`{"ignition":{"version":"3.4.0"},"storage":{"files":[{"path":"/etc/a"}]}}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	child := `{"ignition":{"version":"3.2.0"},"storage":{"files":[{"path":"/etc/b"}]},` +
+		`"systemd":{"units":[{"name":"kubelet.service","contents":"[Service]\nExecStart=/usr/bin/kubelet\n"}]}}`
+
+	testCases := []struct {
+		name             string
+		raw              string
+		expectedVersion  string
+		expectedWarnings []string
+		expectedFiles    []string
+		expectedUnits    []string
+		expectedErr      bool
+	}{
+		{
+			name:            "supported version",
+			raw:             `{"ignition":{"version":"3.4.0"},"storage":{"files":[{"path":"/etc/a"}]}}`,
+			expectedVersion: "3.4.0",
+		},
+		{
+			name:            "older version translated",
+			raw:             `{"ignition":{"version":"3.2.0"},"storage":{"files":[{"path":"/etc/a"}]}}`,
+			expectedVersion: "3.2.0",
+		},
+		{
+			name:             "warnings kept",
+			raw:              `{"ignition":{"version":"3.1.0"},"unknown":true}`,
+			expectedVersion:  "3.1.0",
+			expectedWarnings: []string{"warning at $.unknown"},
+		},
+		{
+			name: "enabled unit without install section",
+			raw: `{"ignition":{"version":"3.4.0"},"systemd":{"units":[{"name":"a.service","enabled":true,` +
+				`"contents":"[Service]\nExecStart=/usr/bin/a\n"}]}}`,
+			expectedVersion:  "3.4.0",
+			expectedWarnings: []string{"warning at $.systemd.units.0"},
+			expectedUnits:    []string{"a.service"},
+		},
+		{
+			name:        "newer version",
+			raw:         `{"ignition":{"version":"3.5.0"}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "invalid version",
+			raw:         `{"ignition":{"version":"latest"}}`,
+			expectedErr: true,
+		},
+		{
+			name:        "fatal report",
+			raw:         `{"ignition":{"version":"3.4.0"},"storage":{"files":[{"path":"relative"}]}}`,
+			expectedErr: true,
+		},
+		{
+			name:            "gzip compressed",
+			raw:             compressed.String(),
+			expectedVersion: "3.4.0",
+			expectedFiles:   []string{"/etc/a"},
+		},
+		{
+			name: "merged config",
+			raw: fmt.Sprintf(`{"ignition":{"version":"3.4.0","config":{"merge":[{"source":%q}]}},`+
+				`"storage":{"files":[{"path":"/etc/a"}]}}`, dataURL(child)),
+			expectedVersion: "3.4.0",
+			expectedFiles:   []string{"/etc/a", "/etc/b"},
+			expectedUnits:   []string{kubeletSystemdName},
+		},
+		{
+			name: "nested merged config",
+			raw: fmt.Sprintf(`{"ignition":{"version":"3.4.0","config":{"merge":[{"source":%q}]}}}`,
+				dataURL(fmt.Sprintf(`{"ignition":{"version":"3.4.0","config":{"merge":[{"source":%q}]}}}`,
+					dataURL(child)))),
+			expectedVersion: "3.4.0",
+			expectedFiles:   []string{"/etc/b"},
+			expectedUnits:   []string{kubeletSystemdName},
+		},
+		{
+			name: "replaced config",
+			raw: fmt.Sprintf(`{"ignition":{"version":"3.4.0","config":{"replace":{"source":%q}}},`+
+				`"storage":{"files":[{"path":"/etc/a"}]}}`, dataURL(child)),
+			expectedVersion: "3.4.0",
+			expectedFiles:   []string{"/etc/b"},
+			expectedUnits:   []string{kubeletSystemdName},
+		},
+		{
+			name: "remote merged config",
+			raw: `{"ignition":{"version":"3.4.0","config":{"merge":[` +
+				`{"source":"https://example.com/config.ign"}]}}}`,
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			configuration, version, warnings, err := parseConfig([]byte(test.raw), logr.Discard())
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedVersion, version)
+			require.Len(t, warnings, len(test.expectedWarnings))
+			for i, expected := range test.expectedWarnings {
+				assert.Contains(t, warnings[i], expected)
+			}
+			assert.Equal(t, "3.4.0", configuration.Ignition.Version)
+			assert.Empty(t, configuration.Ignition.Config.Merge)
+			if test.expectedFiles != nil {
+				var files []string
+				for _, file := range configuration.Storage.Files {
+					files = append(files, file.Path)
+				}
+				assert.Equal(t, test.expectedFiles, files)
+			}
+			var units []string
+			for _, unit := range configuration.Systemd.Units {
+				units = append(units, unit.Name)
+			}
+			assert.Equal(t, test.expectedUnits, units)
+		})
+	}
+}
+
+// manyMachineConfigs returns the given number of rendered MachineConfigs of several pools, in no particular order
+func manyMachineConfigs(count int) []mcfg.MachineConfig {
+	pools := []string{"worker", "master", "infra", "windows"}
+	machineConfigs := make([]mcfg.MachineConfig, 0, count)
+	for i := 0; i < count; i++ {
+		// spread creation times so that neither the first nor the last MachineConfig is the latest
+		minutes := (i * 37) % count
+		name := fmt.Sprintf("rendered-%s-%d", pools[i%len(pools)], minutes)
+		machineConfigs = append(machineConfigs, *renderedConfig(name, minutes))
+	}
+	return machineConfigs
+}
+
+func TestGetLatestRendered(t *testing.T) {
+	machineConfigs := manyMachineConfigs(400)
+	names := make([]string, 0, len(machineConfigs))
+	for _, mc := range machineConfigs {
+		names = append(names, mc.Name)
+	}
+
+	latest, err := getLatestRendered(machineConfigs, RenderedWorkerPrefix)
+	require.NoError(t, err)
+	assert.Equal(t, "rendered-worker-396", latest.Name)
+
+	// the listed MachineConfigs are not sorted
+	for i, mc := range machineConfigs {
+		require.Equal(t, names[i], mc.Name)
+	}
+	// the selected MachineConfig is a copy, which does not retain the listed MachineConfigs
+	latest.Spec.Config.Raw[0] = 'x'
+	for _, mc := range machineConfigs {
+		require.Equal(t, byte('{'), mc.Spec.Config.Raw[0])
+	}
+
+	_, err = getLatestRendered(machineConfigs, "rendered-infra-secondary-")
+	assert.Error(t, err)
+}
+
+func BenchmarkGetLatestRendered(b *testing.B) {
+	machineConfigs := manyMachineConfigs(400)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := getLatestRendered(machineConfigs, RenderedWorkerPrefix); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
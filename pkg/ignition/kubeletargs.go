@@ -0,0 +1,163 @@
+package ignition
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// KubeletArgSource identifies where the value of a kubelet argument comes from
+type KubeletArgSource string
+
+const (
+	// KubeletArgSourceIgnition is the kubelet unit of the ignition spec
+	KubeletArgSourceIgnition KubeletArgSource = "ignition"
+	// KubeletArgSourceWindowsDefaults are the arguments WMCO configures the kubelet of every Windows node with
+	KubeletArgSourceWindowsDefaults KubeletArgSource = "windows-defaults"
+	// KubeletArgSourceOverride is an explicit override, taking precedence over every other source
+	KubeletArgSourceOverride KubeletArgSource = "override"
+)
+
+// kubeletArg is the value of a kubelet argument along with where it comes from
+type kubeletArg struct {
+	value  string
+	source KubeletArgSource
+}
+
+// KubeletArgs builds the arguments of the kubelet on Windows nodes from the ignition spec and the Windows defaults.
+// An argument can only be given different values by different sources through Override, so that a conflict is never
+// resolved implicitly. Arguments without a value, such as --windows-service, are given an empty value.
+type KubeletArgs struct {
+	args      map[string]kubeletArg
+	overrides map[string]string
+	dropped   []string
+}
+
+// NewKubeletArgs returns an empty KubeletArgs
+func NewKubeletArgs() *KubeletArgs {
+	return &KubeletArgs{args: make(map[string]kubeletArg), overrides: make(map[string]string)}
+}
+
+// AddFromIgnition adds the given kubelet arguments, keyed by flag name as returned by Ignition.GetKubeletArgs. The
+// arguments which must never be inherited by the kubelet on Windows nodes, the ones in LinuxOnlyKubeletArgs and any
+// cgroup related argument, are dropped and can be listed with Dropped. An error is returned if an argument conflicts
+// with the value of another source.
+func (k *KubeletArgs) AddFromIgnition(args map[string]string) error {
+	for _, name := range sortedArgNames(args) {
+		if isLinuxOnlyKubeletArg(name) {
+			if !slices.Contains(k.dropped, name) {
+				k.dropped = append(k.dropped, name)
+			}
+			continue
+		}
+		if err := k.add(name, args[name], KubeletArgSourceIgnition); err != nil {
+			return err
+		}
+	}
+	slices.Sort(k.dropped)
+	return nil
+}
+
+// AddWindowsDefaults adds the given kubelet arguments, keyed by flag name, which WMCO configures on every Windows node.
+// An error is returned if an argument conflicts with the value of another source.
+func (k *KubeletArgs) AddWindowsDefaults(args map[string]string) error {
+	for _, name := range sortedArgNames(args) {
+		if err := k.add(name, args[name], KubeletArgSourceWindowsDefaults); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Override sets the kubelet argument with the given name to the given value, whatever the value of the argument from
+// the other sources, whether it was added before or after the override
+func (k *KubeletArgs) Override(name, value string) {
+	k.overrides[name] = value
+}
+
+// add adds the kubelet argument with the given name and value from the given source, returning an error if the
+// argument was already added with a different value. Overridden arguments never conflict.
+func (k *KubeletArgs) add(name, value string, source KubeletArgSource) error {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, "= \t") {
+		return fmt.Errorf("invalid kubelet argument name %q from %s", name, source)
+	}
+	if existing, ok := k.args[name]; ok && existing.value != value {
+		if _, overridden := k.overrides[name]; !overridden {
+			return fmt.Errorf("kubelet argument %s from %s conflicts with %s from %s",
+				formatKubeletArg(name, value), source, formatKubeletArg(name, existing.value), existing.source)
+		}
+	}
+	if _, ok := k.args[name]; !ok {
+		k.args[name] = kubeletArg{value: value, source: source}
+	}
+	return nil
+}
+
+// Source returns where the value of the kubelet argument with the given name comes from, and false if the argument is
+// not set
+func (k *KubeletArgs) Source(name string) (KubeletArgSource, bool) {
+	if _, ok := k.overrides[name]; ok {
+		return KubeletArgSourceOverride, true
+	}
+	arg, ok := k.args[name]
+	return arg.source, ok
+}
+
+// Dropped returns the sorted names of the arguments from the ignition spec which were dropped, as they must never be
+// inherited by the kubelet on Windows nodes
+func (k *KubeletArgs) Dropped() []string {
+	return slices.Clone(k.dropped)
+}
+
+// Args returns the kubelet arguments, sorted by name so that the kubelet command line only changes along with them.
+// Values containing whitespace are quoted.
+func (k *KubeletArgs) Args() []string {
+	values := make(map[string]string, len(k.args)+len(k.overrides))
+	for name, arg := range k.args {
+		values[name] = arg.value
+	}
+	maps.Copy(values, k.overrides)
+	var args []string
+	for _, name := range sortedArgNames(values) {
+		arg := formatKubeletArg(name, values[name])
+		if strings.ContainsAny(arg, " \t") {
+			arg = "\"" + arg + "\""
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// Command returns the command line running the kubelet binary at the given path with the arguments, quoting the path
+// if it contains whitespace
+func (k *KubeletArgs) Command(kubeletPath string) string {
+	if strings.ContainsAny(kubeletPath, " \t") {
+		kubeletPath = "\"" + kubeletPath + "\""
+	}
+	return strings.Join(append([]string{kubeletPath}, k.Args()...), " ")
+}
+
+// formatKubeletArg returns the command line flag setting the kubelet argument with the given name to the given value
+func formatKubeletArg(name, value string) string {
+	if value == "" {
+		return "--" + name
+	}
+	return "--" + name + "=" + value
+}
+
+// isLinuxOnlyKubeletArg returns true if the kubelet argument with the given name must never be inherited by the kubelet
+// on Windows nodes. Windows has no cgroups, so every cgroup related argument is Linux only.
+func isLinuxOnlyKubeletArg(name string) bool {
+	return slices.Contains(LinuxOnlyKubeletArgs, name) || strings.Contains(name, "cgroup")
+}
+
+// sortedArgNames returns the sorted names of the given arguments
+func sortedArgNames(args map[string]string) []string {
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
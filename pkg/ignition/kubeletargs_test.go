@@ -0,0 +1,127 @@
+package ignition
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKubeletArgs(t *testing.T) {
+	testCases := []struct {
+		name             string
+		windowsDefaults  map[string]string
+		fromIgnition     map[string]string
+		overrides        map[string]string
+		expectedArgs     []string
+		expectedDropped  []string
+		expectedSources  map[string]KubeletArgSource
+		expectedErr      bool
+		expectedErrMatch string
+	}{
+		{
+			name:            "Windows defaults and ignition args",
+			windowsDefaults: map[string]string{"windows-service": "", "v": "2", "cert-dir": `c:\var\lib\kubelet\pki\`},
+			fromIgnition:    map[string]string{CloudProviderOption: "external"},
+			expectedArgs: []string{`--cert-dir=c:\var\lib\kubelet\pki\`, "--cloud-provider=external", "--v=2",
+				"--windows-service"},
+			expectedSources: map[string]KubeletArgSource{
+				CloudProviderOption: KubeletArgSourceIgnition,
+				"windows-service":   KubeletArgSourceWindowsDefaults,
+			},
+		},
+		{
+			name:            "same value from both sources",
+			windowsDefaults: map[string]string{CloudProviderOption: "external"},
+			fromIgnition:    map[string]string{CloudProviderOption: "external"},
+			expectedArgs:    []string{"--cloud-provider=external"},
+			expectedSources: map[string]KubeletArgSource{CloudProviderOption: KubeletArgSourceWindowsDefaults},
+		},
+		{
+			name:            "conflicting cloud provider",
+			windowsDefaults: map[string]string{CloudProviderOption: "external"},
+			fromIgnition:    map[string]string{CloudProviderOption: "aws"},
+			expectedErr:     true,
+			expectedErrMatch: "--cloud-provider=aws from ignition conflicts with --cloud-provider=external from " +
+				"windows-defaults",
+		},
+		{
+			name:            "conflicting cloud provider overridden",
+			windowsDefaults: map[string]string{CloudProviderOption: "external"},
+			fromIgnition:    map[string]string{CloudProviderOption: "aws"},
+			overrides:       map[string]string{CloudProviderOption: ""},
+			expectedArgs:    []string{"--cloud-provider"},
+			expectedSources: map[string]KubeletArgSource{CloudProviderOption: KubeletArgSourceOverride},
+		},
+		{
+			name: "Linux only args dropped",
+			fromIgnition: map[string]string{
+				"cgroup-driver":   "systemd",
+				"cgroups-per-qos": "true",
+				"runtime-cgroups": "/system.slice/crio.service",
+				"system-cgroups":  "/system.slice",
+				"node-ip":         "10.0.0.5",
+				CloudConfigOption: `C:\k\cloud.conf`,
+				"max-pods":        "250",
+			},
+			expectedArgs:    []string{`--cloud-config=C:\k\cloud.conf`, "--max-pods=250"},
+			expectedDropped: []string{"cgroup-driver", "cgroups-per-qos", "node-ip", "runtime-cgroups", "system-cgroups"},
+		},
+		{
+			name:            "value with whitespace",
+			windowsDefaults: map[string]string{"config": `C:\Program Files\kubelet.conf`},
+			expectedArgs:    []string{`"--config=C:\Program Files\kubelet.conf"`},
+		},
+		{
+			name:            "invalid name",
+			windowsDefaults: map[string]string{"--v": "2"},
+			expectedErr:     true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			args := NewKubeletArgs()
+			for name, value := range test.overrides {
+				args.Override(name, value)
+			}
+			err := args.AddWindowsDefaults(test.windowsDefaults)
+			if err == nil {
+				err = args.AddFromIgnition(test.fromIgnition)
+			}
+			if test.expectedErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErrMatch)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedArgs, args.Args())
+			assert.Equal(t, test.expectedDropped, args.Dropped())
+			for name, expected := range test.expectedSources {
+				source, ok := args.Source(name)
+				assert.True(t, ok)
+				assert.Equal(t, expected, source)
+			}
+		})
+	}
+}
+
+func TestKubeletArgsOverrideAfterAdd(t *testing.T) {
+	args := NewKubeletArgs()
+	require.NoError(t, args.AddFromIgnition(map[string]string{CloudProviderOption: "aws"}))
+	args.Override(CloudProviderOption, "external")
+	// once overridden, the argument no longer conflicts
+	require.NoError(t, args.AddWindowsDefaults(map[string]string{CloudProviderOption: "gce"}))
+	assert.Equal(t, []string{"--cloud-provider=external"}, args.Args())
+	_, ok := args.Source("cloud-config")
+	assert.False(t, ok)
+}
+
+func TestKubeletArgsCommand(t *testing.T) {
+	args := NewKubeletArgs()
+	require.NoError(t, args.AddWindowsDefaults(map[string]string{"windows-service": "", "v": "2"}))
+	require.NoError(t, args.AddFromIgnition(map[string]string{CloudProviderOption: "external"}))
+	assert.Equal(t, `C:\k\kubelet.exe --cloud-provider=external --v=2 --windows-service`,
+		args.Command(`C:\k\kubelet.exe`))
+	assert.Equal(t, `"C:\Program Files\kubelet.exe" --cloud-provider=external --v=2 --windows-service`,
+		args.Command(`C:\Program Files\kubelet.exe`))
+}
@@ -0,0 +1,100 @@
+package ignition
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+	kubeletconfig "k8s.io/kubelet/config/v1beta1"
+)
+
+const (
+	// KubeletConfigPath is the path to the kubelet configuration file as defined in ignition
+	KubeletConfigPath = "/etc/kubernetes/kubelet.conf"
+	// kubeletConfigDecoderBufferSize is the number of bytes the kubelet configuration decoder looks ahead to determine
+	// if the file is JSON or YAML
+	kubeletConfigDecoderBufferSize = 4096
+)
+
+// windowsUnsupportedEvictionSignals are the eviction signals used on Linux nodes which the kubelet does not support on
+// Windows nodes
+var windowsUnsupportedEvictionSignals = []string{
+	"allocatableMemory.available",
+	"imagefs.inodesFree",
+	"nodefs.inodesFree",
+	"pid.available",
+}
+
+// windowsReservedResources are the resources which can be reserved for system and Kubernetes daemons on Windows nodes
+var windowsReservedResources = []string{"cpu", "ephemeral-storage", "memory"}
+
+// GetKubeletConfig returns the kubelet configuration of Linux worker nodes, as specified in the ignition file. Fields
+// unknown to the KubeletConfiguration type are ignored.
+func (ign *Ignition) GetKubeletConfig() (*kubeletconfig.KubeletConfiguration, error) {
+	contents, err := ign.GetFileContents(KubeletConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	config := &kubeletconfig.KubeletConfiguration{}
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(contents), kubeletConfigDecoderBufferSize)
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", KubeletConfigPath, err)
+	}
+	return config, nil
+}
+
+// TranslateKubeletConfig returns the subset of the given Linux kubelet configuration which applies to Windows nodes:
+// the eviction thresholds, the resources reserved for system and Kubernetes daemons, the feature gates, and the image
+// garbage collection thresholds. Cgroup and other Linux only fields are dropped, as are eviction signals and reserved
+// resources which are not supported on Windows.
+func TranslateKubeletConfig(linux *kubeletconfig.KubeletConfiguration) kubeletconfig.KubeletConfiguration {
+	windowsConfig := kubeletconfig.KubeletConfiguration{
+		TypeMeta:                         linux.TypeMeta,
+		EvictionHard:                     filterKeys(linux.EvictionHard, isWindowsEvictionSignal),
+		EvictionSoft:                     filterKeys(linux.EvictionSoft, isWindowsEvictionSignal),
+		EvictionSoftGracePeriod:          filterKeys(linux.EvictionSoftGracePeriod, isWindowsEvictionSignal),
+		EvictionMinimumReclaim:           filterKeys(linux.EvictionMinimumReclaim, isWindowsEvictionSignal),
+		EvictionPressureTransitionPeriod: linux.EvictionPressureTransitionPeriod,
+		EvictionMaxPodGracePeriod:        linux.EvictionMaxPodGracePeriod,
+		SystemReserved:                   filterKeys(linux.SystemReserved, isWindowsReservedResource),
+		KubeReserved:                     filterKeys(linux.KubeReserved, isWindowsReservedResource),
+		FeatureGates:                     maps.Clone(linux.FeatureGates),
+	}
+	if linux.ImageGCHighThresholdPercent != nil {
+		high := *linux.ImageGCHighThresholdPercent
+		windowsConfig.ImageGCHighThresholdPercent = &high
+	}
+	if linux.ImageGCLowThresholdPercent != nil {
+		low := *linux.ImageGCLowThresholdPercent
+		windowsConfig.ImageGCLowThresholdPercent = &low
+	}
+	return windowsConfig
+}
+
+// isWindowsEvictionSignal returns true if the kubelet supports the given eviction signal on Windows nodes
+func isWindowsEvictionSignal(signal string) bool {
+	return !slices.Contains(windowsUnsupportedEvictionSignals, signal)
+}
+
+// isWindowsReservedResource returns true if the given resource can be reserved on Windows nodes
+func isWindowsReservedResource(resource string) bool {
+	return slices.Contains(windowsReservedResources, resource)
+}
+
+// filterKeys returns a copy of the given map holding the entries whose key is accepted by the given function. Returns
+// nil if no entry is accepted.
+func filterKeys(values map[string]string, accept func(string) bool) map[string]string {
+	var filtered map[string]string
+	for key, value := range values {
+		if !accept(key) {
+			continue
+		}
+		if filtered == nil {
+			filtered = make(map[string]string)
+		}
+		filtered[key] = value
+	}
+	return filtered
+}
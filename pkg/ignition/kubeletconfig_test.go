@@ -0,0 +1,141 @@
+package ignition
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeletconfig "k8s.io/kubelet/config/v1beta1"
+	"k8s.io/utils/ptr"
+)
+
+func TestGetKubeletConfig(t *testing.T) {
+	kubeletConfigFile := func(contents string) []ignCfgTypes.File {
+		file := ignCfgTypes.File{Node: ignCfgTypes.Node{Path: KubeletConfigPath}}
+		file.Contents.Source = ptr.To("data:," + url.PathEscape(contents))
+		return []ignCfgTypes.File{file}
+	}
+	testCases := []struct {
+		name          string
+		files         []ignCfgTypes.File
+		expected      *kubeletconfig.KubeletConfiguration
+		expectedErr   bool
+		expectMissing bool
+	}{
+		{
+			name: "yaml with unknown fields",
+			files: kubeletConfigFile(`kind: KubeletConfiguration
+apiVersion: kubelet.config.k8s.io/v1beta1
+cgroupDriver: systemd
+unknownField: true
+evictionHard:
+  memory.available: 100Mi
+  nodefs.inodesFree: 5%
+systemReserved:
+  cpu: 500m
+featureGates:
+  RotateKubeletServerCertificate: true
+`),
+			expected: &kubeletconfig.KubeletConfiguration{
+				TypeMeta: metav1.TypeMeta{Kind: "KubeletConfiguration",
+					APIVersion: "kubelet.config.k8s.io/v1beta1"},
+				CgroupDriver:   "systemd",
+				EvictionHard:   map[string]string{"memory.available": "100Mi", "nodefs.inodesFree": "5%"},
+				SystemReserved: map[string]string{"cpu": "500m"},
+				FeatureGates:   map[string]bool{"RotateKubeletServerCertificate": true},
+			},
+		},
+		{
+			name:     "json",
+			files:    kubeletConfigFile(`{"kind":"KubeletConfiguration","maxPods":250}`),
+			expected: &kubeletconfig.KubeletConfiguration{TypeMeta: metav1.TypeMeta{Kind: "KubeletConfiguration"}, MaxPods: 250},
+		},
+		{
+			name:          "missing file",
+			expectedErr:   true,
+			expectMissing: true,
+		},
+		{
+			name:        "invalid contents",
+			files:       kubeletConfigFile("evictionHard: [memory.available]\n"),
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{config: ignCfgTypes.Config{Storage: ignCfgTypes.Storage{Files: test.files}}}
+			config, err := ign.GetKubeletConfig()
+			if test.expectedErr {
+				require.Error(t, err)
+				assert.Equal(t, test.expectMissing, errors.Is(err, ErrFileNotFound))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, config)
+		})
+	}
+}
+
+func TestTranslateKubeletConfig(t *testing.T) {
+	testCases := []struct {
+		name     string
+		linux    *kubeletconfig.KubeletConfiguration
+		expected kubeletconfig.KubeletConfiguration
+	}{
+		{
+			name:     "empty",
+			linux:    &kubeletconfig.KubeletConfiguration{},
+			expected: kubeletconfig.KubeletConfiguration{},
+		},
+		{
+			name: "linux only fields and signals are dropped",
+			linux: &kubeletconfig.KubeletConfiguration{
+				TypeMeta:               metav1.TypeMeta{Kind: "KubeletConfiguration"},
+				CgroupDriver:           "systemd",
+				CgroupRoot:             "/",
+				SystemCgroups:          "/system.slice",
+				EnforceNodeAllocatable: []string{"pods"},
+				EvictionHard: map[string]string{"memory.available": "100Mi", "nodefs.available": "10%",
+					"nodefs.inodesFree": "5%", "imagefs.inodesFree": "5%", "pid.available": "10%"},
+				EvictionSoft:                     map[string]string{"memory.available": "500Mi"},
+				EvictionSoftGracePeriod:          map[string]string{"memory.available": "1m30s"},
+				EvictionMinimumReclaim:           map[string]string{"nodefs.inodesFree": "1%"},
+				EvictionPressureTransitionPeriod: metav1.Duration{Duration: 5 * time.Minute},
+				EvictionMaxPodGracePeriod:        30,
+				SystemReserved:                   map[string]string{"cpu": "500m", "memory": "1Gi", "pid": "1000"},
+				KubeReserved:                     map[string]string{"ephemeral-storage": "1Gi"},
+				FeatureGates:                     map[string]bool{"RotateKubeletServerCertificate": true},
+				ImageGCHighThresholdPercent:      ptr.To[int32](85),
+				ImageGCLowThresholdPercent:       ptr.To[int32](80),
+			},
+			expected: kubeletconfig.KubeletConfiguration{
+				TypeMeta:                         metav1.TypeMeta{Kind: "KubeletConfiguration"},
+				EvictionHard:                     map[string]string{"memory.available": "100Mi", "nodefs.available": "10%"},
+				EvictionSoft:                     map[string]string{"memory.available": "500Mi"},
+				EvictionSoftGracePeriod:          map[string]string{"memory.available": "1m30s"},
+				EvictionPressureTransitionPeriod: metav1.Duration{Duration: 5 * time.Minute},
+				EvictionMaxPodGracePeriod:        30,
+				SystemReserved:                   map[string]string{"cpu": "500m", "memory": "1Gi"},
+				KubeReserved:                     map[string]string{"ephemeral-storage": "1Gi"},
+				FeatureGates:                     map[string]bool{"RotateKubeletServerCertificate": true},
+				ImageGCHighThresholdPercent:      ptr.To[int32](85),
+				ImageGCLowThresholdPercent:       ptr.To[int32](80),
+			},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			windowsConfig := TranslateKubeletConfig(test.linux)
+			assert.Equal(t, test.expected, windowsConfig)
+			// The translated configuration must not share state with the Linux configuration
+			if test.linux.ImageGCHighThresholdPercent != nil {
+				assert.NotSame(t, test.linux.ImageGCHighThresholdPercent, windowsConfig.ImageGCHighThresholdPercent)
+			}
+		})
+	}
+}
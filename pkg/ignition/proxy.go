@@ -0,0 +1,76 @@
+package ignition
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ProxyEnvPath is the path of the file holding the cluster wide proxy environment variables, as defined in ignition
+	ProxyEnvPath = "/etc/mco/proxy.env"
+	// httpProxyVar is the environment variable holding the proxy used for HTTP requests
+	httpProxyVar = "HTTP_PROXY"
+	// httpsProxyVar is the environment variable holding the proxy used for HTTPS requests
+	httpsProxyVar = "HTTPS_PROXY"
+	// noProxyVar is the environment variable holding the comma separated destinations which must not be proxied
+	noProxyVar = "NO_PROXY"
+)
+
+// ProxyVars holds the cluster wide proxy settings configured on Linux nodes
+type ProxyVars struct {
+	// HTTPProxy is the proxy used for HTTP requests
+	HTTPProxy string
+	// HTTPSProxy is the proxy used for HTTPS requests
+	HTTPSProxy string
+	// NoProxy are the destinations which must not be proxied, such as hostnames, domains, IPs and CIDRs, in the
+	// order they are configured in
+	NoProxy []string
+}
+
+// GetProxyVars returns the proxy settings set for the kubelet, either by the Environment directives of its unit, or by
+// the proxy environment file within the ignition spec. Variables set by the proxy environment file take precedence.
+// An empty ProxyVars is returned if no proxy is configured.
+func (ign *Ignition) GetProxyVars() (ProxyVars, error) {
+	unitContents, dropinContents, err := ign.getUnitContents(kubeletSystemdName)
+	if err != nil {
+		return ProxyVars{}, err
+	}
+	service, err := parseServiceUnit(unitContents, dropinContents...)
+	if err != nil {
+		return ProxyVars{}, fmt.Errorf("error parsing kubelet systemd unit: %w", err)
+	}
+	environment, err := service.resolveEnvironment(ign.GetFileContents)
+	if err != nil {
+		return ProxyVars{}, err
+	}
+	contents, err := ign.GetFileContents(ProxyEnvPath)
+	if err != nil && !errors.Is(err, ErrFileNotFound) {
+		return ProxyVars{}, err
+	}
+	for name, value := range parseEnvironmentFile(string(contents)) {
+		environment[name] = value
+	}
+	return newProxyVars(environment), nil
+}
+
+// newProxyVars returns the proxy settings of the given environment. The upper case variables take precedence over
+// the lower case ones.
+func newProxyVars(environment map[string]string) ProxyVars {
+	lookup := func(name string) string {
+		if value, ok := environment[name]; ok {
+			return strings.TrimSpace(value)
+		}
+		return strings.TrimSpace(environment[strings.ToLower(name)])
+	}
+	proxyVars := ProxyVars{
+		HTTPProxy:  lookup(httpProxyVar),
+		HTTPSProxy: lookup(httpsProxyVar),
+	}
+	for _, destination := range strings.Split(lookup(noProxyVar), ",") {
+		if destination = strings.TrimSpace(destination); destination != "" {
+			proxyVars.NoProxy = append(proxyVars.NoProxy, destination)
+		}
+	}
+	return proxyVars
+}
@@ -0,0 +1,226 @@
+package ignition
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// RegistriesConfPath is the path to the containers registries configuration file as defined in ignition
+	RegistriesConfPath = "/etc/containers/registries.conf"
+	// registriesConfDropinPattern matches the containers registries configuration drop-ins, which are applied on top of
+	// the main file in lexicographic order
+	registriesConfDropinPattern = "/etc/containers/registries.conf.d/*.conf"
+	// registryTable and registryMirrorTable are the TOML array of tables holding the registries and their mirrors
+	registryTable       = "registry"
+	registryMirrorTable = "registry.mirror"
+	// PullFromMirrorDigestOnly is the pull-from-mirror value restricting a mirror to pulls by digest
+	PullFromMirrorDigestOnly = "digest-only"
+)
+
+// Registry is the configuration of a container image registry, as set by the containers registries configuration
+type Registry struct {
+	// Prefix selects the images the configuration applies to. If empty, Location is used.
+	Prefix string
+	// Location is the registry, with an optional namespace, the images are pulled from
+	Location string
+	// Insecure allows unencrypted connections and connections with unverified certificates to the registry
+	Insecure bool
+	// Blocked forbids pulling images from the registry
+	Blocked bool
+	// MirrorByDigestOnly restricts all mirrors of the registry to pulls by digest
+	MirrorByDigestOnly bool
+	// Mirrors are the locations the images are pulled from instead of Location, in order of preference
+	Mirrors []RegistryMirror
+}
+
+// RegistryMirror is a mirror of a container image registry
+type RegistryMirror struct {
+	// Location is the registry, with an optional namespace, mirroring the images
+	Location string
+	// Insecure allows unencrypted connections and connections with unverified certificates to the mirror
+	Insecure bool
+	// PullFromMirror restricts the pulls which use the mirror, such as PullFromMirrorDigestOnly. Empty means all pulls.
+	PullFromMirror string
+}
+
+// key returns the value identifying the registry across configuration files
+func (r Registry) key() string {
+	if r.Prefix != "" {
+		return r.Prefix
+	}
+	return r.Location
+}
+
+// GetRegistryMirrors returns the registries configured by the containers registries configuration file and its
+// drop-ins within the ignition spec, in the order they are configured in. A registry configured by a drop-in replaces
+// the one with the same prefix configured by the main file or an earlier drop-in. An empty slice is returned if the
+// ignition spec does not contain any registries configuration.
+func (ign *Ignition) GetRegistryMirrors() ([]Registry, error) {
+	dropins, err := ign.FilesMatching(registriesConfDropinPattern)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{RegistriesConfPath}
+	for _, dropin := range dropins {
+		paths = append(paths, dropin.Node.Path)
+	}
+	registries := []Registry{}
+	for _, path := range paths {
+		if !ign.FileExists(path) {
+			continue
+		}
+		contents, err := ign.GetFileContents(path)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parseRegistriesConf(string(contents))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", path, err)
+		}
+		for _, registry := range parsed {
+			if i := slices.IndexFunc(registries, func(r Registry) bool { return r.key() == registry.key() }); i != -1 {
+				registries[i] = registry
+				continue
+			}
+			registries = append(registries, registry)
+		}
+	}
+	return registries, nil
+}
+
+// parseRegistriesConf returns the registries configured by the given containers registries configuration. Only the
+// subset of TOML used by the configuration is supported: tables, arrays of tables, and key value pairs whose value is a
+// string, a boolean or an array. Settings other than the ones of the registries and their mirrors are ignored.
+func parseRegistriesConf(contents string) ([]Registry, error) {
+	var registries []Registry
+	table := ""
+	lines := strings.Split(contents, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			// only the options of the registry and mirror arrays of tables are of interest
+			table = ""
+			switch strings.ReplaceAll(line, " ", "") {
+			case "[[" + registryTable + "]]":
+				table = registryTable
+				registries = append(registries, Registry{})
+			case "[[" + registryMirrorTable + "]]":
+				if len(registries) == 0 {
+					return nil, fmt.Errorf("line %d: mirror defined outside of a registry", i+1)
+				}
+				table = registryMirrorTable
+				registry := &registries[len(registries)-1]
+				registry.Mirrors = append(registry.Mirrors, RegistryMirror{})
+			}
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected a key value pair", i+1)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value = strings.TrimSpace(value)
+		// arrays may span several lines, and are not used by the settings of interest
+		if strings.HasPrefix(value, "[") {
+			for strings.Count(value, "[") > strings.Count(value, "]") && i+1 < len(lines) {
+				i++
+				value += strings.TrimSpace(stripTOMLComment(lines[i]))
+			}
+			continue
+		}
+		if table == "" {
+			continue
+		}
+		if err := setRegistryOption(&registries[len(registries)-1], table, key, value); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+	return registries, nil
+}
+
+// setRegistryOption sets the option with the given key and TOML encoded value of the given registry, or of its last
+// mirror if the option belongs to the mirror table
+func setRegistryOption(registry *Registry, table, key, value string) error {
+	var stringOption *string
+	var boolOption *bool
+	if table == registryMirrorTable {
+		mirror := &registry.Mirrors[len(registry.Mirrors)-1]
+		switch key {
+		case "location":
+			stringOption = &mirror.Location
+		case "insecure":
+			boolOption = &mirror.Insecure
+		case "pull-from-mirror":
+			stringOption = &mirror.PullFromMirror
+		}
+	} else {
+		switch key {
+		case "prefix":
+			stringOption = &registry.Prefix
+		case "location":
+			stringOption = &registry.Location
+		case "insecure":
+			boolOption = &registry.Insecure
+		case "blocked":
+			boolOption = &registry.Blocked
+		case "mirror-by-digest-only":
+			boolOption = &registry.MirrorByDigestOnly
+		}
+	}
+	switch {
+	case stringOption != nil:
+		parsed, err := parseTOMLString(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*stringOption = parsed
+	case boolOption != nil:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*boolOption = parsed
+	}
+	return nil
+}
+
+// parseTOMLString returns the value of the given TOML basic or literal string
+func parseTOMLString(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1], nil
+	}
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return strconv.Unquote(value)
+	}
+	return "", fmt.Errorf("expected a string, got %s", value)
+}
+
+// stripTOMLComment returns the given TOML line without its comment, if any. Comments start with a hash sign which is
+// not part of a string.
+func stripTOMLComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,125 @@
+package ignition
+
+import (
+	"net/url"
+	"testing"
+
+	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+)
+
+func TestGetRegistryMirrors(t *testing.T) {
+	registriesFile := func(path, contents string) ignCfgTypes.File {
+		file := ignCfgTypes.File{Node: ignCfgTypes.Node{Path: path}}
+		file.Contents.Source = ptr.To("data:," + url.PathEscape(contents))
+		return file
+	}
+	registriesConf := `unqualified-search-registries = [
+  "registry.access.redhat.com", # the Red Hat registry
+  "docker.io"
+]
+short-name-mode = ""
+
+[[registry]]
+  prefix = ""
+  location = "quay.io/openshift-release-dev/ocp-release"
+  mirror-by-digest-only = true
+
+  [[registry.mirror]]
+    location = "mirror.example.com:5000/ocp/release"
+    insecure = false
+
+  [[registry.mirror]]
+    location = 'backup.example.com/ocp/release'
+
+[[registry]]
+  prefix = ""
+  location = "registry.example.com"
+  insecure = true # self-signed
+  blocked = false
+
+  [[registry.mirror]]
+    location = "mirror.example.com:5000"
+    pull-from-mirror = "digest-only"
+`
+	testCases := []struct {
+		name        string
+		files       []ignCfgTypes.File
+		expected    []Registry
+		expectedErr bool
+	}{
+		{
+			name:     "no registries configuration",
+			expected: []Registry{},
+		},
+		{
+			name:  "single file",
+			files: []ignCfgTypes.File{registriesFile(RegistriesConfPath, registriesConf)},
+			expected: []Registry{
+				{
+					Location:           "quay.io/openshift-release-dev/ocp-release",
+					MirrorByDigestOnly: true,
+					Mirrors: []RegistryMirror{{Location: "mirror.example.com:5000/ocp/release"},
+						{Location: "backup.example.com/ocp/release"}},
+				},
+				{
+					Location: "registry.example.com",
+					Insecure: true,
+					Mirrors: []RegistryMirror{{Location: "mirror.example.com:5000",
+						PullFromMirror: PullFromMirrorDigestOnly}},
+				},
+			},
+		},
+		{
+			name: "drop-ins win",
+			files: []ignCfgTypes.File{
+				registriesFile("/etc/containers/registries.conf.d/20-b.conf", "[[registry]]\n"+
+					"location = \"registry.example.com\"\n[[registry.mirror]]\nlocation = \"b.example.com\"\n"),
+				registriesFile(RegistriesConfPath, registriesConf),
+				registriesFile("/etc/containers/registries.conf.d/10-a.conf", "[[registry]]\n"+
+					"location = \"registry.example.com\"\n[[registry.mirror]]\nlocation = \"a.example.com\"\n"+
+					"[[registry]]\nprefix = \"docker.io/library\"\nlocation = \"mirror.example.com/library\"\n"),
+			},
+			expected: []Registry{
+				{
+					Location:           "quay.io/openshift-release-dev/ocp-release",
+					MirrorByDigestOnly: true,
+					Mirrors: []RegistryMirror{{Location: "mirror.example.com:5000/ocp/release"},
+						{Location: "backup.example.com/ocp/release"}},
+				},
+				{
+					Location: "registry.example.com",
+					Mirrors:  []RegistryMirror{{Location: "b.example.com"}},
+				},
+				{
+					Prefix:   "docker.io/library",
+					Location: "mirror.example.com/library",
+				},
+			},
+		},
+		{
+			name:        "mirror outside of a registry",
+			files:       []ignCfgTypes.File{registriesFile(RegistriesConfPath, "[[registry.mirror]]\nlocation = \"a\"\n")},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid boolean",
+			files:       []ignCfgTypes.File{registriesFile(RegistriesConfPath, "[[registry]]\ninsecure = \"yes\"\n")},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{config: ignCfgTypes.Config{Storage: ignCfgTypes.Storage{Files: test.files}}}
+			registries, err := ign.GetRegistryMirrors()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, registries)
+		})
+	}
+}
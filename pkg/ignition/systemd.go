@@ -0,0 +1,285 @@
+package ignition
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/unit"
+	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/google/shlex"
+)
+
+var (
+	// ErrUnitNotFound is returned when the ignition spec does not contain the requested systemd unit
+	ErrUnitNotFound = errors.New("systemd unit not found in ignition")
+	// ErrUnitMasked is returned when the requested systemd unit is masked or disabled within the ignition spec, and so
+	// is not run on Linux nodes
+	ErrUnitMasked = errors.New("systemd unit is masked")
+)
+
+// environmentReference matches a reference to an environment variable within a systemd command line
+var environmentReference = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}|\$[A-Za-z_][A-Za-z0-9_]*`)
+
+// serviceUnit holds the directives of a systemd service unit, with its drop-ins applied, which determine the command
+// run by the service and its environment
+type serviceUnit struct {
+	// execStart is the command of the service
+	execStart string
+	// environment holds the variables set by Environment directives
+	environment map[string]string
+	// environmentFiles are the paths of the files setting variables, a leading dash marks a file allowed not to exist
+	environmentFiles []string
+}
+
+// GetSystemdUnit returns the systemd unit with the given name, such as kubelet.service, from the ignition spec.
+// ErrUnitNotFound is returned if the ignition spec does not contain the unit, and ErrUnitMasked if the unit is masked
+// or disabled.
+func (ign *Ignition) GetSystemdUnit(name string) (*ignCfgTypes.Unit, error) {
+	ign.lock.RLock()
+	defer ign.lock.RUnlock()
+	for _, systemdUnit := range ign.config.Systemd.Units {
+		if systemdUnit.Name != name {
+			continue
+		}
+		if (systemdUnit.Mask != nil && *systemdUnit.Mask) || (systemdUnit.Enabled != nil && !*systemdUnit.Enabled) {
+			return nil, fmt.Errorf("%s: %w", name, ErrUnitMasked)
+		}
+		systemdUnit.Dropins = slices.Clone(systemdUnit.Dropins)
+		return &systemdUnit, nil
+	}
+	return nil, fmt.Errorf("%s: %w", name, ErrUnitNotFound)
+}
+
+// GetUnitArgs returns the arguments of the command run by the systemd service with the given name, as specified in
+// the ignition file, keyed by flag name. Flags without a value are returned with an empty value. Variables referenced
+// by the arguments are resolved from the Environment directives of the unit and the EnvironmentFiles within the
+// ignition spec. The names of the variables which could not be resolved are returned as warnings, and their references
+// are kept as is within the arguments.
+func (ign *Ignition) GetUnitArgs(name string) (map[string]string, []string, error) {
+	unitContents, dropinContents, err := ign.getUnitContents(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	args, unresolved, err := parseUnitArgs(ign.GetFileContents, unitContents, dropinContents...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing %s args: %w", name, err)
+	}
+	return args, unresolved, nil
+}
+
+// getUnitContents returns the contents of the systemd unit with the given name and of its drop-ins, in the order
+// systemd applies them
+func (ign *Ignition) getUnitContents(name string) (string, []string, error) {
+	systemdUnit, err := ign.GetSystemdUnit(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if systemdUnit.Contents == nil {
+		return "", nil, fmt.Errorf("ignition missing %s unit file", name)
+	}
+	// drop-ins are applied on top of the unit in the lexicographic order of their names
+	slices.SortFunc(systemdUnit.Dropins, func(a, b ignCfgTypes.Dropin) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	var dropinContents []string
+	for _, dropin := range systemdUnit.Dropins {
+		if dropin.Contents != nil {
+			dropinContents = append(dropinContents, *dropin.Contents)
+		}
+	}
+	return *systemdUnit.Contents, dropinContents, nil
+}
+
+// parseServiceUnit parses the Service section of a systemd unit file and its drop-ins, applied in the given order
+func parseServiceUnit(unitContents string, dropinContents ...string) (*serviceUnit, error) {
+	var options []*unit.UnitOption
+	for _, contents := range append([]string{unitContents}, dropinContents...) {
+		unitOptions, err := unit.DeserializeOptions(strings.NewReader(normalizeUnitContents(contents)))
+		if err != nil {
+			return nil, fmt.Errorf("error deserializing unit: %w", err)
+		}
+		options = append(options, unitOptions...)
+	}
+	// An empty ExecStart resets the commands set by previous ExecStart directives, so the command of the unit is the
+	// value of the last ExecStart directive of the Service section. Environment and EnvironmentFile directives
+	// accumulate, with an empty directive resetting the ones set previously.
+	service := &serviceUnit{environment: make(map[string]string)}
+	for _, option := range options {
+		if option.Section != serviceSection {
+			continue
+		}
+		switch option.Name {
+		case execStartOption:
+			service.execStart = option.Value
+		case environmentOption:
+			if option.Value == "" {
+				service.environment = make(map[string]string)
+				continue
+			}
+			assignments, err := shlex.Split(option.Value)
+			if err != nil {
+				return nil, fmt.Errorf("error splitting Environment assignments: %w", err)
+			}
+			for _, assignment := range assignments {
+				if name, value, found := strings.Cut(assignment, "="); found {
+					service.environment[name] = value
+				}
+			}
+		case environmentFileOption:
+			if option.Value == "" {
+				service.environmentFiles = nil
+				continue
+			}
+			service.environmentFiles = append(service.environmentFiles, option.Value)
+		}
+	}
+	return service, nil
+}
+
+// resolveEnvironment returns the variables set for the service. The contents of EnvironmentFiles are read with the
+// given function, ErrFileNotFound is expected if a file is not available. If the function is nil, only the variables
+// set by Environment directives are returned.
+func (service *serviceUnit) resolveEnvironment(readFile func(path string) ([]byte, error)) (map[string]string, error) {
+	environment := make(map[string]string)
+	for name, value := range service.environment {
+		environment[name] = value
+	}
+	if readFile == nil {
+		return environment, nil
+	}
+	// variables set by EnvironmentFiles override the ones set by Environment directives
+	for _, environmentFile := range service.environmentFiles {
+		contents, err := readFile(strings.TrimPrefix(environmentFile, "-"))
+		if err != nil {
+			if errors.Is(err, ErrFileNotFound) {
+				// the file is not part of the ignition spec, such as a file generated on the Linux node, so the
+				// variables it sets are left unresolved
+				continue
+			}
+			return nil, fmt.Errorf("error reading EnvironmentFile %s: %w", environmentFile, err)
+		}
+		for name, value := range parseEnvironmentFile(string(contents)) {
+			environment[name] = value
+		}
+	}
+	return environment, nil
+}
+
+// parseUnitArgs parses a systemd unit file and its drop-ins, returning the flags of the command of the service keyed
+// by name, and the names of the variables referenced by the command which could not be resolved. The contents of
+// EnvironmentFiles are read with the given function, ErrFileNotFound is expected if a file is not available.
+func parseUnitArgs(readFile func(path string) ([]byte, error), unitContents string,
+	dropinContents ...string) (map[string]string, []string, error) {
+	service, err := parseServiceUnit(unitContents, dropinContents...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if service.execStart == "" {
+		return nil, nil, fmt.Errorf("unit missing ExecStart")
+	}
+	environment, err := service.resolveEnvironment(readFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	// systemd joins continuation lines with a space before splitting the command line into words
+	argumentSplit, err := shlex.Split(strings.ReplaceAll(service.execStart, "\\\n", " "))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error splitting ExecStart command line: %w", err)
+	}
+	if len(argumentSplit) == 0 {
+		return nil, nil, fmt.Errorf("unit missing ExecStart")
+	}
+	args := make(map[string]string)
+	// Skipping the first word, which indicates the binary, look at all the flags. A flag is given either as a key value
+	// pair, as a key followed by its value in the next word, or as a key without a value, such as a boolean flag, which
+	// is returned with an empty value. A key followed by a word which is not a flag is always treated as the former, as
+	// boolean flags cannot be told apart from other flags. Words which are neither flags nor flag values, such as the
+	// path of the binary when it is run through a wrapper, are ignored.
+	words := argumentSplit[1:]
+	unresolved := make(map[string]struct{})
+	for i := range words {
+		words[i] = expandEnvironment(words[i], environment, unresolved)
+	}
+	for i := 0; i < len(words); i++ {
+		if !strings.HasPrefix(words[i], "-") {
+			continue
+		}
+		flag := strings.TrimLeft(words[i], "-")
+		if flag == "" {
+			continue
+		}
+		if key, value, found := strings.Cut(flag, "="); found {
+			args[key] = value
+			continue
+		}
+		if i+1 < len(words) && !strings.HasPrefix(words[i+1], "-") {
+			args[flag] = words[i+1]
+			i++
+			continue
+		}
+		args[flag] = ""
+	}
+	unresolvedNames := make([]string, 0, len(unresolved))
+	for name := range unresolved {
+		unresolvedNames = append(unresolvedNames, name)
+	}
+	sort.Strings(unresolvedNames)
+	return args, unresolvedNames, nil
+}
+
+// expandEnvironment replaces the references to the variables of the given environment within the given word.
+// References to other variables are kept as is, and their names are added to the given unresolved set.
+func expandEnvironment(word string, environment map[string]string, unresolved map[string]struct{}) string {
+	return environmentReference.ReplaceAllStringFunc(word, func(reference string) string {
+		name := strings.Trim(reference, "${}")
+		if value, ok := environment[name]; ok {
+			return value
+		}
+		unresolved[name] = struct{}{}
+		return reference
+	})
+}
+
+// parseEnvironmentFile returns the variables set by the given systemd EnvironmentFile contents. Each line sets a
+// variable as NAME=VALUE, with the value optionally quoted. Empty lines and comments are ignored.
+func parseEnvironmentFile(contents string) map[string]string {
+	environment := make(map[string]string)
+	for _, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		environment[strings.TrimSpace(name)] = value
+	}
+	return environment
+}
+
+// normalizeUnitContents removes trailing whitespace from each line of the given unit contents, along with comment
+// lines within continued values. systemd tolerates both, while the unit deserializer would end the value early or keep
+// the comment as part of the value.
+func normalizeUnitContents(unitContents string) string {
+	var lines []string
+	continued := false
+	for _, line := range strings.Split(unitContents, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if continued && (strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";")) {
+			continue
+		}
+		lines = append(lines, line)
+		continued = strings.HasSuffix(line, "\\")
+	}
+	return strings.Join(lines, "\n")
+}
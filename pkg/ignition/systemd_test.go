@@ -0,0 +1,98 @@
+package ignition
+
+import (
+	"errors"
+	"testing"
+
+	ignCfgTypes "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+)
+
+func TestGetUnitArgs(t *testing.T) {
+	crioUnit := ignCfgTypes.Unit{
+		Name:    CRIOSystemdName,
+		Enabled: ptr.To(true),
+		Contents: ptr.To("[Service]\nEnvironment=CRIO_LOG_LEVEL=info\n" +
+			"ExecStart=/usr/bin/crio --log-level=${CRIO_LOG_LEVEL} $CRIO_STORAGE_OPTIONS\n"),
+		Dropins: []ignCfgTypes.Dropin{
+			{Name: "20-verbose.conf", Contents: ptr.To("[Service]\nEnvironment=CRIO_LOG_LEVEL=debug\n")},
+			{Name: "10-metrics.conf", Contents: ptr.To("[Service]\nExecStart=\n" +
+				"ExecStart=/usr/bin/crio --log-level=${CRIO_LOG_LEVEL} --enable-metrics\n")},
+		},
+	}
+	testCases := []struct {
+		name               string
+		units              []ignCfgTypes.Unit
+		unitName           string
+		expected           map[string]string
+		expectedUnresolved []string
+		expectedErr        error
+	}{
+		{
+			name:               "unit with drop-ins",
+			units:              []ignCfgTypes.Unit{crioUnit},
+			unitName:           CRIOSystemdName,
+			expected:           map[string]string{"log-level": "debug", "enable-metrics": ""},
+			expectedUnresolved: []string{},
+		},
+		{
+			name: "unit with unresolved variables",
+			units: []ignCfgTypes.Unit{{Name: "kube-proxy.service",
+				Contents: ptr.To("[Service]\nExecStart=/usr/bin/kube-proxy --v=${KUBE_PROXY_LOG_LEVEL}\n")}},
+			unitName:           "kube-proxy.service",
+			expected:           map[string]string{"v": "${KUBE_PROXY_LOG_LEVEL}"},
+			expectedUnresolved: []string{"KUBE_PROXY_LOG_LEVEL"},
+		},
+		{
+			name: "masked unit",
+			units: []ignCfgTypes.Unit{{Name: CRIOSystemdName, Mask: ptr.To(true),
+				Contents: crioUnit.Contents}},
+			unitName:    CRIOSystemdName,
+			expectedErr: ErrUnitMasked,
+		},
+		{
+			name: "disabled unit",
+			units: []ignCfgTypes.Unit{{Name: CRIOSystemdName, Enabled: ptr.To(false),
+				Contents: crioUnit.Contents}},
+			unitName:    CRIOSystemdName,
+			expectedErr: ErrUnitMasked,
+		},
+		{
+			name:        "missing unit",
+			units:       []ignCfgTypes.Unit{crioUnit},
+			unitName:    kubeletSystemdName,
+			expectedErr: ErrUnitNotFound,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			ign := &Ignition{config: ignCfgTypes.Config{Systemd: ignCfgTypes.Systemd{Units: test.units}}}
+			args, unresolved, err := ign.GetUnitArgs(test.unitName)
+			if test.expectedErr != nil {
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, test.expectedErr), "unexpected error %s", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, args)
+			assert.Equal(t, test.expectedUnresolved, unresolved)
+		})
+	}
+}
+
+func TestGetSystemdUnit(t *testing.T) {
+	dropins := []ignCfgTypes.Dropin{{Name: "20-b.conf"}, {Name: "10-a.conf"}}
+	ign := &Ignition{config: ignCfgTypes.Config{Systemd: ignCfgTypes.Systemd{Units: []ignCfgTypes.Unit{
+		{Name: CRIOSystemdName, Contents: ptr.To("[Service]\nExecStart=/usr/bin/crio\n"), Dropins: dropins},
+	}}}}
+	systemdUnit, err := ign.GetSystemdUnit(CRIOSystemdName)
+	require.NoError(t, err)
+	assert.Equal(t, CRIOSystemdName, systemdUnit.Name)
+
+	// ordering the drop-ins of the returned unit must not affect the ignition spec
+	_, _, err = ign.GetUnitArgs(CRIOSystemdName)
+	require.NoError(t, err)
+	assert.Equal(t, "20-b.conf", dropins[0].Name)
+}
@@ -0,0 +1,17 @@
+// Package internal holds the files shipped in the operator payload which are not built from source
+package internal
+
+import (
+	_ "embed"
+)
+
+// HNSModule is the contents of the PowerShell module which defines functions for dealing with Windows HNS networks
+//
+//go:embed hns.psm1
+var HNSModule string
+
+// WinDefenderExclusionScript is the contents of the PowerShell script that creates exclusions for the given executables
+// if the Windows Defender Antivirus is active
+//
+//go:embed windows-defender-exclusion.ps1
+var WinDefenderExclusionScript string
@@ -1,18 +1,18 @@
-# This script creates an exclusion for the given file if the Windows Defender antivirus is running on the instance.
+# This script creates an exclusion for the given files if the Windows Defender antivirus is running on the instance.
 # No action taken otherwise. 
 # If getting the antivirus process or creating the exclusion fails unexpectedly, return the error.
 # Returns nothing otherwise.
 
 # USAGE
-#    windows-defender-exclusion.ps1 [OPTIONS] <file_path>
+#    windows-defender-exclusion.ps1 [OPTIONS] <file_path>[,<file_path>...]
 # OPTIONS
-#    -BinPath                 path to the file that should be excluded
+#    -BinPaths                paths to the files that should be excluded. -BinPath is accepted as an alias.
 # EXAMPLES
-#    windows-defender-exclusion.ps1 -BinPath "C:\k\containerd\containerd.exe"
+#    windows-defender-exclusion.ps1 -BinPaths "C:\k\containerd\containerd.exe","C:\k\kubelet.exe"
 
 
 param(
-    [Parameter(Mandatory=$true)] [String] $BinPath
+    [Parameter(Mandatory=$true)] [Alias("BinPath")] [String[]] $BinPaths
 )
 
 # Check if the process associated with Windows Defender exists. Reference:
@@ -22,7 +22,7 @@ try {
     # error is relevant, and errors are not suppressed.
     $winDefenderProcess = Get-Process -Name MsMpEng -ErrorAction Stop
     # No error means the process exists, so create exclusion
-    Add-MpPreference -ExclusionProcess $BinPath 
+    Add-MpPreference -ExclusionProcess $BinPaths
 }
 catch [Microsoft.PowerShell.Commands.ProcessCommandException] {
     # Process does not exist, do nothing
@@ -4,22 +4,22 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
 	configv1 "github.com/openshift/api/config/v1"
 	clientset "github.com/openshift/client-go/config/clientset/versioned"
-	"github.com/vincent-petithory/dataurl"
 	"golang.org/x/crypto/ssh"
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
-	clientcmdv1 "k8s.io/client-go/tools/clientcmd/api/v1"
 	cloudproviderapi "k8s.io/cloud-provider/api"
 	cloudnodeutil "k8s.io/cloud-provider/node/helpers"
 	"k8s.io/kubectl/pkg/drain"
@@ -27,12 +27,14 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	crclientcfg "sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/certificates"
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/ignition"
 	"github.com/openshift/windows-machine-config-operator/pkg/instance"
 	"github.com/openshift/windows-machine-config-operator/pkg/metadata"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeutil"
 	"github.com/openshift/windows-machine-config-operator/pkg/retry"
 	"github.com/openshift/windows-machine-config-operator/pkg/secrets"
@@ -53,15 +55,24 @@ const (
 	WorkerLabel = "node-role.kubernetes.io/worker"
 	// PubKeyHashAnnotation corresponds to the public key present on the VM
 	PubKeyHashAnnotation = "windowsmachineconfig.openshift.io/pub-key-hash"
+	// PayloadFilesAnnotation holds the payload files transferred to the instance by WMCO, used to clean up files which
+	// are no longer part of the payload after an upgrade
+	PayloadFilesAnnotation = "windowsmachineconfig.openshift.io/payload-files"
+	// ContainerdDebugAnnotation enables containerd debug logging and its debug API on the node when set to true
+	ContainerdDebugAnnotation = "windowsmachineconfig.openshift.io/containerd-debug"
 	// KubeletClientCAFilename is the name of the CA certificate file required by kubelet to interact
 	// with the kube-apiserver client
-	KubeletClientCAFilename = "kubelet-ca.crt"
+	KubeletClientCAFilename = ignition.KubeletCAFilename
 	// mcoNamespace is the namespace the Machine Config Server is deployed in, which manages the node bootsrapper secret
 	mcoNamespace = "openshift-machine-config-operator"
 	// mcoBootstrapSecret is the resource name that holds the cert and token required to create the bootstrap kubeconfig
 	mcoBootstrapSecret = "node-bootstrapper-token"
 )
 
+// cloudConfigRequiredPlatforms are the platforms whose Windows nodes cannot be configured without the cloud config file
+// referenced by the kubelet args
+var cloudConfigRequiredPlatforms = []configv1.PlatformType{configv1.AzurePlatformType, configv1.VSpherePlatformType}
+
 // nodeConfig holds the information to make the given VM a kubernetes node. As of now, it holds the information
 // related to kubeclient and the windowsVM.
 type nodeConfig struct {
@@ -128,6 +139,12 @@ func NewNodeConfig(c client.Client, clientset *kubernetes.Clientset, clusterServ
 	log := ctrl.Log.WithName(fmt.Sprintf("nc %s", instanceInfo.Address))
 	win, err := windows.New(clusterDNS, instanceInfo, signer, &platformType)
 	if err != nil {
+		if errors.Is(err, payload.ErrPayloadFileNotFound) {
+			// a missing payload file is a bug in the operator image, retrying will not resolve it so the error is
+			// terminal, preventing the reconciler from requeuing the request
+			return nil, reconcile.TerminalError(fmt.Errorf("operator image is missing required payload files: %w",
+				err))
+		}
 		return nil, fmt.Errorf("error instantiating Windows instance from VM: %w", err)
 	}
 
@@ -217,6 +234,14 @@ func (nc *nodeConfig) Configure() error {
 			return fmt.Errorf("error getting node object: %w", err)
 		}
 
+		// Obsolete payload files are only removed once the services have been restarted without them and the node is
+		// Ready. A failed removal does not fail the configuration, the files are tracked until they are removed.
+		if err := nc.removeObsoletePayloadFiles(); err != nil {
+			nc.log.Error(err, "unable to remove obsolete payload files")
+		} else if err := nc.applyPayloadFilesAnnotation(); err != nil {
+			return err
+		}
+
 		// If we deploy on Azure with CCM support, we have to explicitly remove the cloud taint, because cloud node
 		// manager running on the node can't do it itself, due to lack of RBAC permissions given by the node
 		// kubeconfig it uses.
@@ -257,6 +282,52 @@ func (nc *nodeConfig) Configure() error {
 	return err
 }
 
+// removeObsoletePayloadFiles removes files transferred to the instance by a previous configuration which are no longer
+// part of the payload, for example binaries dropped or renamed in a newer operator version
+func (nc *nodeConfig) removeObsoletePayloadFiles() error {
+	value, present := nc.node.GetAnnotations()[PayloadFilesAnnotation]
+	if !present {
+		return nil
+	}
+	var previous []*payload.FileInfo
+	if err := json.Unmarshal([]byte(value), &previous); err != nil {
+		// an invalid annotation only prevents the cleanup, it must not block configuration
+		nc.log.Info("unable to parse annotation, skipping removal of obsolete payload files",
+			"annotation", PayloadFilesAnnotation, "error", err)
+		return nil
+	}
+	diff, err := payload.Diff(previous, nc.Windows.PayloadFiles())
+	if err != nil {
+		nc.log.Info("unable to compare payload files, skipping removal of obsolete payload files", "error", err)
+		return nil
+	}
+	removed := diff.Removed
+	if len(removed) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(removed))
+	for _, file := range removed {
+		paths = append(paths, file.Path)
+	}
+	nc.log.Info("removing obsolete payload files", "files", paths)
+	return nc.Windows.RemoveFiles(paths)
+}
+
+// applyPayloadFilesAnnotation annotates the node with the payload files transferred to the instance, so that the files
+// which become obsolete can be removed by a later configuration
+func (nc *nodeConfig) applyPayloadFilesAnnotation() error {
+	payloadFiles, err := json.Marshal(nc.Windows.PayloadFiles())
+	if err != nil {
+		return fmt.Errorf("error encoding payload files: %w", err)
+	}
+	if err := metadata.ApplyLabelsAndAnnotations(context.TODO(), nc.client, *nc.node, nil,
+		map[string]string{PayloadFilesAnnotation: string(payloadFiles)}); err != nil {
+		return fmt.Errorf("error updating %s annotation on node %s: %w", PayloadFilesAnnotation,
+			nc.node.GetName(), err)
+	}
+	return nil
+}
+
 // safeReboot safely restarts the underlying instance, first cordoning and draining the associated node.
 // Waits for reboot to take effect before uncordoning the node.
 func (nc *nodeConfig) SafeReboot(ctx context.Context) error {
@@ -348,15 +419,25 @@ func (nc *nodeConfig) createWICDServiceAccountTokenSecret() (*core.Secret, error
 
 // createBootstrapFiles creates all prerequisite files on the node required to start kubelet using latest ignition spec
 func (nc *nodeConfig) createBootstrapFiles() error {
-	filePathsToContents := make(map[string]string)
-	filePathsToContents, err := nc.createFilesFromIgnition()
+	ign, err := ignition.New(context.TODO(), nc.client)
 	if err != nil {
 		return err
 	}
-	filePathsToContents[windows.BootstrapKubeconfigPath], err = nc.generateBootstrapKubeconfig()
+	filePathsToContents, err := nc.createFilesFromIgnition(ign)
 	if err != nil {
 		return err
 	}
+	bootstrapFiles, err := nc.renderBootstrapFiles(ign)
+	if err != nil {
+		return err
+	}
+	files, err := bootstrapFiles.Files(windows.K8sDir)
+	if err != nil {
+		return err
+	}
+	for path, contents := range files {
+		filePathsToContents[path] = string(contents)
+	}
 	filePathsToContents[windows.KubeletConfigPath], err = createKubeletConf(nc.clusterServiceCIDR)
 	if err != nil {
 		return err
@@ -376,53 +457,56 @@ func (nc *nodeConfig) write(pathToData map[string]string) error {
 }
 
 // createFilesFromIgnition returns the contents and write locations on the instance for any file it can create from
-// ignition spec: kubelet CA cert, cloud-config file
-func (nc *nodeConfig) createFilesFromIgnition() (map[string]string, error) {
-	ign, err := ignition.New(nc.client)
+// the given ignition spec, other than the bootstrap files: cloud-config file
+func (nc *nodeConfig) createFilesFromIgnition(ign *ignition.Ignition) (map[string]string, error) {
+	_, unresolved, err := ign.GetKubeletArgs()
 	if err != nil {
 		return nil, err
 	}
-	kubeletArgs, err := ign.GetKubeletArgs()
-	if err != nil {
-		return nil, err
+	if len(unresolved) > 0 {
+		nc.log.V(1).Info("kubelet args reference variables which could not be resolved", "variables", unresolved)
 	}
 
-	filesToTransfer := map[string]struct{}{}
-	if _, ok := kubeletArgs[ignition.CloudConfigOption]; ok {
-		filesToTransfer[ignition.CloudConfigPath] = struct{}{}
-	}
 	filePathsToContents := make(map[string]string)
-	// process kubelet-ca
-	filePathsToContents[windows.K8sDir+"\\"+KubeletClientCAFilename] = string(ign.GetKubeletCAData())
-	// loop through all the files in the ignition if they are files to transfer
-	if len(filesToTransfer) == 0 {
+	// the cloud config file is only transferred if the kubelet args reference it and it is present in the ignition,
+	// its absence is only tolerated on the platforms which do not require it
+	contents, err := ign.ValidateCloudConfig()
+	if err != nil {
+		if !errors.Is(err, ignition.ErrFileNotFound) || slices.Contains(cloudConfigRequiredPlatforms, nc.platformType) {
+			return nil, err
+		}
+		nc.log.Error(err, "not transferring cloud config", "platform", nc.platformType)
 		return filePathsToContents, nil
 	}
-	// For each new file in the ignition file check if is a file we are interested in and, if so, decode its contents
-	for _, ignFile := range ign.GetFiles() {
-		if _, ok := filesToTransfer[ignFile.Node.Path]; ok {
-			if ignFile.Contents.Source == nil {
-				return nil, fmt.Errorf("could not process %s: File is empty", ignFile.Node.Path)
-			}
-			contents, err := dataurl.DecodeString(*ignFile.Contents.Source)
-			if err != nil {
-				return nil, fmt.Errorf("could not decode %s: %w", ignFile.Node.Path, err)
-			}
-			fileName := filepath.Base(ignFile.Node.Path)
-			filePathsToContents[windows.K8sDir+"\\"+fileName] = string(contents.Data)
+	if contents == nil {
+		return filePathsToContents, nil
+	}
+	// the cloud config file is copied as is, warn about modes which cannot be carried over to the instance
+	if metadata, err := ign.GetFileMetadata(ignition.CloudConfigPath); err == nil {
+		access, warnings := metadata.WindowsAccess()
+		for _, warning := range warnings {
+			nc.log.Error(errors.New(warning), "unexpected cloud config permissions")
 		}
+		nc.log.V(1).Info("transferring cloud config", "access", access, "user", metadata.User,
+			"group", metadata.Group)
 	}
+	filePathsToContents[windows.K8sDir+"\\"+filepath.Base(ignition.CloudConfigPath)] = string(contents)
 	return filePathsToContents, nil
 }
 
-// generateBootstrapKubeconfig returns contents of a kubeconfig for kubelet to initially communicate with the API server
-func (nc *nodeConfig) generateBootstrapKubeconfig() (string, error) {
+// renderBootstrapFiles returns the kubelet CA from the given ignition spec, and the kubeconfig for kubelet to initially
+// communicate with the API server, created from the MCS bootstrap ServiceAccount token secret
+func (nc *nodeConfig) renderBootstrapFiles(ign *ignition.Ignition) (ignition.BootstrapFiles, error) {
 	bootstrapSecret, err := nc.k8sclientset.CoreV1().Secrets(mcoNamespace).Get(context.TODO(), mcoBootstrapSecret,
 		meta.GetOptions{})
 	if err != nil {
-		return "", err
+		return ignition.BootstrapFiles{}, err
 	}
-	return newKubeconfigFromSecret(bootstrapSecret, "kubelet")
+	caCert, token, err := serviceAccountCredentials(bootstrapSecret)
+	if err != nil {
+		return ignition.BootstrapFiles{}, err
+	}
+	return ign.RenderBootstrapFiles(caCert, nodeConfigCache.apiServerEndpoint, string(token))
 }
 
 // generateWICDKubeconfig returns the contents of a kubeconfig created from the WICD ServiceAccount
@@ -436,24 +520,31 @@ func (nc *nodeConfig) generateWICDKubeconfig() (string, error) {
 
 // newKubeconfigFromSecret returns the contents of a kubeconfig generated from the given service account token secret
 func newKubeconfigFromSecret(saSecret *core.Secret, username string) (string, error) {
-	// extract ca.crt and token data fields
+	caCert, token, err := serviceAccountCredentials(saSecret)
+	if err != nil {
+		return "", err
+	}
+	kc := ignition.NewKubeconfig(caCert, string(token), nodeConfigCache.apiServerEndpoint, username)
+	kubeconfigData, err := json.Marshal(kc)
+	if err != nil {
+		return "", err
+	}
+	return string(kubeconfigData), nil
+}
+
+// serviceAccountCredentials returns the ca.crt and token data fields of the given service account token secret
+func serviceAccountCredentials(saSecret *core.Secret) ([]byte, []byte, error) {
 	caCert := saSecret.Data[core.ServiceAccountRootCAKey]
 	if caCert == nil {
-		return "", fmt.Errorf("unable to find %s CA cert in secret %s", core.ServiceAccountRootCAKey,
+		return nil, nil, fmt.Errorf("unable to find %s CA cert in secret %s", core.ServiceAccountRootCAKey,
 			saSecret.GetName())
 	}
 	token := saSecret.Data[core.ServiceAccountTokenKey]
 	if token == nil {
-		return "", fmt.Errorf("unable to find %s token in secret %s", core.ServiceAccountTokenKey,
+		return nil, nil, fmt.Errorf("unable to find %s token in secret %s", core.ServiceAccountTokenKey,
 			saSecret.GetName())
 	}
-	kc := generateKubeconfig(caCert, string(token), nodeConfigCache.apiServerEndpoint,
-		username)
-	kubeconfigData, err := json.Marshal(kc)
-	if err != nil {
-		return "", err
-	}
-	return string(kubeconfigData), nil
+	return caCert, token, nil
 }
 
 // createKubeletConf returns contents of the config file for kubelet, with Windows specific configuration
@@ -624,34 +715,6 @@ func (nc *nodeConfig) UpdateTrustedCABundleFile(data map[string]string) error {
 	return nc.Windows.EnsureFileContent([]byte(data[certificates.CABundleKey]), fileName, dir)
 }
 
-// generateKubeconfig creates a kubeconfig spec with the certificate and token data from the given secret
-func generateKubeconfig(caCert []byte, token, apiServerURL, username string) clientcmdv1.Config {
-	kubeconfig := clientcmdv1.Config{
-		Clusters: []clientcmdv1.NamedCluster{{
-			Name: "local",
-			Cluster: clientcmdv1.Cluster{
-				Server:                   apiServerURL,
-				CertificateAuthorityData: caCert,
-			}},
-		},
-		AuthInfos: []clientcmdv1.NamedAuthInfo{{
-			Name: username,
-			AuthInfo: clientcmdv1.AuthInfo{
-				Token: token,
-			},
-		}},
-		Contexts: []clientcmdv1.NamedContext{{
-			Name: username,
-			Context: clientcmdv1.Context{
-				Cluster:  "local",
-				AuthInfo: username,
-			},
-		}},
-		CurrentContext: username,
-	}
-	return kubeconfig
-}
-
 // generateKubeletConfiguration returns the configuration spec for the kubelet Windows service
 func generateKubeletConfiguration(clusterDNS string) kubeletconfig.KubeletConfiguration {
 	// default numeric values chosen based on the OpenShift kubelet config recommendations for Linux worker nodes
@@ -712,3 +775,12 @@ func CreatePubKeyHashAnnotation(key ssh.PublicKey) string {
 	trimmedKey := strings.TrimSuffix(pubKey, "\n")
 	return fmt.Sprintf("%x", sha256.Sum256([]byte(trimmedKey)))
 }
+
+// ContainerdConfParams returns the given containerd configuration settings of the given node, with containerd
+// debugging enabled if the node has the ContainerdDebugAnnotation set to true
+func ContainerdConfParams(node *core.Node, params payload.ContainerdConfParams) payload.ContainerdConfParams {
+	if node != nil && node.GetAnnotations()[ContainerdDebugAnnotation] == "true" {
+		return payload.WithContainerdDebug(params)
+	}
+	return params
+}
@@ -6,6 +6,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 )
 
 func TestNewKubeConfigFromSecret(t *testing.T) {
@@ -111,3 +114,34 @@ func TestCreateKubeletConf(t *testing.T) {
 		})
 	}
 }
+
+func TestContainerdConfParams(t *testing.T) {
+	params := payload.ContainerdConfParams{SandboxImage: "mirror.example.com/pause:3.9"}
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    payload.ContainerdConfParams
+	}{
+		{
+			name:     "no annotation",
+			expected: params,
+		},
+		{
+			name:        "debug annotation",
+			annotations: map[string]string{ContainerdDebugAnnotation: "true"},
+			expected: payload.ContainerdConfParams{SandboxImage: "mirror.example.com/pause:3.9",
+				LogLevel: payload.ContainerdLogLevelDebug, DebugAddress: payload.DefaultContainerdDebugAddress},
+		},
+		{
+			name:        "debug annotation disabled",
+			annotations: map[string]string{ContainerdDebugAnnotation: "false"},
+			expected:    params,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			node := &core.Node{ObjectMeta: meta.ObjectMeta{Name: "node", Annotations: test.annotations}}
+			assert.Equal(t, test.expected, ContainerdConfParams(node, params))
+		})
+	}
+}
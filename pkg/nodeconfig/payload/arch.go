@@ -0,0 +1,114 @@
+package payload
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Windows architectures the payload is built for
+const (
+	// ArchAMD64 is the architecture of x86-64 Windows instances. The payload file paths are the ones of this
+	// architecture.
+	ArchAMD64 = "amd64"
+	// ArchARM64 is the architecture of ARM64 Windows instances
+	ArchARM64 = "arm64"
+)
+
+// archDirectories are the payload directories holding executables built for each architecture. The executables of
+// other architectures than ArchAMD64 are in the same directory suffixed with the architecture, like kube-node-arm64.
+var archDirectories = []string{"kube-node", "containerd", cniDirectory}
+
+// ArchFiles gives the payload file paths of a Windows architecture
+type ArchFiles struct {
+	arch string
+}
+
+// ForArch returns the payload file paths of the given Windows architecture, or an error if the payload is not built
+// for it
+func ForArch(arch string) (ArchFiles, error) {
+	switch arch {
+	case ArchAMD64, ArchARM64:
+		return ArchFiles{arch: arch}, nil
+	}
+	return ArchFiles{}, fmt.Errorf("unsupported Windows architecture %q, expected %s or %s", arch, ArchAMD64,
+		ArchARM64)
+}
+
+// Arch returns the architecture of the payload file paths
+func (a ArchFiles) Arch() string {
+	return a.arch
+}
+
+// Path returns the path of the given payload file for the architecture. Files which are not built for each
+// architecture, like scripts and configuration files, are shared by every architecture and returned as is.
+func (a ArchFiles) Path(file string) string {
+	if a.arch == ArchAMD64 || !isArchSpecific(file) {
+		return file
+	}
+	return payloadPath(path.Base(path.Dir(file))+"-"+a.arch, path.Base(file))
+}
+
+// isArchSpecific returns true if the given payload file is an executable built for each architecture
+func isArchSpecific(file string) bool {
+	if path.Ext(file) != ".exe" {
+		return false
+	}
+	dir := strings.TrimSuffix(strings.TrimPrefix(path.Dir(file), payloadDirectory), "/")
+	for _, archDir := range archDirectories {
+		if dir == archDir {
+			return true
+		}
+	}
+	return false
+}
+
+// Files returns the paths of all payload files for the architecture, sorted by path
+func (a ArchFiles) Files() []string {
+	var files []string
+	for _, file := range Files() {
+		files = append(files, a.Path(file))
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Destinations returns the destination of every payload file for the architecture on Windows instances, keyed by
+// payload path. The destinations are the same for every architecture.
+func (a ArchFiles) Destinations() map[string]FileDestination {
+	destinations := make(map[string]FileDestination)
+	for file, destination := range Destinations() {
+		destinations[a.Path(file)] = destination
+	}
+	return destinations
+}
+
+// archSpecificFiles returns the paths of the shipped payload files built for the architecture, sorted by path
+func (a ArchFiles) archSpecificFiles() []string {
+	var files []string
+	for _, file := range shippedFiles() {
+		if isArchSpecific(file) {
+			files = append(files, a.Path(file))
+		}
+	}
+	sort.Strings(files)
+	return files
+}
+
+// ValidateArchs ensures the payload files built for each of the given Windows architectures exist, are non-empty and
+// are readable. The files shared by every architecture are validated by Validate. It should only be given the
+// architectures of the Windows instances in the cluster, as the payload may not carry the files of every architecture.
+func ValidateArchs(archs []string, opts ...Option) error {
+	fsys := newOptions(opts).fsys
+	for _, arch := range archs {
+		archFiles, err := ForArch(arch)
+		if err != nil {
+			return err
+		}
+		if err := ValidateFS(fsys, archFiles.archSpecificFiles()); err != nil {
+			return fmt.Errorf("invalid %s payload: %w", arch, err)
+		}
+	}
+	return nil
+}
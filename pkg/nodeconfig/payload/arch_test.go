@@ -0,0 +1,131 @@
+package payload
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForArch(t *testing.T) {
+	testCases := []struct {
+		name        string
+		arch        string
+		expected    map[string]string
+		expectedErr bool
+	}{
+		{
+			name: "amd64",
+			arch: ArchAMD64,
+			expected: map[string]string{
+				KubeletPath:        "/payload/kube-node/kubelet.exe",
+				ContainerdPath:     "/payload/containerd/containerd.exe",
+				HostLocalCNIPlugin: "/payload/cni/host-local.exe",
+				ContainerdConfPath: "/payload/containerd/containerd_conf.toml",
+				WICDPath:           "/payload/windows-instance-config-daemon.exe",
+			},
+		},
+		{
+			name: "arm64",
+			arch: ArchARM64,
+			expected: map[string]string{
+				KubeletPath:         "/payload/kube-node-arm64/kubelet.exe",
+				KubeProxyPath:       "/payload/kube-node-arm64/kube-proxy.exe",
+				ContainerdPath:      "/payload/containerd-arm64/containerd.exe",
+				HcsshimPath:         "/payload/containerd-arm64/containerd-shim-runhcs-v1.exe",
+				WinOverlayCNIPlugin: "/payload/cni-arm64/win-overlay.exe",
+				ContainerdConfPath:  "/payload/containerd/containerd_conf.toml",
+				HNSPSModule:         "/payload/powershell/hns.psm1",
+				WICDPath:            "/payload/windows-instance-config-daemon.exe",
+			},
+		},
+		{
+			name:        "unsupported architecture",
+			arch:        "386",
+			expectedErr: true,
+		},
+		{
+			name:        "architecture is case sensitive",
+			arch:        "ARM64",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			archFiles, err := ForArch(test.arch)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.arch, archFiles.Arch())
+			for file, expected := range test.expected {
+				assert.Equal(t, expected, archFiles.Path(file))
+			}
+			files := archFiles.Files()
+			assert.Len(t, files, len(Files()))
+			destinations := archFiles.Destinations()
+			assert.Len(t, destinations, len(files))
+			for _, file := range Files() {
+				assert.Contains(t, files, archFiles.Path(file))
+				// every architecture shares the same destinations
+				assert.Equal(t, Destinations()[file], destinations[archFiles.Path(file)])
+			}
+		})
+	}
+}
+
+func TestValidateArchs(t *testing.T) {
+	arm64, err := ForArch(ArchARM64)
+	require.NoError(t, err)
+	withARM64 := fstest.MapFS{}
+	for _, file := range shippedFiles() {
+		withARM64[toFSPath(file)] = &fstest.MapFile{Data: []byte(file)}
+		withARM64[toFSPath(arm64.Path(file))] = &fstest.MapFile{Data: []byte(file)}
+	}
+
+	testCases := []struct {
+		name        string
+		fsys        fstest.MapFS
+		archs       []string
+		expectedErr bool
+	}{
+		{
+			name:  "amd64 only",
+			fsys:  newTestPayloadFS(t),
+			archs: []string{ArchAMD64},
+		},
+		{
+			name: "no Windows Machines",
+			fsys: newTestPayloadFS(t),
+		},
+		{
+			name:        "arm64 files missing",
+			fsys:        newTestPayloadFS(t),
+			archs:       []string{ArchAMD64, ArchARM64},
+			expectedErr: true,
+		},
+		{
+			name:  "arm64 files present",
+			fsys:  withARM64,
+			archs: []string{ArchAMD64, ArchARM64},
+		},
+		{
+			name:        "unsupported architecture",
+			fsys:        withARM64,
+			archs:       []string{"s390x"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateArchs(test.archs, WithFS(test.fsys))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
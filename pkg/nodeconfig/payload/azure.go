@@ -0,0 +1,60 @@
+package payload
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nodeVariableRegex matches the name of a node variable, substituted with a value read from the Node object when the
+// service command is run on the node
+var nodeVariableRegex = regexp.MustCompile(`^[A-Z][A-Z_]*$`)
+
+// AzureCloudNodeManagerArgs holds the settings of the Azure cloud node manager Windows service
+type AzureCloudNodeManagerArgs struct {
+	// NodeName is the name of the node, passed explicitly as it can differ from the hostname of the instance. It must
+	// be lowercase, unless it is the name of a node variable substituted with the node name on the node.
+	NodeName string
+	// KubeconfigPath is the path on Windows nodes of the kubeconfig used to update the Node object
+	KubeconfigPath string
+	// WaitRoutes makes the cloud node manager wait for the routes of the node to be created. It must be disabled on
+	// clusters whose network plugin does not configure Azure routes.
+	WaitRoutes bool
+	// CloudConfigPath is the path on Windows nodes of the Azure cloud config. It is required on Azure Stack Hub, whose
+	// endpoints cannot be discovered, and should be left empty on Azure public clouds.
+	CloudConfigPath string
+}
+
+// Command returns the command line of the Azure cloud node manager Windows service with the given settings, after
+// validating them
+func (a AzureCloudNodeManagerArgs) Command() (string, error) {
+	if a.NodeName == "" {
+		return "", fmt.Errorf("azure cloud node manager node name cannot be empty")
+	}
+	if strings.ToLower(a.NodeName) != a.NodeName && !nodeVariableRegex.MatchString(a.NodeName) {
+		return "", fmt.Errorf("azure cloud node manager node name %q must be lowercase", a.NodeName)
+	}
+	if strings.ContainsAny(a.NodeName, " \t\"") {
+		return "", fmt.Errorf("azure cloud node manager node name %q cannot contain whitespace or quotes", a.NodeName)
+	}
+	if !windowsAbsPathRegex.MatchString(a.KubeconfigPath) {
+		return "", fmt.Errorf("azure cloud node manager kubeconfig path %q is not an absolute Windows path",
+			a.KubeconfigPath)
+	}
+	if a.CloudConfigPath != "" && !windowsAbsPathRegex.MatchString(a.CloudConfigPath) {
+		return "", fmt.Errorf("azure cloud node manager cloud config path %q is not an absolute Windows path",
+			a.CloudConfigPath)
+	}
+	cmd := []string{
+		QuoteWindowsArg(RemoteK8sDir + "\\" + AzureCloudNodeManager),
+		"--windows-service",
+		"--node-name=" + a.NodeName,
+		"--wait-routes=" + strconv.FormatBool(a.WaitRoutes),
+		QuoteWindowsArg("--kubeconfig=" + a.KubeconfigPath),
+	}
+	if a.CloudConfigPath != "" {
+		cmd = append(cmd, QuoteWindowsArg("--cloud-config="+a.CloudConfigPath))
+	}
+	return strings.Join(cmd, " "), nil
+}
@@ -0,0 +1,82 @@
+package payload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzureCloudNodeManagerArgsCommand(t *testing.T) {
+	testCases := []struct {
+		name        string
+		args        AzureCloudNodeManagerArgs
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name: "Azure public cloud",
+			args: AzureCloudNodeManagerArgs{NodeName: "NODE_NAME", KubeconfigPath: `C:\k\kubeconfig`},
+			expected: `C:\k\azure-cloud-node-manager.exe --windows-service --node-name=NODE_NAME --wait-routes=false ` +
+				`--kubeconfig=C:\k\kubeconfig`,
+		},
+		{
+			name: "Azure public cloud waiting for routes",
+			args: AzureCloudNodeManagerArgs{NodeName: "winworker-abc", KubeconfigPath: `C:\k\kubeconfig`,
+				WaitRoutes: true},
+			expected: `C:\k\azure-cloud-node-manager.exe --windows-service --node-name=winworker-abc ` +
+				`--wait-routes=true --kubeconfig=C:\k\kubeconfig`,
+		},
+		{
+			name: "Azure Stack Hub",
+			args: AzureCloudNodeManagerArgs{NodeName: "NODE_NAME", KubeconfigPath: `C:\k\kubeconfig`,
+				CloudConfigPath: `C:\k\cloud.conf`},
+			expected: `C:\k\azure-cloud-node-manager.exe --windows-service --node-name=NODE_NAME --wait-routes=false ` +
+				`--kubeconfig=C:\k\kubeconfig --cloud-config=C:\k\cloud.conf`,
+		},
+		{
+			name: "Azure Stack Hub with paths with spaces",
+			args: AzureCloudNodeManagerArgs{NodeName: "NODE_NAME", KubeconfigPath: `C:\k\my kubeconfig`,
+				CloudConfigPath: `C:\k\cloud config\cloud.conf`},
+			expected: `C:\k\azure-cloud-node-manager.exe --windows-service --node-name=NODE_NAME --wait-routes=false ` +
+				`"--kubeconfig=C:\k\my kubeconfig" "--cloud-config=C:\k\cloud config\cloud.conf"`,
+		},
+		{
+			name:        "empty node name",
+			args:        AzureCloudNodeManagerArgs{KubeconfigPath: `C:\k\kubeconfig`},
+			expectedErr: true,
+		},
+		{
+			name:        "uppercase node name",
+			args:        AzureCloudNodeManagerArgs{NodeName: "WinWorker-abc", KubeconfigPath: `C:\k\kubeconfig`},
+			expectedErr: true,
+		},
+		{
+			name:        "node name with whitespace",
+			args:        AzureCloudNodeManagerArgs{NodeName: "winworker abc", KubeconfigPath: `C:\k\kubeconfig`},
+			expectedErr: true,
+		},
+		{
+			name:        "relative kubeconfig path",
+			args:        AzureCloudNodeManagerArgs{NodeName: "NODE_NAME", KubeconfigPath: "kubeconfig"},
+			expectedErr: true,
+		},
+		{
+			name: "relative cloud config path",
+			args: AzureCloudNodeManagerArgs{NodeName: "NODE_NAME", KubeconfigPath: `C:\k\kubeconfig`,
+				CloudConfigPath: "cloud.conf"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := test.args.Command()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, cmd)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package payload
+
+import (
+	"fmt"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+)
+
+// FileInfoCache caches FileInfo objects, so that unchanged files are not rehashed. A file is considered unchanged if
+// its size and modification time match the cached entry. The FileInfo of files embedded in the operator binary is
+// computed from their embedded contents, which are the ones transferred to instances. FileInfoCache is safe for
+// concurrent use.
+type FileInfoCache struct {
+	opts    []Option
+	mu      sync.RWMutex
+	entries map[string]*FileInfo
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+}
+
+// CacheStats holds the number of lookups served from a FileInfoCache, and the number which required hashing the file
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// NewFileInfoCache returns an empty FileInfoCache, accessing files as configured by the given options
+func NewFileInfoCache(opts ...Option) *FileInfoCache {
+	return &FileInfoCache{opts: opts, entries: make(map[string]*FileInfo)}
+}
+
+// Get returns the FileInfo of the file at the given path, using the cached entry if the file's size and modification
+// time are unchanged. If strict is set, the cache is bypassed and the file is always rehashed, so a content change
+// which preserved the size and modification time is never missed.
+func (c *FileInfoCache) Get(path string, strict bool) (*FileInfo, error) {
+	if fileInfo, ok := embeddedFileInfo(path); ok {
+		c.hits.Add(1)
+		return fileInfo, nil
+	}
+	if !strict {
+		stat, err := fs.Stat(newOptions(c.opts).fsys, toFSPath(path))
+		if err != nil {
+			return nil, fmt.Errorf("could not stat file: %w", classifyFileError(withPath(err, path)))
+		}
+		c.mu.RLock()
+		cached, present := c.entries[path]
+		c.mu.RUnlock()
+		if present && cached.Size == stat.Size() && cached.ModTime.Equal(stat.ModTime()) {
+			c.hits.Add(1)
+			fileInfo := *cached
+			return &fileInfo, nil
+		}
+	}
+	c.misses.Add(1)
+	fileInfo, err := NewFileInfo(path, c.opts...)
+	if err != nil {
+		c.Invalidate(path)
+		return nil, err
+	}
+	cached := *fileInfo
+	c.mu.Lock()
+	c.entries[path] = &cached
+	c.mu.Unlock()
+	return fileInfo, nil
+}
+
+// FileInfos returns FileInfo objects for all the given paths, keyed by path, using cached entries for unchanged files.
+// Files which need to be hashed are hashed concurrently.
+func (c *FileInfoCache) FileInfos(paths []string) (map[string]*FileInfo, error) {
+	return newFileInfos(paths, func(path string) (*FileInfo, error) {
+		return c.Get(path, false)
+	})
+}
+
+// Invalidate removes the cached entry for the given path, forcing the file to be rehashed on the next lookup
+func (c *FileInfoCache) Invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, path)
+}
+
+// Stats returns the number of cache hits and misses since the cache was created
+func (c *FileInfoCache) Stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
@@ -0,0 +1,108 @@
+package payload
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileInfoCache(t *testing.T) {
+	modTime := time.Now()
+	fsys := fstest.MapFS{"payload/kube-node/kubelet.exe": {Data: []byte("kubelet"), ModTime: modTime}}
+	cache := NewFileInfoCache(WithFS(fsys))
+
+	first, err := cache.Get(KubeletPath, false)
+	require.NoError(t, err)
+	assert.Equal(t, CacheStats{Misses: 1}, cache.Stats())
+	second, err := cache.Get(KubeletPath, false)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, cache.Stats())
+
+	// modifying a returned FileInfo must not affect the cache
+	second.SHA256 = "modified"
+	third, err := cache.Get(KubeletPath, false)
+	require.NoError(t, err)
+	assert.Equal(t, first.SHA256, third.SHA256)
+
+	// a content change preserving size and modification time is only detected by a strict lookup
+	fsys["payload/kube-node/kubelet.exe"] = &fstest.MapFile{Data: []byte("kubelex"), ModTime: modTime}
+	cached, err := cache.Get(KubeletPath, false)
+	require.NoError(t, err)
+	assert.Equal(t, first.SHA256, cached.SHA256)
+	strict, err := cache.Get(KubeletPath, true)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.SHA256, strict.SHA256)
+	// the strict lookup refreshes the cached entry
+	cached, err = cache.Get(KubeletPath, false)
+	require.NoError(t, err)
+	assert.Equal(t, strict.SHA256, cached.SHA256)
+
+	// a modification time change causes a rehash
+	fsys["payload/kube-node/kubelet.exe"] = &fstest.MapFile{Data: []byte("kubelet"), ModTime: modTime.Add(time.Second)}
+	before := cache.Stats()
+	updated, err := cache.Get(KubeletPath, false)
+	require.NoError(t, err)
+	assert.Equal(t, first.SHA256, updated.SHA256)
+	assert.Equal(t, before.Misses+1, cache.Stats().Misses)
+
+	// an invalidated entry is rehashed
+	cache.Invalidate(KubeletPath)
+	before = cache.Stats()
+	_, err = cache.Get(KubeletPath, false)
+	require.NoError(t, err)
+	assert.Equal(t, before.Misses+1, cache.Stats().Misses)
+
+	delete(fsys, "payload/kube-node/kubelet.exe")
+	_, err = cache.Get(KubeletPath, false)
+	assert.ErrorIs(t, err, ErrPayloadFileNotFound)
+}
+
+func TestFileInfoCacheEmbedded(t *testing.T) {
+	// the on-disk copy of an embedded file differs from its embedded contents, which are the ones transferred
+	fsys := fstest.MapFS{toFSPath(HNSPSModule): {Data: []byte("stale")}}
+	cache := NewFileInfoCache(WithFS(fsys))
+	for _, strict := range []bool{false, true} {
+		fileInfo, err := cache.Get(HNSPSModule, strict)
+		require.NoError(t, err)
+		assert.Equal(t, HNSPSModule, fileInfo.Path)
+		assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(HNSModuleContents())), fileInfo.SHA256)
+		assert.Equal(t, int64(len(HNSModuleContents())), fileInfo.Size)
+	}
+	assert.Zero(t, cache.Stats().Misses)
+	// embedded files do not depend on the on-disk copy
+	delete(fsys, toFSPath(HNSPSModule))
+	_, err := cache.Get(HNSPSModule, false)
+	assert.NoError(t, err)
+}
+
+func TestFileInfoCacheConcurrent(t *testing.T) {
+	fsys := fstest.MapFS{}
+	var paths []string
+	for i := 0; i < 10; i++ {
+		path := fmt.Sprintf("/payload/file-%d.exe", i)
+		fsys[toFSPath(path)] = &fstest.MapFile{Data: []byte(path)}
+		paths = append(paths, path)
+	}
+	cache := NewFileInfoCache(WithFS(fsys))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			files, err := cache.FileInfos(paths)
+			assert.NoError(t, err)
+			assert.Len(t, files, len(paths))
+		}()
+	}
+	wg.Wait()
+	stats := cache.Stats()
+	assert.Equal(t, uint64(8*len(paths)), stats.Hits+stats.Misses)
+	assert.GreaterOrEqual(t, stats.Misses, uint64(len(paths)))
+}
@@ -0,0 +1,232 @@
+package payload
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/openshift/library-go/pkg/image/reference"
+)
+
+// ContainerdConfGeneratedPath is the path of the containerd configuration generated with overridden settings. When
+// generated, it is copied to Windows nodes in place of ContainerdConfPath, so it is not one of the payload Files.
+var ContainerdConfGeneratedPath = payloadPath(generatedDirectoryName, "containerd_conf.toml")
+
+// sandboxImageRegex matches the sandbox_image setting of the containerd configuration, capturing everything up to its
+// value
+var sandboxImageRegex = regexp.MustCompile(`(?m)^(\s*sandbox_image\s*=\s*)"[^"\n]*"`)
+
+const (
+	// processRuntimeSection is the header of the options of the process isolated runtime handler, the default one of
+	// the shipped containerd configuration, after which additional runtime handlers are added
+	processRuntimeSection = `[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runhcs-wcow-process.options]`
+	// hyperVRuntimeName is the name of the runtime handler running Hyper-V isolated pods of the Windows OS build of the
+	// node. The handler of a specific Windows OS build is suffixed with it.
+	hyperVRuntimeName = "runhcs-wcow-hypervisor"
+	// minHyperVIsolationBuild is the Windows OS build of Windows Server 2019, the earliest supporting Hyper-V isolated
+	// containers run by containerd
+	minHyperVIsolationBuild = 17763
+	// registryTable is the table of the containerd configuration holding the CRI registry settings
+	registryTable = `plugins."io.containerd.grpc.v1.cri".registry`
+	// DefaultContainerdDebugAddress is the named pipe containerd serves its debug API on when debugging is enabled
+	DefaultContainerdDebugAddress = `\\.\pipe\containerd-debug`
+)
+
+// ContainerdLogLevel is the level containerd logs at
+type ContainerdLogLevel string
+
+const (
+	// ContainerdLogLevelInfo logs informational messages and above
+	ContainerdLogLevelInfo ContainerdLogLevel = "info"
+	// ContainerdLogLevelDebug logs debug messages and above
+	ContainerdLogLevelDebug ContainerdLogLevel = "debug"
+)
+
+// namedPipeRegex matches a Windows named pipe on the local machine
+var namedPipeRegex = regexp.MustCompile(`^\\\\\.\\pipe\\[^\\/:*?"<>|]+$`)
+
+// windowsServerBuilds are the Windows OS builds of the Windows Server releases whose containers can be run with
+// Hyper-V isolation on nodes of the same or a later build
+var windowsServerBuilds = []int{17763, 20348, 25398, 26100}
+
+// ContainerdConfParams holds the settings overridden in the containerd configuration shipped in the payload
+type ContainerdConfParams struct {
+	// SandboxImage is the pull spec of the pause image used for pod sandboxes. If empty, the image of the shipped
+	// configuration is kept.
+	SandboxImage string
+	// HyperVIsolationBuild is the Windows OS build of the nodes, the third component of their OS version. If set,
+	// runtime handlers running Hyper-V isolated pods are added: runhcs-wcow-hypervisor for containers of the node
+	// build, and runhcs-wcow-hypervisor-<build> for each Windows Server build up to the node build.
+	HyperVIsolationBuild int
+	// LogLevel is the level containerd logs at. If empty, the level of the shipped configuration is kept.
+	LogLevel ContainerdLogLevel
+	// DebugAddress is the named pipe containerd serves its debug API on. If empty, the address of the shipped
+	// configuration is kept.
+	DebugAddress string
+	// RegistryHosts is true if the hosts.toml files of the configured registries are copied to the nodes. If set,
+	// containerd reads the registry configuration from RemoteContainerdRegistriesDir.
+	RegistryHosts bool
+}
+
+// WithContainerdDebug returns the given params with containerd logging at debug level, and serving its debug API on
+// DefaultContainerdDebugAddress unless another address is set. It is used for nodes which are being debugged.
+func WithContainerdDebug(params ContainerdConfParams) ContainerdConfParams {
+	params.LogLevel = ContainerdLogLevelDebug
+	if params.DebugAddress == "" {
+		params.DebugAddress = DefaultContainerdDebugAddress
+	}
+	return params
+}
+
+// GenerateContainerdConf returns the FileInfo of the containerd configuration to copy to Windows nodes, along with true
+// if its contents changed. If the given params override none of the shipped settings, the shipped configuration at
+// ContainerdConfPath is used as is. Otherwise, the shipped configuration with the overridden settings is written to
+// ContainerdConfGeneratedPath.
+func GenerateContainerdConf(params ContainerdConfParams, opts ...Option) (*FileInfo, bool, error) {
+	if params == (ContainerdConfParams{}) {
+		fileInfo, err := NewFileInfo(ContainerdConfPath, opts...)
+		if err != nil {
+			return nil, false, err
+		}
+		return fileInfo, false, nil
+	}
+	if params.SandboxImage != "" {
+		if err := validateImageReference(params.SandboxImage); err != nil {
+			return nil, false, fmt.Errorf("invalid sandbox image: %w", err)
+		}
+	}
+	if params.HyperVIsolationBuild != 0 && params.HyperVIsolationBuild < minHyperVIsolationBuild {
+		return nil, false, fmt.Errorf("windows OS build %d does not support Hyper-V isolation, %d or later is required",
+			params.HyperVIsolationBuild, minHyperVIsolationBuild)
+	}
+	switch params.LogLevel {
+	case "", ContainerdLogLevelInfo, ContainerdLogLevelDebug:
+	default:
+		return nil, false, fmt.Errorf("invalid containerd log level %q", params.LogLevel)
+	}
+	if params.DebugAddress != "" && !namedPipeRegex.MatchString(params.DebugAddress) {
+		return nil, false, fmt.Errorf("containerd debug address %q is not a local named pipe", params.DebugAddress)
+	}
+	shipped, err := fs.ReadFile(newOptions(opts).fsys, toFSPath(ContainerdConfPath))
+	if err != nil {
+		return nil, false, fmt.Errorf("could not read containerd configuration: %w",
+			classifyFileError(withPath(err, ContainerdConfPath)))
+	}
+	contents := string(shipped)
+	if params.SandboxImage != "" {
+		if contents, err = setSandboxImage(contents, params.SandboxImage); err != nil {
+			return nil, false, err
+		}
+	}
+	if params.LogLevel != "" {
+		if contents, err = setTOMLValue(contents, "debug", "level", string(params.LogLevel)); err != nil {
+			return nil, false, err
+		}
+	}
+	if params.DebugAddress != "" {
+		if contents, err = setTOMLValue(contents, "debug", "address", params.DebugAddress); err != nil {
+			return nil, false, err
+		}
+	}
+	if params.RegistryHosts {
+		// containerd expects the config path of the CRI registry with forward slashes on Windows
+		configPath := strings.ReplaceAll(RemoteContainerdRegistriesDir, "\\", "/")
+		if contents, err = setTOMLValue(contents, registryTable, "config_path", configPath); err != nil {
+			return nil, false, err
+		}
+	}
+	if params.HyperVIsolationBuild != 0 {
+		if contents, err = addHyperVRuntimes(contents, params.HyperVIsolationBuild); err != nil {
+			return nil, false, err
+		}
+	}
+	return writeGeneratedScriptTo(ContainerdConfGeneratedPath, contents, params, opts...)
+}
+
+// setSandboxImage returns the given containerd configuration with its sandbox_image set to the given image
+func setSandboxImage(conf, image string) (string, error) {
+	if matches := len(sandboxImageRegex.FindAllStringIndex(conf, -1)); matches != 1 {
+		return "", fmt.Errorf("expected a single sandbox_image setting in the containerd configuration, found %d",
+			matches)
+	}
+	// the image is validated, so its quoted form is a valid TOML basic string without any $ to expand
+	return sandboxImageRegex.ReplaceAllString(conf, "${1}"+strconv.Quote(image)), nil
+}
+
+// setTOMLValue returns the given containerd configuration with the given key of the given table set to the given
+// string value. The key must already be set within the table.
+func setTOMLValue(conf, table, key, value string) (string, error) {
+	lines := strings.SplitAfter(conf, "\n")
+	inTable := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inTable = trimmed == "["+table+"]"
+			continue
+		}
+		name, _, found := strings.Cut(trimmed, "=")
+		if !inTable || !found || strings.TrimSpace(name) != key {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		lines[i] = indent + key + " = " + strconv.Quote(value) + "\n"
+		return strings.Join(lines, ""), nil
+	}
+	return "", fmt.Errorf("containerd configuration has no %s setting in its %s table", key, table)
+}
+
+// addHyperVRuntimes returns the given containerd configuration with the Hyper-V isolated runtime handlers of the given
+// node Windows OS build added after the process isolated runtime handler
+func addHyperVRuntimes(conf string, nodeBuild int) (string, error) {
+	i := strings.Index(conf, processRuntimeSection)
+	if i == -1 {
+		return "", fmt.Errorf("containerd configuration has no %s section", processRuntimeSection)
+	}
+	end := i + len(processRuntimeSection) + 1
+	var b strings.Builder
+	writeHyperVRuntime(&b, hyperVRuntimeName)
+	for _, build := range windowsServerBuilds {
+		if build <= nodeBuild {
+			writeHyperVRuntime(&b, hyperVRuntimeName+"-"+strconv.Itoa(build))
+		}
+	}
+	return conf[:end] + b.String() + conf[end:], nil
+}
+
+// writeHyperVRuntime writes the section of the Hyper-V isolated runtime handler with the given name, matching the
+// indentation of the shipped runtime handlers. The shim is the one copied to Windows nodes from HcsshimPath.
+func writeHyperVRuntime(b *strings.Builder, name string) {
+	section := `plugins."io.containerd.grpc.v1.cri".containerd.runtimes.` + name
+	fmt.Fprintf(b, "\n        [%s]\n", section)
+	b.WriteString("          base_runtime_spec = \"\"\n")
+	b.WriteString("          container_annotations = [\"io.microsoft.container.*\"]\n")
+	b.WriteString("          pod_annotations = [\"io.microsoft.virtualmachine.*\"]\n")
+	b.WriteString("          privileged_without_host_devices = false\n")
+	b.WriteString("          privileged_without_host_devices_all_devices_allowed = false\n")
+	b.WriteString("          runtime_engine = \"\"\n")
+	fmt.Fprintf(b, "          runtime_path = %s\n", strconv.Quote(RemoteContainerdDir+"\\"+path.Base(HcsshimPath)))
+	b.WriteString("          runtime_root = \"\"\n")
+	b.WriteString("          runtime_type = \"io.containerd.runhcs.v1\"\n")
+	fmt.Fprintf(b, "\n          [%s.options]\n", section)
+	b.WriteString("            SandboxIsolation = 1\n")
+	b.WriteString("            SandboxPlatform = \"windows/amd64\"\n")
+	b.WriteString("            ScaleCpuLimitsToSandbox = true\n")
+}
+
+// validateImageReference returns an error if the given image is not a valid pull spec of an image repository
+func validateImageReference(image string) error {
+	if strings.TrimSpace(image) != image {
+		return fmt.Errorf("image %q has leading or trailing whitespace", image)
+	}
+	ref, err := reference.Parse(image)
+	if err != nil {
+		return fmt.Errorf("image %q: %w", image, err)
+	}
+	if ref.Name == "" {
+		return fmt.Errorf("image %q has no repository", image)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package payload
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateContainerdConf(t *testing.T) {
+	// the configuration shipped in the payload
+	shipped, err := os.ReadFile("../../internal/containerd_conf.toml")
+	require.NoError(t, err)
+	const shippedImage = `sandbox_image = "mcr.microsoft.com/oss/kubernetes/pause:3.9"`
+	require.Contains(t, string(shipped), shippedImage)
+
+	testCases := []struct {
+		name             string
+		params           ContainerdConfParams
+		shipped          string
+		expectedPath     string
+		expectedImage    string
+		expectedErr      bool
+		expectedGenerate bool
+	}{
+		{
+			name:         "no override",
+			shipped:      string(shipped),
+			expectedPath: ContainerdConfPath,
+		},
+		{
+			name:             "mirrored sandbox image",
+			params:           ContainerdConfParams{SandboxImage: "mirror.example.com:5000/oss/kubernetes/pause:3.9"},
+			shipped:          string(shipped),
+			expectedPath:     ContainerdConfGeneratedPath,
+			expectedImage:    `sandbox_image = "mirror.example.com:5000/oss/kubernetes/pause:3.9"`,
+			expectedGenerate: true,
+		},
+		{
+			name: "sandbox image by digest",
+			params: ContainerdConfParams{SandboxImage: "mirror.example.com/pause@sha256:" +
+				strings.Repeat("a", 64)},
+			shipped:          string(shipped),
+			expectedPath:     ContainerdConfGeneratedPath,
+			expectedImage:    `sandbox_image = "mirror.example.com/pause@sha256:` + strings.Repeat("a", 64) + `"`,
+			expectedGenerate: true,
+		},
+		{
+			name:        "invalid sandbox image",
+			params:      ContainerdConfParams{SandboxImage: "Mirror.example.com/PAUSE:3.9"},
+			shipped:     string(shipped),
+			expectedErr: true,
+		},
+		{
+			name:        "sandbox image with whitespace",
+			params:      ContainerdConfParams{SandboxImage: "mirror.example.com/pause:3.9 "},
+			shipped:     string(shipped),
+			expectedErr: true,
+		},
+		{
+			name:        "registry only sandbox image",
+			params:      ContainerdConfParams{SandboxImage: "mirror.example.com"},
+			shipped:     string(shipped),
+			expectedErr: true,
+		},
+		{
+			name:        "shipped configuration without sandbox image",
+			params:      ContainerdConfParams{SandboxImage: "mirror.example.com/pause:3.9"},
+			shipped:     "version = 2\n",
+			expectedErr: true,
+		},
+		{
+			name:        "missing shipped configuration",
+			params:      ContainerdConfParams{SandboxImage: "mirror.example.com/pause:3.9"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := writableMapFS{fstest.MapFS{}}
+			if test.shipped != "" {
+				fsys.MapFS[toFSPath(ContainerdConfPath)] = &fstest.MapFile{Data: []byte(test.shipped)}
+			}
+			fileInfo, changed, err := GenerateContainerdConf(test.params, WithFS(fsys))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedGenerate, changed)
+			assert.Equal(t, test.expectedPath, fileInfo.Path)
+			contents, err := fs.ReadFile(fsys, toFSPath(test.expectedPath))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(contents)), fileInfo.Checksum())
+			// the shipped configuration is never modified
+			assert.Equal(t, test.shipped, string(fsys.MapFS[toFSPath(ContainerdConfPath)].Data))
+			if !test.expectedGenerate {
+				_, err := fs.Stat(fsys, toFSPath(ContainerdConfGeneratedPath))
+				assert.ErrorIs(t, err, fs.ErrNotExist)
+				return
+			}
+			assert.Contains(t, string(contents), test.expectedImage)
+			assert.NotContains(t, string(contents), shippedImage)
+			// only the sandbox image differs from the shipped configuration
+			_, generated, found := strings.Cut(string(contents), "\n\n")
+			require.True(t, found)
+			assert.Equal(t, strings.Replace(test.shipped, shippedImage, test.expectedImage, 1), generated)
+
+			// regenerating the same configuration leaves it as is
+			_, changed, err = GenerateContainerdConf(test.params, WithFS(fsys))
+			require.NoError(t, err)
+			assert.False(t, changed)
+		})
+	}
+}
+
+func TestGenerateContainerdConfHyperV(t *testing.T) {
+	shipped, err := os.ReadFile("../../internal/containerd_conf.toml")
+	require.NoError(t, err)
+	runtimesTable := `plugins."io.containerd.grpc.v1.cri".containerd.runtimes.`
+
+	testCases := []struct {
+		name             string
+		build            int
+		expectedHandlers []string
+		expectedErr      bool
+	}{
+		{
+			name:             "Windows Server 2019",
+			build:            17763,
+			expectedHandlers: []string{"runhcs-wcow-hypervisor", "runhcs-wcow-hypervisor-17763"},
+		},
+		{
+			name:  "Windows Server 2022",
+			build: 20348,
+			expectedHandlers: []string{"runhcs-wcow-hypervisor", "runhcs-wcow-hypervisor-17763",
+				"runhcs-wcow-hypervisor-20348"},
+		},
+		{
+			name:        "unsupported build",
+			build:       14393,
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := writableMapFS{fstest.MapFS{toFSPath(ContainerdConfPath): {Data: shipped}}}
+			fileInfo, changed, err := GenerateContainerdConf(ContainerdConfParams{HyperVIsolationBuild: test.build},
+				WithFS(fsys))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, changed)
+			assert.Equal(t, ContainerdConfGeneratedPath, fileInfo.Path)
+			contents, err := fs.ReadFile(fsys, toFSPath(ContainerdConfGeneratedPath))
+			require.NoError(t, err)
+			tables := parseTOMLTables(t, string(contents))
+
+			var handlers []string
+			for table := range tables {
+				if name, ok := strings.CutPrefix(table, runtimesTable); ok && !strings.Contains(name, ".") {
+					handlers = append(handlers, name)
+				}
+			}
+			assert.ElementsMatch(t, append([]string{"runhcs-wcow-process"}, test.expectedHandlers...), handlers)
+			for _, handler := range test.expectedHandlers {
+				runtime := tables[runtimesTable+handler]
+				assert.Equal(t, `"io.containerd.runhcs.v1"`, runtime["runtime_type"])
+				assert.Equal(t, `"C:\\k\\containerd\\containerd-shim-runhcs-v1.exe"`, runtime["runtime_path"])
+				assert.Equal(t, "1", tables[runtimesTable+handler+".options"]["SandboxIsolation"])
+			}
+			// the default runtime handler stays process isolated
+			assert.Equal(t, `"runhcs-wcow-process"`,
+				tables[`plugins."io.containerd.grpc.v1.cri".containerd`]["default_runtime_name"])
+		})
+	}
+}
+
+func TestGenerateContainerdConfDebug(t *testing.T) {
+	shipped, err := os.ReadFile("../../internal/containerd_conf.toml")
+	require.NoError(t, err)
+	shippedTables := parseTOMLTables(t, string(shipped))
+
+	testCases := []struct {
+		name            string
+		params          ContainerdConfParams
+		expectedLevel   string
+		expectedAddress string
+		expectedErr     bool
+	}{
+		{
+			name:            "debug enabled",
+			params:          WithContainerdDebug(ContainerdConfParams{}),
+			expectedLevel:   `"debug"`,
+			expectedAddress: `"\\\\.\\pipe\\containerd-debug"`,
+		},
+		{
+			name:            "debug enabled with custom address",
+			params:          WithContainerdDebug(ContainerdConfParams{DebugAddress: `\\.\pipe\ctrd-debug`}),
+			expectedLevel:   `"debug"`,
+			expectedAddress: `"\\\\.\\pipe\\ctrd-debug"`,
+		},
+		{
+			name:            "info level",
+			params:          ContainerdConfParams{LogLevel: ContainerdLogLevelInfo},
+			expectedLevel:   `"info"`,
+			expectedAddress: `""`,
+		},
+		{
+			name:        "invalid log level",
+			params:      ContainerdConfParams{LogLevel: "trace"},
+			expectedErr: true,
+		},
+		{
+			name:        "debug address not a named pipe",
+			params:      ContainerdConfParams{DebugAddress: "127.0.0.1:1338"},
+			expectedErr: true,
+		},
+		{
+			name:        "remote named pipe",
+			params:      ContainerdConfParams{DebugAddress: `\\server\pipe\containerd-debug`},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := writableMapFS{fstest.MapFS{toFSPath(ContainerdConfPath): {Data: shipped}}}
+			fileInfo, changed, err := GenerateContainerdConf(test.params, WithFS(fsys))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, changed)
+			contents, err := fs.ReadFile(fsys, toFSPath(ContainerdConfGeneratedPath))
+			require.NoError(t, err)
+			tables := parseTOMLTables(t, string(contents))
+			assert.Equal(t, test.expectedLevel, tables["debug"]["level"])
+			assert.Equal(t, test.expectedAddress, tables["debug"]["address"])
+			// only the debug settings are overridden
+			for table, values := range shippedTables {
+				if table != "debug" {
+					assert.Equal(t, values, tables[table], "table %s", table)
+				}
+			}
+
+			// the FileInfo only changes along with the configuration
+			unchanged, changed, err := GenerateContainerdConf(test.params, WithFS(fsys))
+			require.NoError(t, err)
+			assert.False(t, changed)
+			assert.Equal(t, fileInfo.Checksum(), unchanged.Checksum())
+			disabled, changed, err := GenerateContainerdConf(ContainerdConfParams{LogLevel: ContainerdLogLevelInfo},
+				WithFS(fsys))
+			require.NoError(t, err)
+			assert.Equal(t, test.params.LogLevel != ContainerdLogLevelInfo, changed)
+			assert.Equal(t, !changed, fileInfo.Checksum() == disabled.Checksum())
+		})
+	}
+}
+
+func TestGenerateContainerdConfRegistryHosts(t *testing.T) {
+	shipped, err := os.ReadFile("../../internal/containerd_conf.toml")
+	require.NoError(t, err)
+	shippedTables := parseTOMLTables(t, string(shipped))
+	require.Equal(t, `""`, shippedTables[registryTable]["config_path"])
+
+	fsys := writableMapFS{fstest.MapFS{toFSPath(ContainerdConfPath): {Data: shipped}}}
+	_, changed, err := GenerateContainerdConf(ContainerdConfParams{RegistryHosts: true}, WithFS(fsys))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	contents, err := fs.ReadFile(fsys, toFSPath(ContainerdConfGeneratedPath))
+	require.NoError(t, err)
+	tables := parseTOMLTables(t, string(contents))
+	assert.Equal(t, `"C:/k/containerd/registries"`, tables[registryTable]["config_path"])
+	// only the registry config path is overridden
+	for table, values := range shippedTables {
+		if table != registryTable {
+			assert.Equal(t, values, tables[table], "table %s", table)
+		}
+	}
+}
+
+// tomlValueRegex matches the TOML values used in the containerd configuration: booleans, integers, floats, and single
+// line arrays of strings
+var tomlValueRegex = regexp.MustCompile(`^(true|false|-?[0-9]+(\.[0-9]+)?|\[("([^"\\]|\\.)*"(, )?)*\])$`)
+
+// parseTOMLTables parses the given TOML document, restricted to the syntax used in the containerd configuration, into
+// the raw values of its keys by table. The test fails if the document is not valid, or defines a table or key twice.
+func parseTOMLTables(t *testing.T, contents string) map[string]map[string]string {
+	tables := map[string]map[string]string{"": {}}
+	table := ""
+	for i, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			require.True(t, strings.HasSuffix(line, "]"), "line %d: invalid table header %q", i+1, line)
+			table = strings.TrimSuffix(strings.TrimPrefix(line, "["), "]")
+			require.NotContains(t, tables, table, "line %d: table %s defined twice", i+1, table)
+			tables[table] = map[string]string{}
+			continue
+		}
+		key, value, found := strings.Cut(line, " = ")
+		require.True(t, found, "line %d: expected a key/value pair, got %q", i+1, line)
+		require.NotContains(t, tables[table], key, "line %d: key %s defined twice", i+1, key)
+		if strings.HasPrefix(value, `"`) {
+			_, err := strconv.Unquote(value)
+			require.NoError(t, err, "line %d: invalid string %s", i+1, value)
+		} else {
+			require.Regexp(t, tomlValueRegex, value, "line %d: invalid value", i+1)
+		}
+		tables[table][key] = value
+	}
+	return tables
+}
@@ -0,0 +1,146 @@
+package payload
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeletconfig "k8s.io/kubelet/config/v1beta1"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// CredentialProviderConfigPath is the path of the generated kubelet image credential provider configuration. It is
+// only copied to Windows nodes on platforms with credential providers, so it is not one of the payload Files.
+var CredentialProviderConfigPath = payloadPath(generatedDirectoryName, "credential-providers.yaml")
+
+const (
+	// RemoteCredentialProviderConfigPath is the path of the credential provider configuration on Windows nodes
+	RemoteCredentialProviderConfigPath = RemoteK8sDir + "\\credential-providers.yaml"
+	// ECRCredentialProviderName is the name of the executable of the AWS ECR credential provider
+	ECRCredentialProviderName = "ecr-credential-provider.exe"
+	// GCPCredentialProviderName is the name of the executable of the GCP Artifact Registry credential provider
+	GCPCredentialProviderName = "gcp-credential-provider.exe"
+	// credentialProviderConfigAPIVersion is the apiVersion of the generated CredentialProviderConfig
+	credentialProviderConfigAPIVersion = "kubelet.config.k8s.io/v1"
+	// credentialProviderAPIVersion is the version of the CredentialProviderRequest and CredentialProviderResponse
+	// exchanged between the kubelet and the providers
+	credentialProviderAPIVersion = "credentialprovider.kubelet.k8s.io/v1"
+)
+
+// CredentialProvider describes a kubelet image credential provider plugin
+type CredentialProvider struct {
+	// Name is the file name of the plugin executable, within RemoteCredentialProviderDir on Windows nodes
+	Name string
+	// MatchImages are the patterns of the images the plugin provides credentials for
+	MatchImages []string
+	// DefaultCacheDuration is how long credentials are cached when the plugin response does not set a duration
+	DefaultCacheDuration time.Duration
+	// Args are the arguments the plugin is run with
+	Args []string
+}
+
+// platformCredentialProviders are the credential providers of each platform, in the order the kubelet queries them.
+// The match images are the registries of the cloud provider, as configured on the Linux nodes of the platform.
+var platformCredentialProviders = map[configv1.PlatformType][]CredentialProvider{
+	configv1.AWSPlatformType: {{
+		Name: ECRCredentialProviderName,
+		MatchImages: []string{"*.dkr.ecr.*.amazonaws.com", "*.dkr.ecr.*.amazonaws.com.cn",
+			"*.dkr.ecr-fips.*.amazonaws.com", "*.dkr.ecr.us-iso-east-1.c2s.ic.gov",
+			"*.dkr.ecr.us-isob-east-1.sc2s.sgov.gov"},
+		DefaultCacheDuration: 12 * time.Hour,
+	}},
+	configv1.GCPPlatformType: {{
+		Name:                 GCPCredentialProviderName,
+		MatchImages:          []string{"gcr.io", "*.gcr.io", "container.cloud.google.com", "*.pkg.dev"},
+		DefaultCacheDuration: time.Minute,
+		Args:                 []string{"get-credentials", "--v=3"},
+	}},
+}
+
+// CredentialProviders returns the credential providers of the given platform whose executables have the given names.
+// If no names are given, every credential provider of the platform is returned. An error is returned if a name does
+// not match any credential provider of the platform.
+func CredentialProviders(platform configv1.PlatformType, names ...string) ([]CredentialProvider, error) {
+	providers := platformCredentialProviders[platform]
+	if len(names) == 0 {
+		return providers, nil
+	}
+	var selected []CredentialProvider
+	for _, name := range names {
+		found := false
+		for _, provider := range providers {
+			if provider.Name == name {
+				selected = append(selected, provider)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no credential provider %s on platform %s", name, platform)
+		}
+	}
+	return selected, nil
+}
+
+// PopulateCredentialProviderConfig creates the kubelet image credential provider configuration of the given platform at
+// CredentialProviderConfigPath, enabling the credential providers with the given executable names, or all of them if
+// none are given. The FileInfo of the configuration is returned, along with true if its contents changed. An error is
+// returned if the platform has no credential providers.
+func PopulateCredentialProviderConfig(platform configv1.PlatformType, names []string,
+	opts ...Option) (*FileInfo, bool, error) {
+	providers, err := CredentialProviders(platform, names...)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(providers) == 0 {
+		return nil, false, fmt.Errorf("platform %s has no credential providers", platform)
+	}
+	contents, err := generateCredentialProviderConfig(providers)
+	if err != nil {
+		return nil, false, err
+	}
+	return writeGeneratedScriptTo(CredentialProviderConfigPath, contents, providers, opts...)
+}
+
+// CredentialProviderKubeletArgs returns the kubelet arguments enabling the credential provider configuration, once
+// copied to Windows nodes along with the credential provider executables
+func CredentialProviderKubeletArgs() []string {
+	return []string{"--image-credential-provider-config=" + RemoteCredentialProviderConfigPath,
+		"--image-credential-provider-bin-dir=" + RemoteCredentialProviderDir}
+}
+
+// generateCredentialProviderConfig returns the YAML serialization of a v1 CredentialProviderConfig enabling the given
+// credential providers. The v1 API shares the structure of the vendored v1beta1 type, which is used to hold it.
+func generateCredentialProviderConfig(providers []CredentialProvider) (string, error) {
+	config := kubeletconfig.CredentialProviderConfig{
+		TypeMeta: metav1.TypeMeta{Kind: "CredentialProviderConfig", APIVersion: credentialProviderConfigAPIVersion},
+	}
+	for _, provider := range providers {
+		// the kubelet looks for the executable by name within its bin directory, so it cannot be a path
+		if provider.Name == "" || path.Base(provider.Name) != provider.Name || strings.ContainsAny(provider.Name, `\:`) ||
+			!strings.HasSuffix(provider.Name, ".exe") {
+			return "", fmt.Errorf("credential provider name %q is not the file name of an executable", provider.Name)
+		}
+		if len(provider.MatchImages) == 0 {
+			return "", fmt.Errorf("credential provider %s has no match images", provider.Name)
+		}
+		if provider.DefaultCacheDuration <= 0 {
+			return "", fmt.Errorf("credential provider %s has no default cache duration", provider.Name)
+		}
+		config.Providers = append(config.Providers, kubeletconfig.CredentialProvider{
+			Name:                 provider.Name,
+			MatchImages:          provider.MatchImages,
+			DefaultCacheDuration: &metav1.Duration{Duration: provider.DefaultCacheDuration},
+			APIVersion:           credentialProviderAPIVersion,
+			Args:                 provider.Args,
+		})
+	}
+	data, err := sigsyaml.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("error serializing credential provider configuration: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,123 @@
+package payload
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kubeletconfig "k8s.io/kubelet/config/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestPopulateCredentialProviderConfig(t *testing.T) {
+	testCases := []struct {
+		name                string
+		platform            configv1.PlatformType
+		names               []string
+		expectedName        string
+		expectedMatchImages []string
+		expectedCache       time.Duration
+		expectedArgs        []string
+		expectedErr         bool
+	}{
+		{
+			name:         "AWS",
+			platform:     configv1.AWSPlatformType,
+			expectedName: "ecr-credential-provider.exe",
+			expectedMatchImages: []string{"*.dkr.ecr.*.amazonaws.com", "*.dkr.ecr.*.amazonaws.com.cn",
+				"*.dkr.ecr-fips.*.amazonaws.com", "*.dkr.ecr.us-iso-east-1.c2s.ic.gov",
+				"*.dkr.ecr.us-isob-east-1.sc2s.sgov.gov"},
+			expectedCache: 12 * time.Hour,
+		},
+		{
+			name:                "GCP",
+			platform:            configv1.GCPPlatformType,
+			names:               []string{GCPCredentialProviderName},
+			expectedName:        "gcp-credential-provider.exe",
+			expectedMatchImages: []string{"gcr.io", "*.gcr.io", "container.cloud.google.com", "*.pkg.dev"},
+			expectedCache:       time.Minute,
+			expectedArgs:        []string{"get-credentials", "--v=3"},
+		},
+		{
+			name:        "provider of another platform",
+			platform:    configv1.GCPPlatformType,
+			names:       []string{ECRCredentialProviderName},
+			expectedErr: true,
+		},
+		{
+			name:        "platform without providers",
+			platform:    configv1.VSpherePlatformType,
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := writableMapFS{fstest.MapFS{}}
+			fileInfo, changed, err := PopulateCredentialProviderConfig(test.platform, test.names, WithFS(fsys))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, changed)
+			assert.Equal(t, CredentialProviderConfigPath, fileInfo.Path)
+			contents, err := fs.ReadFile(fsys, toFSPath(CredentialProviderConfigPath))
+			require.NoError(t, err)
+			// the configuration never references payload paths
+			assert.NotContains(t, string(contents), "/payload")
+
+			var config kubeletconfig.CredentialProviderConfig
+			require.NoError(t, yaml.UnmarshalStrict(contents, &config))
+			assert.Equal(t, "kubelet.config.k8s.io/v1", config.APIVersion)
+			assert.Equal(t, "CredentialProviderConfig", config.Kind)
+			require.Len(t, config.Providers, 1)
+			provider := config.Providers[0]
+			assert.Equal(t, test.expectedName, provider.Name)
+			assert.Equal(t, test.expectedMatchImages, provider.MatchImages)
+			require.NotNil(t, provider.DefaultCacheDuration)
+			assert.Equal(t, test.expectedCache, provider.DefaultCacheDuration.Duration)
+			assert.Equal(t, "credentialprovider.kubelet.k8s.io/v1", provider.APIVersion)
+			assert.Equal(t, test.expectedArgs, provider.Args)
+
+			// regenerating the same configuration leaves it as is
+			_, changed, err = PopulateCredentialProviderConfig(test.platform, test.names, WithFS(fsys))
+			require.NoError(t, err)
+			assert.False(t, changed)
+		})
+	}
+}
+
+func TestGenerateCredentialProviderConfigInvalid(t *testing.T) {
+	valid := CredentialProvider{Name: "acr-credential-provider.exe", MatchImages: []string{"*.azurecr.io"},
+		DefaultCacheDuration: 10 * time.Minute}
+	testCases := []struct {
+		name   string
+		modify func(*CredentialProvider)
+	}{
+		{name: "payload path", modify: func(p *CredentialProvider) { p.Name = "/payload/" + p.Name }},
+		{name: "Windows path", modify: func(p *CredentialProvider) { p.Name = `C:\k\` + p.Name }},
+		{name: "not an executable", modify: func(p *CredentialProvider) { p.Name = strings.TrimSuffix(p.Name, ".exe") }},
+		{name: "no match images", modify: func(p *CredentialProvider) { p.MatchImages = nil }},
+		{name: "no cache duration", modify: func(p *CredentialProvider) { p.DefaultCacheDuration = 0 }},
+	}
+	_, err := generateCredentialProviderConfig([]CredentialProvider{valid})
+	require.NoError(t, err)
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			provider := valid
+			test.modify(&provider)
+			_, err := generateCredentialProviderConfig([]CredentialProvider{provider})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestCredentialProviderKubeletArgs(t *testing.T) {
+	assert.Equal(t, []string{`--image-credential-provider-config=C:\k\credential-providers.yaml`,
+		`--image-credential-provider-bin-dir=C:\k\credential-providers`}, CredentialProviderKubeletArgs())
+}
@@ -0,0 +1,59 @@
+package payload
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// csiProxyPipePrefixRegex matches a prefix valid within the name of a named pipe
+var csiProxyPipePrefixRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// WithCSIProxyEnabled configures whether csi-proxy is included in the files required by Windows nodes. It is enabled
+// by default, and can be disabled on clusters whose CSI drivers run as HostProcess containers, which do not use it.
+func WithCSIProxyEnabled(enabled bool) Option {
+	return func(o *options) {
+		o.csiProxyEnabled = enabled
+	}
+}
+
+// CSIProxyArgs holds the settings of the csi-proxy Windows service
+type CSIProxyArgs struct {
+	// LogFile is the path of the log file on Windows nodes. It must be within RemoteLogDir.
+	LogFile string
+	// PipePrefix is the prefix of the named pipes csi-proxy serves its API groups on. If empty, the csi-proxy default
+	// is used, which is what CSI node plugins expect unless configured otherwise.
+	PipePrefix string
+	// KubeletPath is the kubelet root directory on Windows nodes, which csi-proxy restricts volume paths to. If empty,
+	// the csi-proxy default of C:\var\lib\kubelet is used.
+	KubeletPath string
+}
+
+// Command returns the command line of the csi-proxy Windows service with the given settings, after validating them
+func (c CSIProxyArgs) Command() (string, error) {
+	if err := validateLogFile(c.LogFile); err != nil {
+		return "", fmt.Errorf("invalid csi-proxy settings: %w", err)
+	}
+	if c.PipePrefix != "" && !csiProxyPipePrefixRegex.MatchString(c.PipePrefix) {
+		return "", fmt.Errorf("invalid csi-proxy settings: pipe prefix %q must only contain letters, digits, "+
+			"dots, dashes and underscores", c.PipePrefix)
+	}
+	if c.KubeletPath != "" && !windowsAbsPathRegex.MatchString(c.KubeletPath) {
+		return "", fmt.Errorf("invalid csi-proxy settings: kubelet path %q is not an absolute Windows path",
+			c.KubeletPath)
+	}
+	cmd := []string{
+		RemoteK8sDir + "\\" + path.Base(CSIProxyPath),
+		QuoteWindowsArg("-log_file=" + c.LogFile),
+		"-logtostderr=false",
+		"-windows-service",
+	}
+	if c.PipePrefix != "" {
+		cmd = append(cmd, "-pipe-prefix="+c.PipePrefix)
+	}
+	if c.KubeletPath != "" {
+		cmd = append(cmd, QuoteWindowsArg("-kubelet-path="+c.KubeletPath))
+	}
+	return strings.Join(cmd, " "), nil
+}
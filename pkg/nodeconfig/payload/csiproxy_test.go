@@ -0,0 +1,67 @@
+package payload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSIProxyArgsCommand(t *testing.T) {
+	testCases := []struct {
+		name        string
+		args        CSIProxyArgs
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "defaults",
+			args:     CSIProxyArgs{LogFile: `C:\var\log\csi-proxy\csi-proxy.log`},
+			expected: `C:\k\csi-proxy.exe -log_file=C:\var\log\csi-proxy\csi-proxy.log -logtostderr=false -windows-service`,
+		},
+		{
+			name: "pipe prefix and kubelet path",
+			args: CSIProxyArgs{LogFile: `C:\var\log\csi-proxy\csi-proxy.log`, PipePrefix: "wmco-csi-proxy-",
+				KubeletPath: `D:\kubelet`},
+			expected: `C:\k\csi-proxy.exe -log_file=C:\var\log\csi-proxy\csi-proxy.log -logtostderr=false ` +
+				`-windows-service -pipe-prefix=wmco-csi-proxy- -kubelet-path=D:\kubelet`,
+		},
+		{
+			name: "paths with spaces",
+			args: CSIProxyArgs{LogFile: `C:\var\log\csi proxy\csi-proxy.log`, KubeletPath: `D:\kubelet root`},
+			expected: `C:\k\csi-proxy.exe "-log_file=C:\var\log\csi proxy\csi-proxy.log" -logtostderr=false ` +
+				`-windows-service "-kubelet-path=D:\kubelet root"`,
+		},
+		{
+			name:        "no log file",
+			args:        CSIProxyArgs{},
+			expectedErr: true,
+		},
+		{
+			name:        "log file outside of the log directory",
+			args:        CSIProxyArgs{LogFile: `C:\k\csi-proxy.log`},
+			expectedErr: true,
+		},
+		{
+			name:        "pipe prefix with a path separator",
+			args:        CSIProxyArgs{LogFile: `C:\var\log\csi-proxy\csi-proxy.log`, PipePrefix: `\\.\pipe\csi`},
+			expectedErr: true,
+		},
+		{
+			name:        "relative kubelet path",
+			args:        CSIProxyArgs{LogFile: `C:\var\log\csi-proxy\csi-proxy.log`, KubeletPath: "kubelet"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := test.args.Command()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, cmd)
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package payload
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefenderExclusionPaths returns the paths on Windows instances of every executable installed from the payload,
+// sorted by path. These are excluded from Windows Defender scans, which otherwise slow down pod starts.
+func DefenderExclusionPaths() []string {
+	var paths []string
+	for src, destination := range Destinations() {
+		if path.Ext(src) == ".exe" {
+			paths = append(paths, destination.Dir+"\\"+path.Base(src))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// DefenderExclusionScriptArgs returns the arguments of the Windows Defender exclusion script, excluding every
+// executable returned by DefenderExclusionPaths. Each path is quoted, so the arguments can be appended to the script
+// path in a PowerShell command.
+func DefenderExclusionScriptArgs() string {
+	var quoted []string
+	for _, exclusionPath := range DefenderExclusionPaths() {
+		quoted = append(quoted, psQuote(exclusionPath))
+	}
+	return "-BinPaths " + strings.Join(quoted, ",")
+}
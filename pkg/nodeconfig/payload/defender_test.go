@@ -0,0 +1,31 @@
+package payload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefenderExclusionPaths(t *testing.T) {
+	paths := DefenderExclusionPaths()
+	assert.Subset(t, paths, []string{
+		"C:\\k\\containerd\\containerd.exe",
+		"C:\\k\\kubelet.exe",
+		"C:\\k\\kube-proxy.exe",
+		"C:\\k\\hybrid-overlay-node.exe",
+		"C:\\k\\windows_exporter.exe",
+	})
+	assert.IsIncreasing(t, paths)
+	for _, path := range paths {
+		assert.Regexp(t, `^C:\\k\\.*\.exe$`, path)
+	}
+	// scripts and configuration files are not executables
+	assert.NotContains(t, paths, "C:\\k\\containerd\\containerd_conf.toml")
+	assert.NotContains(t, paths, "C:\\Temp\\hns.psm1")
+}
+
+func TestDefenderExclusionScriptArgs(t *testing.T) {
+	args := DefenderExclusionScriptArgs()
+	assert.Regexp(t, `^-BinPaths ('[^']+',)+'[^']+'$`, args)
+	assert.Contains(t, args, "'C:\\k\\kubelet.exe'")
+}
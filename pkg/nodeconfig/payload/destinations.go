@@ -0,0 +1,71 @@
+package payload
+
+import (
+	"io/fs"
+)
+
+// Directories payload files are copied to on Windows instances
+const (
+	// RemoteTempDir is the temporary directory holding scripts used during configuration
+	RemoteTempDir = "C:\\Temp"
+	// RemoteK8sDir is the directory holding the Kubernetes executables
+	RemoteK8sDir = "C:\\k"
+	// RemoteLogDir is the directory holding the log directories of the services configured on Windows instances
+	RemoteLogDir = "C:\\var\\log"
+	// RemoteCNIDir is the directory holding the CNI plugin executables
+	RemoteCNIDir = RemoteK8sDir + "\\cni"
+	// RemoteContainerdDir is the directory holding the containerd executables and configuration
+	RemoteContainerdDir = RemoteK8sDir + "\\containerd"
+	// RemoteContainerdRegistriesDir is containerd's certs.d directory, holding the hosts.toml and CA files of the
+	// configured registries
+	RemoteContainerdRegistriesDir = RemoteContainerdDir + "\\registries"
+	// RemoteCredentialProviderDir is the kubelet image credential provider bin directory, holding the credential
+	// provider plugin executables
+	RemoteCredentialProviderDir = RemoteK8sDir + "\\credential-providers"
+)
+
+const (
+	// executableMode is the file mode executables are created with
+	executableMode fs.FileMode = 0755
+	// dataMode is the file mode scripts and configuration files are created with
+	dataMode fs.FileMode = 0644
+)
+
+// FileDestination describes where a payload file is copied to on Windows instances
+type FileDestination struct {
+	// Dir is the directory the file is copied to, keeping its file name
+	Dir string
+	// Mode is the file mode the file is requested to be created with. Windows has no POSIX permissions, so the SFTP
+	// server only maps it to the read-only attribute at best, and access to the file is governed by the ACLs inherited
+	// from Dir.
+	Mode fs.FileMode
+}
+
+// Destinations returns the destination of every payload file on Windows instances, keyed by payload path
+func Destinations() map[string]FileDestination {
+	executable := func(dir string) FileDestination { return FileDestination{Dir: dir, Mode: executableMode} }
+	data := func(dir string) FileDestination { return FileDestination{Dir: dir, Mode: dataMode} }
+	return map[string]FileDestination{
+		WICDPath:                       executable(RemoteK8sDir),
+		KubeletPath:                    executable(RemoteK8sDir),
+		KubeProxyPath:                  executable(RemoteK8sDir),
+		KubeLogRunnerPath:              executable(RemoteK8sDir),
+		HybridOverlayPath:              executable(RemoteK8sDir),
+		CSIProxyPath:                   executable(RemoteK8sDir),
+		WindowsExporterPath:            executable(RemoteK8sDir),
+		AzureCloudNodeManagerPath:      executable(RemoteK8sDir),
+		ContainerdPath:                 executable(RemoteContainerdDir),
+		HcsshimPath:                    executable(RemoteContainerdDir),
+		ContainerdConfPath:             data(RemoteContainerdDir),
+		HostLocalCNIPlugin:             executable(RemoteCNIDir),
+		WinBridgeCNIPlugin:             executable(RemoteCNIDir),
+		WinOverlayCNIPlugin:            executable(RemoteCNIDir),
+		GcpGetValidHostnameScriptPath:  data(RemoteTempDir),
+		WinDefenderExclusionScriptPath: data(RemoteTempDir),
+		HNSPSModule:                    data(RemoteTempDir),
+		CNIConfigurationScript:         data(RemoteTempDir),
+		KubeProxyPrepScript:            data(RemoteTempDir),
+		PreflightScript:                data(RemoteTempDir),
+		HNSCleanupScript:               data(RemoteTempDir),
+	}
+}
@@ -0,0 +1,78 @@
+package payload
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// DirInfo contains information about a payload directory, with a single digest covering the contents of all files
+// within it
+type DirInfo struct {
+	// Path is the path of the directory
+	Path string
+	// SHA256 is the digest of the directory, covering the relative path and contents of every regular file within it.
+	// An empty directory has the digest of empty input.
+	SHA256 string
+	// Files holds the slash separated paths, relative to Path, of every file covered by the digest, in sorted order
+	Files []string
+}
+
+// NewDirInfo returns a DirInfo object describing the payload directory at the given path. The directory is walked
+// recursively in sorted order, so the digest only changes when a file is added, removed, renamed or modified. The
+// generated payload directory is skipped, and an error is returned if the directory contains a symlink or any other
+// irregular file.
+func NewDirInfo(dir string, opts ...Option) (*DirInfo, error) {
+	fsys := newOptions(opts).fsys
+	root := toFSPath(dir)
+	excluded := toFSPath(payloadPath(generatedDirectoryName))
+	h := sha256.New()
+	dirInfo := &DirInfo{Path: dir}
+	err := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return classifyFileError(withPath(err, "/"+name))
+		}
+		switch {
+		case d.IsDir():
+			if name == excluded && name != root {
+				return fs.SkipDir
+			}
+			return nil
+		case d.Type()&fs.ModeSymlink != 0:
+			return fmt.Errorf("%s is a symlink", "/"+name)
+		case !d.Type().IsRegular():
+			return fmt.Errorf("%s is not a regular file", "/"+name)
+		}
+		relPath := strings.TrimPrefix(strings.TrimPrefix(name, root), "/")
+		fileDigest, err := fileSHA256(fsys, name)
+		if err != nil {
+			return err
+		}
+		// Each file contributes a line holding its relative path and digest. Paths cannot contain a NUL byte, so
+		// this cannot be ambiguous.
+		fmt.Fprintf(h, "%s\x00%s\n", relPath, fileDigest)
+		dirInfo.Files = append(dirInfo.Files, relPath)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not get contents of directory %s: %w", dir, err)
+	}
+	dirInfo.SHA256 = fmt.Sprintf("%x", h.Sum(nil))
+	return dirInfo, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 digest of the given file within fsys
+func fileSHA256(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", classifyFileError(withPath(err, "/"+name))
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", classifyFileError(withPath(err, "/"+name))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
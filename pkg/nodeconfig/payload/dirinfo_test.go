@@ -0,0 +1,107 @@
+package payload
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDirInfo(t *testing.T) {
+	base := fstest.MapFS{
+		"payload/powershell/hns.psm1":             {Data: []byte("hns")},
+		"payload/powershell/gcp-get-hostname.ps1": {Data: []byte("gcp")},
+		"payload/powershell/nested/helper.ps1":    {Data: []byte("helper")},
+	}
+	baseInfo, err := NewDirInfo(payloadDirectory+"powershell", WithFS(base))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gcp-get-hostname.ps1", "hns.psm1", "nested/helper.ps1"}, baseInfo.Files)
+
+	withFile := func(name string, file *fstest.MapFile) fstest.MapFS {
+		fsys := fstest.MapFS{}
+		for k, v := range base {
+			fsys[k] = v
+		}
+		fsys[name] = file
+		return fsys
+	}
+	testCases := []struct {
+		name        string
+		fsys        fstest.MapFS
+		dir         string
+		changed     bool
+		expectedErr bool
+	}{
+		{
+			name: "identical contents",
+			fsys: withFile("payload/powershell/hns.psm1", &fstest.MapFile{Data: []byte("hns")}),
+			dir:  payloadDirectory + "powershell",
+		},
+		{
+			name:    "modified file",
+			fsys:    withFile("payload/powershell/hns.psm1", &fstest.MapFile{Data: []byte("hns v2")}),
+			dir:     payloadDirectory + "powershell",
+			changed: true,
+		},
+		{
+			name:    "added file",
+			fsys:    withFile("payload/powershell/nested/other.ps1", &fstest.MapFile{Data: []byte("other")}),
+			dir:     payloadDirectory + "powershell",
+			changed: true,
+		},
+		{
+			name: "file outside of the directory",
+			fsys: withFile("payload/kube-node/kubelet.exe", &fstest.MapFile{Data: []byte("kubelet")}),
+			dir:  payloadDirectory + "powershell",
+		},
+		{
+			name:        "symlink",
+			fsys:        withFile("payload/powershell/link.ps1", &fstest.MapFile{Mode: fs.ModeSymlink}),
+			dir:         payloadDirectory + "powershell",
+			expectedErr: true,
+		},
+		{
+			name:        "missing directory",
+			fsys:        fstest.MapFS{},
+			dir:         payloadDirectory + "powershell",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := NewDirInfo(test.dir, WithFS(test.fsys))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.changed, info.SHA256 != baseInfo.SHA256)
+		})
+	}
+}
+
+func TestNewDirInfoGenerated(t *testing.T) {
+	fsys := fstest.MapFS{
+		"payload/powershell/hns.psm1":        {Data: []byte("hns")},
+		"payload/generated/network-conf.ps1": {Data: []byte("network")},
+	}
+	info, err := NewDirInfo(payloadDirectory, WithFS(fsys))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"powershell/hns.psm1"}, info.Files)
+
+	fsys["payload/generated/network-conf.ps1"] = &fstest.MapFile{Data: []byte("regenerated")}
+	regenerated, err := NewDirInfo(payloadDirectory, WithFS(fsys))
+	require.NoError(t, err)
+	assert.Equal(t, info.SHA256, regenerated.SHA256)
+}
+
+func TestNewDirInfoEmpty(t *testing.T) {
+	fsys := fstest.MapFS{"payload/powershell": {Mode: fs.ModeDir}}
+	info, err := NewDirInfo(payloadDirectory+"powershell", WithFS(fsys))
+	require.NoError(t, err)
+	assert.Empty(t, info.Files)
+	// the SHA-256 digest of empty input
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", info.SHA256)
+}
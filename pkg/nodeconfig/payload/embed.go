@@ -0,0 +1,45 @@
+package payload
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/internal"
+)
+
+// HNSModuleContents returns the contents of the HNS PowerShell module, embedded at build time. The on-disk copy is at
+// HNSPSModule.
+func HNSModuleContents() []byte {
+	return []byte(internal.HNSModule)
+}
+
+// DefenderExclusionScriptContents returns the contents of the Windows Defender exclusion script, embedded at build
+// time. The on-disk copy is at WinDefenderExclusionScriptPath.
+func DefenderExclusionScriptContents() []byte {
+	return []byte(internal.WinDefenderExclusionScript)
+}
+
+// EmbeddedContents returns the embedded contents of the payload file at the given path, and whether the file is
+// embedded. Embedded contents should be preferred over the on-disk copy, as they cannot be affected by a broken
+// payload mount.
+func EmbeddedContents(path string) ([]byte, bool) {
+	switch path {
+	case HNSPSModule:
+		return HNSModuleContents(), true
+	case WinDefenderExclusionScriptPath:
+		return DefenderExclusionScriptContents(), true
+	}
+	return nil, false
+}
+
+// embeddedFileInfo returns the FileInfo of the embedded contents of the payload file at the given path, and whether the
+// file is embedded. As embedded files are transferred from their embedded contents, their digest must be computed from
+// them rather than from the on-disk copy.
+func embeddedFileInfo(path string) (*FileInfo, bool) {
+	contents, ok := EmbeddedContents(path)
+	if !ok {
+		return nil, false
+	}
+	return &FileInfo{Path: path, SHA256: fmt.Sprintf("%x", sha256.Sum256(contents)), Algorithm: AlgorithmSHA256,
+		Size: int64(len(contents))}, true
+}
@@ -0,0 +1,34 @@
+package payload
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbeddedContents(t *testing.T) {
+	for _, path := range Files() {
+		contents, embedded := EmbeddedContents(path)
+		if !strings.HasPrefix(path, payloadPath(powershellDirectory)+"/") {
+			assert.False(t, embedded, "%s should not be embedded", path)
+			continue
+		}
+		assert.True(t, embedded, "%s should be embedded", path)
+		assert.NotEmpty(t, contents, "%s is empty", path)
+	}
+}
+
+// TestHNSModuleFunctions ensures the HNS module exports every function the network configuration script relies on
+func TestHNSModuleFunctions(t *testing.T) {
+	exported := make(map[string]bool)
+	for _, match := range regexp.MustCompile(`Export-ModuleMember -Function (\S+)`).FindAllStringSubmatch(
+		string(HNSModuleContents()), -1) {
+		exported[strings.ToLower(match[1])] = true
+	}
+	for _, function := range []string{"New-HnsEndpoint", "Attach-HNSHostEndpoint", "Invoke-HNSRequest"} {
+		assert.Contains(t, kubeProxyPrepTemplate, function)
+		assert.True(t, exported[strings.ToLower(function)], "%s is not exported by the HNS module", function)
+	}
+}
@@ -0,0 +1,217 @@
+package payload
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ExporterWebConfigPath is the path of the generated windows_exporter web configuration, enabling TLS on its metrics
+// endpoint. It is only copied to Windows nodes once generated, so it is not one of the payload Files.
+var ExporterWebConfigPath = payloadPath(generatedDirectoryName, "windows-exporter-webconfig.yaml")
+
+const (
+	// RemoteExporterDir is the directory holding the windows_exporter web configuration and TLS files on Windows nodes
+	RemoteExporterDir = RemoteK8sDir + "\\windows-exporter"
+	// DefaultExporterCertPath is the path of the certificate the windows_exporter metrics endpoint is served with
+	DefaultExporterCertPath = RemoteExporterDir + "\\tls.crt"
+	// DefaultExporterKeyPath is the path of the private key of DefaultExporterCertPath
+	DefaultExporterKeyPath = RemoteExporterDir + "\\tls.key"
+	// DefaultExporterMinTLSVersion is the minimum TLS version accepted by the windows_exporter metrics endpoint
+	DefaultExporterMinTLSVersion = "TLS12"
+	// exporterClientAuthType is the client authentication windows_exporter requires when a client CA is configured
+	exporterClientAuthType = "RequireAndVerifyClientCert"
+	// DefaultExporterPort is the port windows_exporter serves metrics on when no listen address is set
+	DefaultExporterPort = 9182
+)
+
+// DefaultExporterCollectors are the windows_exporter collectors enabled when none are configured
+var DefaultExporterCollectors = []string{"cpu", "cs", "logical_disk", "net", "os", "service", "system", "textfile",
+	"container", "memory", "cpu_info"}
+
+// exporterCollectors are the names of the collectors windows_exporter supports
+var exporterCollectors = []string{"ad", "adcs", "adfs", "cache", "container", "cpu", "cpu_info", "cs", "dfsr", "dhcp",
+	"diskdrive", "dns", "exchange", "fsrmquota", "hyperv", "iis", "logical_disk", "logon", "memory", "mscluster",
+	"msmq", "mssql", "net", "netframework", "nps", "os", "physical_disk", "printer", "process", "remote_fx",
+	"scheduled_task", "service", "smb", "smbclient", "smtp", "system", "tcp", "teradici_pcoip", "terminal_services",
+	"textfile", "thermalzone", "time", "vmware"}
+
+// reservedExporterPorts are the TCP ports of the services configured on Windows nodes, which windows_exporter cannot
+// listen on. containerd only listens on named pipes and a random loopback port, so it has no fixed port.
+var reservedExporterPorts = map[uint64]string{
+	10248: "kubelet healthz",
+	10250: "kubelet",
+	10249: "kube-proxy metrics",
+	10256: "kube-proxy healthz",
+}
+
+// ExporterConfig holds the settings of the windows_exporter Windows service
+type ExporterConfig struct {
+	// Collectors are the names of the enabled collectors. If empty, DefaultExporterCollectors are enabled.
+	Collectors []string
+	// ListenAddress is the address metrics are served on, in host:port or :port form. If empty, metrics are served on
+	// DefaultExporterPort of every address.
+	ListenAddress string
+	// WebConfigPath is the path on Windows nodes of the web configuration enabling TLS, such as one generated by
+	// PopulateExporterWebConfig. If empty, metrics are served over plain HTTP.
+	WebConfigPath string
+}
+
+// Command returns the command line of the windows_exporter Windows service with the given settings, after validating
+// them
+func (c ExporterConfig) Command() (string, error) {
+	collectors := c.Collectors
+	if len(collectors) == 0 {
+		collectors = DefaultExporterCollectors
+	}
+	seen := make(map[string]bool)
+	for _, collector := range collectors {
+		if !slices.Contains(exporterCollectors, collector) {
+			return "", fmt.Errorf("unknown windows_exporter collector %q", collector)
+		}
+		if seen[collector] {
+			return "", fmt.Errorf("windows_exporter collector %q enabled more than once", collector)
+		}
+		seen[collector] = true
+	}
+	cmd := RemoteK8sDir + "\\" + WindowsExporterName + " --collectors.enabled " + strings.Join(collectors, ",")
+	if c.ListenAddress != "" {
+		if err := validateExporterListenAddress(c.ListenAddress); err != nil {
+			return "", fmt.Errorf("invalid windows_exporter listen address %q: %w", c.ListenAddress, err)
+		}
+		cmd += " --web.listen-address=" + c.ListenAddress
+	}
+	if c.WebConfigPath != "" {
+		// the command line is not quoted, so the path cannot contain spaces
+		if !windowsAbsPathRegex.MatchString(c.WebConfigPath) || strings.ContainsAny(c.WebConfigPath, " \t") {
+			return "", fmt.Errorf("windows_exporter web configuration path %q is not an absolute Windows path "+
+				"without spaces", c.WebConfigPath)
+		}
+		cmd += " --web.config.file=" + c.WebConfigPath
+	}
+	return cmd, nil
+}
+
+// validateExporterListenAddress ensures the given address is an optional IP address and port pair, whose port is not
+// used by another service configured on Windows nodes
+func validateExporterListenAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		return fmt.Errorf("%q is not an IP address", host)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || portNumber == 0 {
+		return fmt.Errorf("%q is not a valid port", port)
+	}
+	if service, reserved := reservedExporterPorts[portNumber]; reserved {
+		return fmt.Errorf("port %d is used by %s", portNumber, service)
+	}
+	return nil
+}
+
+// exporterTLSVersions are the TLS versions windows_exporter accepts as minimum version, in increasing order
+var exporterTLSVersions = []string{"TLS10", "TLS11", "TLS12", "TLS13"}
+
+// windowsAbsPathRegex matches an absolute path on a local Windows drive, without characters Windows forbids in paths
+var windowsAbsPathRegex = regexp.MustCompile(`^[A-Za-z]:\\[^\x00-\x1f"*?<>|]*$`)
+
+// ExporterWebConfigParams holds the TLS settings of the windows_exporter metrics endpoint. Paths are paths on Windows
+// nodes.
+type ExporterWebConfigParams struct {
+	// MinTLSVersion is the minimum TLS version accepted, one of TLS10, TLS11, TLS12 or TLS13. If empty,
+	// DefaultExporterMinTLSVersion is used.
+	MinTLSVersion string
+	// CipherSuites are the names of the TLS 1.2 and earlier cipher suites accepted, as named by the Go crypto/tls
+	// package. If empty, the Go defaults are used. Cipher suites cannot be configured along with TLS13.
+	CipherSuites []string
+	// ClientCAPath is the path of the CA bundle client certificates are verified against. If set, clients are required
+	// to present a certificate signed by it.
+	ClientCAPath string
+	// CertPath is the path of the serving certificate. If empty, DefaultExporterCertPath is used.
+	CertPath string
+	// KeyPath is the path of the private key of the serving certificate. If empty, DefaultExporterKeyPath is used.
+	KeyPath string
+}
+
+// PopulateExporterWebConfig creates the windows_exporter web configuration at ExporterWebConfigPath, returning its
+// FileInfo and true if its contents changed
+func PopulateExporterWebConfig(params ExporterWebConfigParams, opts ...Option) (*FileInfo, bool, error) {
+	contents, err := generateExporterWebConfig(params)
+	if err != nil {
+		return nil, false, err
+	}
+	return writeGeneratedScriptTo(ExporterWebConfigPath, contents, params, opts...)
+}
+
+// generateExporterWebConfig returns the windows_exporter web configuration with the given TLS settings, after
+// validating them against the settings windows_exporter supports
+func generateExporterWebConfig(params ExporterWebConfigParams) (string, error) {
+	if params.MinTLSVersion == "" {
+		params.MinTLSVersion = DefaultExporterMinTLSVersion
+	}
+	if params.CertPath == "" {
+		params.CertPath = DefaultExporterCertPath
+	}
+	if params.KeyPath == "" {
+		params.KeyPath = DefaultExporterKeyPath
+	}
+	if !slices.Contains(exporterTLSVersions, params.MinTLSVersion) {
+		return "", fmt.Errorf("invalid windows_exporter minimum TLS version %q, expected one of %s",
+			params.MinTLSVersion, strings.Join(exporterTLSVersions, ", "))
+	}
+	if len(params.CipherSuites) > 0 && params.MinTLSVersion == "TLS13" {
+		return "", fmt.Errorf("windows_exporter cipher suites cannot be configured with minimum TLS version TLS13")
+	}
+	for _, cipherSuite := range params.CipherSuites {
+		if !isSecureCipherSuite(cipherSuite) {
+			return "", fmt.Errorf("unsupported windows_exporter cipher suite %q", cipherSuite)
+		}
+	}
+	for _, file := range []struct{ name, path string }{{"certificate", params.CertPath}, {"key", params.KeyPath},
+		{"client CA", params.ClientCAPath}} {
+		if file.path != "" && !windowsAbsPathRegex.MatchString(file.path) {
+			return "", fmt.Errorf("windows_exporter %s path %q is not an absolute Windows path", file.name, file.path)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("tls_server_config:\n")
+	fmt.Fprintf(&b, "  cert_file: %s\n", yamlQuote(params.CertPath))
+	fmt.Fprintf(&b, "  key_file: %s\n", yamlQuote(params.KeyPath))
+	if params.ClientCAPath != "" {
+		fmt.Fprintf(&b, "  client_auth_type: %s\n", exporterClientAuthType)
+		fmt.Fprintf(&b, "  client_ca_file: %s\n", yamlQuote(params.ClientCAPath))
+	}
+	fmt.Fprintf(&b, "  min_version: %s\n", params.MinTLSVersion)
+	if len(params.CipherSuites) > 0 {
+		b.WriteString("  cipher_suites:\n")
+		for _, cipherSuite := range params.CipherSuites {
+			fmt.Fprintf(&b, "  - %s\n", cipherSuite)
+		}
+	}
+	return b.String(), nil
+}
+
+// isSecureCipherSuite returns true if the given name is one of the configurable cipher suites Go considers secure.
+// Insecure cipher suites are rejected even though windows_exporter accepts them, as are TLS 1.3 cipher suites, which
+// cannot be configured.
+func isSecureCipherSuite(name string) bool {
+	for _, cipherSuite := range tls.CipherSuites() {
+		if cipherSuite.Name == name {
+			return cipherSuite.SupportedVersions[0] != tls.VersionTLS13
+		}
+	}
+	return false
+}
+
+// yamlQuote returns the given value as a single quoted YAML string, in which backslashes are not escape characters
+func yamlQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
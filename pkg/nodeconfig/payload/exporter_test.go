@@ -0,0 +1,215 @@
+package payload
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+)
+
+// exporterWebConfig is the subset of the windows_exporter web configuration that is generated
+type exporterWebConfig struct {
+	TLSServerConfig struct {
+		CertFile       string   `json:"cert_file"`
+		KeyFile        string   `json:"key_file"`
+		ClientAuthType string   `json:"client_auth_type"`
+		ClientCAFile   string   `json:"client_ca_file"`
+		MinVersion     string   `json:"min_version"`
+		CipherSuites   []string `json:"cipher_suites"`
+	} `json:"tls_server_config"`
+}
+
+func TestPopulateExporterWebConfig(t *testing.T) {
+	testCases := []struct {
+		name               string
+		params             ExporterWebConfigParams
+		expectedCert       string
+		expectedKey        string
+		expectedClientAuth string
+		expectedClientCA   string
+		expectedMinVersion string
+		expectedCiphers    []string
+		expectedErr        bool
+	}{
+		{
+			name:               "defaults",
+			expectedCert:       `C:\k\windows-exporter\tls.crt`,
+			expectedKey:        `C:\k\windows-exporter\tls.key`,
+			expectedMinVersion: "TLS12",
+		},
+		{
+			name: "mutual TLS with restricted cipher suites",
+			params: ExporterWebConfigParams{
+				MinTLSVersion: "TLS12",
+				CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+					"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+				ClientCAPath: `C:\k\windows-exporter\client-ca.crt`,
+				CertPath:     `C:\Program Files\exporter\serving.crt`,
+				KeyPath:      `C:\Program Files\exporter\it's.key`,
+			},
+			expectedCert:       `C:\Program Files\exporter\serving.crt`,
+			expectedKey:        `C:\Program Files\exporter\it's.key`,
+			expectedClientAuth: "RequireAndVerifyClientCert",
+			expectedClientCA:   `C:\k\windows-exporter\client-ca.crt`,
+			expectedMinVersion: "TLS12",
+			expectedCiphers: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+				"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+		},
+		{
+			name:               "TLS 1.3",
+			params:             ExporterWebConfigParams{MinTLSVersion: "TLS13"},
+			expectedCert:       `C:\k\windows-exporter\tls.crt`,
+			expectedKey:        `C:\k\windows-exporter\tls.key`,
+			expectedMinVersion: "TLS13",
+		},
+		{
+			name:        "unsupported TLS version",
+			params:      ExporterWebConfigParams{MinTLSVersion: "VersionTLS12"},
+			expectedErr: true,
+		},
+		{
+			name: "cipher suites with TLS 1.3",
+			params: ExporterWebConfigParams{MinTLSVersion: "TLS13",
+				CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+			expectedErr: true,
+		},
+		{
+			name:        "insecure cipher suite",
+			params:      ExporterWebConfigParams{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			expectedErr: true,
+		},
+		{
+			name:        "TLS 1.3 cipher suite",
+			params:      ExporterWebConfigParams{CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown cipher suite",
+			params:      ExporterWebConfigParams{CipherSuites: []string{"ECDHE-RSA-AES128-GCM-SHA256"}},
+			expectedErr: true,
+		},
+		{
+			name:        "relative client CA path",
+			params:      ExporterWebConfigParams{ClientCAPath: "client-ca.crt"},
+			expectedErr: true,
+		},
+		{
+			name:        "certificate path with newline",
+			params:      ExporterWebConfigParams{CertPath: "C:\\k\\tls.crt\n  key_file: C:\\k\\other.key"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := writableMapFS{fstest.MapFS{}}
+			fileInfo, changed, err := PopulateExporterWebConfig(test.params, WithFS(fsys))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, changed)
+			assert.Equal(t, ExporterWebConfigPath, fileInfo.Path)
+			contents, err := fs.ReadFile(fsys, toFSPath(ExporterWebConfigPath))
+			require.NoError(t, err)
+			var config exporterWebConfig
+			require.NoError(t, yaml.UnmarshalStrict(contents, &config))
+			assert.Equal(t, test.expectedCert, config.TLSServerConfig.CertFile)
+			assert.Equal(t, test.expectedKey, config.TLSServerConfig.KeyFile)
+			assert.Equal(t, test.expectedClientAuth, config.TLSServerConfig.ClientAuthType)
+			assert.Equal(t, test.expectedClientCA, config.TLSServerConfig.ClientCAFile)
+			assert.Equal(t, test.expectedMinVersion, config.TLSServerConfig.MinVersion)
+			assert.Equal(t, test.expectedCiphers, config.TLSServerConfig.CipherSuites)
+
+			// regenerating the same configuration leaves it as is
+			unchanged, changed, err := PopulateExporterWebConfig(test.params, WithFS(fsys))
+			require.NoError(t, err)
+			assert.False(t, changed)
+			assert.Equal(t, fileInfo.Checksum(), unchanged.Checksum())
+		})
+	}
+}
+
+func TestExporterConfigCommand(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      ExporterConfig
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name: "defaults",
+			expected: `C:\k\windows_exporter.exe --collectors.enabled ` +
+				"cpu,cs,logical_disk,net,os,service,system,textfile,container,memory,cpu_info",
+		},
+		{
+			name: "custom collectors, listen address and web configuration",
+			config: ExporterConfig{
+				Collectors:    []string{"cpu", "container", "tcp"},
+				ListenAddress: ":9183",
+				WebConfigPath: `C:\k\windows-exporter\webconfig.yaml`,
+			},
+			expected: `C:\k\windows_exporter.exe --collectors.enabled cpu,container,tcp ` +
+				`--web.listen-address=:9183 --web.config.file=C:\k\windows-exporter\webconfig.yaml`,
+		},
+		{
+			name:     "IPv6 listen address",
+			config:   ExporterConfig{Collectors: []string{"cpu"}, ListenAddress: "[::1]:9182"},
+			expected: `C:\k\windows_exporter.exe --collectors.enabled cpu --web.listen-address=[::1]:9182`,
+		},
+		{
+			name:        "unknown collector",
+			config:      ExporterConfig{Collectors: []string{"cpu", "containerd"}},
+			expectedErr: true,
+		},
+		{
+			name:        "duplicate collector",
+			config:      ExporterConfig{Collectors: []string{"cpu", "cpu"}},
+			expectedErr: true,
+		},
+		{
+			name:        "kubelet port",
+			config:      ExporterConfig{ListenAddress: "0.0.0.0:10250"},
+			expectedErr: true,
+		},
+		{
+			name:        "kube-proxy healthz port",
+			config:      ExporterConfig{ListenAddress: ":10256"},
+			expectedErr: true,
+		},
+		{
+			name:        "missing port",
+			config:      ExporterConfig{ListenAddress: "0.0.0.0"},
+			expectedErr: true,
+		},
+		{
+			name:        "hostname listen address",
+			config:      ExporterConfig{ListenAddress: "localhost:9182"},
+			expectedErr: true,
+		},
+		{
+			name:        "web configuration path with spaces",
+			config:      ExporterConfig{WebConfigPath: `C:\Program Files\webconfig.yaml`},
+			expectedErr: true,
+		},
+		{
+			name:        "relative web configuration path",
+			config:      ExporterConfig{WebConfigPath: "webconfig.yaml"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := test.config.Command()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, cmd)
+		})
+	}
+}
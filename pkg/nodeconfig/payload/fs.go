@@ -0,0 +1,128 @@
+package payload
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WriteFileFS is a file system which also supports writing files, used for files generated by the operator
+type WriteFileFS interface {
+	fs.FS
+	// WriteFile writes data to the named file, creating it if necessary
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+// generatedDirMode is the file mode directories holding generated files are created with
+const generatedDirMode fs.FileMode = 0755
+
+// rootFS is the default file system, giving access to the operator's file system from the given directory
+type rootFS struct {
+	fs.FS
+	// dir is the directory the file system is rooted at
+	dir string
+}
+
+// newRootFS returns a rootFS rooted at the given directory
+func newRootFS(dir string) rootFS {
+	return rootFS{FS: os.DirFS(dir), dir: dir}
+}
+
+// WriteFile atomically replaces the file at the given path, relative to the root directory, creating its parent
+// directory if needed
+func (r rootFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return writeFileAtomic(filepath.Join(r.dir, filepath.FromSlash(name)), data, perm)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as path, and renames it into place once it
+// has been synced to disk. Readers observe either the previous or the new contents, never a partially written file.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) (err error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, generatedDirMode); err != nil {
+		return fmt.Errorf("could not create directory %s: %w", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file for %s: %w", path, err)
+	}
+	defer func() {
+		// remove the temporary file if it was not renamed into place
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(data); err != nil {
+		return fmt.Errorf("could not write temporary file for %s: %w", path, err)
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return fmt.Errorf("could not set permissions of temporary file for %s: %w", path, err)
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("could not sync temporary file for %s: %w", path, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("could not close temporary file for %s: %w", path, err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not move temporary file to %s: %w", path, err)
+	}
+	return nil
+}
+
+// Option configures how the payload functions access payload files
+type Option func(*options)
+
+// options holds the configuration set by Options
+type options struct {
+	// fsys is the file system payload files are accessed through. It is rooted at "/", so that payload paths can be
+	// used after removing their leading slash.
+	fsys fs.FS
+	// operatorVersion is the operator version recorded in the header of generated scripts
+	operatorVersion string
+	// now returns the current time, recorded in the header of generated scripts
+	now func() time.Time
+	// csiProxyEnabled includes csi-proxy in the files required by Windows nodes
+	csiProxyEnabled bool
+}
+
+// WithFS configures payload files to be accessed through the given file system, which must be rooted at "/". To be
+// able to generate files, fsys must implement WriteFileFS.
+func WithFS(fsys fs.FS) Option {
+	return func(o *options) {
+		o.fsys = fsys
+	}
+}
+
+// WithOperatorVersion configures the operator version recorded in the header of generated scripts
+func WithOperatorVersion(version string) Option {
+	return func(o *options) {
+		o.operatorVersion = version
+	}
+}
+
+// newOptions returns the options resulting from applying opts to the defaults
+func newOptions(opts []Option) *options {
+	o := &options{fsys: newRootFS("/"), now: time.Now, csiProxyEnabled: true}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// writeFile writes data to the file at the given absolute path
+func (o *options) writeFile(path string, data []byte, perm fs.FileMode) error {
+	writable, ok := o.fsys.(WriteFileFS)
+	if !ok {
+		return fmt.Errorf("cannot write %s: file system does not support writing", path)
+	}
+	return writable.WriteFile(toFSPath(path), data, perm)
+}
+
+// toFSPath converts the given absolute path into a path valid within a file system rooted at "/"
+func toFSPath(path string) string {
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+}
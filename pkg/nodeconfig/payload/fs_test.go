@@ -0,0 +1,129 @@
+package payload
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPopulateCNIConfScriptAtomic(t *testing.T) {
+	root := t.TempDir()
+	scriptPath := filepath.Join(root, filepath.FromSlash(toFSPath(CNIConfigurationScript)))
+	populate := func() error {
+		_, _, err := PopulateCNIConfScript("172.30.0.0/16", "OVNKubernetesHybridOverlayNetwork", "c:\\k\\hns.psm1",
+			"c:\\k\\cni\\config\\cni.conf", CNIConfSettings{}, WithFS(newRootFS(root)))
+		return err
+	}
+
+	// the generated directory is created if it does not exist
+	require.NoError(t, populate())
+	dirStat, err := os.Stat(filepath.Dir(scriptPath))
+	require.NoError(t, err)
+	assert.Equal(t, generatedDirMode, dirStat.Mode().Perm())
+	expected, err := os.ReadFile(scriptPath)
+	require.NoError(t, err)
+
+	// a partially written script left behind by a previous run is replaced
+	require.NoError(t, os.WriteFile(scriptPath, expected[:len(expected)/2], 0644))
+	require.NoError(t, populate())
+	contents, err := os.ReadFile(scriptPath)
+	require.NoError(t, err)
+	assert.Equal(t, expected, contents)
+
+	entries, err := os.ReadDir(filepath.Dir(scriptPath))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "temporary files should not be left behind")
+}
+
+func TestWriteNetworkScripts(t *testing.T) {
+	root := t.TempDir()
+	testCases := []struct {
+		name         string
+		dest         string
+		expectedPath string
+		expectedErr  bool
+	}{
+		{
+			name:         "nested directories are created",
+			dest:         "/var/run/wmco/generated/cni-conf.ps1",
+			expectedPath: "/var/run/wmco/generated/cni-conf.ps1",
+		},
+		{
+			name:         "path is cleaned",
+			dest:         "/var/run/wmco//generated/../scripts/./cni-conf.ps1",
+			expectedPath: "/var/run/wmco/scripts/cni-conf.ps1",
+		},
+		{
+			name:        "relative path",
+			dest:        "generated/cni-conf.ps1",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			script, changed, err := WriteCNIConfScript(test.dest, "172.30.0.0/16", "OVNKubernetesHybridOverlayNetwork",
+				"c:\\k\\hns.psm1", "c:\\k\\cni\\config\\cni.conf", CNIConfSettings{}, WithFS(newRootFS(root)))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, changed)
+			assert.Equal(t, test.expectedPath, script.Path)
+			_, err = os.Stat(filepath.Join(root, filepath.FromSlash(script.Path)))
+			assert.NoError(t, err)
+		})
+	}
+
+	script, changed, err := WriteKubeProxyPrepScript("/var/run/wmco/kube-proxy-prep.ps1",
+		"OVNKubernetesHybridOverlayNetwork", "c:\\k\\hns.psm1", KubeProxyPrepSettings{}, WithFS(newRootFS(root)))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "/var/run/wmco/kube-proxy-prep.ps1", script.Path)
+	// the default payload location is left untouched
+	_, err = os.Stat(filepath.Join(root, filepath.FromSlash(toFSPath(KubeProxyPrepScript))))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.ps1")
+	versions := [][]byte{bytes.Repeat([]byte("a"), 1<<20), bytes.Repeat([]byte("b"), 1<<19)}
+	require.NoError(t, writeFileAtomic(path, versions[0], 0644))
+
+	// readers must only ever observe one of the complete versions, while the file is being replaced
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			contents, err := os.ReadFile(path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if !bytes.Equal(contents, versions[0]) && !bytes.Equal(contents, versions[1]) {
+				assert.Failf(t, "observed intermediate contents", "read %d bytes", len(contents))
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		require.NoError(t, writeFileAtomic(path, versions[i%2], 0644))
+	}
+	close(done)
+	wg.Wait()
+
+	// the parent directory cannot be created beneath a regular file
+	err := writeFileAtomic(filepath.Join(path, "child"), versions[0], 0644)
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package payload
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultGCPMetadataHostnameEndpoint is the endpoint of the GCP instance metadata service returning the hostname
+	// of the instance. The DNS name of the metadata server is preferred over its IP address.
+	DefaultGCPMetadataHostnameEndpoint = "http://metadata.google.internal/computeMetadata/v1/instance/hostname"
+	// defaultGCPMetadataTimeout is the default timeout of a single request to the metadata service
+	defaultGCPMetadataTimeout = 10 * time.Second
+	// defaultGCPMetadataAttempts is the default number of requests made to the metadata service before failing
+	defaultGCPMetadataAttempts = 5
+	// defaultGCPMetadataRetryDelay is the default delay between requests to the metadata service
+	defaultGCPMetadataRetryDelay = 2 * time.Second
+	// maxGCPHostnameLength is the maximum length of the hostname of a GCP instance used as node name
+	maxGCPHostnameLength = 63
+	// gcpHostnameHashLength is the number of hex characters of the hostname hash suffixing a truncated hostname
+	gcpHostnameHashLength = 8
+)
+
+// gcpHostnameRegex matches a lowercase RFC 1123 subdomain
+var gcpHostnameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// gcpHostnameTemplate is the template used to generate the script which resolves the hostname of GCP instances. The
+// hostname returned by the metadata service is used as is if it is short enough, otherwise its first label is. If
+// that is too long as well, it is truncated and suffixed with a hash of the full hostname, so that truncated
+// hostnames do not collide. This logic matches GCPHostname.
+const gcpHostnameTemplate = `# This script returns the hostname of the GCP instance, as resolved by the instance metadata service,
+# shortened to at most {{.MaxLength}} characters if needed. See instance metadata documentation for GCP:
+# https://cloud.google.com/compute/docs/metadata/default-metadata-values
+$ErrorActionPreference = "Stop"
+
+$metadata_endpoint={{psQuote .MetadataEndpoint}}
+$max_length={{.MaxLength}}
+$hash_length={{.HashLength}}
+
+$hostname=$null
+for($attempt=1; $attempt -le {{.Attempts}}; $attempt++) {
+    try {
+        $hostname=(Invoke-RestMethod -Headers @{'Metadata-Flavor'='Google'} -Uri $metadata_endpoint ` +
+	`-TimeoutSec {{.TimeoutSeconds}})
+        break
+    } catch {
+        if($attempt -eq {{.Attempts}}) {
+            throw "could not get hostname from $metadata_endpoint after $attempt attempts: $_"
+        }
+        Start-Sleep -Milliseconds {{.RetryDelayMilliseconds}}
+    }
+}
+$hostname=([string]$hostname).Trim().TrimEnd(".").ToLowerInvariant()
+
+if($hostname.Length -gt $max_length) {
+    $first_label=$hostname.Split(".")[0]
+    if($first_label.Length -le $max_length) {
+        $hostname=$first_label
+    } else {
+        $sha256=[System.Security.Cryptography.SHA256]::Create()
+        $hash=-join ($sha256.ComputeHash([System.Text.Encoding]::UTF8.GetBytes($hostname)) | ` +
+	`ForEach-Object { $_.ToString("x2") })
+        $prefix=$first_label.Substring(0, $max_length - $hash_length - 1).TrimEnd("-")
+        $hostname=$prefix + "-" + $hash.Substring(0, $hash_length)
+    }
+}
+
+# the hostname must be a valid RFC 1123 subdomain to be used as node name
+if($hostname -cnotmatch {{psQuote .HostnamePattern}}) {
+    throw "hostname '$hostname' is not a valid RFC 1123 subdomain"
+}
+return $hostname
+`
+
+// gcpHostnameScriptTemplate is the parsed gcpHostnameTemplate
+var gcpHostnameScriptTemplate = newScriptTemplate("gcp-get-hostname", gcpHostnameTemplate)
+
+// GCPHostnameSettings holds the optional settings of the generated GCP hostname script
+type GCPHostnameSettings struct {
+	// MetadataEndpoint is the http or https URL of the metadata service endpoint returning the hostname of the
+	// instance. If empty, DefaultGCPMetadataHostnameEndpoint is used.
+	MetadataEndpoint string
+	// Timeout is the timeout of a single request to the metadata service, rounded up to whole seconds. If zero, a
+	// default of 10 seconds is used.
+	Timeout time.Duration
+	// Attempts is the number of requests made to the metadata service before the script fails. If zero, a default of 5
+	// is used.
+	Attempts int
+	// RetryDelay is the delay between requests to the metadata service. If zero, a default of 2 seconds is used.
+	RetryDelay time.Duration
+}
+
+// gcpHostnameTemplateData holds the values the GCP hostname template is rendered with
+type gcpHostnameTemplateData struct {
+	MetadataEndpoint       string
+	TimeoutSeconds         int64
+	Attempts               int
+	RetryDelayMilliseconds int64
+	MaxLength              int
+	HashLength             int
+	HostnamePattern        string
+}
+
+// PopulateGCPHostnameScript creates the .ps1 file resolving the hostname of GCP instances at
+// GcpGetValidHostnameScriptPath, returning the FileInfo of the script and true if its contents changed
+func PopulateGCPHostnameScript(settings GCPHostnameSettings, opts ...Option) (*FileInfo, bool, error) {
+	return WriteGCPHostnameScript(GcpGetValidHostnameScriptPath, settings, opts...)
+}
+
+// WriteGCPHostnameScript creates the .ps1 file resolving the hostname of GCP instances at the given absolute path,
+// creating its parent directories as needed. The FileInfo of the written file, whose path is cleaned, is returned
+// along with true if its contents changed.
+func WriteGCPHostnameScript(dest string, settings GCPHostnameSettings, opts ...Option) (*FileInfo, bool, error) {
+	scriptContents, err := generateGCPHostnameScript(settings)
+	if err != nil {
+		return nil, false, err
+	}
+	return writeGeneratedScriptTo(dest, scriptContents, settings, opts...)
+}
+
+// generateGCPHostnameScript generates the contents of the .ps1 file resolving the hostname of GCP instances
+func generateGCPHostnameScript(settings GCPHostnameSettings) (string, error) {
+	data := gcpHostnameTemplateData{
+		MetadataEndpoint:       settings.MetadataEndpoint,
+		TimeoutSeconds:         int64((settings.Timeout + time.Second - 1) / time.Second),
+		Attempts:               settings.Attempts,
+		RetryDelayMilliseconds: settings.RetryDelay.Milliseconds(),
+		MaxLength:              maxGCPHostnameLength,
+		HashLength:             gcpHostnameHashLength,
+		HostnamePattern:        gcpHostnameRegex.String(),
+	}
+	if data.MetadataEndpoint == "" {
+		data.MetadataEndpoint = DefaultGCPMetadataHostnameEndpoint
+	}
+	if endpoint, err := url.Parse(data.MetadataEndpoint); err != nil || endpoint.Host == "" ||
+		(endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return "", fmt.Errorf("invalid GCP hostname configuration: metadata endpoint %q is not an http(s) URL",
+			data.MetadataEndpoint)
+	}
+	if settings.Timeout < 0 || settings.Attempts < 0 || settings.RetryDelay < 0 {
+		return "", fmt.Errorf("invalid GCP hostname configuration: Timeout, Attempts and RetryDelay must not be " +
+			"negative")
+	}
+	if settings.Timeout == 0 {
+		data.TimeoutSeconds = int64(defaultGCPMetadataTimeout / time.Second)
+	}
+	if settings.Attempts == 0 {
+		data.Attempts = defaultGCPMetadataAttempts
+	}
+	if settings.RetryDelay == 0 {
+		data.RetryDelayMilliseconds = defaultGCPMetadataRetryDelay.Milliseconds()
+	}
+	var script strings.Builder
+	if err := gcpHostnameScriptTemplate.Execute(&script, data); err != nil {
+		return "", fmt.Errorf("could not render GCP hostname script: %w", err)
+	}
+	return script.String(), nil
+}
+
+// GCPHostname returns the name a GCP instance with the given hostname, as returned by the metadata service, is
+// registered with, as resolved by the GCP hostname script. Hostnames longer than 63 characters are shortened to their
+// first label, or if that is too long as well, truncated and suffixed with a hash of the full hostname. An error is
+// returned if the result is not a valid RFC 1123 subdomain.
+func GCPHostname(hostname string) (string, error) {
+	fullHostname := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(hostname), "."))
+	name := fullHostname
+	if len(name) > maxGCPHostnameLength {
+		firstLabel, _, _ := strings.Cut(name, ".")
+		if len(firstLabel) <= maxGCPHostnameLength {
+			name = firstLabel
+		} else {
+			hash := fmt.Sprintf("%x", sha256.Sum256([]byte(fullHostname)))
+			prefix := strings.TrimRight(firstLabel[:maxGCPHostnameLength-gcpHostnameHashLength-1], "-")
+			name = prefix + "-" + hash[:gcpHostnameHashLength]
+		}
+	}
+	if !gcpHostnameRegex.MatchString(name) {
+		return "", fmt.Errorf("hostname %q is not a valid RFC 1123 subdomain", name)
+	}
+	return name, nil
+}
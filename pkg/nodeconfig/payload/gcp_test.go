@@ -0,0 +1,177 @@
+package payload
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCPHostname(t *testing.T) {
+	longLabel := strings.Repeat("a", 70)
+	longHostname := longLabel + ".c.openshift-gce-devel.internal"
+	longHash := fmt.Sprintf("%x", sha256.Sum256([]byte(longHostname)))[:8]
+
+	testCases := []struct {
+		name        string
+		hostname    string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "short hostname",
+			hostname: "winworker-abc.c.openshift-gce-devel.internal",
+			expected: "winworker-abc.c.openshift-gce-devel.internal",
+		},
+		{
+			name:     "hostname normalized",
+			hostname: " WinWorker-ABC.c.openshift-gce-devel.internal.\n",
+			expected: "winworker-abc.c.openshift-gce-devel.internal",
+		},
+		{
+			name:     "long hostname shortened to its first label",
+			hostname: "winworker-abc." + strings.Repeat("b", 60) + ".internal",
+			expected: "winworker-abc",
+		},
+		{
+			name:     "long first label truncated with hash suffix",
+			hostname: longHostname,
+			expected: strings.Repeat("a", 54) + "-" + longHash,
+		},
+		{
+			name:     "truncated label trailing dashes trimmed",
+			hostname: strings.Repeat("a", 52) + "--" + strings.Repeat("c", 20),
+			expected: strings.Repeat("a", 52) + "-" +
+				fmt.Sprintf("%x", sha256.Sum256([]byte(strings.Repeat("a", 52)+"--"+strings.Repeat("c", 20))))[:8],
+		},
+		{
+			name:        "invalid characters",
+			hostname:    "winworker_abc.internal",
+			expectedErr: true,
+		},
+		{
+			name:        "empty hostname",
+			hostname:    "",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			hostname, err := GCPHostname(test.hostname)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, hostname)
+			assert.LessOrEqual(t, len(hostname), maxGCPHostnameLength)
+		})
+	}
+}
+
+func TestGenerateGCPHostnameScript(t *testing.T) {
+	testCases := []struct {
+		name        string
+		settings    GCPHostnameSettings
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			name:     "defaults",
+			settings: GCPHostnameSettings{},
+			expected: []string{
+				"$metadata_endpoint='" + DefaultGCPMetadataHostnameEndpoint + "'",
+				"-TimeoutSec 10)",
+				"$attempt -le 5;",
+				"Start-Sleep -Milliseconds 2000",
+				"$max_length=63",
+				"$hash_length=8",
+			},
+		},
+		{
+			name: "custom settings",
+			settings: GCPHostnameSettings{
+				MetadataEndpoint: "https://169.254.169.254/computeMetadata/v1/instance/hostname",
+				Timeout:          2500 * time.Millisecond,
+				Attempts:         3,
+				RetryDelay:       500 * time.Millisecond,
+			},
+			expected: []string{
+				"$metadata_endpoint='https://169.254.169.254/computeMetadata/v1/instance/hostname'",
+				"-TimeoutSec 3)",
+				"$attempt -le 3;",
+				"Start-Sleep -Milliseconds 500",
+			},
+		},
+		{
+			name:        "endpoint without scheme",
+			settings:    GCPHostnameSettings{MetadataEndpoint: "metadata.google.internal/computeMetadata"},
+			expectedErr: true,
+		},
+		{
+			name:        "endpoint with unsupported scheme",
+			settings:    GCPHostnameSettings{MetadataEndpoint: "ftp://metadata.google.internal/hostname"},
+			expectedErr: true,
+		},
+		{
+			name:        "negative attempts",
+			settings:    GCPHostnameSettings{Attempts: -1},
+			expectedErr: true,
+		},
+		{
+			name:        "negative timeout",
+			settings:    GCPHostnameSettings{Timeout: -time.Second},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := generateGCPHostnameScript(test.settings)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for _, expected := range test.expected {
+				assert.Contains(t, script, expected)
+			}
+			assert.Contains(t, script, "$hostname -cnotmatch '"+gcpHostnameRegex.String()+"'")
+			assert.Contains(t, script, "@{'Metadata-Flavor'='Google'}")
+		})
+	}
+}
+
+func TestPopulateGCPHostnameScript(t *testing.T) {
+	generatedAt := time.Date(2023, 5, 4, 13, 27, 45, 0, time.UTC)
+	fsys := writableMapFS{fstest.MapFS{}}
+	opts := []Option{WithFS(fsys), WithOperatorVersion("9.0.0-abcdef"), withClock(generatedAt)}
+
+	fileInfo, changed, err := PopulateGCPHostnameScript(GCPHostnameSettings{}, opts...)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, GcpGetValidHostnameScriptPath, fileInfo.Path)
+	contents, err := fs.ReadFile(fsys, toFSPath(GcpGetValidHostnameScriptPath))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(contents)), fileInfo.Checksum())
+	assert.Contains(t, string(contents), "9.0.0-abcdef")
+
+	// regenerating the same script leaves it as is
+	unchanged, changed, err := PopulateGCPHostnameScript(GCPHostnameSettings{}, opts...)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, fileInfo.Checksum(), unchanged.Checksum())
+
+	updated, changed, err := PopulateGCPHostnameScript(GCPHostnameSettings{Attempts: 2}, opts...)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.NotEqual(t, fileInfo.Checksum(), updated.Checksum())
+
+	_, _, err = PopulateGCPHostnameScript(GCPHostnameSettings{MetadataEndpoint: "not a URL"}, opts...)
+	assert.Error(t, err)
+}
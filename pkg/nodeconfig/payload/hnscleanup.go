@@ -0,0 +1,114 @@
+package payload
+
+import (
+	"fmt"
+	"strings"
+)
+
+// vipEndpointName is the name of the HNS endpoint used as the kube-proxy source VIP, created by the kube-proxy
+// preparation script
+const vipEndpointName = "VIPEndpoint"
+
+// hnsCleanupTemplate is the template used to generate the script which removes the HNS endpoints left behind by a
+// previous configuration. The VIPEndpoint is removed whichever network it belongs to, as it may belong to a network
+// which was since recreated.
+const hnsCleanupTemplate = `# This script removes the HNS endpoints created for the Windows node
+{{- if .RemoveNetwork}} and the HNS network they belong to{{end}}
+{{- if .DryRun}}
+# Dry run: the HNS endpoints and networks which would be removed are only listed
+{{- end}}
+$ErrorActionPreference = "Stop"
+
+Import-Module -DisableNameChecking {{psQuote .HNSModulePath}}
+$hns_network_name={{psQuote .HNSNetworkName}}
+$vip_endpoint_name={{psQuote .VIPEndpointName}}
+
+$hns_network=Get-HnsNetwork | where { $_.Name -eq $hns_network_name }
+$hns_network_id=""
+if($hns_network -ne $null) {
+    $hns_network_id=[string]$hns_network.ID
+}
+
+# HNS does not return IDs in a consistent case
+$endpoints=@(Invoke-HNSRequest GET endpoints | where { ($_.Name -eq $vip_endpoint_name) -or
+    (($hns_network_id -ne "") -and ([string]$_.VirtualNetwork).Equals($hns_network_id,
+    [StringComparison]::OrdinalIgnoreCase)) })
+foreach($endpoint in $endpoints) {
+{{- if .DryRun}}
+    Write-Output "would remove HNS endpoint $($endpoint.Name) $($endpoint.ID)"
+{{- else}}
+    Write-Output "removing HNS endpoint $($endpoint.Name) $($endpoint.ID)"
+    Invoke-HNSRequest DELETE endpoints -Id $endpoint.ID | Out-Null
+{{- end}}
+}
+{{- if .RemoveNetwork}}
+
+if($hns_network -ne $null) {
+{{- if .DryRun}}
+    Write-Output "would remove HNS network $hns_network_name $hns_network_id"
+{{- else}}
+    Write-Output "removing HNS network $hns_network_name $hns_network_id"
+    $hns_network | Remove-HnsNetwork
+{{- end}}
+}
+{{- end}}
+`
+
+// hnsCleanupScriptTemplate is the parsed hnsCleanupTemplate
+var hnsCleanupScriptTemplate = newScriptTemplate("hns-cleanup", hnsCleanupTemplate)
+
+// HNSCleanupSettings holds the optional settings of the generated HNS cleanup script
+type HNSCleanupSettings struct {
+	// RemoveNetwork also removes the HNS network, which disconnects the instance from the network for a few seconds
+	RemoveNetwork bool
+	// DryRun generates a script which only lists the HNS endpoints and networks it would remove
+	DryRun bool
+}
+
+// hnsCleanupTemplateData holds the values substituted into hnsCleanupTemplate
+type hnsCleanupTemplateData struct {
+	HNSNetworkName  string
+	HNSModulePath   string
+	VIPEndpointName string
+	RemoveNetwork   bool
+	DryRun          bool
+}
+
+// PopulateHNSCleanupScript creates the .ps1 file removing the HNS endpoints created for Windows nodes at
+// HNSCleanupScript, returning the FileInfo of the script and true if its contents changed
+func PopulateHNSCleanupScript(hnsNetworkName, hnsPSModulePath string, settings HNSCleanupSettings,
+	opts ...Option) (*FileInfo, bool, error) {
+	return WriteHNSCleanupScript(HNSCleanupScript, hnsNetworkName, hnsPSModulePath, settings, opts...)
+}
+
+// WriteHNSCleanupScript creates the .ps1 file removing the HNS endpoints created for Windows nodes at the given
+// absolute path, creating its parent directories as needed. The FileInfo of the written file, whose path is cleaned,
+// is returned along with true if its contents changed.
+func WriteHNSCleanupScript(dest, hnsNetworkName, hnsPSModulePath string, settings HNSCleanupSettings,
+	opts ...Option) (*FileInfo, bool, error) {
+	scriptContents, err := generateHNSCleanupScript(hnsNetworkName, hnsPSModulePath, settings)
+	if err != nil {
+		return nil, false, err
+	}
+	inputs := []interface{}{hnsNetworkName, hnsPSModulePath, settings}
+	return writeGeneratedScriptTo(dest, scriptContents, inputs, opts...)
+}
+
+// generateHNSCleanupScript generates the contents of the .ps1 file removing the HNS endpoints created for Windows
+// nodes
+func generateHNSCleanupScript(hnsNetworkName, hnsPSModulePath string, settings HNSCleanupSettings) (string, error) {
+	if err := validateHNSInputs(hnsNetworkName, hnsPSModulePath); err != nil {
+		return "", fmt.Errorf("invalid HNS cleanup configuration: %w", err)
+	}
+	var script strings.Builder
+	if err := hnsCleanupScriptTemplate.Execute(&script, hnsCleanupTemplateData{
+		HNSNetworkName:  hnsNetworkName,
+		HNSModulePath:   hnsPSModulePath,
+		VIPEndpointName: vipEndpointName,
+		RemoveNetwork:   settings.RemoveNetwork,
+		DryRun:          settings.DryRun,
+	}); err != nil {
+		return "", fmt.Errorf("could not render HNS cleanup script: %w", err)
+	}
+	return script.String(), nil
+}
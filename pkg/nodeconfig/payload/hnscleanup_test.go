@@ -0,0 +1,131 @@
+package payload
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateHNSCleanupScript(t *testing.T) {
+	testCases := []struct {
+		name           string
+		hnsNetworkName string
+		hnsPSModule    string
+		settings       HNSCleanupSettings
+		expected       []string
+		notExpected    []string
+		expectedErr    bool
+	}{
+		{
+			name:           "endpoints only",
+			hnsNetworkName: "OVNKubernetesHybridOverlayNetwork",
+			hnsPSModule:    `C:\Temp\hns.psm1`,
+			expected: []string{
+				`Import-Module -DisableNameChecking 'C:\Temp\hns.psm1'`,
+				`$hns_network_name='OVNKubernetesHybridOverlayNetwork'`,
+				`$vip_endpoint_name='VIPEndpoint'`,
+				`Invoke-HNSRequest DELETE endpoints -Id $endpoint.ID | Out-Null`,
+			},
+			notExpected: []string{"Remove-HnsNetwork", "would remove"},
+		},
+		{
+			name:           "endpoints and network",
+			hnsNetworkName: "OVNKubernetesHybridOverlayNetwork",
+			hnsPSModule:    `C:\Temp\hns.psm1`,
+			settings:       HNSCleanupSettings{RemoveNetwork: true},
+			expected: []string{
+				`Invoke-HNSRequest DELETE endpoints -Id $endpoint.ID | Out-Null`,
+				`$hns_network | Remove-HnsNetwork`,
+			},
+			notExpected: []string{"would remove"},
+		},
+		{
+			name:           "dry run",
+			hnsNetworkName: "OVNKubernetesHybridOverlayNetwork",
+			hnsPSModule:    `C:\Temp\hns.psm1`,
+			settings:       HNSCleanupSettings{RemoveNetwork: true, DryRun: true},
+			expected: []string{
+				`Write-Output "would remove HNS endpoint $($endpoint.Name) $($endpoint.ID)"`,
+				`Write-Output "would remove HNS network $hns_network_name $hns_network_id"`,
+			},
+			notExpected: []string{"DELETE", "Remove-HnsNetwork", "removing"},
+		},
+		{
+			name:           "quotes escaped",
+			hnsNetworkName: "it's a network",
+			hnsPSModule:    `C:\Program Files\it's\hns.psm1`,
+			expected: []string{
+				`Import-Module -DisableNameChecking 'C:\Program Files\it''s\hns.psm1'`,
+				`$hns_network_name='it''s a network'`,
+			},
+		},
+		{
+			name:           "empty network name",
+			hnsPSModule:    `C:\Temp\hns.psm1`,
+			hnsNetworkName: " ",
+			expectedErr:    true,
+		},
+		{
+			name:           "network name with newline",
+			hnsNetworkName: "network\nRemove-Item C:\\",
+			hnsPSModule:    `C:\Temp\hns.psm1`,
+			expectedErr:    true,
+		},
+		{
+			name:           "relative module path",
+			hnsNetworkName: "OVNKubernetesHybridOverlayNetwork",
+			hnsPSModule:    "hns.psm1",
+			expectedErr:    true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			script, err := generateHNSCleanupScript(test.hnsNetworkName, test.hnsPSModule, test.settings)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for _, expected := range test.expected {
+				assert.Contains(t, script, expected)
+			}
+			for _, notExpected := range test.notExpected {
+				assert.NotContains(t, script, notExpected)
+			}
+			if test.settings.DryRun {
+				// a dry run only lists what would be removed
+				for _, line := range strings.Split(script, "\n") {
+					assert.NotRegexp(t, `(?i)(Remove-|DELETE)`, line)
+				}
+			}
+		})
+	}
+}
+
+func TestPopulateHNSCleanupScript(t *testing.T) {
+	fsys := writableMapFS{fstest.MapFS{}}
+	opts := []Option{WithFS(fsys), withClock(time.Date(2023, 5, 4, 13, 27, 45, 0, time.UTC))}
+	fileInfo, changed, err := PopulateHNSCleanupScript("OVNKubernetesHybridOverlayNetwork", `C:\Temp\hns.psm1`,
+		HNSCleanupSettings{}, opts...)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, HNSCleanupScript, fileInfo.Path)
+	contents, err := fs.ReadFile(fsys, toFSPath(HNSCleanupScript))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(contents), "# Generated by the Windows Machine Config Operator"))
+
+	// regenerating the same script leaves it as is, while a dry run changes it
+	_, changed, err = PopulateHNSCleanupScript("OVNKubernetesHybridOverlayNetwork", `C:\Temp\hns.psm1`,
+		HNSCleanupSettings{}, opts...)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	_, changed, err = PopulateHNSCleanupScript("OVNKubernetesHybridOverlayNetwork", `C:\Temp\hns.psm1`,
+		HNSCleanupSettings{DryRun: true}, opts...)
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
@@ -0,0 +1,74 @@
+package payload
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultHybridOverlayVXLANPort is the UDP port hybrid-overlay uses for VXLAN traffic when none is configured
+const DefaultHybridOverlayVXLANPort = 4789
+
+// HybridOverlayArgs holds the settings of the hybrid-overlay Windows service
+type HybridOverlayArgs struct {
+	// NodeName is the name of the node, or the name of a node variable substituted with it on the node
+	NodeName string
+	// KubeconfigPath is the path on Windows nodes of the bootstrap kubeconfig
+	KubeconfigPath string
+	// CertDir is the directory on Windows nodes the client certificates are written to
+	CertDir string
+	// LogFile is the path of the log file on Windows nodes. It must be within RemoteLogDir.
+	LogFile string
+	// VXLANPort is the UDP port used for VXLAN traffic, which must match the hybrid overlay VXLAN port of the cluster
+	// network. If zero, the port is not passed and DefaultHybridOverlayVXLANPort is used.
+	VXLANPort uint32
+	// ExtraArgs are appended to the command line as is
+	ExtraArgs []string
+}
+
+// Command returns the command line of the hybrid-overlay Windows service with the given settings, after validating
+// them
+func (h HybridOverlayArgs) Command() (string, error) {
+	if h.NodeName == "" || strings.ContainsAny(h.NodeName, " \t\"") {
+		return "", fmt.Errorf("hybrid-overlay node name %q must be non-empty, without whitespace or quotes",
+			h.NodeName)
+	}
+	if !windowsAbsPathRegex.MatchString(h.KubeconfigPath) {
+		return "", fmt.Errorf("hybrid-overlay kubeconfig path %q is not an absolute Windows path", h.KubeconfigPath)
+	}
+	if !windowsAbsPathRegex.MatchString(h.CertDir) {
+		return "", fmt.Errorf("hybrid-overlay certificate directory %q is not an absolute Windows path", h.CertDir)
+	}
+	if err := validateLogFile(h.LogFile); err != nil {
+		return "", fmt.Errorf("invalid hybrid-overlay settings: %w", err)
+	}
+	if h.VXLANPort > 65535 {
+		return "", fmt.Errorf("hybrid-overlay VXLAN port %d is not within 1-65535", h.VXLANPort)
+	}
+	cmd := []string{
+		RemoteK8sDir + "\\" + path.Base(HybridOverlayPath),
+		"--node", h.NodeName,
+		QuoteWindowsArg("--bootstrap-kubeconfig=" + h.KubeconfigPath),
+		QuoteWindowsArg("--cert-dir=" + h.CertDir),
+		"--cert-duration=24h",
+		"--windows-service",
+		"--logfile", QuoteWindowsArg(h.LogFile),
+	}
+	if h.VXLANPort != 0 {
+		cmd = append(cmd, "--hybrid-overlay-vxlan-port", strconv.FormatUint(uint64(h.VXLANPort), 10))
+	}
+	return strings.Join(append(cmd, h.ExtraArgs...), " "), nil
+}
+
+// ParseVXLANPort parses the given hybrid overlay VXLAN port of the cluster network, returning zero if it is empty
+func ParseVXLANPort(port string) (uint32, error) {
+	if port == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || parsed == 0 {
+		return 0, fmt.Errorf("VXLAN port %q is not within 1-65535", port)
+	}
+	return uint32(parsed), nil
+}
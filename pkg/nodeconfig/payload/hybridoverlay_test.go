@@ -0,0 +1,106 @@
+package payload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHybridOverlayArgsCommand(t *testing.T) {
+	base := HybridOverlayArgs{NodeName: "NODE_NAME", KubeconfigPath: `C:\k\kubeconfig`, CertDir: `C:\k\cni\config`,
+		LogFile: `C:\var\log\hybrid-overlay\hybrid-overlay.log`}
+	baseCmd := `C:\k\hybrid-overlay-node.exe --node NODE_NAME --bootstrap-kubeconfig=C:\k\kubeconfig ` +
+		`--cert-dir=C:\k\cni\config --cert-duration=24h --windows-service ` +
+		`--logfile C:\var\log\hybrid-overlay\hybrid-overlay.log`
+	testCases := []struct {
+		name        string
+		modify      func(*HybridOverlayArgs)
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "default port",
+			modify:   func(*HybridOverlayArgs) {},
+			expected: baseCmd,
+		},
+		{
+			name:     "overridden port",
+			modify:   func(h *HybridOverlayArgs) { h.VXLANPort = 9789 },
+			expected: baseCmd + " --hybrid-overlay-vxlan-port 9789",
+		},
+		{
+			name:     "default port given explicitly",
+			modify:   func(h *HybridOverlayArgs) { h.VXLANPort = DefaultHybridOverlayVXLANPort },
+			expected: baseCmd + " --hybrid-overlay-vxlan-port 4789",
+		},
+		{
+			name: "overridden port with extra args",
+			modify: func(h *HybridOverlayArgs) {
+				h.VXLANPort = 65535
+				h.ExtraArgs = []string{"--loglevel", "5"}
+			},
+			expected: baseCmd + " --hybrid-overlay-vxlan-port 65535 --loglevel 5",
+		},
+		{
+			name:        "port out of range",
+			modify:      func(h *HybridOverlayArgs) { h.VXLANPort = 65536 },
+			expectedErr: true,
+		},
+		{
+			name:        "empty node name",
+			modify:      func(h *HybridOverlayArgs) { h.NodeName = "" },
+			expectedErr: true,
+		},
+		{
+			name:        "relative kubeconfig path",
+			modify:      func(h *HybridOverlayArgs) { h.KubeconfigPath = "kubeconfig" },
+			expectedErr: true,
+		},
+		{
+			name:        "log file outside of the log directory",
+			modify:      func(h *HybridOverlayArgs) { h.LogFile = `C:\k\hybrid-overlay.log` },
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			args := base
+			test.modify(&args)
+			cmd, err := args.Command()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, cmd)
+		})
+	}
+}
+
+func TestParseVXLANPort(t *testing.T) {
+	testCases := []struct {
+		name        string
+		port        string
+		expected    uint32
+		expectedErr bool
+	}{
+		{name: "unset", port: "", expected: 0},
+		{name: "default", port: "4789", expected: DefaultHybridOverlayVXLANPort},
+		{name: "overridden", port: "9789", expected: 9789},
+		{name: "zero", port: "0", expectedErr: true},
+		{name: "out of range", port: "65536", expectedErr: true},
+		{name: "not a number", port: "vxlan", expectedErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			port, err := ParseVXLANPort(test.port)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, port)
+		})
+	}
+}
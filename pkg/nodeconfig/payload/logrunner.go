@@ -0,0 +1,109 @@
+package payload
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// LogRunnerArgs holds the kube-log-runner settings of a service whose output is written to a log file
+type LogRunnerArgs struct {
+	// LogFile is the path of the log file on Windows nodes. It must be within RemoteLogDir, in which the log
+	// directories of the services are created during node configuration.
+	LogFile string
+	// FlushInterval is the interval the log file is flushed at. If zero, the kube-log-runner default is used.
+	FlushInterval time.Duration
+	// RotationSize is the size in bytes past which the log file is rotated. If zero, the log file is never rotated.
+	RotationSize int64
+	// MaxAge is the age past which rotated log files are removed. It can only be set along with RotationSize. If zero,
+	// rotated log files are kept.
+	MaxAge time.Duration
+}
+
+// BuildLogRunnerCommand returns the command line running the binary at the given path on Windows nodes with the given
+// args, wrapped with kube-log-runner writing its output to a log file with the given settings. The binary and log file
+// paths are quoted as needed, while the args are used as is.
+func BuildLogRunnerCommand(binaryPath string, args []string, cfg LogRunnerArgs) (string, error) {
+	if !windowsAbsPathRegex.MatchString(binaryPath) {
+		return "", fmt.Errorf("binary path %q is not an absolute Windows path", binaryPath)
+	}
+	if err := cfg.validate(); err != nil {
+		return "", fmt.Errorf("invalid kube-log-runner settings: %w", err)
+	}
+	cmd := []string{RemoteK8sDir + "\\" + path.Base(KubeLogRunnerPath), QuoteWindowsArg("-log-file=" + cfg.LogFile)}
+	if cfg.FlushInterval != 0 {
+		cmd = append(cmd, "-flush-interval="+cfg.FlushInterval.String())
+	}
+	if cfg.RotationSize != 0 {
+		cmd = append(cmd, "-log-file-size="+resource.NewQuantity(cfg.RotationSize, resource.BinarySI).String())
+	}
+	if cfg.MaxAge != 0 {
+		cmd = append(cmd, "-log-file-age="+cfg.MaxAge.String())
+	}
+	cmd = append(cmd, QuoteWindowsArg(binaryPath))
+	return strings.Join(append(cmd, args...), " "), nil
+}
+
+// validate ensures the log file is within RemoteLogDir, and the durations and rotation size are valid
+func (cfg LogRunnerArgs) validate() error {
+	if err := validateLogFile(cfg.LogFile); err != nil {
+		return err
+	}
+	if cfg.FlushInterval < 0 {
+		return fmt.Errorf("flush interval %s is negative", cfg.FlushInterval)
+	}
+	if cfg.RotationSize < 0 {
+		return fmt.Errorf("rotation size %d is negative", cfg.RotationSize)
+	}
+	if cfg.MaxAge < 0 {
+		return fmt.Errorf("max age %s is negative", cfg.MaxAge)
+	}
+	if cfg.MaxAge != 0 && cfg.RotationSize == 0 {
+		return fmt.Errorf("max age requires a non-zero rotation size, as log files are only rotated by size")
+	}
+	return nil
+}
+
+// validateLogFile ensures the given log file is an absolute Windows path within RemoteLogDir
+func validateLogFile(logFile string) error {
+	if !windowsAbsPathRegex.MatchString(logFile) {
+		return fmt.Errorf("log file %q is not an absolute Windows path", logFile)
+	}
+	if !strings.HasPrefix(strings.ToLower(logFile), strings.ToLower(RemoteLogDir)+"\\") ||
+		strings.HasSuffix(logFile, "\\") || strings.Contains(logFile, "\\..") {
+		return fmt.Errorf("log file %q is not within %s", logFile, RemoteLogDir)
+	}
+	return nil
+}
+
+// QuoteWindowsArg returns the given command line argument quoted if it contains whitespace or quotes, following the
+// rules Windows programs parse their command line with, as syscall.EscapeArg does on Windows. Backslashes are only
+// escaped when followed by a quote.
+func QuoteWindowsArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"") {
+		return arg
+	}
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	backslashes := 0
+	for i := 0; i < len(arg); i++ {
+		switch arg[i] {
+		case '\\':
+			backslashes++
+		case '"':
+			// the backslashes preceding a quote, and the quote itself, are escaped
+			quoted.WriteString(strings.Repeat("\\", backslashes+1))
+			backslashes = 0
+		default:
+			backslashes = 0
+		}
+		quoted.WriteByte(arg[i])
+	}
+	// the trailing backslashes would otherwise escape the closing quote
+	quoted.WriteString(strings.Repeat("\\", backslashes))
+	quoted.WriteByte('"')
+	return quoted.String()
+}
@@ -0,0 +1,118 @@
+package payload
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildLogRunnerCommand(t *testing.T) {
+	testCases := []struct {
+		name        string
+		binaryPath  string
+		args        []string
+		cfg         LogRunnerArgs
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:       "kubelet",
+			binaryPath: `C:\k\kubelet.exe`,
+			args:       []string{"--config=c:\\k\\kubelet.conf", "--windows-service"},
+			cfg:        LogRunnerArgs{LogFile: `C:\var\log\kubelet\kubelet.log`},
+			expected: `C:\k\kube-log-runner.exe -log-file=C:\var\log\kubelet\kubelet.log C:\k\kubelet.exe ` +
+				`--config=c:\k\kubelet.conf --windows-service`,
+		},
+		{
+			name:       "kube-proxy without args",
+			binaryPath: `C:\k\kube-proxy.exe`,
+			cfg:        LogRunnerArgs{LogFile: `C:\var\log\kube-proxy\kube-proxy.log`},
+			expected:   `C:\k\kube-log-runner.exe -log-file=C:\var\log\kube-proxy\kube-proxy.log C:\k\kube-proxy.exe`,
+		},
+		{
+			name:       "rotation",
+			binaryPath: `C:\k\kubelet.exe`,
+			cfg: LogRunnerArgs{LogFile: `C:\var\log\kubelet\kubelet.log`, FlushInterval: 5 * time.Second,
+				RotationSize: 100 * 1024 * 1024, MaxAge: 7 * 24 * time.Hour},
+			expected: `C:\k\kube-log-runner.exe -log-file=C:\var\log\kubelet\kubelet.log -flush-interval=5s ` +
+				`-log-file-size=100Mi -log-file-age=168h0m0s C:\k\kubelet.exe`,
+		},
+		{
+			name:       "paths with spaces",
+			binaryPath: `C:\Program Files\agent\agent.exe`,
+			cfg:        LogRunnerArgs{LogFile: `C:\var\log\my agent\agent.log`},
+			expected: `C:\k\kube-log-runner.exe "-log-file=C:\var\log\my agent\agent.log" ` +
+				`"C:\Program Files\agent\agent.exe"`,
+		},
+		{
+			name:        "log file outside of the log directory",
+			binaryPath:  `C:\k\kubelet.exe`,
+			cfg:         LogRunnerArgs{LogFile: `C:\k\kubelet.log`},
+			expectedErr: true,
+		},
+		{
+			name:        "log file escaping the log directory",
+			binaryPath:  `C:\k\kubelet.exe`,
+			cfg:         LogRunnerArgs{LogFile: `C:\var\log\..\kubelet.log`},
+			expectedErr: true,
+		},
+		{
+			name:        "missing log file",
+			binaryPath:  `C:\k\kubelet.exe`,
+			expectedErr: true,
+		},
+		{
+			name:        "relative binary path",
+			binaryPath:  "kubelet.exe",
+			cfg:         LogRunnerArgs{LogFile: `C:\var\log\kubelet\kubelet.log`},
+			expectedErr: true,
+		},
+		{
+			name:        "max age without rotation size",
+			binaryPath:  `C:\k\kubelet.exe`,
+			cfg:         LogRunnerArgs{LogFile: `C:\var\log\kubelet\kubelet.log`, MaxAge: time.Hour},
+			expectedErr: true,
+		},
+		{
+			name:        "negative rotation size",
+			binaryPath:  `C:\k\kubelet.exe`,
+			cfg:         LogRunnerArgs{LogFile: `C:\var\log\kubelet\kubelet.log`, RotationSize: -1},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := BuildLogRunnerCommand(test.binaryPath, test.args, test.cfg)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, cmd)
+		})
+	}
+}
+
+func TestQuoteWindowsArg(t *testing.T) {
+	testCases := []struct {
+		arg      string
+		expected string
+	}{
+		{arg: `C:\k\kubelet.exe`, expected: `C:\k\kubelet.exe`},
+		{arg: `C:\Program Files\a.exe`, expected: `"C:\Program Files\a.exe"`},
+		{arg: `C:\a b\`, expected: `"C:\a b\\"`},
+		{arg: `say "hi"`, expected: `"say \"hi\""`},
+		{arg: `a\"b`, expected: `"a\\\"b"`},
+		{arg: "", expected: `""`},
+		{arg: "--hostname-override=$env:FOO", expected: "--hostname-override=$env:FOO"},
+		{arg: "--hostname-override=nœud ノード", expected: `"--hostname-override=nœud ノード"`},
+		{arg: "a\tb", expected: "\"a\tb\""},
+	}
+	for _, test := range testCases {
+		t.Run(test.arg, func(t *testing.T) {
+			assert.Equal(t, test.expected, QuoteWindowsArg(test.arg))
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ManifestPath is the path of the manifest holding the checksum of every payload file, written at image build time
+var ManifestPath = payloadPath("payload-manifest.json")
+
+const (
+	// RequireManifestEnvVar is the environment variable which, when set to true, makes a missing manifest an error.
+	// Developer builds may not include a manifest, so it is optional by default.
+	RequireManifestEnvVar = "WMCO_REQUIRE_MANIFEST"
+)
+
+// ErrManifestNotFound is returned by VerifyManifest when the payload does not include a manifest, and one is not
+// required
+var ErrManifestNotFound = errors.New("payload manifest not found")
+
+// Manifest describes the expected contents of every payload file shipped in the operator image
+type Manifest struct {
+	Files []*FileInfo `json:"files"`
+}
+
+// GenerateManifest returns a manifest describing the current contents of all payload files shipped in the image.
+// Generated files are excluded, as they are created by the operator at runtime.
+func GenerateManifest(opts ...Option) (*Manifest, error) {
+	fileInfos, err := NewFileInfos(shippedFiles(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	for _, path := range shippedFiles() {
+		manifest.Files = append(manifest.Files, fileInfos[path])
+	}
+	return manifest, nil
+}
+
+// VerifyManifest re-hashes every payload file and compares it against the manifest at ManifestPath. The returned error
+// lists every file which does not match the manifest. If the manifest does not exist, ErrManifestNotFound is returned,
+// unless RequireManifestEnvVar is set to true.
+func VerifyManifest(opts ...Option) error {
+	o := newOptions(opts)
+	data, err := fs.ReadFile(o.fsys, toFSPath(ManifestPath))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("could not read payload manifest: %w", withPath(err, ManifestPath))
+		}
+		if required, _ := strconv.ParseBool(os.Getenv(RequireManifestEnvVar)); required {
+			return fmt.Errorf("payload manifest %s is required by %s but does not exist", ManifestPath,
+				RequireManifestEnvVar)
+		}
+		return ErrManifestNotFound
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return fmt.Errorf("could not parse payload manifest: %w", err)
+	}
+
+	expected := make(map[string]*FileInfo, len(manifest.Files))
+	for _, file := range manifest.Files {
+		expected[file.Path] = file
+	}
+	var mismatches []string
+	for _, path := range shippedFiles() {
+		if _, present := expected[path]; !present {
+			mismatches = append(mismatches, fmt.Sprintf("%s is not listed in the manifest", path))
+		}
+	}
+	for _, file := range manifest.Files {
+		current, err := NewFileInfoWithAlgorithm(file.Path, file.algorithm(), opts...)
+		if err != nil {
+			mismatches = append(mismatches, err.Error())
+			continue
+		}
+		if current.Checksum() != file.Checksum() {
+			mismatches = append(mismatches, fmt.Sprintf("%s has %s %s, expected %s", file.Path, file.algorithm(),
+				current.Checksum(), file.Checksum()))
+		}
+	}
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("payload does not match manifest: %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// shippedFiles returns the sorted paths of all payload files shipped in the operator image, excluding files generated
+// by the operator at runtime
+func shippedFiles() []string {
+	var files []string
+	for category, categoryFiles := range FilesByCategory() {
+		if category != CategoryGenerated {
+			files = append(files, categoryFiles...)
+		}
+	}
+	sort.Strings(files)
+	return files
+}
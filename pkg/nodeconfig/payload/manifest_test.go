@@ -0,0 +1,116 @@
+package payload
+
+import (
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPayloadFS returns a file system holding every payload file shipped in the image, and a manifest describing
+// them
+func newTestPayloadFS(t *testing.T) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, path := range shippedFiles() {
+		fsys[toFSPath(path)] = &fstest.MapFile{Data: []byte(path)}
+	}
+	manifest, err := GenerateManifest(WithFS(fsys))
+	require.NoError(t, err)
+	data, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	fsys[toFSPath(ManifestPath)] = &fstest.MapFile{Data: data}
+	return fsys
+}
+
+func TestGenerateManifest(t *testing.T) {
+	manifest, err := GenerateManifest(WithFS(newTestPayloadFS(t)))
+	require.NoError(t, err)
+	var paths []string
+	for _, file := range manifest.Files {
+		paths = append(paths, file.Path)
+	}
+	assert.Equal(t, shippedFiles(), paths)
+	assert.NotContains(t, paths, CNIConfigurationScript)
+	assert.NotContains(t, paths, KubeProxyPrepScript)
+
+	_, err = GenerateManifest(WithFS(fstest.MapFS{}))
+	assert.Error(t, err)
+}
+
+func TestVerifyManifest(t *testing.T) {
+	testCases := []struct {
+		name             string
+		modify           func(fstest.MapFS)
+		requireManifest  string
+		expectedNotFound bool
+		expectedErrors   []string
+	}{
+		{
+			name:   "payload matches manifest",
+			modify: func(fstest.MapFS) {},
+		},
+		{
+			name: "modified and missing files",
+			modify: func(fsys fstest.MapFS) {
+				fsys[toFSPath(KubeletPath)] = &fstest.MapFile{Data: []byte("corrupted")}
+				delete(fsys, toFSPath(KubeProxyPath))
+			},
+			expectedErrors: []string{
+				KubeletPath + " has sha256 ",
+				"open " + KubeProxyPath + ": file does not exist",
+			},
+		},
+		{
+			name: "file not listed in manifest",
+			modify: func(fsys fstest.MapFS) {
+				fsys[toFSPath(ManifestPath)] = &fstest.MapFile{Data: []byte(`{"files":[]}`)}
+			},
+			expectedErrors: []string{KubeletPath + " is not listed in the manifest"},
+		},
+		{
+			name: "invalid manifest",
+			modify: func(fsys fstest.MapFS) {
+				fsys[toFSPath(ManifestPath)] = &fstest.MapFile{Data: []byte("{")}
+			},
+			expectedErrors: []string{"could not parse payload manifest"},
+		},
+		{
+			name: "missing optional manifest",
+			modify: func(fsys fstest.MapFS) {
+				delete(fsys, toFSPath(ManifestPath))
+			},
+			expectedNotFound: true,
+		},
+		{
+			name: "missing required manifest",
+			modify: func(fsys fstest.MapFS) {
+				delete(fsys, toFSPath(ManifestPath))
+			},
+			requireManifest: "true",
+			expectedErrors:  []string{"is required by " + RequireManifestEnvVar},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(RequireManifestEnvVar, test.requireManifest)
+			fsys := newTestPayloadFS(t)
+			test.modify(fsys)
+			err := VerifyManifest(WithFS(fsys))
+			if test.expectedNotFound {
+				assert.ErrorIs(t, err, ErrManifestNotFound)
+				return
+			}
+			if len(test.expectedErrors) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.NotErrorIs(t, err, ErrManifestNotFound)
+			for _, expected := range test.expectedErrors {
+				assert.Contains(t, err.Error(), expected)
+			}
+		})
+	}
+}
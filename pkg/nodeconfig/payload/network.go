@@ -0,0 +1,1280 @@
+package payload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// networkScriptParams is the parameter block of every network script, which must precede any other statement.
+	// The log directory defaults to the one the script was generated with.
+	networkScriptParams = `param(
+    # LogDir is the directory the script logs to, in addition to stdout. If empty, the script only logs to stdout.
+    [string]$LogDir={{psQuote .LogDir}}
+{{- if .Idempotent}},
+    # Force runs the script even if it already succeeded with the same contents and HNS network
+    [switch]$Force
+{{- end}}
+)
+`
+	// networkScriptFunctions defines the PowerShell functions every network script logs and reports its result with.
+	// The result is written to stdout as a single line JSON object, to be parsed by ParseNetworkScriptResult, and the
+	// script exits with the given code.
+	networkScriptFunctions = `# Write-Log writes a timestamped message to stdout, and to the log file if LogDir is set.
+# The log file is truncated once it grows past its maximum size. Failing to write it does not fail the script.
+function Write-Log($message) {
+    $line = "$((Get-Date).ToUniversalTime().ToString('o')) [{{.ScriptName}}] $message"
+    [Console]::Out.WriteLine($line)
+    if(-not $LogDir) {
+        return
+    }
+    try {
+        $log_file = Join-Path $LogDir {{psQuote .LogFile}}
+        if((Test-Path -LiteralPath $log_file) -and ((Get-Item -LiteralPath $log_file).Length -gt {{.MaxLogFileSize}})) {
+            Clear-Content -LiteralPath $log_file
+        }
+        Add-Content -LiteralPath $log_file -Value $line
+    } catch {
+        # the log file is best effort
+    }
+}
+
+# Write-Result writes the result of the script to stdout as JSON, and exits with the given code
+function Write-Result($failedStep, $message, $sourceVip, $exitCode) {
+    $status = "{{.Statuses.Succeeded}}"
+    if($exitCode -ne 0) {
+        $status = "{{.Statuses.Failed}}"
+        Write-Log "failed at step ${failedStep}: $message"
+    } else {
+        Write-Log "succeeded"
+{{- if .Idempotent}}
+        if($marker_path -and -not $marker_current) {
+            try {
+                $marker = [ordered]@{hash=$script_hash; hnsNetworkId=[string]$hns_network.ID; sourceVip=$sourceVip;
+                    networkState=$network_state; outputHash=$marker_output_hash}
+                Set-Content -LiteralPath $marker_path -Value ($marker | ConvertTo-Json -Compress)
+            } catch {
+                Write-Log "could not write marker ${marker_path}: $_"
+            }
+        }
+{{- end}}
+    }
+    $result = [ordered]@{status=$status; failedStep=$failedStep; message=$message; sourceVip=$sourceVip}
+    [Console]::Out.WriteLine(($result | ConvertTo-Json -Compress))
+    exit $exitCode
+}
+`
+	// networkScriptPrologue is the start of the CNI configuration and kube-proxy preparation scripts. It defines the
+	// network script functions, and gets the HNS network.
+	networkScriptPrologue = networkScriptFunctions + `
+$hns_network_name={{psQuote .HNSNetworkName}}
+Write-Log "getting HNS network $hns_network_name"
+try {
+    Import-Module -DisableNameChecking {{psQuote .HNSModulePath}}
+    $hns_networks=@(Get-HnsNetwork)
+} catch {
+    Write-Result "{{.Steps.GetHNSNetwork}}" "could not get HNS network: $_" "" {{.ExitCodes.HNSNetworkNotFound}}
+}
+$hns_network=$hns_networks | where { $_.Name -eq $hns_network_name}
+{{- if not .StrictNameMatch}}
+# The network may not have been renamed yet, in which case the single network of the expected type is used. Its
+# subnet and management IP are unchanged by the rename.
+$hns_network_type={{psQuote .HNSNetworkType}}
+if($hns_network -eq $null) {
+    $candidates=@($hns_networks | where { $_.Type -eq $hns_network_type })
+    if($candidates.Count -ne 1) {
+        Write-Result "{{.Steps.GetHNSNetwork}}" "HNS network $hns_network_name not found, and there are ` +
+		`$($candidates.Count) $hns_network_type networks to use instead" "" {{.ExitCodes.HNSNetworkNotFound}}
+    }
+    $hns_network=$candidates[0]
+    Write-Log "WARNING: HNS network $hns_network_name not found, using $hns_network_type network $($hns_network.Name)"
+}
+{{- end}}
+if($hns_network -eq $null) {
+    Write-Result "{{.Steps.GetHNSNetwork}}" "HNS network $hns_network_name not found" "" ` +
+		`{{.ExitCodes.HNSNetworkNotFound}}
+}
+{{- if .Idempotent}}
+
+# The rest of the script is skipped if it already succeeded with the same contents and HNS network, and its outputs
+# are unchanged, unless -Force is given. The marker next to the script records the result of its last successful run.
+$script_hash='{{.ScriptHash}}'
+$marker_path=""
+$marker_current=$false
+$marker_output_hash=""
+# the subnets and management IP the outputs are derived from, which can change without the network being recreated.
+# The management IP is only used as the provider address of overlay networks.
+$network_state="$(@($hns_network.Subnets.AddressPrefix) -join ',')` +
+		`{{if and .Overlay (not .ProviderAddressOverride)}}|$($hns_network.ManagementIP){{end}}"
+
+# Get-ContentHash returns the hex encoded SHA256 hash of the given string
+function Get-ContentHash($value) {
+    $sha=[System.Security.Cryptography.SHA256]::Create()
+    return [BitConverter]::ToString($sha.ComputeHash([System.Text.Encoding]::UTF8.GetBytes($value))).Replace("-","")
+}
+
+if($PSCommandPath) {
+    $marker_path="$PSCommandPath.marker"
+}
+if($marker_path -and -not $Force -and (Test-Path -LiteralPath $marker_path)) {
+    try {
+        $marker=Get-Content -LiteralPath $marker_path -Raw | ConvertFrom-Json
+        $marker_network_id=[string]$marker.hnsNetworkId
+        $marker_current=($marker.hash -eq $script_hash) -and ($marker.networkState -eq $network_state) -and ` +
+		`$marker_network_id.Equals([string]$hns_network.ID, [StringComparison]::OrdinalIgnoreCase)
+    } catch {
+        Write-Log "ignoring unreadable marker ${marker_path}: $_"
+    }
+}
+if($marker_current) {
+{{- template "markerOutputsCheck" .}}
+}
+if($marker_current) {
+    Write-Log "already succeeded with HNS network $($hns_network.ID), skipping"
+    Write-Result "" "" ([string]$marker.sourceVip) 0
+}
+{{- end}}
+`
+	// cniConfTemplate is the template used to generate the script which renders the CNI configuration
+	cniConfTemplate = `# This script ensures the contents of the CNI config file is correct
+` + networkScriptParams + `$ErrorActionPreference = "Stop"
+
+` + networkScriptPrologue + `
+$cni_template=@'
+{{if .ConfList -}}
+{
+    "cniVersion":"{{.CNIVersion}}",
+    "name":{{jsonString .HNSNetworkName}},
+    "plugins":[
+    {{"{"}}{{template "networkPlugin" .}}
+    }{{range .ChainedPlugins}},
+    {{.}}{{end}}
+    ]
+}
+{{- else -}}
+{
+    "cniVersion":"{{.CNIVersion}}",
+    "name":{{jsonString .HNSNetworkName}},{{template "networkPlugin" .}}
+}
+{{- end}}
+'@
+
+# Select the host subnet of the node. The HNS network can briefly have multiple subnets while the node's subnet
+# changes, such as during the migration to OVN-Kubernetes interconnect. Only IPv4 subnets are configured.
+$subnets=@($hns_network.Subnets.AddressPrefix | where { $_ -and $_ -notmatch ":" })
+{{- if .HostSubnet}}
+$subnet={{psQuote .HostSubnet}}
+if($subnets -notcontains $subnet) {
+    Write-Result "{{.Steps.CheckHNSSubnet}}" "HNS network $($hns_network.Name) does not have host subnet $subnet, ` +
+		`its subnets are: $($subnets -join ', ')" "" {{.ExitCodes.HNSSubnetMissing}}
+}
+{{- else}}
+if($subnets.Count -ne 1) {
+    Write-Result "{{.Steps.CheckHNSSubnet}}" "HNS network $($hns_network.Name) has $($subnets.Count) subnets ` +
+		`($($subnets -join ', ')), expected exactly one unless the node host subnet is given" "" ` +
+		`{{.ExitCodes.HNSSubnetMissing}}
+}
+$subnet=$subnets[0]
+{{- end}}
+
+# Generate CNI Config
+$cni_template=$cni_template.Replace("ovn_host_subnet",$subnet)
+{{- if .Overlay}}
+{{if .ProviderAddressOverride -}}
+$provider_address={{psQuote .ProviderAddressOverride}}
+{{- else -}}
+$provider_address=$hns_network.ManagementIP
+{{- end}}
+$cni_template=$cni_template.Replace("provider_address",$provider_address)
+{{- end}}
+
+# Compare CNI config with existing file, and replace if necessary
+$cni_config_path={{psQuote .CNIConfigPath}}
+try {
+    $existing_config=""
+    if(Test-Path -LiteralPath $cni_config_path) {
+        $config_file_content=(Get-Content -LiteralPath $cni_config_path -Raw)
+        if($config_file_content -ne $null) {
+` + "            $existing_config=$config_file_content.Replace(\"`r\",\"\")" + `
+        }
+    }
+    if($existing_config -ne $cni_template){
+        Write-Log "writing CNI config to $cni_config_path"
+        Set-Content -LiteralPath $cni_config_path -Value $cni_template -NoNewline
+    } else {
+        Write-Log "CNI config $cni_config_path is up to date"
+    }
+} catch {
+    Write-Result "{{.Steps.WriteCNIConfig}}" "could not write CNI config: $_" "" {{.ExitCodes.CNIConfigWriteFailed}}
+}
+{{- if .Idempotent}}
+$marker_output_hash=Get-ContentHash $cni_template
+{{- end}}
+
+Write-Result "" "" "" 0
+` + networkPluginTemplate + `{{define "markerOutputsCheck"}}
+    # The CNI config must still be the one written by the last successful run
+    $cni_config_path={{psQuote .CNIConfigPath}}
+    $existing_config=$null
+    if(Test-Path -LiteralPath $cni_config_path) {
+        $existing_config=Get-Content -LiteralPath $cni_config_path -Raw
+    }
+` + "    if(($existing_config -eq $null) -or ((Get-ContentHash $existing_config.Replace(\"`r\",\"\")) -ne " +
+		`[string]$marker.outputHash)) {
+        Write-Log "CNI config $cni_config_path changed since the last successful run"
+        $marker_current=$false
+    }
+{{- end}}`
+	// networkPluginTemplate defines the configuration of the win-overlay or win-bridge CNI plugin, shared by the single
+	// plugin configuration and the configuration list. The ProviderAddress policy only applies to overlay networks.
+	networkPluginTemplate = `{{define "networkPlugin"}}
+    "type":"{{.CNIPluginType}}",
+    "apiVersion": 2,{{if .MTU}}
+    "mtu": {{.MTU}},{{end}}{{if .Debug}}
+    "loglevel": "{{.CNILogLevel}}",{{end}}
+    "capabilities":{
+        "portMappings": true,
+        "dns":true
+    },
+    "ipam":{
+        "type":"host-local",
+        "subnet":"ovn_host_subnet"
+    },
+    "policies":[
+    {
+        "name": "EndpointPolicy",
+        "value": {
+            "type": "OutBoundNAT",
+            "settings": {
+                "exceptionList": [{{range $i, $cidr := .OutboundNATExceptions}}{{if $i}},{{end}}
+                "{{$cidr}}"{{end}}
+                ],
+                "destinationPrefix": "",
+                "needEncap": false
+            }
+        }
+    }{{range .SDNRouteCIDRs}},
+    {
+        "name": "EndpointPolicy",
+        "value": {
+            "type": "SDNRoute",
+            "settings": {
+                "exceptionList": [],
+                "destinationPrefix": "{{.}}",
+                "needEncap": true
+            }
+        }
+    }{{end}}{{if .Overlay}},
+    {
+        "name": "EndpointPolicy",
+        "value": {
+            "type": "ProviderAddress",
+            "settings": {
+                "providerAddress": "provider_address"
+            }
+        }
+    }{{end}}
+    ]{{end}}`
+	// kubeProxyPrepTemplate is the template used to generate the script which ensures the HNS endpoint used as the
+	// kube-proxy source VIP exists, and returns its IP
+	kubeProxyPrepTemplate = `# This script ensures the HNS endpoint used as the kube-proxy source VIP exists, and returns its IP
+` + networkScriptParams + `$ErrorActionPreference = "Stop"
+
+` + networkScriptPrologue + `{{if .SourceVIPOverride}}
+# The source VIP is managed outside of the cluster
+Write-Result "" "" {{psQuote .SourceVIPOverride}} 0
+{{- else if not .Overlay}}
+# Bridge networks have no VIP endpoint, so there is no source VIP
+Write-Result "" "" "" 0
+{{- else}}
+# Query the HNS endpoints, retrying transient failures. The script exits with a distinct code if all attempts fail,
+# rather than creating a duplicate endpoint.
+$endpoints=$null
+Write-Log "querying HNS endpoints"
+for($attempt=1; $attempt -le {{.HNSQueryAttempts}}; $attempt++) {
+    try {
+        $endpoints = Invoke-HNSRequest GET endpoints
+        break
+    } catch {
+        if($attempt -eq {{.HNSQueryAttempts}}) {
+            Write-Result "{{.Steps.QueryHNSEndpoints}}" "could not query HNS endpoints after $attempt attempts: $_" "" ` +
+		`{{.ExitCodes.HNSQueryFailed}}
+        }
+        Start-Sleep -Milliseconds {{.HNSQueryRetryDelayMilliseconds}}
+    }
+}
+
+# Delete the HNS endpoint if it belongs to a previous HNS network, as is the case after the network was recreated.
+# HNS does not return IDs in a consistent case.
+$endpoint = $endpoints | where { $_.Name -eq 'VIPEndpoint'}
+if($endpoint -ne $null) {
+    $endpoint_network_id=[string]$endpoint.VirtualNetwork
+    if(-not $endpoint_network_id.Equals([string]$hns_network.ID, [StringComparison]::OrdinalIgnoreCase)) {
+        Write-Log "deleting stale VIPEndpoint $($endpoint.ID)"
+        try {
+            Invoke-HNSRequest DELETE endpoints -Id $endpoint.ID | Out-Null
+        } catch {
+            Write-Result "{{.Steps.DeleteStaleEndpoint}}" "could not delete stale VIPEndpoint: $_" "" ` +
+		`{{.ExitCodes.EndpointCreateFailed}}
+        }
+        $endpoint = $null
+    }
+}
+
+# Create HNS endpoint if it doesn't exist
+if( $endpoint -eq $null) {
+    Write-Log "creating VIPEndpoint"
+    try {
+        $endpoint = New-HnsEndpoint -NetworkId $hns_network.ID -Name "VIPEndpoint"
+        Attach-HNSHostEndpoint -EndpointID $endpoint.ID -CompartmentID 1 | Out-Null
+    } catch {
+        Write-Result "{{.Steps.CreateEndpoint}}" "could not create VIPEndpoint: $_" "" ` +
+		`{{.ExitCodes.EndpointCreateFailed}}
+    }
+}
+
+# ConvertTo-IPv4Number returns the numeric value of the given IPv4 address
+function ConvertTo-IPv4Number($address) {
+    $bytes=([System.Net.IPAddress]::Parse($address)).GetAddressBytes()
+    return ([uint64]$bytes[0] * 16777216) + ([uint64]$bytes[1] * 65536) + ([uint64]$bytes[2] * 256) + [uint64]$bytes[3]
+}
+
+# Test-InSubnet returns true if the given IPv4 address is within the given IPv4 CIDR
+function Test-InSubnet($address, $cidr) {
+    $prefix, $length = $cidr.Split("/")
+    $block=[math]::Pow(2, 32 - [int]$length)
+    return [math]::Floor((ConvertTo-IPv4Number $address) / $block) -eq [math]::Floor((ConvertTo-IPv4Number $prefix) / $block)
+}
+
+# Resolve the HNS endpoint IP, waiting for the endpoint to be assigned an IPv4 address within the HNS network's subnet.
+# The lowest matching address is used, so the result does not depend on the order addresses are listed in.
+$subnets=@($hns_network.Subnets.AddressPrefix | where { $_ -and $_ -notmatch ":" })
+$source_vip=$null
+Write-Log "resolving source VIP"
+for($attempt=1; $attempt -le {{.SourceVIPAttempts}}; $attempt++) {
+    $addresses=@((Get-NetIPConfiguration -AllCompartments -All -Detailed | where { $_.NetAdapter.LinkLayerAddress -eq $endpoint.MacAddress }).IPV4Address.IPAddress | where { $_ })
+    $source_vip=$addresses | where { $address=$_.Trim(); $subnets | where { Test-InSubnet $address $_ } } |
+        ForEach-Object { $_.Trim() } | Sort-Object { ConvertTo-IPv4Number $_ } | Select-Object -First 1
+    if($source_vip) {
+        break
+    }
+    if($attempt -lt {{.SourceVIPAttempts}}) {
+        Start-Sleep -Milliseconds {{.SourceVIPRetryDelayMilliseconds}}
+    }
+}
+if(-not $source_vip) {
+    Write-Result "{{.Steps.ResolveSourceVIP}}" "VIPEndpoint has no IPv4 address within the HNS network subnets after ` +
+		`{{.SourceVIPAttempts}} attempts" "" {{.ExitCodes.SourceVIPNotFound}}
+}
+Write-Result "" "" $source_vip 0
+{{- end}}
+{{define "markerOutputsCheck"}}
+{{- if and .Overlay (not .SourceVIPOverride)}}
+    # The VIP endpoint must still exist on the HNS network with the source VIP of the last successful run
+    try {
+        $endpoint=Invoke-HNSRequest GET endpoints | where { $_.Name -eq 'VIPEndpoint' } | Select-Object -First 1
+        $addresses=@()
+        if(($endpoint -ne $null) -and ` +
+		`([string]$endpoint.VirtualNetwork).Equals([string]$hns_network.ID, [StringComparison]::OrdinalIgnoreCase)) {
+            $addresses=@((Get-NetIPConfiguration -AllCompartments -All -Detailed | ` +
+		`where { $_.NetAdapter.LinkLayerAddress -eq $endpoint.MacAddress }).IPV4Address.IPAddress | ` +
+		`where { $_ } | ForEach-Object { $_.Trim() })
+        }
+        if(-not $marker.sourceVip -or ($addresses -notcontains [string]$marker.sourceVip)) {
+            Write-Log "VIPEndpoint no longer has source VIP $($marker.sourceVip)"
+            $marker_current=$false
+        }
+    } catch {
+        Write-Log "could not check VIPEndpoint: $_"
+        $marker_current=$false
+    }
+{{- end}}
+{{- end}}`
+	// preflightTemplate is the template used to generate the script which waits for the preconditions of the other
+	// network scripts to be met: the HNS service running, and the HNS network present with a subnet and, for overlay
+	// networks, a management IP. Each precondition not met in time fails the script with a distinct exit code.
+	preflightTemplate = `# This script waits for the HNS network to be ready for the network configuration scripts
+` + networkScriptParams + `$ErrorActionPreference = "Stop"
+
+` + networkScriptFunctions + `
+$hns_network_name={{psQuote .HNSNetworkName}}
+$deadline=(Get-Date).AddMilliseconds({{.PreflightTimeoutMilliseconds}})
+
+# Wait for the HNS service to be running
+Write-Log "waiting for the HNS service to be running"
+while((Get-Service -Name hns -ErrorAction SilentlyContinue).Status -ne "Running") {
+    if((Get-Date) -ge $deadline) {
+        Write-Result "{{.Steps.CheckHNSService}}" "HNS service is not running" "" {{.ExitCodes.HNSServiceNotRunning}}
+    }
+    Start-Sleep -Milliseconds {{.PreflightRetryDelayMilliseconds}}
+}
+
+try {
+    Import-Module -DisableNameChecking {{psQuote .HNSModulePath}}
+} catch {
+    Write-Result "{{.Steps.GetHNSNetwork}}" "could not import HNS module: $_" "" {{.ExitCodes.HNSNetworkNotFound}}
+}
+
+# Wait for the HNS network to be present with a subnet{{if .Overlay}} and a management IP{{end}}
+Write-Log "waiting for HNS network $hns_network_name"
+while($true) {
+    try {
+        $hns_networks=@(Get-HnsNetwork)
+    } catch {
+        $hns_networks=@()
+    }
+    $hns_network=$hns_networks | where { $_.Name -eq $hns_network_name}
+{{- if not .StrictNameMatch}}
+    if($hns_network -eq $null) {
+        $candidates=@($hns_networks | where { $_.Type -eq {{psQuote .HNSNetworkType}} })
+        if($candidates.Count -eq 1) {
+            $hns_network=$candidates[0]
+        }
+    }
+{{- end}}
+    if($hns_network -eq $null) {
+        $failed_step="{{.Steps.GetHNSNetwork}}"
+        $message="HNS network $hns_network_name not found"
+        $exit_code={{.ExitCodes.HNSNetworkNotFound}}
+    } elseif(-not $hns_network.Subnets.AddressPrefix) {
+        $failed_step="{{.Steps.CheckHNSSubnet}}"
+        $message="HNS network $hns_network_name has no subnet"
+        $exit_code={{.ExitCodes.HNSSubnetMissing}}
+{{- if .Overlay}}
+    } elseif(-not $hns_network.ManagementIP) {
+        $failed_step="{{.Steps.CheckManagementIP}}"
+        $message="HNS network $hns_network_name has no management IP"
+        $exit_code={{.ExitCodes.ManagementIPMissing}}
+{{- end}}
+    } else {
+        break
+    }
+    if((Get-Date) -ge $deadline) {
+        Write-Result $failed_step $message "" $exit_code
+    }
+    Start-Sleep -Milliseconds {{.PreflightRetryDelayMilliseconds}}
+}
+Write-Result "" "" "" 0
+`
+)
+
+var (
+	// cniConfScriptTemplate is the parsed cniConfTemplate
+	cniConfScriptTemplate = newScriptTemplate("cni-conf", cniConfTemplate)
+	// kubeProxyPrepScriptTemplate is the parsed kubeProxyPrepTemplate
+	kubeProxyPrepScriptTemplate = newScriptTemplate("kube-proxy-prep", kubeProxyPrepTemplate)
+	// preflightScriptTemplate is the parsed preflightTemplate
+	preflightScriptTemplate = newScriptTemplate("network-preflight", preflightTemplate)
+)
+
+// networkConfTemplateData holds the values the network configuration templates are rendered with
+type networkConfTemplateData struct {
+	// HNSNetworkName is the name of the HNS network the CNI configuration is for
+	HNSNetworkName string
+	// OutboundNATExceptions are the CIDRs excluded from outbound NAT: the service network CIDRs, followed by any
+	// additional exceptions
+	OutboundNATExceptions []string
+	// SDNRouteCIDRs are the CIDRs an SDNRoute policy is created for
+	SDNRouteCIDRs []string
+	// MTU is the MTU of the pod interfaces, omitted from the CNI configuration if zero
+	MTU int
+	// Debug enables debug logging of the CNI plugin
+	Debug bool
+	// CNILogLevel is the log level of the CNI plugin when Debug is set
+	CNILogLevel string
+	// ProviderAddressOverride is the provider address used instead of the HNS network's management IP, if set
+	ProviderAddressOverride string
+	// HostSubnet is the subnet of the node the HNS network must have, if set
+	HostSubnet string
+	// Idempotent skips the script if it already succeeded with the same contents and HNS network, as recorded in a
+	// marker file, unless it is run with -Force
+	Idempotent bool
+	// ScriptHash is the hash of the script contents recorded in the marker file. It is computed by renderScript.
+	ScriptHash string
+	// ConfList renders a CNI network configuration list rather than a single plugin configuration
+	ConfList bool
+	// CNIVersion is the CNI specification version of the configuration
+	CNIVersion string
+	// ChainedPlugins are the JSON encoded configurations of the plugins following the network plugin in the
+	// configuration list
+	ChainedPlugins []string
+	// Overlay is true if the configuration is for an overlay network, rather than a bridge network
+	Overlay bool
+	// CNIPluginType is the name of the CNI plugin of the network
+	CNIPluginType string
+	// HNSModulePath is the path of the HNS PowerShell module on the Windows instance
+	HNSModulePath string
+	// CNIConfigPath is the path of the CNI configuration file on the Windows instance
+	CNIConfigPath string
+	// LogDir is the default directory the script logs to, disabling logging to a file if empty
+	LogDir string
+	// HNSNetworkType is the HNS type of the network, used to select it if no network has the expected name
+	HNSNetworkType string
+	// StrictNameMatch disables selecting the HNS network by type
+	StrictNameMatch bool
+	// ScriptName identifies the script in its log lines
+	ScriptName string
+	// LogFile is the name of the log file within LogDir
+	LogFile string
+	// MaxLogFileSize is the size in bytes past which the log file is truncated
+	MaxLogFileSize int64
+	// HNSQueryAttempts is the number of times the HNS endpoints are queried before giving up
+	HNSQueryAttempts int
+	// HNSQueryRetryDelayMilliseconds is the delay between HNS endpoint queries
+	HNSQueryRetryDelayMilliseconds int64
+	// SourceVIPAttempts is the number of times the VIP endpoint's IP is looked up before giving up
+	SourceVIPAttempts int
+	// SourceVIPRetryDelayMilliseconds is the delay between lookups of the VIP endpoint's IP
+	SourceVIPRetryDelayMilliseconds int64
+	// SourceVIPOverride is the source VIP reported instead of the VIP endpoint's IP, if set
+	SourceVIPOverride string
+	// PreflightTimeoutMilliseconds is how long the preflight script waits for the preconditions to be met
+	PreflightTimeoutMilliseconds int64
+	// PreflightRetryDelayMilliseconds is the delay between the preflight script's checks
+	PreflightRetryDelayMilliseconds int64
+	// Statuses are the statuses the scripts report
+	Statuses networkScriptStatuses
+	// Steps are the steps the scripts report as failed
+	Steps networkScriptSteps
+	// ExitCodes are the exit codes of each class of script failure
+	ExitCodes networkScriptExitCodes
+}
+
+// networkScriptStatuses holds the statuses reported by the network scripts, for use in templates
+type networkScriptStatuses struct {
+	Succeeded NetworkScriptStatus
+	Failed    NetworkScriptStatus
+}
+
+// networkScriptSteps holds the steps reported as failed by the network scripts, for use in templates
+type networkScriptSteps struct {
+	GetHNSNetwork       NetworkScriptStep
+	QueryHNSEndpoints   NetworkScriptStep
+	DeleteStaleEndpoint NetworkScriptStep
+	CreateEndpoint      NetworkScriptStep
+	ResolveSourceVIP    NetworkScriptStep
+	WriteCNIConfig      NetworkScriptStep
+	CheckHNSService     NetworkScriptStep
+	CheckHNSSubnet      NetworkScriptStep
+	CheckManagementIP   NetworkScriptStep
+}
+
+// networkScriptExitCodes holds the exit codes of the network scripts, for use in templates
+type networkScriptExitCodes struct {
+	HNSNetworkNotFound   int
+	HNSQueryFailed       int
+	EndpointCreateFailed int
+	SourceVIPNotFound    int
+	CNIConfigWriteFailed int
+	HNSServiceNotRunning int
+	HNSSubnetMissing     int
+	ManagementIPMissing  int
+}
+
+const (
+	// defaultHNSQueryAttempts is the number of times the kube-proxy preparation script queries the HNS endpoints
+	defaultHNSQueryAttempts = 5
+	// defaultHNSQueryRetryDelay is the delay between the HNS endpoint queries of the kube-proxy preparation script
+	defaultHNSQueryRetryDelay = 2 * time.Second
+	// sourceVIPAttempts and sourceVIPRetryDelay bound how long the kube-proxy preparation script waits for the VIP
+	// endpoint to be assigned an IPv4 address
+	sourceVIPAttempts   = 30
+	sourceVIPRetryDelay = 2 * time.Second
+	// HNSNetworkNotFoundExitCode is the exit code of the network scripts when the HNS module cannot be imported or the
+	// HNS network cannot be found. Exit code 1 is left to uncaught script failures.
+	HNSNetworkNotFoundExitCode = 2
+	// HNSQueryFailedExitCode is the exit code of the kube-proxy preparation script when the HNS endpoints cannot be
+	// queried, distinguishing it from other script failures
+	HNSQueryFailedExitCode = 3
+	// EndpointCreateFailedExitCode is the exit code of the kube-proxy preparation script when the VIP endpoint cannot
+	// be created or attached, or a stale VIP endpoint cannot be deleted
+	EndpointCreateFailedExitCode = 4
+	// SourceVIPNotFoundExitCode is the exit code of the kube-proxy preparation script when the VIP endpoint is not
+	// assigned an IPv4 address within the HNS network's subnets in time
+	SourceVIPNotFoundExitCode = 5
+	// CNIConfigWriteFailedExitCode is the exit code of the CNI configuration script when the CNI config file cannot be
+	// written
+	CNIConfigWriteFailedExitCode = 6
+	// HNSServiceNotRunningExitCode is the exit code of the preflight script when the HNS service is not running in time
+	HNSServiceNotRunningExitCode = 7
+	// HNSSubnetMissingExitCode is the exit code of the preflight script when the HNS network has no subnet in time,
+	// and of the CNI configuration script when the node's host subnet cannot be determined from the HNS network
+	HNSSubnetMissingExitCode = 8
+	// ManagementIPMissingExitCode is the exit code of the preflight script when the overlay HNS network has no
+	// management IP in time
+	ManagementIPMissingExitCode = 9
+	// defaultPreflightTimeout is how long the preflight script waits for the preconditions to be met, if not set
+	defaultPreflightTimeout = 2 * time.Minute
+	// preflightRetryDelay is the delay between the preflight script's checks
+	preflightRetryDelay = 2 * time.Second
+	// cniConfVersion is the CNI specification version of the single plugin configuration
+	cniConfVersion = "0.2.0"
+	// cniConfListVersion is the CNI specification version of the network configuration list
+	cniConfListVersion = "1.0.0"
+	// cniConfListExtension is the extension CNI network configuration lists are loaded from
+	cniConfListExtension = ".conflist"
+	// minMTU and maxMTU are the bounds of a valid CNI configuration MTU: the minimum IPv4 datagram size every host
+	// must accept, and the largest jumbo frame size commonly supported
+	minMTU = 576
+	maxMTU = 9216
+	// networkScriptLogFile is the name of the file the network scripts log to, within their log directory
+	networkScriptLogFile = "network-conf.log"
+	// cniDebugLogLevel is the log level of the CNI plugin when debug logging is enabled
+	cniDebugLogLevel = "debug"
+	// maxNetworkScriptLogSize is the size in bytes past which the network script log file is truncated
+	maxNetworkScriptLogSize = 5 * 1024 * 1024
+	// operatorVersionHeader, inputsHashHeader and generatedAtHeader prefix the lines of the generated script header
+	operatorVersionHeader = "# Operator version: "
+	inputsHashHeader      = "# Inputs SHA256: "
+	generatedAtHeader     = "# Generated at: "
+)
+
+// cniPluginTypeRegex matches the names of CNI plugin binaries
+var cniPluginTypeRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// windowsPathRegex matches absolute Windows paths, starting with either a drive letter or a UNC share
+var windowsPathRegex = regexp.MustCompile(`^([A-Za-z]:\\|\\\\[^\\]+\\)`)
+
+// CNIConfSettings holds the optional settings of the generated CNI configuration. The zero value leaves the CNI
+// configuration unchanged.
+type CNIConfSettings struct {
+	// ExtraOutboundNATExceptions are CIDRs, in addition to the service network, whose traffic skips outbound NAT,
+	// such as on-prem networks pods need to reach with their own IP
+	ExtraOutboundNATExceptions []string
+	// RouteExtraOutboundNATExceptions creates an SDNRoute policy for each of the ExtraOutboundNATExceptions, as is
+	// done for the service network
+	RouteExtraOutboundNATExceptions bool
+	// MTU is the MTU of the pod interfaces, which must match the cluster's overlay MTU. If zero, the MTU is left to
+	// the CNI plugin's default.
+	MTU int
+	// HostSubnet is the IPv4 subnet of the node, in canonical CIDR form, which the HNS network must have. It should
+	// be set on OVN-Kubernetes interconnect clusters, where the HNS network can have multiple subnets while the node's
+	// subnet changes. If empty, the HNS network must have a single subnet, which is used.
+	HostSubnet string
+	// ProviderAddressOverride is the IP used as the provider address of the overlay network, instead of the HNS
+	// network's management IP. It should be set on instances with multiple NICs, where the management IP can belong
+	// to an interface other than the one carrying overlay traffic.
+	ProviderAddressOverride string
+	// ConfList generates a CNI network configuration list, with the network plugin followed by ChainedPlugins,
+	// rather than a single plugin configuration. The CNI config path must then have the .conflist extension.
+	ConfList bool
+	// ChainedPlugins are the plugins run after the network plugin, in order. They can only be set along with ConfList.
+	ChainedPlugins []CNIPlugin
+	// NetworkType is the type of the HNS network. If empty, an overlay network is configured.
+	NetworkType NetworkType
+	// StrictNameMatch requires the HNS network to have the expected name. Otherwise, if no network has the name, the
+	// single HNS network of the expected type is used, and the script fails if there is not exactly one.
+	StrictNameMatch bool
+	// LogDir is the absolute Windows path of the directory the script logs to by default, in addition to stdout. If
+	// empty, the script only logs to stdout unless it is run with -LogDir.
+	LogDir string
+	// Debug sets the log level of the CNI plugin to debug, so that its logs can be collected from the node
+	Debug bool
+}
+
+// NetworkType is the type of the HNS network the network scripts are generated for
+type NetworkType string
+
+const (
+	// OverlayNetwork is a VXLAN overlay network, configured with the win-overlay CNI plugin
+	OverlayNetwork NetworkType = "overlay"
+	// BridgeNetwork is an L2 bridge network, configured with the win-bridge CNI plugin. Bridge networks have no
+	// provider address and no VIP endpoint.
+	BridgeNetwork NetworkType = "bridge"
+)
+
+// cniPluginTypes maps each network type to the CNI plugin configuring it
+var cniPluginTypes = map[NetworkType]string{
+	OverlayNetwork: "win-overlay",
+	BridgeNetwork:  "win-bridge",
+}
+
+// hnsNetworkTypes maps each network type to the type HNS reports for it
+var hnsNetworkTypes = map[NetworkType]string{
+	OverlayNetwork: "Overlay",
+	BridgeNetwork:  "L2Bridge",
+}
+
+// ResolveNetworkType returns the given network type, defaulting to an overlay network, or an error if it is unknown
+func ResolveNetworkType(networkType NetworkType) (NetworkType, error) {
+	if networkType == "" {
+		return OverlayNetwork, nil
+	}
+	if _, ok := cniPluginTypes[networkType]; !ok {
+		return "", fmt.Errorf("unknown network type %q", networkType)
+	}
+	return networkType, nil
+}
+
+// CNIPlugin is a CNI plugin chained after the network plugin in a CNI network configuration list
+type CNIPlugin struct {
+	// Type is the name of the plugin binary, such as portmap
+	Type string `json:"type"`
+	// Capabilities are the runtime capabilities of the plugin, such as portMappings
+	Capabilities map[string]bool `json:"capabilities,omitempty"`
+}
+
+// KubeProxyPrepSettings holds the optional settings of the generated kube-proxy preparation script. The zero value
+// resolves the source VIP from the VIP endpoint.
+type KubeProxyPrepSettings struct {
+	// SourceVIPOverride is an IPv4 address used as the kube-proxy source VIP, for clusters which manage the source VIP
+	// externally. If set, the VIP endpoint is not created.
+	SourceVIPOverride string
+	// NetworkType is the type of the HNS network. If empty, an overlay network is configured. Bridge networks have no
+	// VIP endpoint, so the script does not report a source VIP.
+	NetworkType NetworkType
+	// StrictNameMatch requires the HNS network to have the expected name. Otherwise, if no network has the name, the
+	// single HNS network of the expected type is used, and the script fails if there is not exactly one.
+	StrictNameMatch bool
+	// LogDir is the absolute Windows path of the directory the script logs to by default, in addition to stdout. If
+	// empty, the script only logs to stdout unless it is run with -LogDir.
+	LogDir string
+}
+
+// PreflightSettings holds the optional settings of the generated preflight script
+type PreflightSettings struct {
+	// Timeout is how long the script waits for the preconditions to be met. If zero, a default of two minutes is used.
+	Timeout time.Duration
+	// NetworkType is the type of the HNS network. If empty, an overlay network is checked. Bridge networks are not
+	// required to have a management IP.
+	NetworkType NetworkType
+	// StrictNameMatch requires the HNS network to have the expected name. Otherwise, if no network has the name, the
+	// single HNS network of the expected type is checked, matching the other network scripts.
+	StrictNameMatch bool
+	// LogDir is the absolute Windows path of the directory the script logs to by default, in addition to stdout. If
+	// empty, the script only logs to stdout unless it is run with -LogDir.
+	LogDir string
+}
+
+// PopulateCNIConfScript creates the .ps1 file responsible for CNI configuration at CNIConfigurationScript, returning
+// the FileInfo of the script and true if its contents changed
+func PopulateCNIConfScript(clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath string,
+	settings CNIConfSettings, opts ...Option) (*FileInfo, bool, error) {
+	return WriteCNIConfScript(CNIConfigurationScript, clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath,
+		settings, opts...)
+}
+
+// WriteCNIConfScript creates the .ps1 file responsible for CNI configuration at the given absolute path, creating its
+// parent directories as needed. The FileInfo of the written file, whose path is cleaned, is returned along with true
+// if its contents changed.
+func WriteCNIConfScript(dest, clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath string,
+	settings CNIConfSettings, opts ...Option) (*FileInfo, bool, error) {
+	scriptContents, err := generateCNIConfScript(clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath,
+		settings)
+	if err != nil {
+		return nil, false, err
+	}
+	inputs := []interface{}{clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath, settings}
+	return writeGeneratedScriptTo(dest, scriptContents, inputs, opts...)
+}
+
+// PopulateKubeProxyPrepScript creates the .ps1 file responsible for creating the kube-proxy source VIP endpoint at
+// KubeProxyPrepScript, returning the FileInfo of the script and true if its contents changed
+func PopulateKubeProxyPrepScript(hnsNetworkName, hnsPSModulePath string, settings KubeProxyPrepSettings,
+	opts ...Option) (*FileInfo, bool, error) {
+	return WriteKubeProxyPrepScript(KubeProxyPrepScript, hnsNetworkName, hnsPSModulePath, settings, opts...)
+}
+
+// WriteKubeProxyPrepScript creates the .ps1 file responsible for creating the kube-proxy source VIP endpoint at the
+// given absolute path, creating its parent directories as needed. The FileInfo of the written file, whose path is
+// cleaned, is returned along with true if its contents changed.
+func WriteKubeProxyPrepScript(dest, hnsNetworkName, hnsPSModulePath string, settings KubeProxyPrepSettings,
+	opts ...Option) (*FileInfo, bool, error) {
+	scriptContents, err := generateKubeProxyPrepScript(hnsNetworkName, hnsPSModulePath, defaultHNSQueryAttempts,
+		defaultHNSQueryRetryDelay, settings)
+	if err != nil {
+		return nil, false, err
+	}
+	inputs := []interface{}{hnsNetworkName, hnsPSModulePath, settings}
+	return writeGeneratedScriptTo(dest, scriptContents, inputs, opts...)
+}
+
+// PopulatePreflightScript creates the .ps1 file responsible for waiting for the HNS network to be ready at
+// PreflightScript, returning the FileInfo of the script and true if its contents changed
+func PopulatePreflightScript(hnsNetworkName, hnsPSModulePath string, settings PreflightSettings,
+	opts ...Option) (*FileInfo, bool, error) {
+	return WritePreflightScript(PreflightScript, hnsNetworkName, hnsPSModulePath, settings, opts...)
+}
+
+// WritePreflightScript creates the .ps1 file responsible for waiting for the HNS network to be ready at the given
+// absolute path, creating its parent directories as needed. The FileInfo of the written file, whose path is cleaned,
+// is returned along with true if its contents changed.
+func WritePreflightScript(dest, hnsNetworkName, hnsPSModulePath string, settings PreflightSettings,
+	opts ...Option) (*FileInfo, bool, error) {
+	scriptContents, err := generatePreflightScript(hnsNetworkName, hnsPSModulePath, settings)
+	if err != nil {
+		return nil, false, err
+	}
+	inputs := []interface{}{hnsNetworkName, hnsPSModulePath, settings}
+	return writeGeneratedScriptTo(dest, scriptContents, inputs, opts...)
+}
+
+// PopulateNetworkConfScript creates both the CNI configuration and kube-proxy preparation scripts, returning true if
+// the contents of either changed.
+//
+// Deprecated: use PopulateCNIConfScript and PopulateKubeProxyPrepScript, so each script can be regenerated
+// independently.
+func PopulateNetworkConfScript(clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath string,
+	opts ...Option) (bool, error) {
+	_, cniChanged, err := PopulateCNIConfScript(clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath,
+		CNIConfSettings{}, opts...)
+	if err != nil {
+		return false, err
+	}
+	_, kubeProxyChanged, err := PopulateKubeProxyPrepScript(hnsNetworkName, hnsPSModulePath, KubeProxyPrepSettings{},
+		opts...)
+	if err != nil {
+		return false, err
+	}
+	return cniChanged || kubeProxyChanged, nil
+}
+
+// writeGeneratedScriptTo validates and cleans the given destination path, then writes the given script to it, preceded
+// by a header identifying the operator version and inputs it was generated from. The FileInfo of the written script is
+// returned, along with true if its contents changed.
+func writeGeneratedScriptTo(dest, script string, inputs interface{}, opts ...Option) (*FileInfo, bool, error) {
+	if !path.IsAbs(dest) {
+		return nil, false, fmt.Errorf("destination %q is not an absolute path", dest)
+	}
+	dest = path.Clean(dest)
+	o := newOptions(opts)
+	header, err := scriptHeader(inputs, o)
+	if err != nil {
+		return nil, false, err
+	}
+	changed, err := writeGeneratedFile(dest, header+script, opts...)
+	if err != nil {
+		return nil, false, err
+	}
+	fileInfo, err := NewFileInfo(dest, opts...)
+	if err != nil {
+		return nil, false, err
+	}
+	return fileInfo, changed, nil
+}
+
+// scriptHeader returns the comment header of a generated script, recording the operator version, a hash of the inputs
+// the script was generated from, and the generation time. The time is truncated to the hour, so that scripts
+// regenerated shortly after one another are identical.
+func scriptHeader(inputs interface{}, o *options) (string, error) {
+	encodedInputs, err := json.Marshal(inputs)
+	if err != nil {
+		return "", fmt.Errorf("could not encode script inputs: %w", err)
+	}
+	operatorVersion := o.operatorVersion
+	if operatorVersion == "" {
+		operatorVersion = "unknown"
+	}
+	return fmt.Sprintf("# Generated by the Windows Machine Config Operator, changes made on the node are overwritten\n"+
+		"%s%s\n%s%x\n%s%s\n\n", operatorVersionHeader, operatorVersion, inputsHashHeader,
+		sha256.Sum256(encodedInputs), generatedAtHeader, o.now().UTC().Truncate(time.Hour).Format(time.RFC3339)), nil
+}
+
+// writeGeneratedFile writes the given contents to the generated file at the given path, returning true if its contents
+// changed. The file is only written if its current contents differ, other than by the generation time of its header,
+// or it cannot be read. It is replaced atomically, so a partially written script is never copied to Windows nodes.
+func writeGeneratedFile(path, contents string, opts ...Option) (bool, error) {
+	o := newOptions(opts)
+	// a missing or unreadable file is regenerated
+	if existing, err := fs.ReadFile(o.fsys, toFSPath(path)); err == nil &&
+		withoutGenerationTime(string(existing)) == withoutGenerationTime(contents) {
+		return false, nil
+	}
+	if err := o.writeFile(path, []byte(contents), fs.ModePerm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// withoutGenerationTime returns the given generated file contents with the generation time line of the header removed
+func withoutGenerationTime(contents string) string {
+	var lines []string
+	for _, line := range strings.SplitAfter(contents, "\n") {
+		if !strings.HasPrefix(line, generatedAtHeader) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// generateCNIConfScript generates the contents of the .ps1 file responsible for CNI configuration
+func generateCNIConfScript(clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath string,
+	settings CNIConfSettings) (string, error) {
+	serviceCIDRs, err := parseServiceCIDRs(clusterCIDR)
+	if err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	extraExceptions, err := parseExtraCIDRs(settings.ExtraOutboundNATExceptions, serviceCIDRs)
+	if err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if err := validateHNSInputs(hnsNetworkName, hnsPSModulePath); err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if strings.TrimSpace(cniConfigPath) == "" {
+		return "", fmt.Errorf("invalid network configuration: cniConfigPath must not be empty")
+	}
+	if err := validateLogDir(settings.LogDir); err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if err := validateScriptValue("cniConfigPath", cniConfigPath); err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if settings.MTU != 0 && (settings.MTU < minMTU || settings.MTU > maxMTU) {
+		return "", fmt.Errorf("invalid network configuration: MTU %d must be between %d and %d", settings.MTU,
+			minMTU, maxMTU)
+	}
+	if settings.ProviderAddressOverride != "" && net.ParseIP(settings.ProviderAddressOverride) == nil {
+		return "", fmt.Errorf("invalid network configuration: ProviderAddressOverride %q is not an IP address",
+			settings.ProviderAddressOverride)
+	}
+	if err := validateHostSubnet(settings.HostSubnet); err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	networkType, err := ResolveNetworkType(settings.NetworkType)
+	if err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if networkType != OverlayNetwork && settings.ProviderAddressOverride != "" {
+		return "", fmt.Errorf("invalid network configuration: ProviderAddressOverride only applies to overlay " +
+			"networks")
+	}
+	cniVersion := cniConfVersion
+	if settings.ConfList {
+		cniVersion = cniConfListVersion
+	}
+	isConfListPath := strings.HasSuffix(strings.ToLower(cniConfigPath), cniConfListExtension)
+	if isConfListPath != settings.ConfList {
+		return "", fmt.Errorf("invalid network configuration: cniConfigPath %q must have the %s extension if, and "+
+			"only if, a configuration list is generated", cniConfigPath, cniConfListExtension)
+	}
+	chainedPlugins, err := encodeChainedPlugins(settings, cniPluginTypes[networkType])
+	if err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	sdnRouteCIDRs := serviceCIDRs
+	if settings.RouteExtraOutboundNATExceptions {
+		sdnRouteCIDRs = append(sdnRouteCIDRs[:len(sdnRouteCIDRs):len(sdnRouteCIDRs)], extraExceptions...)
+	}
+	return renderScript(cniConfScriptTemplate, networkConfTemplateData{
+		HNSNetworkName:          hnsNetworkName,
+		OutboundNATExceptions:   append(serviceCIDRs[:len(serviceCIDRs):len(serviceCIDRs)], extraExceptions...),
+		SDNRouteCIDRs:           sdnRouteCIDRs,
+		HNSModulePath:           hnsPSModulePath,
+		CNIConfigPath:           cniConfigPath,
+		MTU:                     settings.MTU,
+		ProviderAddressOverride: settings.ProviderAddressOverride,
+		HostSubnet:              settings.HostSubnet,
+		Idempotent:              true,
+		ConfList:                settings.ConfList,
+		CNIVersion:              cniVersion,
+		ChainedPlugins:          chainedPlugins,
+		Overlay:                 networkType == OverlayNetwork,
+		CNIPluginType:           cniPluginTypes[networkType],
+		LogDir:                  settings.LogDir,
+		HNSNetworkType:          hnsNetworkTypes[networkType],
+		StrictNameMatch:         settings.StrictNameMatch,
+		Debug:                   settings.Debug,
+		CNILogLevel:             cniDebugLogLevel,
+	})
+}
+
+// generateKubeProxyPrepScript generates the contents of the .ps1 file responsible for creating the kube-proxy source
+// VIP endpoint. The HNS endpoints are queried up to hnsQueryAttempts times, waiting hnsQueryRetryDelay between
+// failed attempts.
+func generateKubeProxyPrepScript(hnsNetworkName, hnsPSModulePath string, hnsQueryAttempts int,
+	hnsQueryRetryDelay time.Duration, settings KubeProxyPrepSettings) (string, error) {
+	if err := validateHNSInputs(hnsNetworkName, hnsPSModulePath); err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if hnsQueryAttempts < 1 {
+		return "", fmt.Errorf("invalid network configuration: hnsQueryAttempts must be at least 1")
+	}
+	if hnsQueryRetryDelay < 0 {
+		return "", fmt.Errorf("invalid network configuration: hnsQueryRetryDelay must not be negative")
+	}
+	if settings.SourceVIPOverride != "" {
+		if ip := net.ParseIP(settings.SourceVIPOverride); ip == nil || ip.To4() == nil {
+			return "", fmt.Errorf("invalid network configuration: SourceVIPOverride %q is not an IPv4 address",
+				settings.SourceVIPOverride)
+		}
+	}
+	if err := validateLogDir(settings.LogDir); err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	networkType, err := ResolveNetworkType(settings.NetworkType)
+	if err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if networkType != OverlayNetwork && settings.SourceVIPOverride != "" {
+		return "", fmt.Errorf("invalid network configuration: SourceVIPOverride only applies to overlay networks")
+	}
+	return renderScript(kubeProxyPrepScriptTemplate, networkConfTemplateData{
+		HNSNetworkName:                  hnsNetworkName,
+		HNSModulePath:                   hnsPSModulePath,
+		HNSQueryAttempts:                hnsQueryAttempts,
+		HNSQueryRetryDelayMilliseconds:  hnsQueryRetryDelay.Milliseconds(),
+		SourceVIPAttempts:               sourceVIPAttempts,
+		SourceVIPRetryDelayMilliseconds: sourceVIPRetryDelay.Milliseconds(),
+		SourceVIPOverride:               settings.SourceVIPOverride,
+		Overlay:                         networkType == OverlayNetwork,
+		LogDir:                          settings.LogDir,
+		HNSNetworkType:                  hnsNetworkTypes[networkType],
+		StrictNameMatch:                 settings.StrictNameMatch,
+		Idempotent:                      true,
+	})
+}
+
+// generatePreflightScript generates the contents of the .ps1 file responsible for waiting for the HNS network to be
+// ready
+func generatePreflightScript(hnsNetworkName, hnsPSModulePath string, settings PreflightSettings) (string, error) {
+	if err := validateHNSInputs(hnsNetworkName, hnsPSModulePath); err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if err := validateLogDir(settings.LogDir); err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	if settings.Timeout < 0 {
+		return "", fmt.Errorf("invalid network configuration: Timeout must not be negative")
+	}
+	timeout := settings.Timeout
+	if timeout == 0 {
+		timeout = defaultPreflightTimeout
+	}
+	networkType, err := ResolveNetworkType(settings.NetworkType)
+	if err != nil {
+		return "", fmt.Errorf("invalid network configuration: %w", err)
+	}
+	return renderScript(preflightScriptTemplate, networkConfTemplateData{
+		HNSNetworkName:                  hnsNetworkName,
+		HNSModulePath:                   hnsPSModulePath,
+		PreflightTimeoutMilliseconds:    timeout.Milliseconds(),
+		PreflightRetryDelayMilliseconds: preflightRetryDelay.Milliseconds(),
+		Overlay:                         networkType == OverlayNetwork,
+		LogDir:                          settings.LogDir,
+		HNSNetworkType:                  hnsNetworkTypes[networkType],
+		StrictNameMatch:                 settings.StrictNameMatch,
+	})
+}
+
+// newScriptTemplate parses the given script template, panicking if it is invalid. Values substituted into the
+// template must be escaped with the psQuote or jsonString functions, unless they are validated to be plain numbers
+// or CIDRs.
+func newScriptTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).Option("missingkey=error").Funcs(template.FuncMap{
+		"psQuote":    psQuote,
+		"jsonString": jsonString,
+	}).Parse(text))
+}
+
+// psQuote returns the given value as a single quoted PowerShell string, which is never expanded or evaluated.
+// PowerShell also treats the typographic single quotes as quotes, so they are escaped along with the apostrophe.
+func psQuote(value string) string {
+	var quoted strings.Builder
+	quoted.WriteRune('\'')
+	for _, r := range value {
+		switch r {
+		case '\'', '\u2018', '\u2019', '\u201a', '\u201b':
+			// a quote is escaped by doubling it
+			quoted.WriteRune(r)
+		}
+		quoted.WriteRune(r)
+	}
+	quoted.WriteRune('\'')
+	return quoted.String()
+}
+
+// jsonString returns the given value as a quoted JSON string
+func jsonString(value string) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// validateScriptValue ensures the given value can be safely substituted into a script. Newlines would end the line,
+// or a here-string, the value is part of, and backticks are PowerShell's escape character, so both are rejected
+// outright rather than escaped.
+func validateScriptValue(name, value string) error {
+	if strings.ContainsAny(value, "\r\n`\x00") {
+		return fmt.Errorf("%s %q must not contain newlines, backticks or NUL characters", name, value)
+	}
+	return nil
+}
+
+// renderScript renders the given script template with the given data, along with the values used to log and report
+// the script's result
+func renderScript(tmpl *template.Template, data networkConfTemplateData) (string, error) {
+	data.ScriptName = tmpl.Name()
+	data.LogFile = networkScriptLogFile
+	data.MaxLogFileSize = maxNetworkScriptLogSize
+	data.Statuses = networkScriptStatuses{Succeeded: NetworkScriptSucceeded, Failed: NetworkScriptFailed}
+	data.Steps = networkScriptSteps{
+		GetHNSNetwork:       StepGetHNSNetwork,
+		QueryHNSEndpoints:   StepQueryHNSEndpoints,
+		DeleteStaleEndpoint: StepDeleteStaleEndpoint,
+		CreateEndpoint:      StepCreateEndpoint,
+		ResolveSourceVIP:    StepResolveSourceVIP,
+		WriteCNIConfig:      StepWriteCNIConfig,
+		CheckHNSService:     StepCheckHNSService,
+		CheckHNSSubnet:      StepCheckHNSSubnet,
+		CheckManagementIP:   StepCheckManagementIP,
+	}
+	data.ExitCodes = networkScriptExitCodes{
+		HNSNetworkNotFound:   HNSNetworkNotFoundExitCode,
+		HNSQueryFailed:       HNSQueryFailedExitCode,
+		EndpointCreateFailed: EndpointCreateFailedExitCode,
+		SourceVIPNotFound:    SourceVIPNotFoundExitCode,
+		CNIConfigWriteFailed: CNIConfigWriteFailedExitCode,
+		HNSServiceNotRunning: HNSServiceNotRunningExitCode,
+		HNSSubnetMissing:     HNSSubnetMissingExitCode,
+		ManagementIPMissing:  ManagementIPMissingExitCode,
+	}
+	if data.Idempotent {
+		// the script is first rendered without its hash, so that the hash covers every other part of its contents
+		data.ScriptHash = ""
+		unhashed, err := executeScriptTemplate(tmpl, data)
+		if err != nil {
+			return "", err
+		}
+		data.ScriptHash = fmt.Sprintf("%x", sha256.Sum256([]byte(unhashed)))
+	}
+	return executeScriptTemplate(tmpl, data)
+}
+
+// executeScriptTemplate renders the given script template with the given data
+func executeScriptTemplate(tmpl *template.Template, data networkConfTemplateData) (string, error) {
+	var script bytes.Buffer
+	if err := tmpl.Execute(&script, data); err != nil {
+		return "", fmt.Errorf("could not generate %s script: %w", tmpl.Name(), err)
+	}
+	return script.String(), nil
+}
+
+// parseServiceCIDRs parses the given comma separated list of service CIDRs. Dual-stack clusters have one IPv4 and one
+// IPv6 CIDR, any other combination of multiple CIDRs is invalid.
+func parseServiceCIDRs(clusterCIDR string) ([]string, error) {
+	if clusterCIDR == "" {
+		return nil, fmt.Errorf("clusterCIDR must not be empty")
+	}
+	var cidrs []string
+	families := make(map[bool]bool)
+	for _, cidr := range strings.Split(clusterCIDR, ",") {
+		cidr = strings.TrimSpace(cidr)
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("clusterCIDR %q is not a valid CIDR list: %w", clusterCIDR, err)
+		}
+		isIPv4 := ipNet.IP.To4() != nil
+		if families[isIPv4] {
+			return nil, fmt.Errorf("clusterCIDR %q must contain at most one CIDR per IP family", clusterCIDR)
+		}
+		families[isIPv4] = true
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// encodeChainedPlugins validates the chained plugins of the given settings, which follow the given network plugin,
+// returning their JSON encoded configurations
+func encodeChainedPlugins(settings CNIConfSettings, networkPluginType string) ([]string, error) {
+	if len(settings.ChainedPlugins) > 0 && !settings.ConfList {
+		return nil, fmt.Errorf("chained plugins require a configuration list")
+	}
+	var encoded []string
+	for _, plugin := range settings.ChainedPlugins {
+		if !cniPluginTypeRegex.MatchString(plugin.Type) {
+			return nil, fmt.Errorf("chained plugin type %q is not a valid plugin name", plugin.Type)
+		}
+		if plugin.Type == networkPluginType {
+			return nil, fmt.Errorf("%s cannot be chained after itself", networkPluginType)
+		}
+		pluginJSON, err := json.Marshal(plugin)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode chained plugin %s: %w", plugin.Type, err)
+		}
+		encoded = append(encoded, string(pluginJSON))
+	}
+	return encoded, nil
+}
+
+// parseExtraCIDRs validates the given CIDRs, returning them in canonical form and sorted, so that the generated script
+// does not depend on the order they were given in. Duplicates, including CIDRs already in the existing list, are
+// removed.
+func parseExtraCIDRs(cidrs, existing []string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, cidr := range existing {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid CIDR: %w", cidr, err)
+		}
+		seen[ipNet.String()] = true
+	}
+	var parsed []string
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("outbound NAT exception %q is not a valid CIDR: %w", cidr, err)
+		}
+		if seen[ipNet.String()] {
+			continue
+		}
+		seen[ipNet.String()] = true
+		parsed = append(parsed, ipNet.String())
+	}
+	sort.Strings(parsed)
+	return parsed, nil
+}
+
+// validateHNSInputs ensures the HNS values substituted into the network scripts are well formed, returning an error
+// naming the first invalid parameter
+func validateHNSInputs(hnsNetworkName, hnsPSModulePath string) error {
+	if strings.TrimSpace(hnsNetworkName) == "" {
+		return fmt.Errorf("hnsNetworkName must not be empty")
+	}
+	if err := validateScriptValue("hnsNetworkName", hnsNetworkName); err != nil {
+		return err
+	}
+	if !windowsPathRegex.MatchString(hnsPSModulePath) {
+		return fmt.Errorf("hnsPSModulePath %q is not an absolute Windows path", hnsPSModulePath)
+	}
+	return validateScriptValue("hnsPSModulePath", hnsPSModulePath)
+}
+
+// validateHostSubnet ensures the given host subnet is either empty or an IPv4 CIDR in canonical form, so that it can
+// be compared as is with the subnets HNS reports
+func validateHostSubnet(hostSubnet string) error {
+	if hostSubnet == "" {
+		return nil
+	}
+	ip, ipNet, err := net.ParseCIDR(hostSubnet)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("HostSubnet %q is not an IPv4 CIDR", hostSubnet)
+	}
+	if ipNet.String() != hostSubnet {
+		return fmt.Errorf("HostSubnet %q is not in canonical form, expected %q", hostSubnet, ipNet.String())
+	}
+	return nil
+}
+
+// validateLogDir ensures the given log directory is either empty or an absolute Windows path
+func validateLogDir(logDir string) error {
+	if logDir == "" {
+		return nil
+	}
+	if !windowsPathRegex.MatchString(logDir) {
+		return fmt.Errorf("LogDir %q is not an absolute Windows path", logDir)
+	}
+	return validateScriptValue("LogDir", logDir)
+}
@@ -0,0 +1,1704 @@
+package payload
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateCNIConfScript(t *testing.T) {
+	expectedOut := `# This script ensures the contents of the CNI config file is correct
+param(
+    # LogDir is the directory the script logs to, in addition to stdout. If empty, the script only logs to stdout.
+    [string]$LogDir='',
+    # Force runs the script even if it already succeeded with the same contents and HNS network
+    [switch]$Force
+)
+$ErrorActionPreference = "Stop"
+
+# Write-Log writes a timestamped message to stdout, and to the log file if LogDir is set.
+# The log file is truncated once it grows past its maximum size. Failing to write it does not fail the script.
+function Write-Log($message) {
+    $line = "$((Get-Date).ToUniversalTime().ToString('o')) [cni-conf] $message"
+    [Console]::Out.WriteLine($line)
+    if(-not $LogDir) {
+        return
+    }
+    try {
+        $log_file = Join-Path $LogDir 'network-conf.log'
+        if((Test-Path -LiteralPath $log_file) -and ((Get-Item -LiteralPath $log_file).Length -gt 5242880)) {
+            Clear-Content -LiteralPath $log_file
+        }
+        Add-Content -LiteralPath $log_file -Value $line
+    } catch {
+        # the log file is best effort
+    }
+}
+
+# Write-Result writes the result of the script to stdout as JSON, and exits with the given code
+function Write-Result($failedStep, $message, $sourceVip, $exitCode) {
+    $status = "Succeeded"
+    if($exitCode -ne 0) {
+        $status = "Failed"
+        Write-Log "failed at step ${failedStep}: $message"
+    } else {
+        Write-Log "succeeded"
+        if($marker_path -and -not $marker_current) {
+            try {
+                $marker = [ordered]@{hash=$script_hash; hnsNetworkId=[string]$hns_network.ID; sourceVip=$sourceVip;
+                    networkState=$network_state; outputHash=$marker_output_hash}
+                Set-Content -LiteralPath $marker_path -Value ($marker | ConvertTo-Json -Compress)
+            } catch {
+                Write-Log "could not write marker ${marker_path}: $_"
+            }
+        }
+    }
+    $result = [ordered]@{status=$status; failedStep=$failedStep; message=$message; sourceVip=$sourceVip}
+    [Console]::Out.WriteLine(($result | ConvertTo-Json -Compress))
+    exit $exitCode
+}
+
+$hns_network_name='OVNKubernetesHNSNetwork'
+Write-Log "getting HNS network $hns_network_name"
+try {
+    Import-Module -DisableNameChecking 'c:\k\hns.psm1'
+    $hns_networks=@(Get-HnsNetwork)
+} catch {
+    Write-Result "GetHNSNetwork" "could not get HNS network: $_" "" 2
+}
+$hns_network=$hns_networks | where { $_.Name -eq $hns_network_name}
+# The network may not have been renamed yet, in which case the single network of the expected type is used. Its
+# subnet and management IP are unchanged by the rename.
+$hns_network_type='Overlay'
+if($hns_network -eq $null) {
+    $candidates=@($hns_networks | where { $_.Type -eq $hns_network_type })
+    if($candidates.Count -ne 1) {
+        Write-Result "GetHNSNetwork" "HNS network $hns_network_name not found, and there are $($candidates.Count) $hns_network_type networks to use instead" "" 2
+    }
+    $hns_network=$candidates[0]
+    Write-Log "WARNING: HNS network $hns_network_name not found, using $hns_network_type network $($hns_network.Name)"
+}
+if($hns_network -eq $null) {
+    Write-Result "GetHNSNetwork" "HNS network $hns_network_name not found" "" 2
+}
+
+# The rest of the script is skipped if it already succeeded with the same contents and HNS network, and its outputs
+# are unchanged, unless -Force is given. The marker next to the script records the result of its last successful run.
+$script_hash=''
+$marker_path=""
+$marker_current=$false
+$marker_output_hash=""
+# the subnets and management IP the outputs are derived from, which can change without the network being recreated.
+# The management IP is only used as the provider address of overlay networks.
+$network_state="$(@($hns_network.Subnets.AddressPrefix) -join ',')|$($hns_network.ManagementIP)"
+
+# Get-ContentHash returns the hex encoded SHA256 hash of the given string
+function Get-ContentHash($value) {
+    $sha=[System.Security.Cryptography.SHA256]::Create()
+    return [BitConverter]::ToString($sha.ComputeHash([System.Text.Encoding]::UTF8.GetBytes($value))).Replace("-","")
+}
+
+if($PSCommandPath) {
+    $marker_path="$PSCommandPath.marker"
+}
+if($marker_path -and -not $Force -and (Test-Path -LiteralPath $marker_path)) {
+    try {
+        $marker=Get-Content -LiteralPath $marker_path -Raw | ConvertFrom-Json
+        $marker_network_id=[string]$marker.hnsNetworkId
+        $marker_current=($marker.hash -eq $script_hash) -and ($marker.networkState -eq $network_state) -and $marker_network_id.Equals([string]$hns_network.ID, [StringComparison]::OrdinalIgnoreCase)
+    } catch {
+        Write-Log "ignoring unreadable marker ${marker_path}: $_"
+    }
+}
+if($marker_current) {
+    # The CNI config must still be the one written by the last successful run
+    $cni_config_path='c:\k\cni.conf'
+    $existing_config=$null
+    if(Test-Path -LiteralPath $cni_config_path) {
+        $existing_config=Get-Content -LiteralPath $cni_config_path -Raw
+    }
+` + "    if(($existing_config -eq $null) -or ((Get-ContentHash $existing_config.Replace(\"`r\",\"\")) -ne [string]$marker.outputHash)) {" + `
+        Write-Log "CNI config $cni_config_path changed since the last successful run"
+        $marker_current=$false
+    }
+}
+if($marker_current) {
+    Write-Log "already succeeded with HNS network $($hns_network.ID), skipping"
+    Write-Result "" "" ([string]$marker.sourceVip) 0
+}
+
+$cni_template=@'
+{
+    "cniVersion":"0.2.0",
+    "name":"OVNKubernetesHNSNetwork",
+    "type":"win-overlay",
+    "apiVersion": 2,
+    "capabilities":{
+        "portMappings": true,
+        "dns":true
+    },
+    "ipam":{
+        "type":"host-local",
+        "subnet":"ovn_host_subnet"
+    },
+    "policies":[
+    {
+        "name": "EndpointPolicy",
+        "value": {
+            "type": "OutBoundNAT",
+            "settings": {
+                "exceptionList": [
+                "10.0.0.1/32"
+                ],
+                "destinationPrefix": "",
+                "needEncap": false
+            }
+        }
+    },
+    {
+        "name": "EndpointPolicy",
+        "value": {
+            "type": "SDNRoute",
+            "settings": {
+                "exceptionList": [],
+                "destinationPrefix": "10.0.0.1/32",
+                "needEncap": true
+            }
+        }
+    },
+    {
+        "name": "EndpointPolicy",
+        "value": {
+            "type": "ProviderAddress",
+            "settings": {
+                "providerAddress": "provider_address"
+            }
+        }
+    }
+    ]
+}
+'@
+
+# Select the host subnet of the node. The HNS network can briefly have multiple subnets while the node's subnet
+# changes, such as during the migration to OVN-Kubernetes interconnect. Only IPv4 subnets are configured.
+$subnets=@($hns_network.Subnets.AddressPrefix | where { $_ -and $_ -notmatch ":" })
+if($subnets.Count -ne 1) {
+    Write-Result "CheckHNSSubnet" "HNS network $($hns_network.Name) has $($subnets.Count) subnets ($($subnets -join ', ')), expected exactly one unless the node host subnet is given" "" 8
+}
+$subnet=$subnets[0]
+
+# Generate CNI Config
+$cni_template=$cni_template.Replace("ovn_host_subnet",$subnet)
+$provider_address=$hns_network.ManagementIP
+$cni_template=$cni_template.Replace("provider_address",$provider_address)
+
+# Compare CNI config with existing file, and replace if necessary
+$cni_config_path='c:\k\cni.conf'
+try {
+    $existing_config=""
+    if(Test-Path -LiteralPath $cni_config_path) {
+        $config_file_content=(Get-Content -LiteralPath $cni_config_path -Raw)
+        if($config_file_content -ne $null) {
+` + "            $existing_config=$config_file_content.Replace(\"`r\",\"\")" + `
+        }
+    }
+    if($existing_config -ne $cni_template){
+        Write-Log "writing CNI config to $cni_config_path"
+        Set-Content -LiteralPath $cni_config_path -Value $cni_template -NoNewline
+    } else {
+        Write-Log "CNI config $cni_config_path is up to date"
+    }
+} catch {
+    Write-Result "WriteCNIConfig" "could not write CNI config: $_" "" 6
+}
+$marker_output_hash=Get-ContentHash $cni_template
+
+Write-Result "" "" "" 0
+`
+	actual, err := generateCNIConfScript("10.0.0.1/32",
+		"OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1", "c:\\k\\cni.conf", CNIConfSettings{})
+	require.NoError(t, err)
+	assert.Equal(t, string(expectedOut), withoutScriptHash(actual))
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte(expectedOut))), scriptHash(t, actual))
+}
+
+func TestGenerateKubeProxyPrepScript(t *testing.T) {
+	expectedOut := `# This script ensures the HNS endpoint used as the kube-proxy source VIP exists, and returns its IP
+param(
+    # LogDir is the directory the script logs to, in addition to stdout. If empty, the script only logs to stdout.
+    [string]$LogDir='',
+    # Force runs the script even if it already succeeded with the same contents and HNS network
+    [switch]$Force
+)
+$ErrorActionPreference = "Stop"
+
+# Write-Log writes a timestamped message to stdout, and to the log file if LogDir is set.
+# The log file is truncated once it grows past its maximum size. Failing to write it does not fail the script.
+function Write-Log($message) {
+    $line = "$((Get-Date).ToUniversalTime().ToString('o')) [kube-proxy-prep] $message"
+    [Console]::Out.WriteLine($line)
+    if(-not $LogDir) {
+        return
+    }
+    try {
+        $log_file = Join-Path $LogDir 'network-conf.log'
+        if((Test-Path -LiteralPath $log_file) -and ((Get-Item -LiteralPath $log_file).Length -gt 5242880)) {
+            Clear-Content -LiteralPath $log_file
+        }
+        Add-Content -LiteralPath $log_file -Value $line
+    } catch {
+        # the log file is best effort
+    }
+}
+
+# Write-Result writes the result of the script to stdout as JSON, and exits with the given code
+function Write-Result($failedStep, $message, $sourceVip, $exitCode) {
+    $status = "Succeeded"
+    if($exitCode -ne 0) {
+        $status = "Failed"
+        Write-Log "failed at step ${failedStep}: $message"
+    } else {
+        Write-Log "succeeded"
+        if($marker_path -and -not $marker_current) {
+            try {
+                $marker = [ordered]@{hash=$script_hash; hnsNetworkId=[string]$hns_network.ID; sourceVip=$sourceVip;
+                    networkState=$network_state; outputHash=$marker_output_hash}
+                Set-Content -LiteralPath $marker_path -Value ($marker | ConvertTo-Json -Compress)
+            } catch {
+                Write-Log "could not write marker ${marker_path}: $_"
+            }
+        }
+    }
+    $result = [ordered]@{status=$status; failedStep=$failedStep; message=$message; sourceVip=$sourceVip}
+    [Console]::Out.WriteLine(($result | ConvertTo-Json -Compress))
+    exit $exitCode
+}
+
+$hns_network_name='OVNKubernetesHNSNetwork'
+Write-Log "getting HNS network $hns_network_name"
+try {
+    Import-Module -DisableNameChecking 'c:\k\hns.psm1'
+    $hns_networks=@(Get-HnsNetwork)
+} catch {
+    Write-Result "GetHNSNetwork" "could not get HNS network: $_" "" 2
+}
+$hns_network=$hns_networks | where { $_.Name -eq $hns_network_name}
+# The network may not have been renamed yet, in which case the single network of the expected type is used. Its
+# subnet and management IP are unchanged by the rename.
+$hns_network_type='Overlay'
+if($hns_network -eq $null) {
+    $candidates=@($hns_networks | where { $_.Type -eq $hns_network_type })
+    if($candidates.Count -ne 1) {
+        Write-Result "GetHNSNetwork" "HNS network $hns_network_name not found, and there are $($candidates.Count) $hns_network_type networks to use instead" "" 2
+    }
+    $hns_network=$candidates[0]
+    Write-Log "WARNING: HNS network $hns_network_name not found, using $hns_network_type network $($hns_network.Name)"
+}
+if($hns_network -eq $null) {
+    Write-Result "GetHNSNetwork" "HNS network $hns_network_name not found" "" 2
+}
+
+# The rest of the script is skipped if it already succeeded with the same contents and HNS network, and its outputs
+# are unchanged, unless -Force is given. The marker next to the script records the result of its last successful run.
+$script_hash=''
+$marker_path=""
+$marker_current=$false
+$marker_output_hash=""
+# the subnets and management IP the outputs are derived from, which can change without the network being recreated.
+# The management IP is only used as the provider address of overlay networks.
+$network_state="$(@($hns_network.Subnets.AddressPrefix) -join ',')|$($hns_network.ManagementIP)"
+
+# Get-ContentHash returns the hex encoded SHA256 hash of the given string
+function Get-ContentHash($value) {
+    $sha=[System.Security.Cryptography.SHA256]::Create()
+    return [BitConverter]::ToString($sha.ComputeHash([System.Text.Encoding]::UTF8.GetBytes($value))).Replace("-","")
+}
+
+if($PSCommandPath) {
+    $marker_path="$PSCommandPath.marker"
+}
+if($marker_path -and -not $Force -and (Test-Path -LiteralPath $marker_path)) {
+    try {
+        $marker=Get-Content -LiteralPath $marker_path -Raw | ConvertFrom-Json
+        $marker_network_id=[string]$marker.hnsNetworkId
+        $marker_current=($marker.hash -eq $script_hash) -and ($marker.networkState -eq $network_state) -and $marker_network_id.Equals([string]$hns_network.ID, [StringComparison]::OrdinalIgnoreCase)
+    } catch {
+        Write-Log "ignoring unreadable marker ${marker_path}: $_"
+    }
+}
+if($marker_current) {
+    # The VIP endpoint must still exist on the HNS network with the source VIP of the last successful run
+    try {
+        $endpoint=Invoke-HNSRequest GET endpoints | where { $_.Name -eq 'VIPEndpoint' } | Select-Object -First 1
+        $addresses=@()
+        if(($endpoint -ne $null) -and ([string]$endpoint.VirtualNetwork).Equals([string]$hns_network.ID, [StringComparison]::OrdinalIgnoreCase)) {
+            $addresses=@((Get-NetIPConfiguration -AllCompartments -All -Detailed | where { $_.NetAdapter.LinkLayerAddress -eq $endpoint.MacAddress }).IPV4Address.IPAddress | where { $_ } | ForEach-Object { $_.Trim() })
+        }
+        if(-not $marker.sourceVip -or ($addresses -notcontains [string]$marker.sourceVip)) {
+            Write-Log "VIPEndpoint no longer has source VIP $($marker.sourceVip)"
+            $marker_current=$false
+        }
+    } catch {
+        Write-Log "could not check VIPEndpoint: $_"
+        $marker_current=$false
+    }
+}
+if($marker_current) {
+    Write-Log "already succeeded with HNS network $($hns_network.ID), skipping"
+    Write-Result "" "" ([string]$marker.sourceVip) 0
+}
+
+# Query the HNS endpoints, retrying transient failures. The script exits with a distinct code if all attempts fail,
+# rather than creating a duplicate endpoint.
+$endpoints=$null
+Write-Log "querying HNS endpoints"
+for($attempt=1; $attempt -le 5; $attempt++) {
+    try {
+        $endpoints = Invoke-HNSRequest GET endpoints
+        break
+    } catch {
+        if($attempt -eq 5) {
+            Write-Result "QueryHNSEndpoints" "could not query HNS endpoints after $attempt attempts: $_" "" 3
+        }
+        Start-Sleep -Milliseconds 2000
+    }
+}
+
+# Delete the HNS endpoint if it belongs to a previous HNS network, as is the case after the network was recreated.
+# HNS does not return IDs in a consistent case.
+$endpoint = $endpoints | where { $_.Name -eq 'VIPEndpoint'}
+if($endpoint -ne $null) {
+    $endpoint_network_id=[string]$endpoint.VirtualNetwork
+    if(-not $endpoint_network_id.Equals([string]$hns_network.ID, [StringComparison]::OrdinalIgnoreCase)) {
+        Write-Log "deleting stale VIPEndpoint $($endpoint.ID)"
+        try {
+            Invoke-HNSRequest DELETE endpoints -Id $endpoint.ID | Out-Null
+        } catch {
+            Write-Result "DeleteStaleEndpoint" "could not delete stale VIPEndpoint: $_" "" 4
+        }
+        $endpoint = $null
+    }
+}
+
+# Create HNS endpoint if it doesn't exist
+if( $endpoint -eq $null) {
+    Write-Log "creating VIPEndpoint"
+    try {
+        $endpoint = New-HnsEndpoint -NetworkId $hns_network.ID -Name "VIPEndpoint"
+        Attach-HNSHostEndpoint -EndpointID $endpoint.ID -CompartmentID 1 | Out-Null
+    } catch {
+        Write-Result "CreateEndpoint" "could not create VIPEndpoint: $_" "" 4
+    }
+}
+
+# ConvertTo-IPv4Number returns the numeric value of the given IPv4 address
+function ConvertTo-IPv4Number($address) {
+    $bytes=([System.Net.IPAddress]::Parse($address)).GetAddressBytes()
+    return ([uint64]$bytes[0] * 16777216) + ([uint64]$bytes[1] * 65536) + ([uint64]$bytes[2] * 256) + [uint64]$bytes[3]
+}
+
+# Test-InSubnet returns true if the given IPv4 address is within the given IPv4 CIDR
+function Test-InSubnet($address, $cidr) {
+    $prefix, $length = $cidr.Split("/")
+    $block=[math]::Pow(2, 32 - [int]$length)
+    return [math]::Floor((ConvertTo-IPv4Number $address) / $block) -eq [math]::Floor((ConvertTo-IPv4Number $prefix) / $block)
+}
+
+# Resolve the HNS endpoint IP, waiting for the endpoint to be assigned an IPv4 address within the HNS network's subnet.
+# The lowest matching address is used, so the result does not depend on the order addresses are listed in.
+$subnets=@($hns_network.Subnets.AddressPrefix | where { $_ -and $_ -notmatch ":" })
+$source_vip=$null
+Write-Log "resolving source VIP"
+for($attempt=1; $attempt -le 30; $attempt++) {
+    $addresses=@((Get-NetIPConfiguration -AllCompartments -All -Detailed | where { $_.NetAdapter.LinkLayerAddress -eq $endpoint.MacAddress }).IPV4Address.IPAddress | where { $_ })
+    $source_vip=$addresses | where { $address=$_.Trim(); $subnets | where { Test-InSubnet $address $_ } } |
+        ForEach-Object { $_.Trim() } | Sort-Object { ConvertTo-IPv4Number $_ } | Select-Object -First 1
+    if($source_vip) {
+        break
+    }
+    if($attempt -lt 30) {
+        Start-Sleep -Milliseconds 2000
+    }
+}
+if(-not $source_vip) {
+    Write-Result "ResolveSourceVIP" "VIPEndpoint has no IPv4 address within the HNS network subnets after 30 attempts" "" 5
+}
+Write-Result "" "" $source_vip 0
+`
+	actual, err := generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		defaultHNSQueryAttempts, defaultHNSQueryRetryDelay, KubeProxyPrepSettings{})
+	require.NoError(t, err)
+	assert.Equal(t, expectedOut, withoutScriptHash(actual))
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte(expectedOut))), scriptHash(t, actual))
+
+	// a fast failing variant queries once, without sleeping
+	actual, err = generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1", 1, 0,
+		KubeProxyPrepSettings{})
+	require.NoError(t, err)
+	assert.Contains(t, actual, "$attempt -le 1;")
+	assert.Contains(t, actual, "Start-Sleep -Milliseconds 0\n")
+
+	for _, invalid := range []struct {
+		hnsNetworkName string
+		attempts       int
+		delay          time.Duration
+	}{
+		{hnsNetworkName: "", attempts: 1},
+		{hnsNetworkName: "OVNKubernetesHNSNetwork", attempts: 0},
+		{hnsNetworkName: "OVNKubernetesHNSNetwork", attempts: 1, delay: -time.Second},
+	} {
+		_, err = generateKubeProxyPrepScript(invalid.hnsNetworkName, "c:\\k\\hns.psm1", invalid.attempts, invalid.delay,
+			KubeProxyPrepSettings{})
+		assert.Error(t, err)
+	}
+}
+
+// TestGenerateKubeProxyPrepScriptStaleEndpoint ensures an endpoint left over from a previous HNS network is deleted
+// before the endpoint is created and the source VIP is resolved
+func TestGenerateKubeProxyPrepScriptStaleEndpoint(t *testing.T) {
+	actual, err := generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		defaultHNSQueryAttempts, defaultHNSQueryRetryDelay, KubeProxyPrepSettings{})
+	require.NoError(t, err)
+	staleCheck := strings.Index(actual, "$endpoint.VirtualNetwork")
+	deletion := strings.Index(actual, "Invoke-HNSRequest DELETE endpoints -Id $endpoint.ID")
+	creation := strings.Index(actual, "New-HnsEndpoint")
+	require.True(t, staleCheck > 0 && deletion > 0 && creation > 0)
+	assert.Less(t, staleCheck, deletion)
+	assert.Less(t, deletion, creation)
+	// the network IDs are compared case-insensitively
+	assert.Contains(t, actual, ".Equals([string]$hns_network.ID, [StringComparison]::OrdinalIgnoreCase)")
+}
+
+func TestGenerateKubeProxyPrepScriptSourceVIP(t *testing.T) {
+	actual, err := generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		defaultHNSQueryAttempts, defaultHNSQueryRetryDelay, KubeProxyPrepSettings{})
+	require.NoError(t, err)
+	// the source VIP lookup is retried a bounded number of times before failing with a distinct exit code
+	assert.Contains(t, actual, fmt.Sprintf("$attempt -le %d;", sourceVIPAttempts))
+	assert.Contains(t, actual, fmt.Sprintf("Start-Sleep -Milliseconds %d\n", sourceVIPRetryDelay.Milliseconds()))
+	assert.Contains(t, actual, fmt.Sprintf("\"\" %d\n}\nWrite-Result \"\" \"\" $source_vip 0\n",
+		SourceVIPNotFoundExitCode))
+
+	testCases := []struct {
+		name        string
+		override    string
+		expectedErr bool
+	}{
+		{name: "IPv4 override", override: "10.132.0.2"},
+		{name: "IPv6 override", override: "fd01::2", expectedErr: true},
+		{name: "invalid override", override: "10.132.0.300", expectedErr: true},
+		{name: "PowerShell in override", override: "$(Get-Date)", expectedErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				defaultHNSQueryAttempts, defaultHNSQueryRetryDelay,
+				KubeProxyPrepSettings{SourceVIPOverride: test.override})
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, strings.HasSuffix(actual, "Write-Result \"\" \"\" '"+test.override+"' 0\n"))
+			// the externally managed source VIP is reported without looking up or creating the VIP endpoint
+			assert.NotContains(t, actual, "Invoke-HNSRequest")
+			assert.NotContains(t, actual, "New-HnsEndpoint")
+		})
+	}
+}
+
+func TestGeneratePreflightScript(t *testing.T) {
+	expectedOut := `# This script waits for the HNS network to be ready for the network configuration scripts
+param(
+    # LogDir is the directory the script logs to, in addition to stdout. If empty, the script only logs to stdout.
+    [string]$LogDir=''
+)
+$ErrorActionPreference = "Stop"
+
+# Write-Log writes a timestamped message to stdout, and to the log file if LogDir is set.
+# The log file is truncated once it grows past its maximum size. Failing to write it does not fail the script.
+function Write-Log($message) {
+    $line = "$((Get-Date).ToUniversalTime().ToString('o')) [network-preflight] $message"
+    [Console]::Out.WriteLine($line)
+    if(-not $LogDir) {
+        return
+    }
+    try {
+        $log_file = Join-Path $LogDir 'network-conf.log'
+        if((Test-Path -LiteralPath $log_file) -and ((Get-Item -LiteralPath $log_file).Length -gt 5242880)) {
+            Clear-Content -LiteralPath $log_file
+        }
+        Add-Content -LiteralPath $log_file -Value $line
+    } catch {
+        # the log file is best effort
+    }
+}
+
+# Write-Result writes the result of the script to stdout as JSON, and exits with the given code
+function Write-Result($failedStep, $message, $sourceVip, $exitCode) {
+    $status = "Succeeded"
+    if($exitCode -ne 0) {
+        $status = "Failed"
+        Write-Log "failed at step ${failedStep}: $message"
+    } else {
+        Write-Log "succeeded"
+    }
+    $result = [ordered]@{status=$status; failedStep=$failedStep; message=$message; sourceVip=$sourceVip}
+    [Console]::Out.WriteLine(($result | ConvertTo-Json -Compress))
+    exit $exitCode
+}
+
+$hns_network_name='OVNKubernetesHNSNetwork'
+$deadline=(Get-Date).AddMilliseconds(120000)
+
+# Wait for the HNS service to be running
+Write-Log "waiting for the HNS service to be running"
+while((Get-Service -Name hns -ErrorAction SilentlyContinue).Status -ne "Running") {
+    if((Get-Date) -ge $deadline) {
+        Write-Result "CheckHNSService" "HNS service is not running" "" 7
+    }
+    Start-Sleep -Milliseconds 2000
+}
+
+try {
+    Import-Module -DisableNameChecking 'c:\k\hns.psm1'
+} catch {
+    Write-Result "GetHNSNetwork" "could not import HNS module: $_" "" 2
+}
+
+# Wait for the HNS network to be present with a subnet and a management IP
+Write-Log "waiting for HNS network $hns_network_name"
+while($true) {
+    try {
+        $hns_networks=@(Get-HnsNetwork)
+    } catch {
+        $hns_networks=@()
+    }
+    $hns_network=$hns_networks | where { $_.Name -eq $hns_network_name}
+    if($hns_network -eq $null) {
+        $candidates=@($hns_networks | where { $_.Type -eq 'Overlay' })
+        if($candidates.Count -eq 1) {
+            $hns_network=$candidates[0]
+        }
+    }
+    if($hns_network -eq $null) {
+        $failed_step="GetHNSNetwork"
+        $message="HNS network $hns_network_name not found"
+        $exit_code=2
+    } elseif(-not $hns_network.Subnets.AddressPrefix) {
+        $failed_step="CheckHNSSubnet"
+        $message="HNS network $hns_network_name has no subnet"
+        $exit_code=8
+    } elseif(-not $hns_network.ManagementIP) {
+        $failed_step="CheckManagementIP"
+        $message="HNS network $hns_network_name has no management IP"
+        $exit_code=9
+    } else {
+        break
+    }
+    if((Get-Date) -ge $deadline) {
+        Write-Result $failed_step $message "" $exit_code
+    }
+    Start-Sleep -Milliseconds 2000
+}
+Write-Result "" "" "" 0
+`
+	actual, err := generatePreflightScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1", PreflightSettings{})
+	require.NoError(t, err)
+	assert.Equal(t, expectedOut, actual)
+
+	testCases := []struct {
+		name           string
+		hnsNetworkName string
+		settings       PreflightSettings
+		expected       []string
+		notExpected    []string
+		expectedErr    bool
+	}{
+		{
+			name:     "timeout",
+			settings: PreflightSettings{Timeout: 30 * time.Second},
+			expected: []string{"$deadline=(Get-Date).AddMilliseconds(30000)\n"},
+		},
+		{
+			name:        "bridge network",
+			settings:    PreflightSettings{NetworkType: BridgeNetwork},
+			expected:    []string{"where { $_.Type -eq 'L2Bridge' }", "with a subnet\n"},
+			notExpected: []string{"ManagementIP"},
+		},
+		{
+			name:        "strict name match",
+			settings:    PreflightSettings{StrictNameMatch: true},
+			notExpected: []string{"$candidates"},
+		},
+		{
+			name:     "log directory",
+			settings: PreflightSettings{LogDir: "C:\\var\\log\\"},
+			expected: []string{"[string]$LogDir='C:\\var\\log\\'\n"},
+		},
+		{
+			name:        "negative timeout",
+			settings:    PreflightSettings{Timeout: -time.Second},
+			expectedErr: true,
+		},
+		{
+			name:        "unknown network type",
+			settings:    PreflightSettings{NetworkType: "l2tunnel"},
+			expectedErr: true,
+		},
+		{
+			name:           "empty network name",
+			hnsNetworkName: " ",
+			expectedErr:    true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			hnsNetworkName := test.hnsNetworkName
+			if hnsNetworkName == "" {
+				hnsNetworkName = "OVNKubernetesHNSNetwork"
+			}
+			actual, err := generatePreflightScript(hnsNetworkName, "c:\\k\\hns.psm1", test.settings)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for _, expected := range test.expected {
+				assert.Contains(t, actual, expected)
+			}
+			for _, notExpected := range test.notExpected {
+				assert.NotContains(t, actual, notExpected)
+			}
+		})
+	}
+}
+
+func TestGenerateCNIConfScriptDualStack(t *testing.T) {
+	actual, err := generateCNIConfScript("172.30.0.0/16, fd02::/112", "OVNKubernetesHNSNetwork",
+		"c:\\k\\hns.psm1", "c:\\k\\cni.conf", CNIConfSettings{})
+	require.NoError(t, err)
+	outboundNATExceptions, sdnRoutePrefixes := parseCNIPolicies(t, actual)
+	assert.Equal(t, []string{"172.30.0.0/16", "fd02::/112"}, outboundNATExceptions)
+	assert.Equal(t, []string{"172.30.0.0/16", "fd02::/112"}, sdnRoutePrefixes)
+}
+
+func TestGenerateCNIConfScriptOutboundNATExceptions(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		settings              CNIConfSettings
+		expectedNATExceptions []string
+		expectedSDNRoutes     []string
+		expectedErr           bool
+	}{
+		{
+			name:                  "no extra exceptions",
+			expectedNATExceptions: []string{"172.30.0.0/16"},
+			expectedSDNRoutes:     []string{"172.30.0.0/16"},
+		},
+		{
+			name: "extra exceptions are sorted and deduplicated",
+			settings: CNIConfSettings{
+				ExtraOutboundNATExceptions: []string{"192.168.0.0/16", " 10.0.0.0/8", "10.0.0.0/8"}},
+			expectedNATExceptions: []string{"172.30.0.0/16", "10.0.0.0/8", "192.168.0.0/16"},
+			expectedSDNRoutes:     []string{"172.30.0.0/16"},
+		},
+		{
+			name:                  "service network duplicate and non-canonical CIDR",
+			settings:              CNIConfSettings{ExtraOutboundNATExceptions: []string{"172.30.1.1/16", "10.1.2.3/8"}},
+			expectedNATExceptions: []string{"172.30.0.0/16", "10.0.0.0/8"},
+			expectedSDNRoutes:     []string{"172.30.0.0/16"},
+		},
+		{
+			name: "routed extra exceptions",
+			settings: CNIConfSettings{ExtraOutboundNATExceptions: []string{"192.168.0.0/16", "10.0.0.0/8"},
+				RouteExtraOutboundNATExceptions: true},
+			expectedNATExceptions: []string{"172.30.0.0/16", "10.0.0.0/8", "192.168.0.0/16"},
+			expectedSDNRoutes:     []string{"172.30.0.0/16", "10.0.0.0/8", "192.168.0.0/16"},
+		},
+		{
+			name:        "invalid extra exception",
+			settings:    CNIConfSettings{ExtraOutboundNATExceptions: []string{"10.0.0.0/33"}},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				"c:\\k\\cni.conf", test.settings)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			outboundNATExceptions, sdnRoutePrefixes := parseCNIPolicies(t, actual)
+			assert.Equal(t, test.expectedNATExceptions, outboundNATExceptions)
+			assert.Equal(t, test.expectedSDNRoutes, sdnRoutePrefixes)
+		})
+	}
+}
+
+func TestGenerateCNIConfScriptMTU(t *testing.T) {
+	unset, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		"c:\\k\\cni.conf", CNIConfSettings{})
+	require.NoError(t, err)
+	assert.NotContains(t, unset, "mtu")
+
+	testCases := []struct {
+		name        string
+		mtu         int
+		expectedErr bool
+	}{
+		{name: "minimum", mtu: 576},
+		{name: "reduced overlay MTU", mtu: 1400},
+		{name: "jumbo frames", mtu: 9000},
+		{name: "maximum", mtu: 9216},
+		{name: "too small", mtu: 575, expectedErr: true},
+		{name: "too large", mtu: 9217, expectedErr: true},
+		{name: "negative", mtu: -1, expectedErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				"c:\\k\\cni.conf", CNIConfSettings{MTU: test.mtu})
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			cniConfig := actual[strings.Index(actual, "@'\n")+3 : strings.Index(actual, "'@")]
+			var parsed struct {
+				MTU int `json:"mtu"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(cniConfig), &parsed))
+			assert.Equal(t, test.mtu, parsed.MTU)
+			// the MTU is the only difference from the default configuration
+			assert.Equal(t, withoutScriptHash(unset),
+				withoutScriptHash(strings.Replace(actual, fmt.Sprintf("\n    \"mtu\": %d,", test.mtu), "", 1)))
+		})
+	}
+}
+
+// TestGenerateCNIConfScriptDebug ensures the CNI plugin log level is only set when debug logging is enabled
+func TestGenerateCNIConfScriptDebug(t *testing.T) {
+	for _, confList := range []bool{false, true} {
+		cniConfigPath := "c:\\k\\cni.conf"
+		if confList {
+			cniConfigPath = "c:\\k\\cni.conflist"
+		}
+		disabled, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+			cniConfigPath, CNIConfSettings{ConfList: confList})
+		require.NoError(t, err)
+		assert.NotContains(t, disabled, "loglevel")
+
+		enabled, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+			cniConfigPath, CNIConfSettings{ConfList: confList, Debug: true})
+		require.NoError(t, err)
+		cniConfig := enabled[strings.Index(enabled, "@'\n")+3 : strings.Index(enabled, "'@")]
+		var parsed struct {
+			LogLevel string `json:"loglevel"`
+			Plugins  []struct {
+				LogLevel string `json:"loglevel"`
+			} `json:"plugins"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(cniConfig), &parsed))
+		if confList {
+			require.Len(t, parsed.Plugins, 1)
+			assert.Equal(t, cniDebugLogLevel, parsed.Plugins[0].LogLevel)
+		} else {
+			assert.Equal(t, cniDebugLogLevel, parsed.LogLevel)
+		}
+	}
+}
+
+func TestGenerateCNIConfScriptProviderAddress(t *testing.T) {
+	unset, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		"c:\\k\\cni.conf", CNIConfSettings{})
+	require.NoError(t, err)
+	assert.Contains(t, unset, "$provider_address=$hns_network.ManagementIP\n")
+
+	testCases := []struct {
+		name        string
+		override    string
+		expectedErr bool
+	}{
+		{name: "IPv4 override", override: "10.0.128.5"},
+		{name: "IPv6 override", override: "fd00::5"},
+		{name: "hostname override", override: "node.example.com", expectedErr: true},
+		{name: "PowerShell in override", override: "\"; Remove-Item c:\\k; \"", expectedErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				"c:\\k\\cni.conf", CNIConfSettings{ProviderAddressOverride: test.override})
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Contains(t, actual, "$provider_address='"+test.override+"'\n")
+			assert.NotContains(t, actual, "ManagementIP")
+		})
+	}
+}
+
+// TestGenerateCNIConfScriptHostSubnet ensures the CNI configuration uses the given host subnet once the HNS network is
+// checked to have it, and otherwise requires the HNS network to have a single subnet
+func TestGenerateCNIConfScriptHostSubnet(t *testing.T) {
+	testCases := []struct {
+		name        string
+		hostSubnet  string
+		expected    []string
+		notExpected []string
+		expectedErr bool
+	}{
+		{
+			name: "single subnet",
+			expected: []string{
+				"if($subnets.Count -ne 1) {\n",
+				`Write-Result "CheckHNSSubnet" "HNS network $($hns_network.Name) has $($subnets.Count) subnets ` +
+					`($($subnets -join ', ')), expected exactly one unless the node host subnet is given" "" 8`,
+				"$subnet=$subnets[0]\n",
+			},
+			notExpected: []string{"-notcontains"},
+		},
+		{
+			name:       "explicit host subnet",
+			hostSubnet: "10.132.2.0/24",
+			expected: []string{
+				"$subnet='10.132.2.0/24'\nif($subnets -notcontains $subnet) {\n",
+				`Write-Result "CheckHNSSubnet" "HNS network $($hns_network.Name) does not have host subnet $subnet, ` +
+					`its subnets are: $($subnets -join ', ')" "" 8`,
+			},
+			notExpected: []string{"$subnets.Count", "$subnet=$subnets[0]"},
+		},
+		{
+			name:        "host subnet not in canonical form",
+			hostSubnet:  "10.132.2.1/24",
+			expectedErr: true,
+		},
+		{
+			name:        "IPv6 host subnet",
+			hostSubnet:  "fd01:0:0:2::/64",
+			expectedErr: true,
+		},
+		{
+			name:        "PowerShell in host subnet",
+			hostSubnet:  "10.132.2.0/24'; Remove-Item c:\\k; '",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				"c:\\k\\cni.conf", CNIConfSettings{HostSubnet: test.hostSubnet})
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for _, expected := range test.expected {
+				assert.Contains(t, actual, expected)
+			}
+			for _, notExpected := range test.notExpected {
+				assert.NotContains(t, actual, notExpected)
+			}
+			// the subnet is selected before it is substituted into the CNI configuration
+			assert.Less(t, strings.Index(actual, "$subnets=@("), strings.Index(actual, `Replace("ovn_host_subnet"`))
+		})
+	}
+}
+
+// TestNetworkScriptsNetworkType ensures bridge networks are configured with the win-bridge plugin, without the provider
+// address policy or the VIP endpoint only overlay networks have
+func TestNetworkScriptsNetworkType(t *testing.T) {
+	testCases := []struct {
+		name        string
+		networkType NetworkType
+		pluginType  string
+		expectedErr bool
+	}{
+		{name: "default", pluginType: "win-overlay"},
+		{name: "overlay", networkType: OverlayNetwork, pluginType: "win-overlay"},
+		{name: "bridge", networkType: BridgeNetwork, pluginType: "win-bridge"},
+		{name: "unknown", networkType: "l2tunnel", expectedErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cniConf, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				"c:\\k\\cni.conf", CNIConfSettings{NetworkType: test.networkType})
+			if test.expectedErr {
+				assert.Error(t, err)
+				_, err = generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+					defaultHNSQueryAttempts, defaultHNSQueryRetryDelay,
+					KubeProxyPrepSettings{NetworkType: test.networkType})
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			kubeProxyPrep, err := generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				defaultHNSQueryAttempts, defaultHNSQueryRetryDelay, KubeProxyPrepSettings{NetworkType: test.networkType})
+			require.NoError(t, err)
+
+			cniConfig := cniConf[strings.Index(cniConf, "@'\n")+3 : strings.Index(cniConf, "'@")]
+			var parsed struct {
+				Type     string `json:"type"`
+				Policies []struct {
+					Value struct {
+						Type string `json:"type"`
+					} `json:"value"`
+				} `json:"policies"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(cniConfig), &parsed))
+			assert.Equal(t, test.pluginType, parsed.Type)
+			var policyTypes []string
+			for _, policy := range parsed.Policies {
+				policyTypes = append(policyTypes, policy.Value.Type)
+			}
+			overlay := test.pluginType == "win-overlay"
+			assert.Equal(t, overlay, slices.Contains(policyTypes, "ProviderAddress"))
+			assert.Equal(t, overlay, strings.Contains(cniConf, "ManagementIP"))
+			assert.Equal(t, overlay, strings.Contains(kubeProxyPrep, "New-HnsEndpoint"))
+			if !overlay {
+				assert.True(t, strings.HasSuffix(kubeProxyPrep, "Write-Result \"\" \"\" \"\" 0\n"))
+			}
+		})
+	}
+
+	// overrides of the provider address and source VIP only apply to overlay networks
+	_, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		"c:\\k\\cni.conf", CNIConfSettings{NetworkType: BridgeNetwork, ProviderAddressOverride: "10.0.128.5"})
+	assert.Error(t, err)
+	_, err = generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1", defaultHNSQueryAttempts,
+		defaultHNSQueryRetryDelay, KubeProxyPrepSettings{NetworkType: BridgeNetwork, SourceVIPOverride: "10.132.0.2"})
+	assert.Error(t, err)
+}
+
+// TestNetworkScriptsLogDir ensures the log directory the scripts were generated with is the default of their -LogDir
+// parameter, which must precede any other statement
+func TestNetworkScriptsLogDir(t *testing.T) {
+	testCases := []struct {
+		name        string
+		logDir      string
+		expectedErr bool
+	}{
+		{name: "unset"},
+		{name: "drive path", logDir: "C:\\var\\log\\"},
+		{name: "UNC path", logDir: "\\\\server\\logs"},
+		{name: "quote in path", logDir: "C:\\node's logs"},
+		{name: "relative path", logDir: "var\\log", expectedErr: true},
+		{name: "newline in path", logDir: "C:\\var\\log\nRemove-Item C:\\", expectedErr: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cniScript, cniErr := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				"c:\\k\\cni.conf", CNIConfSettings{LogDir: test.logDir})
+			kubeProxyScript, kubeProxyErr := generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				defaultHNSQueryAttempts, defaultHNSQueryRetryDelay, KubeProxyPrepSettings{LogDir: test.logDir})
+			if test.expectedErr {
+				assert.Error(t, cniErr)
+				assert.Error(t, kubeProxyErr)
+				return
+			}
+			require.NoError(t, cniErr)
+			require.NoError(t, kubeProxyErr)
+			for name, script := range map[string]string{"cni-conf": cniScript, "kube-proxy-prep": kubeProxyScript} {
+				lines := strings.Split(script, "\n")
+				assert.Equal(t, "param(", lines[1])
+				assert.Contains(t, script, "\n    [string]$LogDir="+psQuote(test.logDir)+",\n")
+				assert.Contains(t, script, fmt.Sprintf("[%s] $message\"\n", name))
+				assert.Contains(t, script, fmt.Sprintf("Join-Path $LogDir '%s'\n", networkScriptLogFile))
+				assert.Contains(t, script, fmt.Sprintf("Length -gt %d)", maxNetworkScriptLogSize))
+			}
+		})
+	}
+}
+
+// TestNetworkScriptsHNSNetworkFallback ensures the HNS network is only selected by type if strict name matching is
+// disabled, using the HNS type of the configured network
+func TestNetworkScriptsHNSNetworkFallback(t *testing.T) {
+	testCases := []struct {
+		name            string
+		networkType     NetworkType
+		strictNameMatch bool
+		expectedType    string
+	}{
+		{name: "overlay", expectedType: "Overlay"},
+		{name: "bridge", networkType: BridgeNetwork, expectedType: "L2Bridge"},
+		{name: "strict name match", strictNameMatch: true},
+		{name: "strict name match on bridge", networkType: BridgeNetwork, strictNameMatch: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cniScript, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				"c:\\k\\cni.conf", CNIConfSettings{NetworkType: test.networkType, StrictNameMatch: test.strictNameMatch})
+			require.NoError(t, err)
+			kubeProxyScript, err := generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				defaultHNSQueryAttempts, defaultHNSQueryRetryDelay,
+				KubeProxyPrepSettings{NetworkType: test.networkType, StrictNameMatch: test.strictNameMatch})
+			require.NoError(t, err)
+			for _, script := range []string{cniScript, kubeProxyScript} {
+				assert.Contains(t, script, "$hns_network=$hns_networks | where { $_.Name -eq $hns_network_name}\n")
+				// the script fails if no network is found either way
+				assert.Contains(t, script, "\"HNS network $hns_network_name not found\" \"\" 2\n")
+				if test.strictNameMatch {
+					assert.NotContains(t, script, "$candidates")
+					continue
+				}
+				assert.Contains(t, script, "$hns_network_type='"+test.expectedType+"'\n")
+				assert.Contains(t, script, "if($candidates.Count -ne 1) {\n")
+				assert.Contains(t, script, "Write-Log \"WARNING: HNS network $hns_network_name not found")
+			}
+		})
+	}
+}
+
+// TestGenerateCNIConfScriptConfList ensures both the single plugin configuration and the configuration list parse as
+// the corresponding CNI configuration types
+func TestGenerateCNIConfScriptConfList(t *testing.T) {
+	// the fields of the libcni NetworkConfig and NetworkConfigList types checked by the test
+	type pluginConf struct {
+		Type         string          `json:"type"`
+		Capabilities map[string]bool `json:"capabilities"`
+		Policies     []interface{}   `json:"policies"`
+	}
+	type netConf struct {
+		pluginConf
+		CNIVersion string `json:"cniVersion"`
+		Name       string `json:"name"`
+	}
+	type netConfList struct {
+		CNIVersion string       `json:"cniVersion"`
+		Name       string       `json:"name"`
+		Plugins    []pluginConf `json:"plugins"`
+	}
+	cniConfig := func(script string) []byte {
+		return []byte(script[strings.Index(script, "@'\n")+3 : strings.Index(script, "'@")])
+	}
+
+	script, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		"c:\\k\\cni.conf", CNIConfSettings{})
+	require.NoError(t, err)
+	var conf netConf
+	require.NoError(t, json.Unmarshal(cniConfig(script), &conf))
+	assert.Equal(t, "0.2.0", conf.CNIVersion)
+	assert.Equal(t, "OVNKubernetesHNSNetwork", conf.Name)
+	assert.Equal(t, "win-overlay", conf.Type)
+	assert.Len(t, conf.Policies, 3)
+
+	script, err = generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		"c:\\k\\cni.conflist", CNIConfSettings{ConfList: true, ChainedPlugins: []CNIPlugin{
+			{Type: "portmap", Capabilities: map[string]bool{"portMappings": true}},
+			{Type: "bandwidth"},
+		}})
+	require.NoError(t, err)
+	var confList netConfList
+	require.NoError(t, json.Unmarshal(cniConfig(script), &confList))
+	assert.Equal(t, "1.0.0", confList.CNIVersion)
+	assert.Equal(t, "OVNKubernetesHNSNetwork", confList.Name)
+	require.Len(t, confList.Plugins, 3)
+	assert.Equal(t, "win-overlay", confList.Plugins[0].Type)
+	assert.Len(t, confList.Plugins[0].Policies, 3)
+	assert.Equal(t, pluginConf{Type: "portmap", Capabilities: map[string]bool{"portMappings": true}},
+		confList.Plugins[1])
+	assert.Equal(t, pluginConf{Type: "bandwidth"}, confList.Plugins[2])
+
+	// a configuration list without chained plugins only holds win-overlay
+	script, err = generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		"c:\\k\\cni.conflist", CNIConfSettings{ConfList: true})
+	require.NoError(t, err)
+	confList = netConfList{}
+	require.NoError(t, json.Unmarshal(cniConfig(script), &confList))
+	require.Len(t, confList.Plugins, 1)
+
+	for _, invalid := range []struct {
+		name          string
+		cniConfigPath string
+		settings      CNIConfSettings
+	}{
+		{name: "conflist path for single plugin", cniConfigPath: "c:\\k\\cni.conflist"},
+		{name: "conf path for configuration list", cniConfigPath: "c:\\k\\cni.conf",
+			settings: CNIConfSettings{ConfList: true}},
+		{name: "chained plugins without configuration list", cniConfigPath: "c:\\k\\cni.conf",
+			settings: CNIConfSettings{ChainedPlugins: []CNIPlugin{{Type: "portmap"}}}},
+		{name: "empty plugin type", cniConfigPath: "c:\\k\\cni.conflist",
+			settings: CNIConfSettings{ConfList: true, ChainedPlugins: []CNIPlugin{{}}}},
+		{name: "plugin type with path", cniConfigPath: "c:\\k\\cni.conflist",
+			settings: CNIConfSettings{ConfList: true, ChainedPlugins: []CNIPlugin{{Type: "..\\portmap"}}}},
+		{name: "chained win-overlay", cniConfigPath: "c:\\k\\cni.conflist",
+			settings: CNIConfSettings{ConfList: true, ChainedPlugins: []CNIPlugin{{Type: "win-overlay"}}}},
+	} {
+		t.Run(invalid.name, func(t *testing.T) {
+			_, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+				invalid.cniConfigPath, invalid.settings)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// parseCNIPolicies parses the CNI configuration embedded in the given script, returning the OutBoundNAT exceptions
+// and the destination prefixes of the SDNRoute policies
+func parseCNIPolicies(t *testing.T, script string) ([]string, []string) {
+	cniConfig := script[strings.Index(script, "@'\n")+3 : strings.Index(script, "'@")]
+	var parsed struct {
+		Policies []struct {
+			Value struct {
+				Type     string `json:"type"`
+				Settings struct {
+					ExceptionList     []string `json:"exceptionList"`
+					DestinationPrefix string   `json:"destinationPrefix"`
+				} `json:"settings"`
+			} `json:"value"`
+		} `json:"policies"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(cniConfig), &parsed))
+	var outboundNATExceptions, sdnRoutePrefixes []string
+	for _, policy := range parsed.Policies {
+		switch policy.Value.Type {
+		case "OutBoundNAT":
+			outboundNATExceptions = append(outboundNATExceptions, policy.Value.Settings.ExceptionList...)
+		case "SDNRoute":
+			sdnRoutePrefixes = append(sdnRoutePrefixes, policy.Value.Settings.DestinationPrefix)
+		}
+	}
+	return outboundNATExceptions, sdnRoutePrefixes
+}
+
+// TestGenerateCNIConfScriptLiteralValues ensures substituted values are never interpreted as part of the template
+func TestGenerateCNIConfScriptLiteralValues(t *testing.T) {
+	actual, err := generateCNIConfScript("10.0.0.1/32", "{{.CNIConfigPath}}", "c:\\k\\hns.psm1",
+		"c:\\k\\cni.conf", CNIConfSettings{})
+	require.NoError(t, err)
+	assert.Contains(t, actual, `"name":"{{.CNIConfigPath}}",`)
+	assert.Contains(t, actual, "$hns_network_name='{{.CNIConfigPath}}'\n")
+}
+
+func TestPSQuote(t *testing.T) {
+	testCases := []struct {
+		value    string
+		expected string
+	}{
+		{value: "", expected: "''"},
+		{value: "c:\\Program Files\\k", expected: "'c:\\Program Files\\k'"},
+		{value: "$env:FOO", expected: "'$env:FOO'"},
+		{value: "$(Remove-Item c:\\k)", expected: "'$(Remove-Item c:\\k)'"},
+		{value: "it's", expected: "'it''s'"},
+		{value: `"double"`, expected: `'"double"'`},
+		{value: "\u2018smart\u2019", expected: "'\u2018\u2018smart\u2019\u2019'"},
+		{value: "nœud-ノード", expected: "'nœud-ノード'"},
+	}
+	for _, test := range testCases {
+		t.Run(test.value, func(t *testing.T) {
+			assert.Equal(t, test.expected, psQuote(test.value))
+		})
+	}
+}
+
+// TestNetworkScriptsAdversarialValues ensures arbitrary values are substituted into the network scripts intact, and
+// values which cannot be safely substituted are rejected
+func TestNetworkScriptsAdversarialValues(t *testing.T) {
+	testCases := []struct {
+		name           string
+		hnsNetworkName string
+		cniConfigPath  string
+		expectedErr    bool
+	}{
+		{
+			name:           "spaces",
+			hnsNetworkName: "hybrid overlay network",
+			cniConfigPath:  "c:\\Program Files\\k\\cni.conf",
+		},
+		{
+			name:           "variables",
+			hnsNetworkName: "$env:FOO",
+			cniConfigPath:  "c:\\k\\$env:FOO\\cni.conf",
+		},
+		{
+			name:           "quotes",
+			hnsNetworkName: `it's a "network"`,
+			cniConfigPath:  "c:\\k\\it's\\cni.conf",
+		},
+		{
+			name:           "typographic quotes",
+			hnsNetworkName: "\u2018network\u2019",
+			cniConfigPath:  "c:\\k\\cni.conf",
+		},
+		{
+			name:           "non-ASCII",
+			hnsNetworkName: "réseau-ネットワーク",
+			cniConfigPath:  "c:\\k\\réseau\\cni.conf",
+		},
+		{
+			name:           "wildcard characters",
+			hnsNetworkName: "network[0]*",
+			cniConfigPath:  "c:\\k\\[cni]\\cni.conf",
+		},
+		{
+			name:           "newline in network name",
+			hnsNetworkName: "network\n'@\nRemove-Item c:\\k",
+			cniConfigPath:  "c:\\k\\cni.conf",
+			expectedErr:    true,
+		},
+		{
+			name:           "carriage return in CNI config path",
+			hnsNetworkName: "network",
+			cniConfigPath:  "c:\\k\\cni.conf\r",
+			expectedErr:    true,
+		},
+		{
+			name:           "backtick",
+			hnsNetworkName: "network`",
+			cniConfigPath:  "c:\\k\\cni.conf",
+			expectedErr:    true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cniScript, err := generateCNIConfScript("172.30.0.0/16", test.hnsNetworkName, "c:\\k\\hns.psm1",
+				test.cniConfigPath, CNIConfSettings{})
+			_, kubeProxyErr := generateKubeProxyPrepScript(test.hnsNetworkName, "c:\\k\\hns.psm1",
+				defaultHNSQueryAttempts, defaultHNSQueryRetryDelay, KubeProxyPrepSettings{})
+			if test.expectedErr {
+				assert.Error(t, err)
+				if test.hnsNetworkName != "network" {
+					assert.Error(t, kubeProxyErr)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, kubeProxyErr)
+			assert.Contains(t, cniScript, "$hns_network_name="+psQuote(test.hnsNetworkName)+"\n")
+			assert.Contains(t, cniScript, "$cni_config_path="+psQuote(test.cniConfigPath)+"\n")
+
+			cniConfig := cniScript[strings.Index(cniScript, "@'\n")+3 : strings.Index(cniScript, "'@")]
+			var parsed struct {
+				Name string `json:"name"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(cniConfig), &parsed))
+			assert.Equal(t, test.hnsNetworkName, parsed.Name)
+		})
+	}
+}
+
+func TestGenerateCNIConfScriptInvalidInputs(t *testing.T) {
+	testCases := []struct {
+		name            string
+		clusterCIDR     string
+		hnsNetworkName  string
+		hnsPSModulePath string
+		cniConfigPath   string
+		expectedErr     string
+	}{
+		{
+			name:            "valid CIDR list",
+			clusterCIDR:     "172.30.0.0/16,fd02::/112",
+			hnsNetworkName:  "OVNKubernetesHNSNetwork",
+			hnsPSModulePath: "\\\\server\\share\\hns.psm1",
+			cniConfigPath:   "c:\\k\\cni.conf",
+		},
+		{
+			name:            "empty cluster CIDR",
+			hnsNetworkName:  "OVNKubernetesHNSNetwork",
+			hnsPSModulePath: "c:\\k\\hns.psm1",
+			cniConfigPath:   "c:\\k\\cni.conf",
+			expectedErr:     "clusterCIDR",
+		},
+		{
+			name:            "invalid cluster CIDR",
+			clusterCIDR:     "172.30.0.0/16,172.300.0.0/16",
+			hnsNetworkName:  "OVNKubernetesHNSNetwork",
+			hnsPSModulePath: "c:\\k\\hns.psm1",
+			cniConfigPath:   "c:\\k\\cni.conf",
+			expectedErr:     "clusterCIDR",
+		},
+		{
+			name:            "two IPv4 cluster CIDRs",
+			clusterCIDR:     "172.30.0.0/16,10.0.0.0/16",
+			hnsNetworkName:  "OVNKubernetesHNSNetwork",
+			hnsPSModulePath: "c:\\k\\hns.psm1",
+			cniConfigPath:   "c:\\k\\cni.conf",
+			expectedErr:     "one CIDR per IP family",
+		},
+		{
+			name:            "three cluster CIDRs",
+			clusterCIDR:     "172.30.0.0/16,fd02::/112,fd03::/112",
+			hnsNetworkName:  "OVNKubernetesHNSNetwork",
+			hnsPSModulePath: "c:\\k\\hns.psm1",
+			cniConfigPath:   "c:\\k\\cni.conf",
+			expectedErr:     "one CIDR per IP family",
+		},
+		{
+			name:            "empty HNS network name",
+			clusterCIDR:     "172.30.0.0/16",
+			hnsNetworkName:  " ",
+			hnsPSModulePath: "c:\\k\\hns.psm1",
+			cniConfigPath:   "c:\\k\\cni.conf",
+			expectedErr:     "hnsNetworkName",
+		},
+		{
+			name:            "linux HNS module path",
+			clusterCIDR:     "172.30.0.0/16",
+			hnsNetworkName:  "OVNKubernetesHNSNetwork",
+			hnsPSModulePath: "/payload/powershell/hns.psm1",
+			cniConfigPath:   "c:\\k\\cni.conf",
+			expectedErr:     "hnsPSModulePath",
+		},
+		{
+			name:            "empty CNI config path",
+			clusterCIDR:     "172.30.0.0/16",
+			hnsNetworkName:  "OVNKubernetesHNSNetwork",
+			hnsPSModulePath: "c:\\k\\hns.psm1",
+			expectedErr:     "cniConfigPath",
+		},
+		{
+			name:            "template syntax in substituted value",
+			clusterCIDR:     "172.30.0.0/16",
+			hnsNetworkName:  "{{.HNSModulePath}}",
+			hnsPSModulePath: "c:\\k\\hns.psm1",
+			cniConfigPath:   "c:\\k\\cni.conf",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := generateCNIConfScript(test.clusterCIDR, test.hnsNetworkName, test.hnsPSModulePath,
+				test.cniConfigPath, CNIConfSettings{})
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedErr)
+		})
+	}
+}
+
+// withClock configures generated scripts to be stamped with the given time
+func withClock(now time.Time) Option {
+	return func(o *options) {
+		o.now = func() time.Time { return now }
+	}
+}
+
+func TestPopulateCNIConfScript(t *testing.T) {
+	generatedAt := time.Date(2023, 5, 4, 13, 27, 45, 0, time.UTC)
+	opts := []Option{WithOperatorVersion("9.0.0-abcdef"), withClock(generatedAt)}
+	script, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHybridOverlayNetwork",
+		"c:\\k\\hns.psm1", "c:\\k\\cni\\config\\cni.conf", CNIConfSettings{})
+	require.NoError(t, err)
+	header, err := scriptHeader([]interface{}{"172.30.0.0/16", "OVNKubernetesHybridOverlayNetwork", "c:\\k\\hns.psm1",
+		"c:\\k\\cni\\config\\cni.conf", CNIConfSettings{}}, newOptions(opts))
+	require.NoError(t, err)
+	expected := header + script
+	earlier := strings.Replace(expected, "2023-05-04T13:00:00Z", "2023-05-01T08:00:00Z", 1)
+	scriptPath := toFSPath(CNIConfigurationScript)
+
+	testCases := []struct {
+		name             string
+		existing         *fstest.MapFile
+		expectedChanged  bool
+		expectedContents string
+	}{
+		{
+			name:             "first time generation",
+			expectedChanged:  true,
+			expectedContents: expected,
+		},
+		{
+			name:             "identical contents",
+			existing:         &fstest.MapFile{Data: []byte(expected)},
+			expectedContents: expected,
+		},
+		{
+			name:             "generation time only difference",
+			existing:         &fstest.MapFile{Data: []byte(earlier)},
+			expectedContents: earlier,
+		},
+		{
+			name:             "whitespace only difference",
+			existing:         &fstest.MapFile{Data: []byte(expected + "\n")},
+			expectedChanged:  true,
+			expectedContents: expected,
+		},
+		{
+			name:             "different contents",
+			existing:         &fstest.MapFile{Data: []byte("outdated")},
+			expectedChanged:  true,
+			expectedContents: expected,
+		},
+		{
+			name:             "unreadable existing file",
+			existing:         &fstest.MapFile{Mode: fs.ModeDir},
+			expectedChanged:  true,
+			expectedContents: expected,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := writableMapFS{fstest.MapFS{}}
+			if test.existing != nil {
+				fsys.MapFS[scriptPath] = test.existing
+			}
+			fileInfo, changed, err := PopulateCNIConfScript("172.30.0.0/16", "OVNKubernetesHybridOverlayNetwork",
+				"c:\\k\\hns.psm1", "c:\\k\\cni\\config\\cni.conf", CNIConfSettings{}, append(opts, WithFS(fsys))...)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedChanged, changed)
+			contents, err := fs.ReadFile(fsys, scriptPath)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedContents, string(contents))
+			// the returned FileInfo describes the script on disk
+			assert.Equal(t, CNIConfigurationScript, fileInfo.Path)
+			assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(contents)), fileInfo.Checksum())
+		})
+	}
+
+	// a read-only file system cannot hold generated files
+	_, _, err = PopulateCNIConfScript("172.30.0.0/16", "OVNKubernetesHybridOverlayNetwork",
+		"c:\\k\\hns.psm1", "c:\\k\\cni\\config\\cni.conf", CNIConfSettings{}, WithFS(fstest.MapFS{}))
+	assert.Error(t, err)
+}
+
+// TestScriptHeader ensures the header identifies the operator version and inputs a script was generated from, and
+// only changes with the hour of the generation time
+func TestScriptHeader(t *testing.T) {
+	generatedAt := time.Date(2023, 5, 4, 13, 27, 45, 0, time.FixedZone("UTC+2", 2*60*60))
+	inputs := []interface{}{"172.30.0.0/16", CNIConfSettings{MTU: 1400}}
+	header, err := scriptHeader(inputs, newOptions([]Option{WithOperatorVersion("9.0.0"), withClock(generatedAt)}))
+	require.NoError(t, err)
+	lines := strings.Split(header, "\n")
+	require.Len(t, lines, 6)
+	assert.True(t, strings.HasPrefix(lines[0], "# "))
+	assert.Equal(t, "# Operator version: 9.0.0", lines[1])
+	assert.Regexp(t, "^# Inputs SHA256: [0-9a-f]{64}$", lines[2])
+	assert.Equal(t, "# Generated at: 2023-05-04T11:00:00Z", lines[3])
+	assert.Equal(t, []string{"", ""}, lines[4:])
+
+	// re-rendering within the same hour gives the same header
+	later, err := scriptHeader(inputs, newOptions([]Option{WithOperatorVersion("9.0.0"),
+		withClock(generatedAt.Add(30 * time.Minute))}))
+	require.NoError(t, err)
+	assert.Equal(t, header, later)
+
+	// the hash changes with the inputs
+	changedInputs, err := scriptHeader([]interface{}{"172.30.0.0/16", CNIConfSettings{MTU: 1450}},
+		newOptions([]Option{WithOperatorVersion("9.0.0"), withClock(generatedAt)}))
+	require.NoError(t, err)
+	assert.NotEqual(t, lines[2], strings.Split(changedInputs, "\n")[2])
+
+	// an unset operator version is recorded as unknown
+	unversioned, err := scriptHeader(inputs, newOptions([]Option{withClock(generatedAt)}))
+	require.NoError(t, err)
+	assert.Contains(t, unversioned, "# Operator version: unknown\n")
+}
+
+// TestPopulateNetworkConfScript ensures the deprecated wrapper generates both network scripts
+func TestPopulateNetworkConfScript(t *testing.T) {
+	fsys := writableMapFS{fstest.MapFS{}}
+	changed, err := PopulateNetworkConfScript("172.30.0.0/16", "OVNKubernetesHybridOverlayNetwork",
+		"c:\\k\\hns.psm1", "c:\\k\\cni\\config\\cni.conf", WithFS(fsys))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	for _, path := range []string{CNIConfigurationScript, KubeProxyPrepScript} {
+		_, err := fs.ReadFile(fsys, toFSPath(path))
+		assert.NoError(t, err)
+	}
+
+	// only the kube-proxy preparation script is regenerated
+	delete(fsys.MapFS, toFSPath(KubeProxyPrepScript))
+	changed, err = PopulateNetworkConfScript("172.30.0.0/16", "OVNKubernetesHybridOverlayNetwork",
+		"c:\\k\\hns.psm1", "c:\\k\\cni\\config\\cni.conf", WithFS(fsys))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	_, changed, err = PopulateCNIConfScript("172.30.0.0/16", "OVNKubernetesHybridOverlayNetwork",
+		"c:\\k\\hns.psm1", "c:\\k\\cni\\config\\cni.conf", CNIConfSettings{}, WithFS(fsys))
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+// TestNetworkScriptsNetworkName ensures both network scripts reference the HNS network they were generated with
+func TestNetworkScriptsNetworkName(t *testing.T) {
+	cniScript, err := generateCNIConfScript("172.30.0.0/16", "CustomHybridOverlayNetwork", "c:\\k\\hns.psm1",
+		"c:\\k\\cni.conf", CNIConfSettings{})
+	require.NoError(t, err)
+	kubeProxyScript, err := generateKubeProxyPrepScript("CustomHybridOverlayNetwork", "c:\\k\\hns.psm1",
+		defaultHNSQueryAttempts, defaultHNSQueryRetryDelay, KubeProxyPrepSettings{})
+	require.NoError(t, err)
+	for _, script := range []string{cniScript, kubeProxyScript} {
+		assert.Contains(t, script, "$hns_network_name='CustomHybridOverlayNetwork'\n")
+		assert.NotContains(t, script, "OVNKubernetes")
+	}
+	assert.Contains(t, cniScript, `"name":"CustomHybridOverlayNetwork",`)
+}
+
+// scriptHashRegex matches the hash embedded in the idempotent network scripts
+var scriptHashRegex = regexp.MustCompile(`\$script_hash='([0-9a-f]*)'`)
+
+// scriptHash returns the hash embedded in the given idempotent network script
+func scriptHash(t *testing.T, script string) string {
+	matches := scriptHashRegex.FindAllStringSubmatch(script, -1)
+	require.Len(t, matches, 1)
+	return matches[0][1]
+}
+
+// withoutScriptHash returns the given script with its embedded hash removed, as it was rendered to compute the hash
+func withoutScriptHash(script string) string {
+	return scriptHashRegex.ReplaceAllString(script, "$$script_hash=''")
+}
+
+// TestNetworkScriptsIdempotence ensures the network scripts run before kube-proxy are skipped when they already
+// succeeded with the same contents and HNS network, while the preflight script always runs
+func TestNetworkScriptsIdempotence(t *testing.T) {
+	cniScript, err := generateCNIConfScript("172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		"c:\\k\\cni.conf", CNIConfSettings{})
+	require.NoError(t, err)
+	kubeProxyScript, err := generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		defaultHNSQueryAttempts, defaultHNSQueryRetryDelay, KubeProxyPrepSettings{})
+	require.NoError(t, err)
+	for script, firstChange := range map[string]string{cniScript: "$cni_template=@'", kubeProxyScript: "$endpoints="} {
+		assert.Contains(t, script, "    [switch]$Force\n)\n")
+		assert.Regexp(t, `\$script_hash='[0-9a-f]{64}'\n`, script)
+		assert.Contains(t, script, "if($marker_path -and -not $Force -and (Test-Path -LiteralPath $marker_path)) {\n")
+		// the marker is only checked once the HNS network is known, and before anything is changed
+		fastPath := strings.Index(script, `Write-Result "" "" ([string]$marker.sourceVip) 0`)
+		assert.Greater(t, fastPath, strings.Index(script, "HNS network $hns_network_name not found\""))
+		assert.Less(t, fastPath, strings.Index(script, firstChange))
+		// the script hash is the hash of the script without it
+		assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte(withoutScriptHash(script)))), scriptHash(t, script))
+	}
+
+	// the fast path is only taken if the outputs of the last successful run are unchanged
+	for script, outputsCheck := range map[string]string{
+		cniScript:       "(Get-ContentHash $existing_config.Replace(",
+		kubeProxyScript: `Write-Log "VIPEndpoint no longer has source VIP $($marker.sourceVip)"`,
+	} {
+		check := strings.Index(script, outputsCheck)
+		assert.Greater(t, check, strings.Index(script, "$marker_current=($marker.hash -eq $script_hash)"))
+		assert.Less(t, check, strings.Index(script, `Write-Result "" "" ([string]$marker.sourceVip) 0`))
+		assert.Contains(t, script, "($marker.networkState -eq $network_state)")
+	}
+	assert.Contains(t, cniScript, "$marker_output_hash=Get-ContentHash $cni_template\n")
+	// an externally managed source VIP has no VIP endpoint to check
+	overrideScript, err := generateKubeProxyPrepScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1",
+		defaultHNSQueryAttempts, defaultHNSQueryRetryDelay, KubeProxyPrepSettings{SourceVIPOverride: "10.132.0.2"})
+	require.NoError(t, err)
+	assert.NotContains(t, overrideScript, "VIPEndpoint no longer has source VIP")
+
+	preflightScript, err := generatePreflightScript("OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1", PreflightSettings{})
+	require.NoError(t, err)
+	assert.NotContains(t, preflightScript, "$Force")
+	assert.NotContains(t, preflightScript, "marker")
+}
+
+// TestNetworkScriptsHashInputs ensures the embedded script hash changes whenever any input of the network scripts
+// changes, so that a changed script is never skipped
+func TestNetworkScriptsHashInputs(t *testing.T) {
+	type cniInputs struct {
+		clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath string
+		settings                                                    CNIConfSettings
+	}
+	cniTestCases := map[string]func(*cniInputs){
+		"clusterCIDR":     func(i *cniInputs) { i.clusterCIDR = "172.31.0.0/16" },
+		"hnsNetworkName":  func(i *cniInputs) { i.hnsNetworkName = "CustomHybridOverlayNetwork" },
+		"hnsPSModulePath": func(i *cniInputs) { i.hnsPSModulePath = "c:\\k\\hns2.psm1" },
+		"cniConfigPath":   func(i *cniInputs) { i.cniConfigPath = "c:\\k\\cni\\cni.conf" },
+		"ExtraOutboundNATExceptions": func(i *cniInputs) {
+			i.settings.ExtraOutboundNATExceptions = []string{"10.10.0.0/16"}
+		},
+		"RouteExtraOutboundNATExceptions": func(i *cniInputs) {
+			i.settings.ExtraOutboundNATExceptions = []string{"10.10.0.0/16"}
+			i.settings.RouteExtraOutboundNATExceptions = true
+		},
+		"MTU":                     func(i *cniInputs) { i.settings.MTU = 1400 },
+		"HostSubnet":              func(i *cniInputs) { i.settings.HostSubnet = "10.132.2.0/24" },
+		"ProviderAddressOverride": func(i *cniInputs) { i.settings.ProviderAddressOverride = "10.0.128.5" },
+		"ConfList": func(i *cniInputs) {
+			i.cniConfigPath = "c:\\k\\cni.conflist"
+			i.settings.ConfList = true
+		},
+		"ChainedPlugins": func(i *cniInputs) {
+			i.cniConfigPath = "c:\\k\\cni.conflist"
+			i.settings.ConfList = true
+			i.settings.ChainedPlugins = []CNIPlugin{{Type: "portmap"}}
+		},
+		"NetworkType":     func(i *cniInputs) { i.settings.NetworkType = BridgeNetwork },
+		"StrictNameMatch": func(i *cniInputs) { i.settings.StrictNameMatch = true },
+		"LogDir":          func(i *cniInputs) { i.settings.LogDir = "C:\\var\\log\\" },
+		"Debug":           func(i *cniInputs) { i.settings.Debug = true },
+	}
+	// every setting must be covered
+	settingsType := reflect.TypeOf(CNIConfSettings{})
+	for i := 0; i < settingsType.NumField(); i++ {
+		assert.Contains(t, cniTestCases, settingsType.Field(i).Name)
+	}
+	hashes := make(map[string]string)
+	generate := func(mutate func(*cniInputs)) string {
+		inputs := cniInputs{"172.30.0.0/16", "OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1", "c:\\k\\cni.conf",
+			CNIConfSettings{}}
+		if mutate != nil {
+			mutate(&inputs)
+		}
+		script, err := generateCNIConfScript(inputs.clusterCIDR, inputs.hnsNetworkName, inputs.hnsPSModulePath,
+			inputs.cniConfigPath, inputs.settings)
+		require.NoError(t, err)
+		return scriptHash(t, script)
+	}
+	hashes[generate(nil)] = "default"
+	assert.Equal(t, generate(nil), generate(nil))
+	for name, mutate := range cniTestCases {
+		t.Run("cni-conf "+name, func(t *testing.T) {
+			hash := generate(mutate)
+			assert.NotContains(t, hashes, hash, "same hash as %s", hashes[hash])
+			hashes[hash] = name
+		})
+	}
+
+	type kubeProxyInputs struct {
+		hnsNetworkName, hnsPSModulePath string
+		hnsQueryAttempts                int
+		hnsQueryRetryDelay              time.Duration
+		settings                        KubeProxyPrepSettings
+	}
+	kubeProxyTestCases := map[string]func(*kubeProxyInputs){
+		"hnsNetworkName":     func(i *kubeProxyInputs) { i.hnsNetworkName = "CustomHybridOverlayNetwork" },
+		"hnsPSModulePath":    func(i *kubeProxyInputs) { i.hnsPSModulePath = "c:\\k\\hns2.psm1" },
+		"hnsQueryAttempts":   func(i *kubeProxyInputs) { i.hnsQueryAttempts = 3 },
+		"hnsQueryRetryDelay": func(i *kubeProxyInputs) { i.hnsQueryRetryDelay = time.Second },
+		"SourceVIPOverride":  func(i *kubeProxyInputs) { i.settings.SourceVIPOverride = "10.132.0.2" },
+		"NetworkType":        func(i *kubeProxyInputs) { i.settings.NetworkType = BridgeNetwork },
+		"StrictNameMatch":    func(i *kubeProxyInputs) { i.settings.StrictNameMatch = true },
+		"LogDir":             func(i *kubeProxyInputs) { i.settings.LogDir = "C:\\var\\log\\" },
+	}
+	settingsType = reflect.TypeOf(KubeProxyPrepSettings{})
+	for i := 0; i < settingsType.NumField(); i++ {
+		assert.Contains(t, kubeProxyTestCases, settingsType.Field(i).Name)
+	}
+	generateKubeProxy := func(mutate func(*kubeProxyInputs)) string {
+		inputs := kubeProxyInputs{"OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1", defaultHNSQueryAttempts,
+			defaultHNSQueryRetryDelay, KubeProxyPrepSettings{}}
+		if mutate != nil {
+			mutate(&inputs)
+		}
+		script, err := generateKubeProxyPrepScript(inputs.hnsNetworkName, inputs.hnsPSModulePath,
+			inputs.hnsQueryAttempts, inputs.hnsQueryRetryDelay, inputs.settings)
+		require.NoError(t, err)
+		return scriptHash(t, script)
+	}
+	hashes[generateKubeProxy(nil)] = "kube-proxy-prep default"
+	for name, mutate := range kubeProxyTestCases {
+		t.Run("kube-proxy-prep "+name, func(t *testing.T) {
+			hash := generateKubeProxy(mutate)
+			assert.NotContains(t, hashes, hash, "same hash as %s", hashes[hash])
+			hashes[hash] = name
+		})
+	}
+}
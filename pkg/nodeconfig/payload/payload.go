@@ -2,204 +2,729 @@ package payload
 
 import (
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"io/fs"
-	"io/ioutil"
+	"path"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+
+	configv1 "github.com/openshift/api/config/v1"
 )
 
-// Payload files
+// defaultPayloadDirectory is the directory in the operator image where are all the binaries live
+const defaultPayloadDirectory = "/payload/"
+
+// payloadDirectory is the directory all payload paths are within, with a trailing slash. It can be overridden with
+// SetBaseDir.
+var payloadDirectory = defaultPayloadDirectory
+
+// Payload file names
 const (
-	// payloadDirectory is the directory in the operator image where are all the binaries live
-	payloadDirectory = "/payload/"
+	// GcpGetHostnameScriptName is the name of the PowerShell script that resolves the hostname for GCP instances
+	GcpGetHostnameScriptName = "gcp-get-hostname.ps1"
+	// WinDefenderExclusionScriptName is the name of the PowerShell script that creates exclusions for the given
+	// executables if the Windows Defender Antivirus is active
+	WinDefenderExclusionScriptName = "windows-defender-exclusion.ps1"
+	// HybridOverlayName is the name of the hybrid overlay executable
+	HybridOverlayName = "hybrid-overlay-node.exe"
+	// WindowsExporterName is the name of the Windows metrics exporter executable
+	WindowsExporterName = "windows_exporter.exe"
+	// AzureCloudNodeManager is the name of the cloud node manager for Azure platform
+	AzureCloudNodeManager = "azure-cloud-node-manager.exe"
+	// cniDirectory is the directory for storing the CNI plugins and the CNI config template
+	cniDirectory = "cni"
+	// powershellDirectory is the directory for storing the PowerShell scripts and modules
+	powershellDirectory = "powershell"
+	// generatedDirectoryName is the directory for storing the files generated by the operator at runtime
+	generatedDirectoryName = "generated"
+)
+
+// Payload files
+var (
 	// WICDPath is the path to the Windows Instance Config Daemon exe
-	WICDPath = payloadDirectory + "windows-instance-config-daemon.exe"
+	WICDPath = payloadPath("windows-instance-config-daemon.exe")
 	// KubeletPath contains the path of the kubelet binary. The container image should already have this binary mounted
-	KubeletPath = payloadDirectory + "/kube-node/kubelet.exe"
+	KubeletPath = payloadPath("kube-node", "kubelet.exe")
 	// KubeProxyPath contains the path of the kube-proxy binary. The container image should already have this binary
 	// mounted
-	KubeProxyPath = payloadDirectory + "/kube-node/kube-proxy.exe"
+	KubeProxyPath = payloadPath("kube-node", "kube-proxy.exe")
 	// KubeLogRunnerPath contains the path of the kube-log-runner binary.
-	KubeLogRunnerPath = payloadDirectory + "/kube-node/kube-log-runner.exe"
+	KubeLogRunnerPath = payloadPath("kube-node", "kube-log-runner.exe")
 	// ContainerdPath contains the path of the containerd binary. The container image should already have this binary
 	// mounted
-	ContainerdPath = payloadDirectory + "/containerd/containerd.exe"
-	//HcsshimPath contains the path of the hcsshim binary. The container image should already have this binary mounted
-	HcsshimPath = payloadDirectory + "/containerd/containerd-shim-runhcs-v1.exe"
+	ContainerdPath = payloadPath("containerd", "containerd.exe")
+	// HcsshimPath contains the path of the hcsshim binary. The container image should already have this binary mounted
+	HcsshimPath = payloadPath("containerd", "containerd-shim-runhcs-v1.exe")
 	// ContainerdConfPath contains the path of the containerd config file.
-	ContainerdConfPath = payloadDirectory + "/containerd/containerd_conf.toml"
-	// GcpGetHostnameScriptName is the name of the PowerShell script that resolves the hostname for GCP instances
-	GcpGetHostnameScriptName = "gcp-get-hostname.ps1"
-	// GcpGetValidHostnameScriptPath is the path of the PowerShell script that resolves the hostname for GCP instances
-	GcpGetValidHostnameScriptPath = payloadDirectory + "/powershell/" + GcpGetHostnameScriptName
-	// WinDefenderExclusionScriptName is the name of the PowerShell script that creates an exclusion for containerd if
-	// the Windows Defender Antivirus is active
-	WinDefenderExclusionScriptName = "windows-defender-exclusion.ps1"
-	// WinDefenderExclusionScriptPath is the path of the PowerShell script that creates an exclusion for containerd if
-	// the Windows Defender Antivirus is active
-	WinDefenderExclusionScriptPath = payloadDirectory + "/powershell/" + WinDefenderExclusionScriptName
+	ContainerdConfPath = payloadPath("containerd", "containerd_conf.toml")
+	// WinDefenderExclusionScriptPath is the path of the PowerShell script that creates exclusions for the given
+	// executables if the Windows Defender Antivirus is active
+	WinDefenderExclusionScriptPath = payloadPath(powershellDirectory, WinDefenderExclusionScriptName)
 	// HNSPSModule is the path to the powershell module which defines various functions for dealing with Windows HNS
 	// networks
-	HNSPSModule = payloadDirectory + "/powershell/hns.psm1"
-	// cniDirectory is the directory for storing the CNI plugins and the CNI config template
-	cniDirectory = "/cni/"
+	HNSPSModule = payloadPath(powershellDirectory, "hns.psm1")
 	// HostLocalCNIPlugin is the path of the host-local CNI plugin binary. The container image should already have
 	// this binary mounted
-	HostLocalCNIPlugin = payloadDirectory + cniDirectory + "host-local.exe"
+	HostLocalCNIPlugin = payloadPath(cniDirectory, "host-local.exe")
 	// WinBridgeCNIPlugin is the path of the win-bridge CNI plugin binary. The container image should already have
 	// this binary mounted
-	WinBridgeCNIPlugin = payloadDirectory + cniDirectory + "win-bridge.exe"
+	WinBridgeCNIPlugin = payloadPath(cniDirectory, "win-bridge.exe")
 	// WinOverlayCNIPlugin is the path of the win-overlay CNI Plugin binary. The container image should already have
 	// this binary mounted
-	WinOverlayCNIPlugin = payloadDirectory + cniDirectory + "win-overlay.exe"
-	// NetworkConfigurationScript is the path for generated Network configuration Script
-	NetworkConfigurationScript = payloadDirectory + "/generated/network-conf.ps1"
-	// HybridOverlayName is the name of the hybrid overlay executable
-	HybridOverlayName = "hybrid-overlay-node.exe"
+	WinOverlayCNIPlugin = payloadPath(cniDirectory, "win-overlay.exe")
+	// CNIConfigurationScript is the path of the generated script which renders the CNI configuration
+	CNIConfigurationScript = payloadPath(generatedDirectoryName, "cni-conf.ps1")
+	// KubeProxyPrepScript is the path of the generated script which ensures the HNS endpoint used as the kube-proxy
+	// source VIP exists, and returns its IP
+	KubeProxyPrepScript = payloadPath(generatedDirectoryName, "kube-proxy-prep.ps1")
+	// PreflightScript is the path of the generated script which waits for the HNS network to be ready for the other
+	// network scripts
+	PreflightScript = payloadPath(generatedDirectoryName, "network-preflight.ps1")
+	// HNSCleanupScript is the path of the generated script which removes the HNS endpoints created for the Windows
+	// node when it is deconfigured
+	HNSCleanupScript = payloadPath(generatedDirectoryName, "hns-cleanup.ps1")
+	// GcpGetValidHostnameScriptPath is the path of the generated script that resolves the hostname for GCP instances
+	GcpGetValidHostnameScriptPath = payloadPath(generatedDirectoryName, GcpGetHostnameScriptName)
 	// HybridOverlayPath contains the path of the hybrid overlay binary. The container image should already have this
 	// binary mounted
-	HybridOverlayPath = payloadDirectory + HybridOverlayName
+	HybridOverlayPath = payloadPath(HybridOverlayName)
 	// CSIProxyPath contains the path of the csi-proxy executable. This should be mounted in the container image.
-	CSIProxyPath = payloadDirectory + "csi-proxy/csi-proxy.exe"
-	// WindowsExporterName is the name of the Windows metrics exporter executable
-	WindowsExporterName = "windows_exporter.exe"
+	CSIProxyPath = payloadPath("csi-proxy", "csi-proxy.exe")
 	// WindowsExporterPath contains the path of the windows_exporter binary. The container image should already have
 	// this binary mounted
-	WindowsExporterPath = payloadDirectory + WindowsExporterName
-	// AzureCloudNodeManager is the name of the cloud node manager for Azure platform
-	AzureCloudNodeManager = "azure-cloud-node-manager.exe"
+	WindowsExporterPath = payloadPath(WindowsExporterName)
 	// AzureCloudNodeManagerPath contains the path of the azure cloud node manager binary. The container image should
 	// already have this binary mounted
-	AzureCloudNodeManagerPath = payloadDirectory + AzureCloudNodeManager
-	// TODO: This script is doing both CNI configuration and HNS endpoint creation, two things that aren't necessarily
-	//       related. Correct that in: https://issues.redhat.com/browse/WINC-882
-	// networkConfTemplate is the template used to generate the network configuration script
-	networkConfTemplate = `# This script ensures the contents of the CNI config file is correct, and returns the HNS endpoint IP
-$ErrorActionPreference = "Stop"
-Import-Module -DisableNameChecking HNS_MODULE_PATH
-
-$cni_template=@'
-{
-    "cniVersion":"0.2.0",
-    "name":"HNS_NETWORK",
-    "type":"win-overlay",
-    "apiVersion": 2,
-    "capabilities":{
-        "portMappings": true,
-        "dns":true
-    },
-    "ipam":{
-        "type":"host-local",
-        "subnet":"ovn_host_subnet"
-    },
-    "policies":[
-    {
-        "name": "EndpointPolicy",
-        "value": {
-            "type": "OutBoundNAT",
-            "settings": {
-                "exceptionList": [
-                "SERVICE_NETWORK_CIDR"
-                ],
-                "destinationPrefix": "",
-                "needEncap": false
-            }
-        }
-    },
-    {
-        "name": "EndpointPolicy",
-        "value": {
-            "type": "SDNRoute",
-            "settings": {
-                "exceptionList": [],
-                "destinationPrefix": "SERVICE_NETWORK_CIDR",
-                "needEncap": true
-            }
-        }
-    },
-    {
-        "name": "EndpointPolicy",
-        "value": {
-            "type": "ProviderAddress",
-            "settings": {
-                "providerAddress": "provider_address"
-            }
-        }
-    }
-    ]
-}
-'@
-
-# Generate CNI Config
-$hns_network=Get-HnsNetwork  | where { $_.Name -eq 'HNS_NETWORK'}
-$subnet=$hns_network.Subnets.AddressPrefix
-$cni_template=$cni_template.Replace("ovn_host_subnet",$subnet)
-$provider_address=$hns_network.ManagementIP
-$cni_template=$cni_template.Replace("provider_address",$provider_address)
-
-# Compare CNI config with existing file, and replace if necessary
-$existing_config=""
-if(Test-Path -Path CNI_CONFIG_PATH) {
-    $config_file_content=(Get-Content -Path CNI_CONFIG_PATH -Raw)
-    if($config_file_content -ne $null) {
-` + "        $existing_config=$config_file_content.Replace(\"`r\",\"\")" + `
-    }
-}
-if($existing_config -ne $cni_template){
-    Set-Content -Path "CNI_CONFIG_PATH" -Value $cni_template -NoNewline
-}
-
-# Create HNS endpoint if it doesn't exist
-$endpoint = Invoke-HNSRequest GET endpoints | where { $_.Name -eq 'VIPEndpoint'}
-if( $endpoint -eq $null) {
-    $endpoint = New-HnsEndpoint -NetworkId $hns_network.ID -Name "VIPEndpoint"
-    Attach-HNSHostEndpoint -EndpointID $endpoint.ID -CompartmentID 1
-}
-
-# Return HNS endpoint IP
-(Get-NetIPConfiguration -AllCompartments -All -Detailed | where { $_.NetAdapter.LinkLayerAddress -eq $endpoint.MacAddress }).IPV4Address.IPAddress.Trim()
-`
+	AzureCloudNodeManagerPath = payloadPath(AzureCloudNodeManager)
 )
 
+// derivedPaths are all the exported paths built with payloadPath, rewritten by SetBaseDir
+var derivedPaths = []*string{
+	&WICDPath, &KubeletPath, &KubeProxyPath, &KubeLogRunnerPath, &ContainerdPath, &HcsshimPath, &ContainerdConfPath,
+	&WinDefenderExclusionScriptPath, &HNSPSModule, &HostLocalCNIPlugin, &WinBridgeCNIPlugin, &WinOverlayCNIPlugin,
+	&CNIConfigurationScript, &KubeProxyPrepScript, &PreflightScript, &HNSCleanupScript, &GcpGetValidHostnameScriptPath,
+	&HybridOverlayPath, &CSIProxyPath, &WindowsExporterPath, &AzureCloudNodeManagerPath, &ManifestPath, &RegistryHostsDir,
+	&ContainerdConfGeneratedPath, &ExporterWebConfigPath, &CredentialProviderConfigPath,
+}
+
+// payloadPath returns the clean path of the given element within the payload directory
+func payloadPath(elem ...string) string {
+	return path.Join(append([]string{payloadDirectory}, elem...)...)
+}
+
+// BaseDir returns the directory all payload paths are within, with a trailing slash
+func BaseDir() string {
+	return payloadDirectory
+}
+
+// SetBaseDir moves all payload paths, including the generated directory, within the given absolute directory instead
+// of /payload/. It is meant to run the operator against a local directory of binaries, and must be called once at
+// startup, before any payload path is used, as it is not safe for concurrent use.
+func SetBaseDir(dir string) error {
+	if !path.IsAbs(dir) {
+		return fmt.Errorf("payload directory %q is not an absolute path", dir)
+	}
+	baseDir := path.Clean(dir)
+	if baseDir != "/" {
+		baseDir += "/"
+	}
+	for _, derived := range derivedPaths {
+		*derived = path.Join(baseDir, strings.TrimPrefix(*derived, payloadDirectory))
+	}
+	payloadDirectory = baseDir
+	return nil
+}
+
+// Category groups payload files by their purpose
+type Category string
+
+const (
+	// CategoryCore contains the binaries and configuration needed by every Windows node
+	CategoryCore Category = "core"
+	// CategoryCNI contains the CNI plugin binaries
+	CategoryCNI Category = "cni"
+	// CategoryPowerShell contains the PowerShell scripts and modules
+	CategoryPowerShell Category = "powershell"
+	// CategoryExporter contains the metrics exporter binaries
+	CategoryExporter Category = "exporter"
+	// CategoryCloud contains the cloud provider specific binaries
+	CategoryCloud Category = "cloud"
+	// CategoryGenerated contains the files generated by the operator at runtime
+	CategoryGenerated Category = "generated"
+)
+
+// FilesByCategory returns the paths of all payload files, grouped by category
+func FilesByCategory() map[Category][]string {
+	return map[Category][]string{
+		CategoryCore: {
+			WICDPath,
+			KubeletPath,
+			KubeProxyPath,
+			KubeLogRunnerPath,
+			ContainerdPath,
+			HcsshimPath,
+			ContainerdConfPath,
+			HybridOverlayPath,
+			CSIProxyPath,
+		},
+		CategoryCNI: {
+			HostLocalCNIPlugin,
+			WinBridgeCNIPlugin,
+			WinOverlayCNIPlugin,
+		},
+		CategoryPowerShell: {
+			WinDefenderExclusionScriptPath,
+			HNSPSModule,
+		},
+		CategoryExporter: {
+			WindowsExporterPath,
+		},
+		CategoryCloud: {
+			AzureCloudNodeManagerPath,
+		},
+		CategoryGenerated: {
+			CNIConfigurationScript,
+			KubeProxyPrepScript,
+			PreflightScript,
+			HNSCleanupScript,
+			GcpGetValidHostnameScriptPath,
+		},
+	}
+}
+
+// Files returns the paths of all payload files, sorted by path
+func Files() []string {
+	var files []string
+	for _, categoryFiles := range FilesByCategory() {
+		files = append(files, categoryFiles...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// RequiredFiles returns the paths of the payload files needed by Windows nodes on the given platform, sorted by path.
+// Platform specific files, like the Azure cloud node manager, are only included for the platform that uses them, and
+// csi-proxy is excluded if disabled with WithCSIProxyEnabled. An error is returned for platforms that are not
+// supported.
+func RequiredFiles(platform configv1.PlatformType, opts ...Option) ([]string, error) {
+	platformFiles := map[string]configv1.PlatformType{
+		GcpGetValidHostnameScriptPath: configv1.GCPPlatformType,
+		AzureCloudNodeManagerPath:     configv1.AzurePlatformType,
+	}
+	switch platform {
+	case configv1.AWSPlatformType, configv1.AzurePlatformType, configv1.GCPPlatformType,
+		configv1.VSpherePlatformType, configv1.NutanixPlatformType, configv1.NonePlatformType:
+	default:
+		return nil, fmt.Errorf("unsupported platform: %q", platform)
+	}
+	csiProxyEnabled := newOptions(opts).csiProxyEnabled
+	var files []string
+	for _, file := range Files() {
+		if requiredPlatform, ok := platformFiles[file]; ok && requiredPlatform != platform {
+			continue
+		}
+		if file == CSIProxyPath && !csiProxyEnabled {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Validate ensures all payload files shipped in the operator image exist, are non-empty and are readable. Generated
+// files are excluded, as they are created by the operator at runtime. The returned error lists every invalid file.
+func Validate(opts ...Option) error {
+	return ValidateFS(newOptions(opts).fsys, shippedFiles())
+}
+
+// ValidateFS ensures the given absolute paths exist within fsys, are non-empty and are readable. fsys is expected to
+// be rooted at "/". The returned error lists every invalid file.
+func ValidateFS(fsys fs.FS, paths []string) error {
+	var errorMessages []string
+	for _, path := range paths {
+		if err := validateFile(fsys, path); err != nil {
+			errorMessages = append(errorMessages, err.Error())
+		}
+	}
+	if len(errorMessages) > 0 {
+		return fmt.Errorf("errors encountered with required files: %s", strings.Join(errorMessages, ", "))
+	}
+	return nil
+}
+
+// validateFile returns an error if the file at the given absolute path is missing, empty or unreadable
+func validateFile(fsys fs.FS, path string) error {
+	fsPath := toFSPath(path)
+	info, err := fs.Stat(fsys, fsPath)
+	if err != nil {
+		return fmt.Errorf("could not stat %s: %w", path, withPath(err, path))
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("%s is empty", path)
+	}
+	f, err := fsys.Open(fsPath)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %w", path, withPath(err, path))
+	}
+	defer f.Close()
+	if _, err := f.Read(make([]byte, 1)); err != nil {
+		return fmt.Errorf("could not read %s: %w", path, withPath(err, path))
+	}
+	return nil
+}
+
+// withPath replaces the path of a *fs.PathError, which is relative to the root of the filesystem it came from, with
+// the given absolute path
+func withPath(err error, path string) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return &fs.PathError{Op: pathErr.Op, Path: path, Err: pathErr.Err}
+	}
+	return err
+}
+
+var (
+	// ErrPayloadFileNotFound indicates a payload file does not exist. As payload files are shipped in the operator
+	// image, this is not recoverable by retrying.
+	ErrPayloadFileNotFound = errors.New("payload file not found")
+	// ErrPayloadFileUnreadable indicates a payload file exists but could not be read, for example due to a permission
+	// or IO error. This may be transient, and the operation can be retried.
+	ErrPayloadFileUnreadable = errors.New("payload file unreadable")
+)
+
+// classifyFileError wraps the given file access error with ErrPayloadFileNotFound or ErrPayloadFileUnreadable
+func classifyFileError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrPayloadFileNotFound, err)
+	}
+	return fmt.Errorf("%w: %w", ErrPayloadFileUnreadable, err)
+}
+
+// Algorithm is a hash algorithm used to compute file digests
+type Algorithm string
+
+const (
+	// AlgorithmSHA256 is the SHA-256 hash algorithm, used by default
+	AlgorithmSHA256 Algorithm = "sha256"
+	// AlgorithmSHA512 is the SHA-512 hash algorithm
+	AlgorithmSHA512 Algorithm = "sha512"
+)
+
+// ErrAlgorithmMismatch is returned when comparing FileInfo objects whose digests were computed with different
+// algorithms
+var ErrAlgorithmMismatch = errors.New("digests computed with different hash algorithms")
+
+// newHash returns a new hash.Hash computing the given algorithm
+func (a Algorithm) newHash() (hash.Hash, error) {
+	switch a {
+	case AlgorithmSHA256:
+		return sha256.New(), nil
+	case AlgorithmSHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm %q", a)
+}
+
 // FileInfo contains information about a file
 type FileInfo struct {
-	Path   string
+	Path string
+	// SHA256 is the hex encoded SHA-256 digest of the file, set when Algorithm is sha256
 	SHA256 string
+	// SHA512 is the hex encoded SHA-512 digest of the file, set when Algorithm is sha512
+	SHA512 string
+	// Algorithm is the algorithm the digest of the file was computed with. An empty Algorithm is treated as sha256.
+	Algorithm Algorithm
+	// Size is the size of the file in bytes
+	Size int64
+	// ModTime is the last modification time of the file
+	ModTime time.Time
+}
+
+// NewFileInfo returns a pointer to a FileInfo object created from the specified file, with a SHA-256 digest. The file
+// contents are streamed through the hash function, so the file is never fully loaded into memory.
+func NewFileInfo(path string, opts ...Option) (*FileInfo, error) {
+	return NewFileInfoWithAlgorithm(path, AlgorithmSHA256, opts...)
 }
 
-// NewFileInfo returns a pointer to a FileInfo object created from the specified file
-func NewFileInfo(path string) (*FileInfo, error) {
-	contents, err := ioutil.ReadFile(path)
+// NewFileInfoWithAlgorithm returns a pointer to a FileInfo object created from the specified file, with a digest
+// computed using the given algorithm
+func NewFileInfoWithAlgorithm(path string, algorithm Algorithm, opts ...Option) (*FileInfo, error) {
+	h, err := algorithm.newHash()
+	if err != nil {
+		return nil, err
+	}
+	f, err := newOptions(opts).fsys.Open(toFSPath(path))
 	if err != nil {
-		return nil, fmt.Errorf("could not get contents of file: %w", err)
+		return nil, fmt.Errorf("could not get contents of file: %w", classifyFileError(withPath(err, path)))
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat file: %w", classifyFileError(withPath(err, path)))
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("could not get contents of file: %w", classifyFileError(withPath(err, path)))
+	}
+	fileInfo := &FileInfo{
+		Path:      path,
+		Algorithm: algorithm,
+		Size:      stat.Size(),
+		ModTime:   stat.ModTime(),
+	}
+	fileInfo.setChecksum(fmt.Sprintf("%x", h.Sum(nil)))
+	return fileInfo, nil
+}
+
+// algorithm returns the algorithm the digest of the file was computed with
+func (f *FileInfo) algorithm() Algorithm {
+	if f.Algorithm == "" {
+		return AlgorithmSHA256
+	}
+	return f.Algorithm
+}
+
+// Checksum returns the hex encoded digest of the file, computed with the FileInfo's algorithm
+func (f *FileInfo) Checksum() string {
+	if f.algorithm() == AlgorithmSHA512 {
+		return f.SHA512
+	}
+	return f.SHA256
+}
+
+// setChecksum sets the digest field corresponding to the FileInfo's algorithm
+func (f *FileInfo) setChecksum(checksum string) {
+	if f.algorithm() == AlgorithmSHA512 {
+		f.SHA512 = checksum
+		return
 	}
-	return &FileInfo{
-		Path:   path,
-		SHA256: fmt.Sprintf("%x", sha256.Sum256(contents)),
-	}, nil
+	f.SHA256 = checksum
 }
 
-// PopulateNetworkConfScript creates the .ps1 file responsible for CNI configuration
-func PopulateNetworkConfScript(clusterCIDR, hnsNetworkName, hnsPSModulePath, cniConfigPath string) error {
-	scriptContents, err := generateNetworkConfigScript(clusterCIDR, hnsNetworkName,
-		hnsPSModulePath, cniConfigPath)
+// NewFileInfos returns FileInfo objects for all the given paths, keyed by path. Files are hashed concurrently by a
+// bounded number of workers. If any file cannot be processed, an error naming every failing path is returned.
+func NewFileInfos(paths []string, opts ...Option) (map[string]*FileInfo, error) {
+	return newFileInfos(paths, func(path string) (*FileInfo, error) {
+		return NewFileInfo(path, opts...)
+	})
+}
+
+// newFileInfos returns FileInfo objects for all the given paths, created concurrently using newFileInfo
+func newFileInfos(paths []string, newFileInfo func(string) (*FileInfo, error)) (map[string]*FileInfo, error) {
+	type result struct {
+		path string
+		info *FileInfo
+		err  error
+	}
+	pathCh := make(chan string)
+	resultCh := make(chan result)
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range pathCh {
+				info, err := newFileInfo(path)
+				resultCh <- result{path: path, info: info, err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, path := range paths {
+			pathCh <- path
+		}
+		close(pathCh)
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	files := make(map[string]*FileInfo, len(paths))
+	var failedPaths []string
+	errs := make(map[string]error)
+	for r := range resultCh {
+		if r.err != nil {
+			failedPaths = append(failedPaths, r.path)
+			errs[r.path] = r.err
+			continue
+		}
+		files[r.path] = r.info
+	}
+	if len(failedPaths) > 0 {
+		// sort the failures so the returned error does not depend on goroutine scheduling
+		sort.Strings(failedPaths)
+		aggregate := &fileInfosError{}
+		for _, path := range failedPaths {
+			aggregate.errs = append(aggregate.errs, fmt.Errorf("%s: %w", path, errs[path]))
+		}
+		return nil, aggregate
+	}
+	return files, nil
+}
+
+// fileInfosError is returned by NewFileInfos when one or more files could not be processed. The individual errors
+// remain accessible through errors.Is and errors.As.
+type fileInfosError struct {
+	errs []error
+}
+
+// Error returns a message listing every failing file
+func (e *fileInfosError) Error() string {
+	var errorMessages []string
+	for _, err := range e.errs {
+		errorMessages = append(errorMessages, err.Error())
+	}
+	return fmt.Sprintf("could not create FileInfo objects: %s", strings.Join(errorMessages, ", "))
+}
+
+// Unwrap returns the error of each failing file
+func (e *fileInfosError) Unwrap() []error {
+	return e.errs
+}
+
+// Digest returns a combined hash over all payload files. The digest changes if any payload file's content changes,
+// and is suitable for use as a node annotation value.
+func Digest(opts ...Option) (string, error) {
+	return DigestFiles(Files(), opts...)
+}
+
+// DigestFiles returns a combined hash over the given files, computed over each file path and content in sorted path
+// order. The result does not depend on the order of the given paths.
+func DigestFiles(paths []string, opts ...Option) (string, error) {
+	files, err := NewFileInfos(paths, opts...)
 	if err != nil {
+		return "", err
+	}
+	sortedPaths := make([]string, 0, len(files))
+	for path := range files {
+		sortedPaths = append(sortedPaths, path)
+	}
+	sort.Strings(sortedPaths)
+	hash := sha256.New()
+	for _, path := range sortedPaths {
+		// separate the fields so that different path and content combinations cannot produce the same input
+		fmt.Fprintf(hash, "%s\x00%s\n", path, files[path].SHA256)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// Equal returns true if both FileInfo objects describe the same path with the same contents. FileInfo objects with
+// digests computed using different algorithms cannot be compared, and ErrAlgorithmMismatch is returned.
+func (f *FileInfo) Equal(other *FileInfo) (bool, error) {
+	if f == nil || other == nil {
+		return f == other, nil
+	}
+	if f.algorithm() != other.algorithm() {
+		return false, fmt.Errorf("cannot compare %s digest of %s with %s digest of %s: %w", f.algorithm(), f.Path,
+			other.algorithm(), other.Path, ErrAlgorithmMismatch)
+	}
+	return f.Path == other.Path && f.Checksum() == other.Checksum(), nil
+}
+
+// DiffResult holds the differences between two payload snapshots
+type DiffResult struct {
+	// Added contains files present only in the new snapshot
+	Added []*FileInfo
+	// Removed contains files present only in the old snapshot
+	Removed []*FileInfo
+	// Changed contains files present in both snapshots with different contents, as described by the new snapshot
+	Changed []*FileInfo
+}
+
+// Diff returns the files that were added, removed or changed between the old and new payload snapshots. Files are
+// identified by path, so a renamed file is reported as removed and added, and files with identical contents at
+// different paths are treated as distinct files. Each result is sorted by path. An error is returned if a file's
+// digests in both snapshots were computed with different algorithms.
+func Diff(old, new []*FileInfo) (*DiffResult, error) {
+	oldByPath := make(map[string]*FileInfo, len(old))
+	for _, f := range old {
+		oldByPath[f.Path] = f
+	}
+	newByPath := make(map[string]*FileInfo, len(new))
+	for _, f := range new {
+		newByPath[f.Path] = f
+	}
+	result := &DiffResult{}
+	for path, f := range newByPath {
+		prev, present := oldByPath[path]
+		if !present {
+			result.Added = append(result.Added, f)
+			continue
+		}
+		equal, err := prev.Equal(f)
+		if err != nil {
+			return nil, err
+		}
+		if !equal {
+			result.Changed = append(result.Changed, f)
+		}
+	}
+	for path, f := range oldByPath {
+		if _, present := newByPath[path]; !present {
+			result.Removed = append(result.Removed, f)
+		}
+	}
+	for _, files := range [][]*FileInfo{result.Added, result.Removed, result.Changed} {
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	}
+	return result, nil
+}
+
+const (
+	// fileInfoSchemaVersion is the version of the JSON representation of a FileInfo. It must be incremented whenever
+	// the representation changes in a way older decoders cannot handle.
+	fileInfoSchemaVersion = 2
+	// fileInfoSHA256SchemaVersion is the version written for sha256 FileInfo objects, whose representation is
+	// unchanged since version 1, so that they remain readable by older decoders
+	fileInfoSHA256SchemaVersion = 1
+)
+
+// fileInfoJSON is the compact, versioned JSON representation of a FileInfo, used for node annotations
+type fileInfoJSON struct {
+	Version   int       `json:"v"`
+	Path      string    `json:"path,omitempty"`
+	Algorithm Algorithm `json:"alg,omitempty"`
+	SHA256    string    `json:"sha256,omitempty"`
+	SHA512    string    `json:"sha512,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+}
+
+// MarshalJSON returns the versioned JSON representation of the FileInfo. ModTime is local to the machine the FileInfo
+// was created on, so it is not included.
+func (f *FileInfo) MarshalJSON() ([]byte, error) {
+	if f.algorithm() == AlgorithmSHA256 {
+		return json.Marshal(fileInfoJSON{
+			Version: fileInfoSHA256SchemaVersion,
+			Path:    f.Path,
+			SHA256:  f.SHA256,
+			Size:    f.Size,
+		})
+	}
+	return json.Marshal(fileInfoJSON{
+		Version:   fileInfoSchemaVersion,
+		Path:      f.Path,
+		Algorithm: f.Algorithm,
+		SHA256:    f.SHA256,
+		SHA512:    f.SHA512,
+		Size:      f.Size,
+	})
+}
+
+// UnmarshalJSON populates the FileInfo from its versioned JSON representation
+func (f *FileInfo) UnmarshalJSON(data []byte) error {
+	var decoded fileInfoJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
 		return err
 	}
-	return ioutil.WriteFile(NetworkConfigurationScript, []byte(scriptContents), fs.ModePerm)
+	if decoded.Version < 1 || decoded.Version > fileInfoSchemaVersion {
+		return fmt.Errorf("unsupported FileInfo schema version %d", decoded.Version)
+	}
+	fileInfo := FileInfo{Path: decoded.Path, Algorithm: decoded.Algorithm, SHA256: decoded.SHA256,
+		SHA512: decoded.SHA512, Size: decoded.Size}
+	if fileInfo.Algorithm == "" {
+		fileInfo.Algorithm = AlgorithmSHA256
+	}
+	if !isHexDigest(fileInfo.Checksum(), fileInfo.Algorithm) {
+		return fmt.Errorf("invalid %s digest %q", fileInfo.Algorithm, fileInfo.Checksum())
+	}
+	*f = fileInfo
+	return nil
 }
 
-// generateNetworkConfigScript generates the contents of the .ps1 file responsible for CNI configuration
-func generateNetworkConfigScript(clusterCIDR, hnsNetworkName, hnsPSModulePath,
-	cniConfigPath string) (string, error) {
-	networkConfScript := networkConfTemplate
-	for key, val := range map[string]string{
-		"HNS_NETWORK":          hnsNetworkName,
-		"SERVICE_NETWORK_CIDR": clusterCIDR,
-		"HNS_MODULE_PATH":      hnsPSModulePath,
-		"CNI_CONFIG_PATH":      cniConfigPath,
-	} {
-		networkConfScript = strings.ReplaceAll(networkConfScript, key, val)
+// Encode returns the FileInfo as a string suitable for use as a node annotation value
+func (f *FileInfo) Encode() (string, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return "", fmt.Errorf("could not encode FileInfo for %s: %w", f.Path, err)
+	}
+	return string(data), nil
+}
+
+// DecodeFileInfo returns the FileInfo held by the given annotation value. Values written by older operator versions
+// only contain the bare hash of the file, in which case the returned FileInfo only has SHA256 set, and callers must
+// compare hashes rather than use Equal.
+func DecodeFileInfo(value string) (*FileInfo, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "{") {
+		if !isHexDigest(value, AlgorithmSHA256) {
+			return nil, fmt.Errorf("invalid FileInfo value %q", value)
+		}
+		return &FileInfo{SHA256: strings.ToLower(value), Algorithm: AlgorithmSHA256}, nil
+	}
+	fileInfo := &FileInfo{}
+	if err := json.Unmarshal([]byte(value), fileInfo); err != nil {
+		return nil, fmt.Errorf("could not decode FileInfo: %w", err)
+	}
+	return fileInfo, nil
+}
+
+// isHexDigest returns true if the given string is a hex encoded digest of the given algorithm
+func isHexDigest(value string, algorithm Algorithm) bool {
+	h, err := algorithm.newHash()
+	if err != nil || len(value) != h.Size()*2 {
+		return false
+	}
+	_, err = hex.DecodeString(value)
+	return err == nil
+}
+
+const (
+	// minShortSHALength is the shortest prefix ShortSHA returns. Shorter prefixes make accidental collisions between
+	// different file contents likely enough to cause changes to go undetected.
+	minShortSHALength = 8
+	// maxShortSHALength is the length of a full hex encoded SHA-256 hash
+	maxShortSHALength = sha256.Size * 2
+)
+
+// ShortSHA returns the first n hex characters of the file's hash, for use where the full hash does not fit, such as
+// label values. n must be between 8 and 64. A prefix is not collision resistant: two files sharing the first n
+// characters are indistinguishable, so short hashes should only be used to make values readable, with the full hash
+// kept in annotations wherever a change must be detected reliably.
+func (f *FileInfo) ShortSHA(n int) (string, error) {
+	if n < minShortSHALength || n > maxShortSHALength {
+		return "", fmt.Errorf("short hash length must be between %d and %d, got %d", minShortSHALength,
+			maxShortSHALength, n)
+	}
+	checksum := f.Checksum()
+	if len(checksum) < n {
+		return "", fmt.Errorf("hash of %s is shorter than %d characters", f.Path, n)
+	}
+	return checksum[:n], nil
+}
+
+// CheckUnchanged returns true if the file at the given path still has the contents described by prev. Unless strict
+// is set, a file with the same size and modification time as prev is assumed to be unchanged without being rehashed.
+// When strict is set, the file is always rehashed, so a content change is never missed.
+func CheckUnchanged(path string, prev *FileInfo, strict bool, opts ...Option) (bool, error) {
+	if prev == nil {
+		return false, nil
+	}
+	if !strict {
+		stat, err := fs.Stat(newOptions(opts).fsys, toFSPath(path))
+		if err != nil {
+			return false, fmt.Errorf("could not stat file: %w", withPath(err, path))
+		}
+		if stat.Size() == prev.Size && stat.ModTime().Equal(prev.ModTime) {
+			return true, nil
+		}
+	}
+	current, err := NewFileInfoWithAlgorithm(path, prev.algorithm(), opts...)
+	if err != nil {
+		return false, err
 	}
-	return networkConfScript, nil
+	return current.Equal(prev)
 }
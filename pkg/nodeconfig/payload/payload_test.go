@@ -1,100 +1,863 @@
 package payload
 
 import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	configv1 "github.com/openshift/api/config/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestGenerateNetworkConfigScript(t *testing.T) {
-	expectedOut := `# This script ensures the contents of the CNI config file is correct, and returns the HNS endpoint IP
-$ErrorActionPreference = "Stop"
-Import-Module -DisableNameChecking c:\k\hns.psm1
-
-$cni_template=@'
-{
-    "cniVersion":"0.2.0",
-    "name":"OVNKubernetesHNSNetwork",
-    "type":"win-overlay",
-    "apiVersion": 2,
-    "capabilities":{
-        "portMappings": true,
-        "dns":true
-    },
-    "ipam":{
-        "type":"host-local",
-        "subnet":"ovn_host_subnet"
-    },
-    "policies":[
-    {
-        "name": "EndpointPolicy",
-        "value": {
-            "type": "OutBoundNAT",
-            "settings": {
-                "exceptionList": [
-                "10.0.0.1/32"
-                ],
-                "destinationPrefix": "",
-                "needEncap": false
-            }
-        }
-    },
-    {
-        "name": "EndpointPolicy",
-        "value": {
-            "type": "SDNRoute",
-            "settings": {
-                "exceptionList": [],
-                "destinationPrefix": "10.0.0.1/32",
-                "needEncap": true
-            }
-        }
-    },
-    {
-        "name": "EndpointPolicy",
-        "value": {
-            "type": "ProviderAddress",
-            "settings": {
-                "providerAddress": "provider_address"
-            }
-        }
-    }
-    ]
-}
-'@
-
-# Generate CNI Config
-$hns_network=Get-HnsNetwork  | where { $_.Name -eq 'OVNKubernetesHNSNetwork'}
-$subnet=$hns_network.Subnets.AddressPrefix
-$cni_template=$cni_template.Replace("ovn_host_subnet",$subnet)
-$provider_address=$hns_network.ManagementIP
-$cni_template=$cni_template.Replace("provider_address",$provider_address)
-
-# Compare CNI config with existing file, and replace if necessary
-$existing_config=""
-if(Test-Path -Path c:\k\cni.conf) {
-    $config_file_content=(Get-Content -Path c:\k\cni.conf -Raw)
-    if($config_file_content -ne $null) {
-` + "        $existing_config=$config_file_content.Replace(\"`r\",\"\")" + `
-    }
-}
-if($existing_config -ne $cni_template){
-    Set-Content -Path "c:\k\cni.conf" -Value $cni_template -NoNewline
-}
-
-# Create HNS endpoint if it doesn't exist
-$endpoint = Invoke-HNSRequest GET endpoints | where { $_.Name -eq 'VIPEndpoint'}
-if( $endpoint -eq $null) {
-    $endpoint = New-HnsEndpoint -NetworkId $hns_network.ID -Name "VIPEndpoint"
-    Attach-HNSHostEndpoint -EndpointID $endpoint.ID -CompartmentID 1
-}
-
-# Return HNS endpoint IP
-(Get-NetIPConfiguration -AllCompartments -All -Detailed | where { $_.NetAdapter.LinkLayerAddress -eq $endpoint.MacAddress }).IPV4Address.IPAddress.Trim()
-`
-	actual, err := generateNetworkConfigScript("10.0.0.1/32",
-		"OVNKubernetesHNSNetwork", "c:\\k\\hns.psm1", "c:\\k\\cni.conf")
-	require.NoError(t, err)
-	assert.Equal(t, string(expectedOut), actual)
+func TestNewFileInfo(t *testing.T) {
+	testCases := []struct {
+		name     string
+		contents []byte
+		expected string
+	}{
+		{
+			name:     "empty file",
+			contents: []byte{},
+			expected: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{
+			name:     "file with contents",
+			contents: []byte("windows"),
+			expected: "340d600392818df2413382dc7d8325c360d83ea49a262d31760348484bbc10b5",
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			require.NoError(t, os.WriteFile(path, test.contents, 0644))
+			info, err := NewFileInfo(path)
+			require.NoError(t, err)
+			assert.Equal(t, path, info.Path)
+			assert.Equal(t, test.expected, info.SHA256)
+		})
+	}
+	t.Run("missing file", func(t *testing.T) {
+		_, err := NewFileInfo(filepath.Join(t.TempDir(), "missing"))
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrPayloadFileNotFound)
+		assert.NotErrorIs(t, err, ErrPayloadFileUnreadable)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+	t.Run("unreadable file", func(t *testing.T) {
+		// a directory can be opened, but reading its contents fails
+		_, err := NewFileInfo(t.TempDir())
+		require.Error(t, err)
+		assert.ErrorIs(t, err, ErrPayloadFileUnreadable)
+		assert.NotErrorIs(t, err, ErrPayloadFileNotFound)
+	})
+}
+
+// BenchmarkNewFileInfo measures the allocations made while hashing a large payload file
+func BenchmarkNewFileInfo(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "kubelet.exe")
+	require.NoError(b, os.WriteFile(path, make([]byte, 64*1024*1024), 0644))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewFileInfo(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCheckUnchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubelet.exe")
+	require.NoError(t, os.WriteFile(path, []byte("kubelet"), 0644))
+	prev, err := NewFileInfo(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("kubelet")), prev.Size)
+
+	unchanged, err := CheckUnchanged(path, prev, false)
+	require.NoError(t, err)
+	assert.True(t, unchanged)
+
+	// Change the contents without changing the size or modification time. Only the strict check can detect this.
+	require.NoError(t, os.WriteFile(path, []byte("kubelex"), 0644))
+	require.NoError(t, os.Chtimes(path, prev.ModTime, prev.ModTime))
+	unchanged, err = CheckUnchanged(path, prev, false)
+	require.NoError(t, err)
+	assert.True(t, unchanged)
+	unchanged, err = CheckUnchanged(path, prev, true)
+	require.NoError(t, err)
+	assert.False(t, unchanged)
+
+	// A size change triggers a rehash even in non-strict mode
+	require.NoError(t, os.WriteFile(path, []byte("kubelet-v2"), 0644))
+	unchanged, err = CheckUnchanged(path, prev, false)
+	require.NoError(t, err)
+	assert.False(t, unchanged)
+
+	unchanged, err = CheckUnchanged(path, nil, false)
+	require.NoError(t, err)
+	assert.False(t, unchanged)
+}
+
+func TestFileInfoEqual(t *testing.T) {
+	a := &FileInfo{Path: "/payload/kubelet.exe", SHA256: "abc", Size: 1}
+	testCases := []struct {
+		name        string
+		other       *FileInfo
+		expected    bool
+		expectedErr error
+	}{
+		{
+			name:     "same path and contents",
+			other:    &FileInfo{Path: "/payload/kubelet.exe", SHA256: "abc", Algorithm: AlgorithmSHA256, Size: 2},
+			expected: true,
+		},
+		{
+			name:  "different contents",
+			other: &FileInfo{Path: "/payload/kubelet.exe", SHA256: "def"},
+		},
+		{
+			name:  "different path",
+			other: &FileInfo{Path: "/payload/kube-proxy.exe", SHA256: "abc"},
+		},
+		{
+			name: "nil",
+		},
+		{
+			name:        "different algorithm",
+			other:       &FileInfo{Path: "/payload/kubelet.exe", SHA512: "abc", Algorithm: AlgorithmSHA512},
+			expectedErr: ErrAlgorithmMismatch,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			equal, err := a.Equal(test.other)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, equal)
+		})
+	}
+}
+
+func TestNewFileInfoWithAlgorithm(t *testing.T) {
+	fsys := fstest.MapFS{"payload/kube-node/kubelet.exe": {Data: []byte("windows")}}
+	sha512Info, err := NewFileInfoWithAlgorithm(KubeletPath, AlgorithmSHA512, WithFS(fsys))
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmSHA512, sha512Info.Algorithm)
+	assert.Empty(t, sha512Info.SHA256)
+	assert.Len(t, sha512Info.Checksum(), 128)
+	assert.Equal(t, sha512Info.SHA512, sha512Info.Checksum())
+
+	sha256Info, err := NewFileInfo(KubeletPath, WithFS(fsys))
+	require.NoError(t, err)
+	assert.Equal(t, AlgorithmSHA256, sha256Info.Algorithm)
+	assert.Equal(t, "340d600392818df2413382dc7d8325c360d83ea49a262d31760348484bbc10b5", sha256Info.Checksum())
+
+	_, err = sha256Info.Equal(sha512Info)
+	assert.ErrorIs(t, err, ErrAlgorithmMismatch)
+	_, err = Diff([]*FileInfo{sha256Info}, []*FileInfo{sha512Info})
+	assert.ErrorIs(t, err, ErrAlgorithmMismatch)
+
+	// unchanged checks rehash with the algorithm of the previous FileInfo
+	unchanged, err := CheckUnchanged(KubeletPath, sha512Info, true, WithFS(fsys))
+	require.NoError(t, err)
+	assert.True(t, unchanged)
+
+	_, err = NewFileInfoWithAlgorithm(KubeletPath, "md5", WithFS(fsys))
+	assert.Error(t, err)
+}
+
+func TestFileInfoEncodeRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kubelet.exe")
+	require.NoError(t, os.WriteFile(path, []byte("windows"), 0644))
+	original, err := NewFileInfo(path)
+	require.NoError(t, err)
+
+	encoded, err := original.Encode()
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{"v":1,"path":%q,"sha256":%q,"size":7}`, path, original.SHA256), encoded)
+
+	decoded, err := DecodeFileInfo(encoded)
+	require.NoError(t, err)
+	equal, err := original.Equal(decoded)
+	require.NoError(t, err)
+	assert.True(t, equal)
+	assert.Equal(t, original.Size, decoded.Size)
+
+	sha512Info, err := NewFileInfoWithAlgorithm(path, AlgorithmSHA512)
+	require.NoError(t, err)
+	encoded, err = sha512Info.Encode()
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`{"v":2,"path":%q,"alg":"sha512","sha512":%q,"size":7}`, path, sha512Info.SHA512),
+		encoded)
+	decoded, err = DecodeFileInfo(encoded)
+	require.NoError(t, err)
+	equal, err = sha512Info.Equal(decoded)
+	require.NoError(t, err)
+	assert.True(t, equal)
+}
+
+func TestDecodeFileInfo(t *testing.T) {
+	sha := "340d600392818df2413382dc7d8325c360d83ea49a262d31760348484bbc10b5"
+	testCases := []struct {
+		name        string
+		value       string
+		expected    *FileInfo
+		expectedErr bool
+	}{
+		{
+			name:     "versioned blob",
+			value:    `{"v":1,"path":"/payload/kubelet.exe","sha256":"` + sha + `","size":7}`,
+			expected: &FileInfo{Path: "/payload/kubelet.exe", SHA256: sha, Algorithm: AlgorithmSHA256, Size: 7},
+		},
+		{
+			name:     "legacy bare hash",
+			value:    sha,
+			expected: &FileInfo{SHA256: sha, Algorithm: AlgorithmSHA256},
+		},
+		{
+			name:     "legacy bare hash with uppercase and whitespace",
+			value:    " 340D600392818DF2413382DC7D8325C360D83EA49A262D31760348484BBC10B5\n",
+			expected: &FileInfo{SHA256: sha, Algorithm: AlgorithmSHA256},
+		},
+		{
+			name:        "unknown schema version",
+			value:       `{"v":3,"path":"/payload/kubelet.exe","sha256":"` + sha + `"}`,
+			expectedErr: true,
+		},
+		{
+			name:        "sha512 blob with sha256 digest",
+			value:       `{"v":2,"path":"/payload/kubelet.exe","alg":"sha512","sha512":"` + sha + `"}`,
+			expectedErr: true,
+		},
+		{
+			name:        "unsupported algorithm",
+			value:       `{"v":2,"path":"/payload/kubelet.exe","alg":"md5","sha256":"` + sha + `"}`,
+			expectedErr: true,
+		},
+		{
+			name:        "missing schema version",
+			value:       `{"path":"/payload/kubelet.exe","sha256":"` + sha + `"}`,
+			expectedErr: true,
+		},
+		{
+			name:        "invalid hash in blob",
+			value:       `{"v":1,"path":"/payload/kubelet.exe","sha256":"abc"}`,
+			expectedErr: true,
+		},
+		{
+			name:        "invalid bare hash",
+			value:       "not-a-hash",
+			expectedErr: true,
+		},
+		{
+			name:        "malformed blob",
+			value:       `{"v":1,`,
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fileInfo, err := DecodeFileInfo(test.value)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, fileInfo)
+		})
+	}
+}
+
+func TestShortSHA(t *testing.T) {
+	sha := "340d600392818df2413382dc7d8325c360d83ea49a262d31760348484bbc10b5"
+	testCases := []struct {
+		name        string
+		sha         string
+		n           int
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:        "below minimum",
+			sha:         sha,
+			n:           7,
+			expectedErr: true,
+		},
+		{
+			name:     "minimum",
+			sha:      sha,
+			n:        8,
+			expected: "340d6003",
+		},
+		{
+			name:     "maximum",
+			sha:      sha,
+			n:        64,
+			expected: sha,
+		},
+		{
+			name:        "above maximum",
+			sha:         sha,
+			n:           65,
+			expectedErr: true,
+		},
+		{
+			name:        "negative",
+			sha:         sha,
+			n:           -1,
+			expectedErr: true,
+		},
+		{
+			name:        "hash too short",
+			sha:         "abc",
+			n:           8,
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			short, err := (&FileInfo{Path: "/payload/kubelet.exe", SHA256: test.sha}).ShortSHA(test.n)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, short)
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	kubelet := &FileInfo{Path: "C:\\k\\kubelet.exe", SHA256: "aaa"}
+	kubeletV2 := &FileInfo{Path: "C:\\k\\kubelet.exe", SHA256: "bbb"}
+	winBridge := &FileInfo{Path: "C:\\k\\cni\\win-bridge.exe", SHA256: "ccc"}
+	winBridgeRenamed := &FileInfo{Path: "C:\\k\\cni\\win-bridge-v2.exe", SHA256: "ccc"}
+	hostLocal := &FileInfo{Path: "C:\\k\\cni\\host-local.exe", SHA256: "ddd"}
+	hostLocalCopy := &FileInfo{Path: "C:\\Temp\\host-local.exe", SHA256: "ddd"}
+
+	testCases := []struct {
+		name     string
+		old      []*FileInfo
+		new      []*FileInfo
+		expected *DiffResult
+	}{
+		{
+			name:     "identical snapshots",
+			old:      []*FileInfo{kubelet, winBridge},
+			new:      []*FileInfo{winBridge, kubelet},
+			expected: &DiffResult{},
+		},
+		{
+			name:     "changed contents",
+			old:      []*FileInfo{kubelet},
+			new:      []*FileInfo{kubeletV2},
+			expected: &DiffResult{Changed: []*FileInfo{kubeletV2}},
+		},
+		{
+			name:     "renamed file is removed and added",
+			old:      []*FileInfo{kubelet, winBridge},
+			new:      []*FileInfo{kubelet, winBridgeRenamed},
+			expected: &DiffResult{Added: []*FileInfo{winBridgeRenamed}, Removed: []*FileInfo{winBridge}},
+		},
+		{
+			name:     "identical contents at different paths are not collapsed",
+			old:      []*FileInfo{hostLocal},
+			new:      []*FileInfo{hostLocal, hostLocalCopy},
+			expected: &DiffResult{Added: []*FileInfo{hostLocalCopy}},
+		},
+		{
+			name:     "results are sorted",
+			old:      []*FileInfo{kubelet, hostLocal, winBridge},
+			new:      nil,
+			expected: &DiffResult{Removed: []*FileInfo{hostLocal, winBridge, kubelet}},
+		},
+		{
+			name:     "empty old snapshot",
+			old:      nil,
+			new:      []*FileInfo{kubelet},
+			expected: &DiffResult{Added: []*FileInfo{kubelet}},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			diff, err := Diff(test.old, test.new)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, diff)
+		})
+	}
+}
+
+func TestNewFileInfos(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file-%d.exe", i))
+		require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf("contents %d", i)), 0644))
+		paths = append(paths, path)
+	}
+	files, err := NewFileInfos(paths)
+	require.NoError(t, err)
+	require.Len(t, files, len(paths))
+	for _, path := range paths {
+		expected, err := NewFileInfo(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, files[path])
+	}
+
+	missingA := filepath.Join(dir, "a-missing.exe")
+	missingB := filepath.Join(dir, "b-missing.exe")
+	_, err = NewFileInfos(append([]string{missingB, missingA}, paths...))
+	require.Error(t, err)
+	// failing paths are reported in sorted order, regardless of scheduling
+	assert.Regexp(t, "a-missing.exe.*b-missing.exe", err.Error())
+	assert.ErrorIs(t, err, ErrPayloadFileNotFound)
+
+	files, err = NewFileInfos(nil)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+// TestFilesInSync ensures every exported payload path is returned by Files()
+func TestFilesInSync(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "payload.go", nil, 0)
+	require.NoError(t, err)
+	// collect all constant and variable expressions in the file, so their values can be evaluated
+	exprs := make(map[string]ast.Expr)
+	for _, decl := range f.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || (genDecl.Tok != token.CONST && genDecl.Tok != token.VAR) {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			for i, name := range valueSpec.Names {
+				if i < len(valueSpec.Values) {
+					exprs[name.Name] = valueSpec.Values[i]
+				}
+			}
+		}
+	}
+
+	var payloadPaths []string
+	for name, expr := range exprs {
+		// payload file paths are the exported values built with payloadPath
+		if !ast.IsExported(name) || !referencesIdent(expr, "payloadPath") {
+			continue
+		}
+		payloadPaths = append(payloadPaths, evalStringExpr(t, exprs, expr))
+	}
+	assert.ElementsMatch(t, payloadPaths, Files())
+}
+
+// TestPathsClean ensures exported payload paths are clean, so they can be compared as strings
+func TestPathsClean(t *testing.T) {
+	for _, path := range append(Files(), ManifestPath) {
+		assert.NotContains(t, path, "//")
+		assert.Equal(t, filepath.ToSlash(filepath.Clean(path)), path)
+		assert.True(t, strings.HasPrefix(path, payloadDirectory), "%s is not within the payload directory", path)
+	}
+}
+
+func TestSetBaseDir(t *testing.T) {
+	testCases := []struct {
+		name        string
+		dir         string
+		expectedDir string
+		expectedErr bool
+	}{
+		{
+			name:        "directory without trailing slash",
+			dir:         "/tmp/wmco-payload",
+			expectedDir: "/tmp/wmco-payload/",
+		},
+		{
+			name:        "unclean directory",
+			dir:         "/tmp//wmco-payload/bin/../",
+			expectedDir: "/tmp/wmco-payload/",
+		},
+		{
+			name:        "default directory",
+			dir:         "/payload",
+			expectedDir: "/payload/",
+		},
+		{
+			name:        "relative directory",
+			dir:         "payload",
+			expectedErr: true,
+		},
+		{
+			name:        "empty directory",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			var defaults []string
+			for _, derived := range derivedPaths {
+				defaults = append(defaults, *derived)
+			}
+			t.Cleanup(func() { require.NoError(t, SetBaseDir(defaultPayloadDirectory)) })
+
+			err := SetBaseDir(test.dir)
+			if test.expectedErr {
+				assert.Error(t, err)
+				assert.Equal(t, defaultPayloadDirectory, BaseDir())
+				for i, derived := range derivedPaths {
+					assert.Equal(t, defaults[i], *derived)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedDir, BaseDir())
+			for i, derived := range derivedPaths {
+				assert.Equal(t, test.expectedDir+strings.TrimPrefix(defaults[i], defaultPayloadDirectory), *derived)
+			}
+			for _, path := range append(Files(), ManifestPath) {
+				assert.True(t, strings.HasPrefix(path, test.expectedDir), "%s is not within %s", path, test.expectedDir)
+			}
+			generatedDir := test.expectedDir + generatedDirectoryName + "/"
+			assert.Equal(t, strings.TrimSuffix(generatedDir, "/"), payloadPath(generatedDirectoryName))
+			for _, path := range FilesByCategory()[CategoryGenerated] {
+				assert.True(t, strings.HasPrefix(path, generatedDir), "%s is not within %s", path, generatedDir)
+			}
+			for _, path := range []string{RegistryHostsDir, ContainerdConfGeneratedPath, ExporterWebConfigPath,
+				CredentialProviderConfigPath} {
+				assert.True(t, strings.HasPrefix(path, generatedDir), "%s is not within %s", path, generatedDir)
+			}
+
+			// the shipped files are validated and generated files written within the new directory
+			fsys := writableMapFS{fstest.MapFS{}}
+			for _, file := range shippedFiles() {
+				fsys.MapFS[toFSPath(file)] = &fstest.MapFile{Data: []byte(file)}
+			}
+			assert.NoError(t, ValidateFS(fsys, shippedFiles()))
+			_, _, err = PopulateGCPHostnameScript(GCPHostnameSettings{}, WithFS(fsys))
+			require.NoError(t, err)
+			assert.Contains(t, fsys.MapFS, toFSPath(generatedDir+GcpGetHostnameScriptName))
+		})
+	}
+}
+
+// TestDerivedPathsInSync ensures every exported path built with payloadPath is rewritten by SetBaseDir
+func TestDerivedPathsInSync(t *testing.T) {
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	require.NoError(t, err)
+	require.Contains(t, pkgs, "payload")
+	var pathNames, derivedNames []string
+	for _, file := range pkgs["payload"].Files {
+		for _, decl := range file.Decls {
+			genDecl, ok := decl.(*ast.GenDecl)
+			if !ok || genDecl.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range genDecl.Specs {
+				valueSpec := spec.(*ast.ValueSpec)
+				for i, name := range valueSpec.Names {
+					if i >= len(valueSpec.Values) {
+						continue
+					}
+					if ast.IsExported(name.Name) && referencesIdent(valueSpec.Values[i], "payloadPath") {
+						pathNames = append(pathNames, name.Name)
+					}
+					if name.Name != "derivedPaths" {
+						continue
+					}
+					for _, elt := range valueSpec.Values[i].(*ast.CompositeLit).Elts {
+						derivedNames = append(derivedNames, elt.(*ast.UnaryExpr).X.(*ast.Ident).Name)
+					}
+				}
+			}
+		}
+	}
+	assert.ElementsMatch(t, pathNames, derivedNames)
+}
+
+// referencesIdent returns true if the given expression references the identifier
+func referencesIdent(expr ast.Expr, ident string) bool {
+	found := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == ident {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// evalStringExpr evaluates an expression made of string literals, identifiers, concatenations and payloadPath calls
+func evalStringExpr(t *testing.T, exprs map[string]ast.Expr, expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		value, err := strconv.Unquote(e.Value)
+		require.NoError(t, err)
+		return value
+	case *ast.Ident:
+		require.Contains(t, exprs, e.Name)
+		return evalStringExpr(t, exprs, exprs[e.Name])
+	case *ast.BinaryExpr:
+		require.Equal(t, token.ADD, e.Op)
+		return evalStringExpr(t, exprs, e.X) + evalStringExpr(t, exprs, e.Y)
+	case *ast.ParenExpr:
+		return evalStringExpr(t, exprs, e.X)
+	case *ast.CallExpr:
+		require.True(t, referencesIdent(e.Fun, "payloadPath"), "unsupported function call")
+		var elem []string
+		for _, arg := range e.Args {
+			elem = append(elem, evalStringExpr(t, exprs, arg))
+		}
+		return payloadPath(elem...)
+	}
+	require.Failf(t, "unsupported expression", "%T", expr)
+	return ""
+}
+
+func TestRequiredFiles(t *testing.T) {
+	testCases := []struct {
+		name        string
+		platform    configv1.PlatformType
+		opts        []Option
+		expected    []string
+		notExpected []string
+		expectErr   bool
+	}{
+		{
+			name:        "AWS",
+			platform:    configv1.AWSPlatformType,
+			expected:    []string{CSIProxyPath},
+			notExpected: []string{GcpGetValidHostnameScriptPath, AzureCloudNodeManagerPath},
+		},
+		{
+			name:        "csi-proxy enabled",
+			platform:    configv1.AWSPlatformType,
+			opts:        []Option{WithCSIProxyEnabled(true)},
+			expected:    []string{CSIProxyPath},
+			notExpected: []string{GcpGetValidHostnameScriptPath, AzureCloudNodeManagerPath},
+		},
+		{
+			name:        "csi-proxy disabled",
+			platform:    configv1.AzurePlatformType,
+			opts:        []Option{WithCSIProxyEnabled(false)},
+			expected:    []string{AzureCloudNodeManagerPath},
+			notExpected: []string{CSIProxyPath, GcpGetValidHostnameScriptPath},
+		},
+		{
+			name:        "Azure",
+			platform:    configv1.AzurePlatformType,
+			expected:    []string{AzureCloudNodeManagerPath},
+			notExpected: []string{GcpGetValidHostnameScriptPath},
+		},
+		{
+			name:        "GCP",
+			platform:    configv1.GCPPlatformType,
+			expected:    []string{GcpGetValidHostnameScriptPath},
+			notExpected: []string{AzureCloudNodeManagerPath},
+		},
+		{
+			name:        "platform none",
+			platform:    configv1.NonePlatformType,
+			notExpected: []string{GcpGetValidHostnameScriptPath, AzureCloudNodeManagerPath},
+		},
+		{
+			name:      "unknown platform",
+			platform:  "unknown",
+			expectErr: true,
+		},
+	}
+	universal := []string{KubeletPath, ContainerdPath, WICDPath, HostLocalCNIPlugin, WinBridgeCNIPlugin,
+		WinOverlayCNIPlugin}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := RequiredFiles(test.platform, test.opts...)
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Subset(t, files, universal)
+			assert.Subset(t, files, test.expected)
+			for _, file := range test.notExpected {
+				assert.NotContains(t, files, file)
+			}
+		})
+	}
+}
+
+// writableMapFS is a fstest.MapFS which supports writing files
+type writableMapFS struct {
+	fstest.MapFS
+}
+
+func (w writableMapFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	w.MapFS[name] = &fstest.MapFile{Data: data, Mode: perm}
+	return nil
+}
+
+func TestNewFileInfoFS(t *testing.T) {
+	modTime := time.Now()
+	fsys := fstest.MapFS{
+		"payload/kube-node/kubelet.exe": {Data: []byte("windows"), ModTime: modTime},
+		"payload/cni":                   {Mode: fs.ModeDir},
+	}
+	testCases := []struct {
+		name        string
+		path        string
+		expected    *FileInfo
+		expectedErr error
+	}{
+		{
+			name: "existing file",
+			path: KubeletPath,
+			expected: &FileInfo{Path: KubeletPath, Size: 7, ModTime: modTime, Algorithm: AlgorithmSHA256,
+				SHA256: "340d600392818df2413382dc7d8325c360d83ea49a262d31760348484bbc10b5"},
+		},
+		{
+			name:        "missing file",
+			path:        KubeProxyPath,
+			expectedErr: ErrPayloadFileNotFound,
+		},
+		{
+			name:        "unreadable file",
+			path:        "/payload/cni",
+			expectedErr: ErrPayloadFileUnreadable,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			info, err := NewFileInfo(test.path, WithFS(fsys))
+			if test.expectedErr != nil {
+				require.Error(t, err)
+				assert.ErrorIs(t, err, test.expectedErr)
+				assert.Contains(t, err.Error(), test.path)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, info)
+		})
+	}
+}
+
+func TestCheckUnchangedFS(t *testing.T) {
+	modTime := time.Now()
+	fsys := fstest.MapFS{"payload/kube-node/kubelet.exe": {Data: []byte("kubelet"), ModTime: modTime}}
+	prev, err := NewFileInfo(KubeletPath, WithFS(fsys))
+	require.NoError(t, err)
+
+	fsys["payload/kube-node/kubelet.exe"] = &fstest.MapFile{Data: []byte("kubelex"), ModTime: modTime}
+	unchanged, err := CheckUnchanged(KubeletPath, prev, false, WithFS(fsys))
+	require.NoError(t, err)
+	assert.True(t, unchanged)
+	unchanged, err = CheckUnchanged(KubeletPath, prev, true, WithFS(fsys))
+	require.NoError(t, err)
+	assert.False(t, unchanged)
+
+	delete(fsys, "payload/kube-node/kubelet.exe")
+	_, err = CheckUnchanged(KubeletPath, prev, false, WithFS(fsys))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestValidateFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"payload/kubelet.exe":    {Data: []byte("kubelet")},
+		"payload/empty.exe":      {Data: []byte{}},
+		"payload/cni/win-bridge": {Data: []byte("win-bridge")},
+	}
+	testCases := []struct {
+		name           string
+		paths          []string
+		expectedErrors []string
+	}{
+		{
+			name:  "valid files",
+			paths: []string{"/payload/kubelet.exe", "/payload/cni/win-bridge"},
+		},
+		{
+			name:  "paths with repeated separators",
+			paths: []string{"/payload//kubelet.exe", "/payload//cni/win-bridge"},
+		},
+		{
+			name:  "missing, empty and directory",
+			paths: []string{"/payload/kubelet.exe", "/payload/file-1", "/payload/empty.exe", "/payload/cni"},
+			expectedErrors: []string{
+				"could not stat /payload/file-1: open /payload/file-1: file does not exist",
+				"/payload/empty.exe is empty",
+				"/payload/cni is a directory",
+			},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateFS(fsys, test.paths)
+			if len(test.expectedErrors) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			for _, expected := range test.expectedErrors {
+				assert.Contains(t, err.Error(), expected)
+			}
+		})
+	}
+}
+
+// TestValidateMissingFiles tests if ValidateFS returns an error listing every missing file on the real filesystem
+func TestValidateMissingFiles(t *testing.T) {
+	err := ValidateFS(os.DirFS("/"), []string{"/payload/file-1", "/payload/file-2"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not stat /payload/file-1: stat /payload/file-1: no such file or directory")
+	assert.Contains(t, err.Error(), "could not stat /payload/file-2: stat /payload/file-2: no such file or directory")
+}
+
+func TestDigestFiles(t *testing.T) {
+	payloadDir := t.TempDir()
+	kubelet := filepath.Join(payloadDir, "kube-node", "kubelet.exe")
+	containerd := filepath.Join(payloadDir, "containerd", "containerd.exe")
+	for _, path := range []string{kubelet, containerd} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(filepath.Base(path)), 0644))
+	}
+
+	digest, err := DigestFiles([]string{kubelet, containerd})
+	require.NoError(t, err)
+	assert.Len(t, digest, 64)
+
+	// the digest is deterministic and independent of the order of the given files
+	again, err := DigestFiles([]string{containerd, kubelet})
+	require.NoError(t, err)
+	assert.Equal(t, digest, again)
+
+	// changing the contents of any file changes the digest
+	require.NoError(t, os.WriteFile(kubelet, []byte("new kubelet"), 0644))
+	changed, err := DigestFiles([]string{kubelet, containerd})
+	require.NoError(t, err)
+	assert.NotEqual(t, digest, changed)
+
+	_, err = DigestFiles([]string{filepath.Join(payloadDir, "missing.exe")})
+	assert.Error(t, err)
+}
+
+func TestDestinations(t *testing.T) {
+	destinations := Destinations()
+	for _, path := range Files() {
+		destination, ok := destinations[path]
+		if assert.True(t, ok, "payload file %s has no destination", path) {
+			assert.NotEmpty(t, destination.Dir, "payload file %s has no destination directory", path)
+			assert.NotZero(t, destination.Mode, "payload file %s has no file mode", path)
+		}
+	}
+	assert.Len(t, destinations, len(Files()))
 }
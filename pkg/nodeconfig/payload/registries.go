@@ -0,0 +1,257 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// registryHostsFileName is the name of the file configuring a registry host within containerd's certs.d directory
+	registryHostsFileName = "hosts.toml"
+	// registryCAFileName is the name of the file holding the CA bundle trusted for a registry host and its mirrors
+	registryCAFileName = "ca.crt"
+)
+
+// RegistryHostsDir is the generated payload directory holding the containerd hosts.toml and CA files of the configured
+// registries. Its layout matches the one of RemoteContainerdRegistriesDir on Windows nodes.
+var RegistryHostsDir = payloadPath(generatedDirectoryName, "registries")
+
+// RegistryConfig holds the mirror and trust settings of a source registry
+type RegistryConfig struct {
+	// Source is the registry, as hostname[:port], or the registry namespace whose images are mirrored
+	Source string
+	// Mirrors are the mirrors of Source, in order of preference
+	Mirrors []RegistryMirror
+	// MirrorByDigestOnly restricts the mirrors to pulls by digest, as configured by ImageDigestMirrorSets
+	MirrorByDigestOnly bool
+	// Insecure disables the verification of the certificate of the registry
+	Insecure bool
+	// CA is the PEM encoded CA bundle trusted for the registry and its mirrors
+	CA []byte
+}
+
+// RegistryMirror holds the settings of a mirror of a source registry
+type RegistryMirror struct {
+	// Location is the registry, as hostname[:port], or the registry namespace mirroring the images
+	Location string
+	// DigestOnly restricts the mirror to pulls by digest
+	DigestOnly bool
+	// Insecure disables the verification of the certificate of the mirror
+	Insecure bool
+}
+
+// registryHost holds the settings of a registry, and of the mirrors of all of its namespaces, which are rendered into
+// a containerd hosts.toml file
+type registryHost struct {
+	// insecure disables the verification of the registry certificate
+	insecure bool
+	// mirrors are the mirrors of the registry in order of preference
+	mirrors []registryHostMirror
+	// ca is the PEM encoded CA bundle trusted for the registry and its mirrors
+	ca []byte
+	// caPath is the path on Windows nodes of the file holding ca
+	caPath string
+}
+
+// registryHostMirror holds the settings of a mirror within a containerd hosts.toml file
+type registryHostMirror struct {
+	// url is the URL of the mirror, including the path of its namespace if any
+	url string
+	// overridePath is true if the URL includes a path, which containerd must use as is
+	overridePath bool
+	// digestOnly is true if the mirror is restricted to pulls by digest, so cannot resolve tags
+	digestOnly bool
+	// insecure disables the verification of the mirror certificate
+	insecure bool
+}
+
+// addMirror adds the given mirror to the ones of the registry host. A mirror which was already added is kept in place,
+// and is only restricted to pulls by digest if both are.
+func (r *registryHost) addMirror(mirror registryHostMirror) {
+	for i := range r.mirrors {
+		if r.mirrors[i].url == mirror.url {
+			r.mirrors[i].digestOnly = r.mirrors[i].digestOnly && mirror.digestOnly
+			r.mirrors[i].insecure = r.mirrors[i].insecure || mirror.insecure
+			return
+		}
+	}
+	r.mirrors = append(r.mirrors, mirror)
+}
+
+// render returns the contents of the hosts.toml file of the registry host with the given name
+func (r *registryHost) render(host string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "server = %s\n", strconv.Quote("https://"+host))
+	if r.caPath != "" {
+		fmt.Fprintf(&b, "ca = %s\n", strconv.Quote(r.caPath))
+	}
+	if r.insecure {
+		b.WriteString("skip_verify = true\n")
+	}
+	for _, mirror := range r.mirrors {
+		fmt.Fprintf(&b, "\n[host.%s]\n", strconv.Quote(mirror.url))
+		if mirror.digestOnly {
+			b.WriteString("  capabilities = [\"pull\"]\n")
+		} else {
+			b.WriteString("  capabilities = [\"pull\", \"resolve\"]\n")
+		}
+		if r.caPath != "" {
+			fmt.Fprintf(&b, "  ca = %s\n", strconv.Quote(r.caPath))
+		}
+		if mirror.overridePath {
+			b.WriteString("  override_path = true\n")
+		}
+		if mirror.insecure {
+			b.WriteString("  skip_verify = true\n")
+		}
+	}
+	return b.String()
+}
+
+// splitRegistryLocation splits the given registry location into the registry host and the namespace within it
+func splitRegistryLocation(location string) (string, string) {
+	host, namespace, _ := strings.Cut(strings.TrimSuffix(location, "/"), "/")
+	return host, namespace
+}
+
+// WriteRegistryHostsFiles writes the containerd hosts.toml files configuring the given registries, along with the CA
+// files they reference, to RegistryHostsDir, following the layout of containerd's certs.d directory on Windows. The
+// FileInfos of the written files are returned sorted by path, along with true if the contents of any of them changed.
+// The configurations of namespaces of the same registry host are merged into its file, in the order of their sources,
+// so the output does not depend on the order of the given registries, and containerd falls back to the next mirror,
+// then to the registry itself, when an image cannot be pulled from a mirror. Registries which have neither mirrors, a
+// CA nor are insecure are skipped. Files of registries which are no longer configured are not removed from
+// RegistryHostsDir, so the returned FileInfos, rather than the contents of the directory, are the files to configure
+// nodes with.
+func WriteRegistryHostsFiles(registries []RegistryConfig, opts ...Option) ([]*FileInfo, bool, error) {
+	registries = sortedBySource(registries)
+	registryHosts := make(map[string]*registryHost)
+	for _, registry := range registries {
+		if err := validateRegistryConfig(registry); err != nil {
+			return nil, false, err
+		}
+		if len(registry.Mirrors) == 0 && !registry.Insecure && len(registry.CA) == 0 {
+			continue
+		}
+		host, _ := splitRegistryLocation(registry.Source)
+		if _, ok := registryHosts[host]; !ok {
+			registryHosts[host] = &registryHost{}
+		}
+		registryHost := registryHosts[host]
+		registryHost.insecure = registryHost.insecure || registry.Insecure
+		if len(registry.CA) > 0 {
+			if len(registryHost.ca) > 0 && !bytes.Equal(registryHost.ca, registry.CA) {
+				return nil, false, fmt.Errorf("registry %s has differing CAs", host)
+			}
+			registryHost.ca = registry.CA
+			registryHost.caPath = RemoteContainerdRegistriesDir + "\\" + registryHostDirectory(host) + "\\" +
+				registryCAFileName
+		}
+		for _, mirror := range registry.Mirrors {
+			mirrorHost, namespace := splitRegistryLocation(mirror.Location)
+			hostMirror := registryHostMirror{
+				url:        "https://" + mirrorHost,
+				digestOnly: registry.MirrorByDigestOnly || mirror.DigestOnly,
+				insecure:   mirror.Insecure,
+			}
+			if namespace != "" {
+				hostMirror.url += "/v2/" + namespace
+				hostMirror.overridePath = true
+			}
+			registryHost.addMirror(hostMirror)
+		}
+	}
+
+	files := make(map[string][]byte)
+	for host, registryHost := range registryHosts {
+		dir := path.Join(RegistryHostsDir, registryHostDirectory(host))
+		files[path.Join(dir, registryHostsFileName)] = []byte(registryHost.render(host))
+		if len(registryHost.ca) > 0 {
+			files[path.Join(dir, registryCAFileName)] = registryHost.ca
+		}
+	}
+	paths := make([]string, 0, len(files))
+	for filePath := range files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+	var changed bool
+	fileInfos := make([]*FileInfo, 0, len(paths))
+	for _, filePath := range paths {
+		fileChanged, err := writeGeneratedFile(filePath, string(files[filePath]), opts...)
+		if err != nil {
+			return nil, false, err
+		}
+		changed = changed || fileChanged
+		fileInfo, err := NewFileInfo(filePath, opts...)
+		if err != nil {
+			return nil, false, err
+		}
+		fileInfos = append(fileInfos, fileInfo)
+	}
+	return fileInfos, changed, nil
+}
+
+// sortedBySource returns a copy of the given registries sorted by source. The order of registries with the same
+// source is kept.
+func sortedBySource(registries []RegistryConfig) []RegistryConfig {
+	sorted := append([]RegistryConfig(nil), registries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Source < sorted[j].Source
+	})
+	return sorted
+}
+
+// validateRegistryConfig returns an error if the given registry cannot be configured in a containerd hosts.toml file
+func validateRegistryConfig(registry RegistryConfig) error {
+	if strings.HasPrefix(registry.Source, "*.") {
+		return fmt.Errorf("registry %s: wildcard sources are not supported by containerd", registry.Source)
+	}
+	if err := validateRegistryLocation(registry.Source); err != nil {
+		return err
+	}
+	for _, mirror := range registry.Mirrors {
+		if err := validateRegistryLocation(mirror.Location); err != nil {
+			return fmt.Errorf("mirror of registry %s: %w", registry.Source, err)
+		}
+	}
+	if len(registry.CA) > 0 {
+		if block, _ := pem.Decode(registry.CA); block == nil || block.Type != "CERTIFICATE" {
+			return fmt.Errorf("CA of registry %s is not a PEM encoded certificate", registry.Source)
+		}
+	}
+	return nil
+}
+
+// validateRegistryLocation returns an error if the given location is not a registry host, as hostname[:port],
+// optionally followed by a namespace
+func validateRegistryLocation(location string) error {
+	host, _ := splitRegistryLocation(location)
+	hostname := host
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return fmt.Errorf("registry location %q has an invalid port", location)
+		}
+		hostname = h
+	}
+	if hostname == "" || strings.ContainsAny(hostname, " \t\\\":/[]") {
+		return fmt.Errorf("registry location %q has an invalid host", location)
+	}
+	return nil
+}
+
+// registryHostDirectory returns the name of the directory of the given registry host within containerd's certs.d
+// directory on Windows, where a colon cannot be part of a file name. containerd looks up hostname:port in the
+// hostname_port_ directory.
+func registryHostDirectory(host string) string {
+	if i := strings.LastIndex(host, ":"); i > 0 {
+		return host[:i] + "_" + host[i+1:] + "_"
+	}
+	return host
+}
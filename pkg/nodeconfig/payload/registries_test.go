@@ -0,0 +1,149 @@
+package payload
+
+import (
+	"encoding/pem"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteRegistryHostsFiles(t *testing.T) {
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("mirror-ca")})
+	otherCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("other-ca")})
+	quayDir := "payload/generated/registries/quay.io/"
+	mirrorDir := "payload/generated/registries/registry.example.com_5000_/"
+	testCases := []struct {
+		name        string
+		registries  []RegistryConfig
+		expected    map[string]string
+		expectedErr bool
+	}{
+		{
+			name:     "no registries",
+			expected: map[string]string{},
+		},
+		{
+			name: "mirrors with CA",
+			registries: []RegistryConfig{
+				{
+					Source:  "quay.io/openshift-release-dev/ocp-v4.0-art-dev",
+					Mirrors: []RegistryMirror{{Location: "mirror.example.com:5000/ocp/art"}},
+					CA:      ca,
+				},
+				{
+					Source: "quay.io/openshift-release-dev/ocp-release",
+					Mirrors: []RegistryMirror{{Location: "mirror.example.com:5000/ocp/release"},
+						{Location: "mirror.example.com:5000/ocp/art"}},
+					MirrorByDigestOnly: true,
+					CA:                 ca,
+				},
+				{Source: "registry.example.com:5000", Insecure: true},
+				{Source: "docker.io"},
+			},
+			expected: map[string]string{
+				// the namespaces of a registry are merged in the order of their sources
+				quayDir + "hosts.toml": `server = "https://quay.io"
+ca = "C:\\k\\containerd\\registries\\quay.io\\ca.crt"
+
+[host."https://mirror.example.com:5000/v2/ocp/release"]
+  capabilities = ["pull"]
+  ca = "C:\\k\\containerd\\registries\\quay.io\\ca.crt"
+  override_path = true
+
+[host."https://mirror.example.com:5000/v2/ocp/art"]
+  capabilities = ["pull", "resolve"]
+  ca = "C:\\k\\containerd\\registries\\quay.io\\ca.crt"
+  override_path = true
+`,
+				quayDir + "ca.crt": string(ca),
+				mirrorDir + "hosts.toml": `server = "https://registry.example.com:5000"
+skip_verify = true
+`,
+			},
+		},
+		{
+			name: "mirror settings",
+			registries: []RegistryConfig{
+				{
+					Source: "registry.example.com:5000/team",
+					Mirrors: []RegistryMirror{{Location: "mirror.example.com/team", DigestOnly: true},
+						{Location: "mirror.example.com:5000", Insecure: true}},
+				},
+				{Source: "registry.example.com:5000", Insecure: true},
+			},
+			expected: map[string]string{
+				mirrorDir + "hosts.toml": `server = "https://registry.example.com:5000"
+skip_verify = true
+
+[host."https://mirror.example.com/v2/team"]
+  capabilities = ["pull"]
+  override_path = true
+
+[host."https://mirror.example.com:5000"]
+  capabilities = ["pull", "resolve"]
+  skip_verify = true
+`,
+			},
+		},
+		{
+			name: "differing CAs of a registry",
+			registries: []RegistryConfig{
+				{Source: "quay.io/a", Mirrors: []RegistryMirror{{Location: "mirror.example.com/a"}}, CA: ca},
+				{Source: "quay.io/b", Mirrors: []RegistryMirror{{Location: "mirror.example.com/b"}}, CA: otherCA},
+			},
+			expectedErr: true,
+		},
+		{
+			name: "wildcard source",
+			registries: []RegistryConfig{
+				{Source: "*.example.com", Mirrors: []RegistryMirror{{Location: "mirror.example.com"}}},
+			},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid mirror",
+			registries:  []RegistryConfig{{Source: "quay.io", Mirrors: []RegistryMirror{{Location: "mirror.example.com:http"}}}},
+			expectedErr: true,
+		},
+		{
+			name:        "invalid CA",
+			registries:  []RegistryConfig{{Source: "quay.io", CA: []byte("not a certificate")}},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := writableMapFS{fstest.MapFS{}}
+			fileInfos, changed, err := WriteRegistryHostsFiles(test.registries, WithFS(fsys))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, len(test.expected) > 0, changed)
+			contents := make(map[string]string)
+			var paths []string
+			for _, fileInfo := range fileInfos {
+				paths = append(paths, fileInfo.Path)
+				data, err := fs.ReadFile(fsys, toFSPath(fileInfo.Path))
+				require.NoError(t, err)
+				contents[toFSPath(fileInfo.Path)] = string(data)
+			}
+			assert.Equal(t, test.expected, contents)
+			assert.IsIncreasing(t, paths)
+
+			// the output does not depend on the order of the registries, so regenerating it changes nothing
+			reversed := append([]RegistryConfig(nil), test.registries...)
+			for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+				reversed[i], reversed[j] = reversed[j], reversed[i]
+			}
+			regenerated, changed, err := WriteRegistryHostsFiles(reversed, WithFS(fsys))
+			require.NoError(t, err)
+			assert.False(t, changed)
+			assert.Equal(t, fileInfos, regenerated)
+		})
+	}
+}
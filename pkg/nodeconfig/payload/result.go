@@ -0,0 +1,124 @@
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// NetworkScriptStatus is the status reported by a generated network script
+type NetworkScriptStatus string
+
+const (
+	// NetworkScriptSucceeded is reported when the script completed successfully
+	NetworkScriptSucceeded NetworkScriptStatus = "Succeeded"
+	// NetworkScriptFailed is reported when a step of the script failed
+	NetworkScriptFailed NetworkScriptStatus = "Failed"
+)
+
+// NetworkScriptStep identifies the step of a generated network script which failed
+type NetworkScriptStep string
+
+const (
+	// StepGetHNSNetwork is the step importing the HNS module and getting the HNS network
+	StepGetHNSNetwork NetworkScriptStep = "GetHNSNetwork"
+	// StepQueryHNSEndpoints is the step querying the existing HNS endpoints
+	StepQueryHNSEndpoints NetworkScriptStep = "QueryHNSEndpoints"
+	// StepDeleteStaleEndpoint is the step deleting a VIP endpoint belonging to a previous HNS network
+	StepDeleteStaleEndpoint NetworkScriptStep = "DeleteStaleEndpoint"
+	// StepCreateEndpoint is the step creating and attaching the VIP endpoint
+	StepCreateEndpoint NetworkScriptStep = "CreateEndpoint"
+	// StepResolveSourceVIP is the step resolving the IP of the VIP endpoint
+	StepResolveSourceVIP NetworkScriptStep = "ResolveSourceVIP"
+	// StepWriteCNIConfig is the step writing the CNI config file
+	StepWriteCNIConfig NetworkScriptStep = "WriteCNIConfig"
+	// StepCheckHNSService is the preflight check of the HNS service running
+	StepCheckHNSService NetworkScriptStep = "CheckHNSService"
+	// StepCheckHNSSubnet is the check of the HNS network having a subnet, or the node's host subnet
+	StepCheckHNSSubnet NetworkScriptStep = "CheckHNSSubnet"
+	// StepCheckManagementIP is the preflight check of the HNS network having a management IP
+	StepCheckManagementIP NetworkScriptStep = "CheckManagementIP"
+)
+
+// ErrNoNetworkScriptResult is returned when script output does not contain a result, as is the case for scripts
+// generated before results were reported. Such output should be handled as it was before.
+var ErrNoNetworkScriptResult = errors.New("script output does not contain a result")
+
+// NetworkScriptResult is the result reported by a generated network script as the last line of its output
+type NetworkScriptResult struct {
+	// Status is the status of the script
+	Status NetworkScriptStatus `json:"status"`
+	// FailedStep is the step which failed, if the script failed
+	FailedStep NetworkScriptStep `json:"failedStep,omitempty"`
+	// Message describes the failure, if the script failed
+	Message string `json:"message,omitempty"`
+	// SourceVIP is the IP of the VIP endpoint, reported by the kube-proxy preparation script
+	SourceVIP string `json:"sourceVip,omitempty"`
+}
+
+// NetworkScriptError is the error describing a failed network script
+type NetworkScriptError struct {
+	// FailedStep is the step which failed
+	FailedStep NetworkScriptStep
+	// Message describes the failure
+	Message string
+}
+
+// Error returns the error message of the failed script
+func (e *NetworkScriptError) Error() string {
+	return fmt.Sprintf("network script failed at step %s: %s", e.FailedStep, e.Message)
+}
+
+// Reason returns the reason of the event reporting the failure, identifying the missing precondition or the failed
+// step. Each reason matches the exit code the script fails with.
+func (e *NetworkScriptError) Reason() string {
+	switch e.FailedStep {
+	case StepGetHNSNetwork:
+		return "HNSNetworkNotFound"
+	case StepQueryHNSEndpoints:
+		return "HNSQueryFailed"
+	case StepDeleteStaleEndpoint, StepCreateEndpoint:
+		return "EndpointCreateFailed"
+	case StepResolveSourceVIP:
+		return "SourceVIPNotFound"
+	case StepWriteCNIConfig:
+		return "CNIConfigWriteFailed"
+	case StepCheckHNSService:
+		return "HNSServiceNotRunning"
+	case StepCheckHNSSubnet:
+		return "HNSSubnetMissing"
+	case StepCheckManagementIP:
+		return "ManagementIPMissing"
+	}
+	return "NetworkScriptFailed"
+}
+
+// ParseNetworkScriptResult parses the result from the output of a generated network script. The result is the last
+// non-empty line of the output, so that output written before it by the script's commands is ignored.
+// ErrNoNetworkScriptResult is returned if the output does not end with a result.
+func ParseNetworkScriptResult(output string) (*NetworkScriptResult, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	lastLine := strings.TrimSpace(lines[len(lines)-1])
+	if !strings.HasPrefix(lastLine, "{") {
+		return nil, ErrNoNetworkScriptResult
+	}
+	var result NetworkScriptResult
+	if err := json.Unmarshal([]byte(lastLine), &result); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoNetworkScriptResult, err)
+	}
+	switch result.Status {
+	case NetworkScriptSucceeded, NetworkScriptFailed:
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", ErrNoNetworkScriptResult, result.Status)
+	}
+	return &result, nil
+}
+
+// Err returns a *NetworkScriptError if the script failed, and nil otherwise
+func (r *NetworkScriptResult) Err() error {
+	if r.Status == NetworkScriptSucceeded {
+		return nil
+	}
+	return &NetworkScriptError{FailedStep: r.FailedStep, Message: r.Message}
+}
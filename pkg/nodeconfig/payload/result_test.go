@@ -0,0 +1,95 @@
+package payload
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetworkScriptResult(t *testing.T) {
+	testCases := []struct {
+		name        string
+		output      string
+		expected    *NetworkScriptResult
+		expectedErr error
+	}{
+		{
+			name:     "success with source VIP",
+			output:   `{"status":"Succeeded","failedStep":"","message":"","sourceVip":"10.132.0.2"}` + "\r\n",
+			expected: &NetworkScriptResult{Status: NetworkScriptSucceeded, SourceVIP: "10.132.0.2"},
+		},
+		{
+			name: "failure after other output",
+			output: "Attaching endpoint\r\n" +
+				`{"status":"Failed","failedStep":"GetHNSNetwork","message":"not found","sourceVip":""}`,
+			expected: &NetworkScriptResult{Status: NetworkScriptFailed, FailedStep: StepGetHNSNetwork,
+				Message: "not found"},
+		},
+		{
+			name:        "legacy source VIP output",
+			output:      "10.132.0.2\r\n",
+			expectedErr: ErrNoNetworkScriptResult,
+		},
+		{
+			name:        "empty output",
+			expectedErr: ErrNoNetworkScriptResult,
+		},
+		{
+			name:        "truncated result",
+			output:      `{"status":"Succeeded",`,
+			expectedErr: ErrNoNetworkScriptResult,
+		},
+		{
+			name:        "unknown status",
+			output:      `{"status":"Pending"}`,
+			expectedErr: ErrNoNetworkScriptResult,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := ParseNetworkScriptResult(test.output)
+			if test.expectedErr != nil {
+				assert.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestNetworkScriptResultErr(t *testing.T) {
+	assert.NoError(t, (&NetworkScriptResult{Status: NetworkScriptSucceeded}).Err())
+
+	err := (&NetworkScriptResult{Status: NetworkScriptFailed, FailedStep: StepCreateEndpoint,
+		Message: "access denied"}).Err()
+	var scriptErr *NetworkScriptError
+	require.True(t, errors.As(err, &scriptErr))
+	assert.Equal(t, StepCreateEndpoint, scriptErr.FailedStep)
+	assert.Equal(t, "network script failed at step CreateEndpoint: access denied", err.Error())
+}
+
+func TestNetworkScriptErrorReason(t *testing.T) {
+	testCases := []struct {
+		step     NetworkScriptStep
+		expected string
+	}{
+		{step: StepGetHNSNetwork, expected: "HNSNetworkNotFound"},
+		{step: StepQueryHNSEndpoints, expected: "HNSQueryFailed"},
+		{step: StepDeleteStaleEndpoint, expected: "EndpointCreateFailed"},
+		{step: StepCreateEndpoint, expected: "EndpointCreateFailed"},
+		{step: StepResolveSourceVIP, expected: "SourceVIPNotFound"},
+		{step: StepWriteCNIConfig, expected: "CNIConfigWriteFailed"},
+		{step: StepCheckHNSService, expected: "HNSServiceNotRunning"},
+		{step: StepCheckHNSSubnet, expected: "HNSSubnetMissing"},
+		{step: StepCheckManagementIP, expected: "ManagementIPMissing"},
+		{step: "Unknown", expected: "NetworkScriptFailed"},
+	}
+	for _, test := range testCases {
+		t.Run(string(test.step), func(t *testing.T) {
+			assert.Equal(t, test.expected, (&NetworkScriptError{FailedStep: test.step}).Reason())
+		})
+	}
+}
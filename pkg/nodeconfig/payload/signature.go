@@ -0,0 +1,406 @@
+package payload
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnsigned is returned when a payload executable does not contain an Authenticode signature
+var ErrUnsigned = errors.New("file does not contain an Authenticode signature")
+
+const (
+	// certificateTableIndex is the index of the certificate table, also known as the security directory, within the
+	// data directories of the PE optional header
+	certificateTableIndex = 4
+	// winCertRevision2 is the only revision of the WIN_CERTIFICATE structure in use
+	winCertRevision2 = 0x0200
+	// winCertTypePKCSSignedData identifies a WIN_CERTIFICATE containing a PKCS#7 SignedData structure
+	winCertTypePKCSSignedData = 0x0002
+	// winCertHeaderSize is the size of the WIN_CERTIFICATE header preceding the certificate data
+	winCertHeaderSize = 8
+)
+
+var (
+	oidSignedData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSpcIndirectData     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidAttrContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidDigestSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidEncryptionRSA       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidEncryptionECDSA     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSignatureSHA256RSA  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSignatureSHA256ECDS = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// contentInfo is the PKCS#7 ContentInfo structure. Content holds the explicitly tagged [0] wrapper.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// signedData is the PKCS#7 SignedData structure
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+// signerInfo is the PKCS#7 SignerInfo structure
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// issuerAndSerialNumber identifies the certificate of a signer
+type issuerAndSerialNumber struct {
+	IssuerName   asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// attribute is a PKCS#7 authenticated attribute
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// spcIndirectDataContent is the Authenticode content signed by the PKCS#7 structure, holding the PE image digest
+type spcIndirectDataContent struct {
+	Data          asn1.RawValue
+	MessageDigest digestInfo
+}
+
+// digestInfo holds a digest and the algorithm used to compute it
+type digestInfo struct {
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	Digest          []byte
+}
+
+// VerifySignature parses the PE executable at the given path and validates its Authenticode signature: the signed
+// image digest must match the contents of the file, the signature must be valid, and the signing certificate must
+// chain up to one of the trusted certificates. ErrUnsigned is returned if the file is not signed. Timestamp
+// countersignatures are not evaluated, so the signing certificate must be valid at the current time.
+func VerifySignature(path string, trusted *x509.CertPool, opts ...Option) error {
+	data, err := fs.ReadFile(newOptions(opts).fsys, toFSPath(path))
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", path, withPath(err, path))
+	}
+	if err := verifyAuthenticode(data, trusted, time.Now()); err != nil {
+		return fmt.Errorf("signature verification failed for %s: %w", path, err)
+	}
+	return nil
+}
+
+// VerifyAllSignatures verifies the Authenticode signature of every payload executable, returning the verification
+// error of each file that failed, keyed by path. Callers can use errors.Is(err, ErrUnsigned) to decide whether an
+// unsigned file should be treated as a warning or a failure.
+func VerifyAllSignatures(trusted *x509.CertPool, opts ...Option) map[string]error {
+	failures := make(map[string]error)
+	for _, path := range Files() {
+		if !strings.HasSuffix(path, ".exe") {
+			continue
+		}
+		if err := VerifySignature(path, trusted, opts...); err != nil {
+			failures[path] = err
+		}
+	}
+	return failures
+}
+
+// verifyAuthenticode validates the Authenticode signature of the given PE image
+func verifyAuthenticode(data []byte, trusted *x509.CertPool, now time.Time) error {
+	layout, err := parsePELayout(data)
+	if err != nil {
+		return err
+	}
+	if layout.certTableSize == 0 {
+		return ErrUnsigned
+	}
+	pkcs7, err := readWinCertificate(data, layout)
+	if err != nil {
+		return err
+	}
+
+	var outer contentInfo
+	if _, err := asn1.Unmarshal(pkcs7, &outer); err != nil {
+		return fmt.Errorf("could not parse PKCS#7 content: %w", err)
+	}
+	if !outer.ContentType.Equal(oidSignedData) {
+		return fmt.Errorf("unexpected PKCS#7 content type %s", outer.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(outer.Content.Bytes, &sd); err != nil {
+		return fmt.Errorf("could not parse PKCS#7 SignedData: %w", err)
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirectData) {
+		return fmt.Errorf("unexpected signed content type %s", sd.ContentInfo.ContentType)
+	}
+	// Authenticode signs the contents of the SpcIndirectDataContent sequence, excluding its tag and length
+	var indirectData asn1.RawValue
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &indirectData); err != nil {
+		return fmt.Errorf("could not parse signed content: %w", err)
+	}
+	var spc spcIndirectDataContent
+	if _, err := asn1.Unmarshal(indirectData.FullBytes, &spc); err != nil {
+		return fmt.Errorf("could not parse SpcIndirectDataContent: %w", err)
+	}
+
+	// The image digest in the signed content must match the actual contents of the file
+	imageHash, err := hashForOID(spc.MessageDigest.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(authenticodeDigest(data, layout, imageHash), spc.MessageDigest.Digest) {
+		return fmt.Errorf("image digest does not match the signed digest, the file has been modified")
+	}
+
+	if len(sd.SignerInfos) != 1 {
+		return fmt.Errorf("expected exactly one signer, found %d", len(sd.SignerInfos))
+	}
+	signer := sd.SignerInfos[0]
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return fmt.Errorf("could not parse signing certificates: %w", err)
+	}
+	signerCert := findSignerCertificate(certs, signer.IssuerAndSerialNumber)
+	if signerCert == nil {
+		return fmt.Errorf("signing certificate not found in signature")
+	}
+	if err := verifySignerInfo(signer, signerCert, indirectData.Bytes); err != nil {
+		return err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs {
+		if cert != signerCert {
+			intermediates.AddCert(cert)
+		}
+	}
+	if _, err := signerCert.Verify(x509.VerifyOptions{
+		Roots:         trusted,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("signing certificate is not trusted: %w", err)
+	}
+	return nil
+}
+
+// verifySignerInfo ensures the authenticated attributes of the signer match the signed content, and that the
+// signature over those attributes was made by the given certificate
+func verifySignerInfo(signer signerInfo, cert *x509.Certificate, content []byte) error {
+	if len(signer.AuthenticatedAttributes.Bytes) == 0 {
+		return fmt.Errorf("signer is missing authenticated attributes")
+	}
+	digestHash, err := hashForOID(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	var messageDigest []byte
+	var contentType asn1.ObjectIdentifier
+	rest := signer.AuthenticatedAttributes.Bytes
+	for len(rest) > 0 {
+		var attr attribute
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return fmt.Errorf("could not parse authenticated attributes: %w", err)
+		}
+		if len(attr.Values) != 1 {
+			continue
+		}
+		switch {
+		case attr.Type.Equal(oidAttrMessageDigest):
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &messageDigest); err != nil {
+				return fmt.Errorf("could not parse message digest attribute: %w", err)
+			}
+		case attr.Type.Equal(oidAttrContentType):
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &contentType); err != nil {
+				return fmt.Errorf("could not parse content type attribute: %w", err)
+			}
+		}
+	}
+	if !contentType.Equal(oidSpcIndirectData) {
+		return fmt.Errorf("unexpected content type attribute %s", contentType)
+	}
+	h := digestHash.New()
+	h.Write(content)
+	if !bytes.Equal(h.Sum(nil), messageDigest) {
+		return fmt.Errorf("message digest attribute does not match the signed content")
+	}
+
+	// The signature is computed over the DER encoding of the attributes as a SET, rather than the implicit [0] tag
+	signedAttrs := append([]byte{}, signer.AuthenticatedAttributes.FullBytes...)
+	signedAttrs[0] = 0x31
+	sigAlgorithm, err := signatureAlgorithm(signer.DigestEncryptionAlgorithm.Algorithm, digestHash)
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignature(sigAlgorithm, signedAttrs, signer.EncryptedDigest); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	return nil
+}
+
+// findSignerCertificate returns the certificate identified by the given issuer and serial number
+func findSignerCertificate(certs []*x509.Certificate, id issuerAndSerialNumber) *x509.Certificate {
+	for _, cert := range certs {
+		if id.SerialNumber != nil && cert.SerialNumber.Cmp(id.SerialNumber) == 0 &&
+			bytes.Equal(cert.RawIssuer, id.IssuerName.FullBytes) {
+			return cert
+		}
+	}
+	return nil
+}
+
+// hashForOID returns the hash function identified by the given digest algorithm OID
+func hashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidDigestSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidDigestSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidDigestSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidDigestSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %s", oid)
+}
+
+// signatureAlgorithm returns the x509 signature algorithm for the given PKCS#7 digest encryption algorithm and hash
+func signatureAlgorithm(oid asn1.ObjectIdentifier, hash crypto.Hash) (x509.SignatureAlgorithm, error) {
+	isRSA := oid.Equal(oidEncryptionRSA) || oid.Equal(oidSignatureSHA256RSA)
+	isECDSA := oid.Equal(oidEncryptionECDSA) || oid.Equal(oidSignatureSHA256ECDS)
+	switch {
+	case isRSA && hash == crypto.SHA1:
+		return x509.SHA1WithRSA, nil
+	case isRSA && hash == crypto.SHA256:
+		return x509.SHA256WithRSA, nil
+	case isRSA && hash == crypto.SHA384:
+		return x509.SHA384WithRSA, nil
+	case isRSA && hash == crypto.SHA512:
+		return x509.SHA512WithRSA, nil
+	case isECDSA && hash == crypto.SHA256:
+		return x509.ECDSAWithSHA256, nil
+	case isECDSA && hash == crypto.SHA384:
+		return x509.ECDSAWithSHA384, nil
+	case isECDSA && hash == crypto.SHA512:
+		return x509.ECDSAWithSHA512, nil
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported signature algorithm %s with %s", oid, hash)
+}
+
+// peLayout holds the file offsets of the PE fields that are excluded from the Authenticode digest
+type peLayout struct {
+	// checksumOffset is the file offset of the CheckSum field of the optional header
+	checksumOffset int
+	// certDirEntryOffset is the file offset of the certificate table entry within the data directories
+	certDirEntryOffset int
+	// certTableOffset is the file offset of the certificate table
+	certTableOffset int
+	// certTableSize is the size of the certificate table, zero if the file is not signed
+	certTableSize int
+}
+
+// parsePELayout parses the headers of the given PE image, returning the location of the fields relevant to
+// Authenticode
+func parsePELayout(data []byte) (*peLayout, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse PE file: %w", err)
+	}
+	defer f.Close()
+	// The PE signature offset is stored at 0x3c, and is followed by the 4 byte signature and the COFF file header
+	if len(data) < 0x40 {
+		return nil, fmt.Errorf("file too small to be a PE image")
+	}
+	optionalHeaderOffset := int(binary.LittleEndian.Uint32(data[0x3c:])) + 4 + binary.Size(f.FileHeader)
+	layout := &peLayout{checksumOffset: optionalHeaderOffset + 64}
+	var certDir pe.DataDirectory
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if header.NumberOfRvaAndSizes <= certificateTableIndex {
+			return layout, nil
+		}
+		layout.certDirEntryOffset = optionalHeaderOffset + 96 + certificateTableIndex*8
+		certDir = header.DataDirectory[certificateTableIndex]
+	case *pe.OptionalHeader64:
+		if header.NumberOfRvaAndSizes <= certificateTableIndex {
+			return layout, nil
+		}
+		layout.certDirEntryOffset = optionalHeaderOffset + 112 + certificateTableIndex*8
+		certDir = header.DataDirectory[certificateTableIndex]
+	default:
+		return nil, fmt.Errorf("PE file is missing the optional header")
+	}
+	// The VirtualAddress of the certificate table is a file offset rather than a memory address
+	layout.certTableOffset = int(certDir.VirtualAddress)
+	layout.certTableSize = int(certDir.Size)
+	if layout.certTableSize > 0 && (layout.certTableOffset < layout.certDirEntryOffset+8 ||
+		layout.certTableOffset+layout.certTableSize > len(data)) {
+		return nil, fmt.Errorf("certificate table is out of bounds")
+	}
+	return layout, nil
+}
+
+// readWinCertificate returns the PKCS#7 data held by the first WIN_CERTIFICATE in the certificate table
+func readWinCertificate(data []byte, layout *peLayout) ([]byte, error) {
+	table := data[layout.certTableOffset : layout.certTableOffset+layout.certTableSize]
+	if len(table) < winCertHeaderSize {
+		return nil, fmt.Errorf("certificate table too small")
+	}
+	length := int(binary.LittleEndian.Uint32(table[0:4]))
+	revision := binary.LittleEndian.Uint16(table[4:6])
+	certType := binary.LittleEndian.Uint16(table[6:8])
+	if length < winCertHeaderSize || length > len(table) {
+		return nil, fmt.Errorf("invalid certificate length %d", length)
+	}
+	if revision != winCertRevision2 || certType != winCertTypePKCSSignedData {
+		return nil, fmt.Errorf("unsupported certificate revision %#x or type %#x", revision, certType)
+	}
+	return table[winCertHeaderSize:length], nil
+}
+
+// authenticodeDigest computes the Authenticode digest of the given PE image. The checksum, the certificate table
+// directory entry and the certificate table itself are excluded from the digest.
+func authenticodeDigest(data []byte, layout *peLayout, hash crypto.Hash) []byte {
+	type span struct{ start, end int }
+	excluded := []span{{layout.checksumOffset, layout.checksumOffset + 4}}
+	if layout.certDirEntryOffset > 0 {
+		excluded = append(excluded, span{layout.certDirEntryOffset, layout.certDirEntryOffset + 8})
+	}
+	if layout.certTableSize > 0 {
+		excluded = append(excluded, span{layout.certTableOffset, layout.certTableOffset + layout.certTableSize})
+	}
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i].start < excluded[j].start })
+	h := hash.New()
+	offset := 0
+	for _, s := range excluded {
+		h.Write(data[offset:s.start])
+		offset = s.end
+	}
+	h.Write(data[offset:])
+	return h.Sum(nil)
+}
@@ -0,0 +1,228 @@
+package payload
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCA holds a certificate and key usable for issuing and signing in tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// newTestCertificate creates a certificate signed by the given parent, self-signed if parent is nil
+func newTestCertificate(t *testing.T, name string, isCA bool, parent *testCA) *testCA {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	issuerCert, issuerKey := template, key
+	if parent != nil {
+		issuerCert, issuerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuerCert, &key.PublicKey, issuerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// newTestPE returns a minimal PE32+ image with no sections, followed by the given body
+func newTestPE(t *testing.T, body []byte) []byte {
+	const peOffset = 0x40
+	data := make([]byte, peOffset)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3c:], peOffset)
+	data = append(data, 'P', 'E', 0, 0)
+
+	optionalHeader := pe.OptionalHeader64{
+		Magic:               0x20b,
+		SizeOfHeaders:       0x200,
+		NumberOfRvaAndSizes: 16,
+	}
+	fileHeader := pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		SizeOfOptionalHeader: uint16(binary.Size(optionalHeader)),
+	}
+	buf := bytes.NewBuffer(data)
+	require.NoError(t, binary.Write(buf, binary.LittleEndian, fileHeader))
+	require.NoError(t, binary.Write(buf, binary.LittleEndian, optionalHeader))
+	data = append(buf.Bytes(), body...)
+	// the certificate table must be 8 byte aligned
+	for len(data)%8 != 0 {
+		data = append(data, 0)
+	}
+	return data
+}
+
+// signTestPE appends an Authenticode signature made by the given signer to the PE image
+func signTestPE(t *testing.T, image []byte, signer *testCA, chain ...*x509.Certificate) []byte {
+	layout, err := parsePELayout(image)
+	require.NoError(t, err)
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256, Parameters: asn1.NullRawValue}
+
+	spc, err := asn1.Marshal(spcIndirectDataContent{
+		Data: asn1.NullRawValue,
+		MessageDigest: digestInfo{
+			DigestAlgorithm: sha256Alg,
+			Digest:          authenticodeDigest(image, layout, crypto.SHA256),
+		},
+	})
+	require.NoError(t, err)
+	var spcValue asn1.RawValue
+	_, err = asn1.Unmarshal(spc, &spcValue)
+	require.NoError(t, err)
+	contentDigest := sha256.Sum256(spcValue.Bytes)
+
+	contentTypeValue, err := asn1.Marshal(oidSpcIndirectData)
+	require.NoError(t, err)
+	messageDigestValue, err := asn1.Marshal(contentDigest[:])
+	require.NoError(t, err)
+	attrs, err := asn1.MarshalWithParams([]attribute{
+		{Type: oidAttrContentType, Values: []asn1.RawValue{{FullBytes: contentTypeValue}}},
+		{Type: oidAttrMessageDigest, Values: []asn1.RawValue{{FullBytes: messageDigestValue}}},
+	}, "set")
+	require.NoError(t, err)
+	attrsDigest := sha256.Sum256(attrs)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, signer.key, crypto.SHA256, attrsDigest[:])
+	require.NoError(t, err)
+	implicitAttrs := append([]byte{}, attrs...)
+	implicitAttrs[0] = 0xa0
+
+	var certs []byte
+	for _, cert := range append([]*x509.Certificate{signer.cert}, chain...) {
+		certs = append(certs, cert.Raw...)
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		ContentInfo: contentInfo{
+			ContentType: oidSpcIndirectData,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: spc},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerialNumber{
+				IssuerName:   asn1.RawValue{FullBytes: signer.cert.RawIssuer},
+				SerialNumber: signer.cert.SerialNumber,
+			},
+			DigestAlgorithm:           sha256Alg,
+			AuthenticatedAttributes:   asn1.RawValue{FullBytes: implicitAttrs},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidEncryptionRSA, Parameters: asn1.NullRawValue},
+			EncryptedDigest:           signature,
+		}},
+	})
+	require.NoError(t, err)
+	pkcs7, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	require.NoError(t, err)
+
+	winCert := make([]byte, winCertHeaderSize, winCertHeaderSize+len(pkcs7)+8)
+	winCert = append(winCert, pkcs7...)
+	for len(winCert)%8 != 0 {
+		winCert = append(winCert, 0)
+	}
+	binary.LittleEndian.PutUint32(winCert[0:4], uint32(len(winCert)))
+	binary.LittleEndian.PutUint16(winCert[4:6], winCertRevision2)
+	binary.LittleEndian.PutUint16(winCert[6:8], winCertTypePKCSSignedData)
+
+	signed := append(append([]byte{}, image...), winCert...)
+	binary.LittleEndian.PutUint32(signed[layout.certDirEntryOffset:], uint32(len(image)))
+	binary.LittleEndian.PutUint32(signed[layout.certDirEntryOffset+4:], uint32(len(winCert)))
+	return signed
+}
+
+func TestVerifySignature(t *testing.T) {
+	root := newTestCertificate(t, "root", true, nil)
+	intermediate := newTestCertificate(t, "intermediate", true, root)
+	leaf := newTestCertificate(t, "leaf", false, intermediate)
+	untrusted := newTestCertificate(t, "untrusted", true, nil)
+	trusted := x509.NewCertPool()
+	trusted.AddCert(root.cert)
+	image := newTestPE(t, []byte("windows executable body"))
+
+	testCases := []struct {
+		name          string
+		contents      []byte
+		expectedErr   bool
+		expectedUnsig bool
+	}{
+		{
+			name:     "signed by trusted chain",
+			contents: signTestPE(t, image, leaf, intermediate.cert),
+		},
+		{
+			name:          "unsigned",
+			contents:      image,
+			expectedErr:   true,
+			expectedUnsig: true,
+		},
+		{
+			name:        "signed by untrusted certificate",
+			contents:    signTestPE(t, image, untrusted),
+			expectedErr: true,
+		},
+		{
+			name:        "missing intermediate",
+			contents:    signTestPE(t, image, leaf),
+			expectedErr: true,
+		},
+		{
+			name: "modified after signing",
+			contents: func() []byte {
+				signed := signTestPE(t, image, leaf, intermediate.cert)
+				signed[len(image)-8] ^= 0xff
+				return signed
+			}(),
+			expectedErr: true,
+		},
+		{
+			name:        "not a PE file",
+			contents:    []byte("not an executable"),
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.exe")
+			require.NoError(t, os.WriteFile(path, test.contents, 0644))
+			err := VerifySignature(path, trusted)
+			if !test.expectedErr {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, test.expectedUnsig, errors.Is(err, ErrUnsigned))
+		})
+	}
+}
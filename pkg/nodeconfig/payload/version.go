@@ -0,0 +1,289 @@
+package payload
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// UnknownVersion is reported for executables which do not contain version information
+const UnknownVersion = "unknown"
+
+const (
+	// resourceTableIndex is the index of the resource table within the data directories of the PE optional header
+	resourceTableIndex = 2
+	// rtVersion is the resource type ID of version information resources
+	rtVersion = 16
+	// resourceSubdirectoryFlag is set on resource directory entries pointing at a subdirectory, rather than data
+	resourceSubdirectoryFlag = 0x80000000
+	// fixedFileInfoSignature is the signature of a VS_FIXEDFILEINFO structure
+	fixedFileInfoSignature = 0xfeef04bd
+	// versionBlockTypeText is the wType value of version blocks holding text
+	versionBlockTypeText = 1
+)
+
+// VersionInfo holds the version information embedded in a Windows executable
+type VersionInfo struct {
+	FileVersion    string
+	ProductVersion string
+}
+
+// ExtractVersion parses the VERSIONINFO resource of the Windows executable at the given path, returning its file and
+// product versions. The string versions are preferred, falling back to the numeric versions when they are not set.
+// Versions are reported as UnknownVersion if the executable does not contain version information.
+func ExtractVersion(path string, opts ...Option) (*VersionInfo, error) {
+	data, err := fs.ReadFile(newOptions(opts).fsys, toFSPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, withPath(err, path))
+	}
+	resource, err := readVersionResource(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not read version resource of %s: %w", path, err)
+	}
+	info := &VersionInfo{FileVersion: UnknownVersion, ProductVersion: UnknownVersion}
+	if resource == nil {
+		return info, nil
+	}
+	root, _, err := parseVersionBlock(resource)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse version resource of %s: %w", path, err)
+	}
+	if fileVersion, productVersion, ok := parseFixedFileInfo(root.value); ok {
+		info.FileVersion, info.ProductVersion = fileVersion, productVersion
+	}
+	strs := root.stringTable()
+	if v := strs["FileVersion"]; v != "" {
+		info.FileVersion = v
+	}
+	if v := strs["ProductVersion"]; v != "" {
+		info.ProductVersion = v
+	}
+	return info, nil
+}
+
+// Versions returns the version information of every payload executable, keyed by path
+func Versions(opts ...Option) (map[string]*VersionInfo, error) {
+	versions := make(map[string]*VersionInfo)
+	var errorMessages []string
+	for _, path := range Files() {
+		if !strings.HasSuffix(path, ".exe") {
+			continue
+		}
+		info, err := ExtractVersion(path, opts...)
+		if err != nil {
+			errorMessages = append(errorMessages, err.Error())
+			continue
+		}
+		versions[path] = info
+	}
+	if len(errorMessages) > 0 {
+		sort.Strings(errorMessages)
+		return nil, fmt.Errorf("could not extract versions: %s", strings.Join(errorMessages, ", "))
+	}
+	return versions, nil
+}
+
+// readVersionResource returns the contents of the first version resource within the given PE image, or nil if it does
+// not contain one
+func readVersionResource(data []byte) ([]byte, error) {
+	f, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse PE file: %w", err)
+	}
+	defer f.Close()
+	var resourceDir pe.DataDirectory
+	switch header := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		if header.NumberOfRvaAndSizes > resourceTableIndex {
+			resourceDir = header.DataDirectory[resourceTableIndex]
+		}
+	case *pe.OptionalHeader64:
+		if header.NumberOfRvaAndSizes > resourceTableIndex {
+			resourceDir = header.DataDirectory[resourceTableIndex]
+		}
+	}
+	if resourceDir.VirtualAddress == 0 || resourceDir.Size == 0 {
+		return nil, nil
+	}
+	section := sectionForRVA(f, resourceDir.VirtualAddress)
+	if section == nil {
+		return nil, fmt.Errorf("resource table is not within any section")
+	}
+	sectionData, err := section.Data()
+	if err != nil {
+		return nil, fmt.Errorf("could not read section %s: %w", section.Name, err)
+	}
+	if int(resourceDir.VirtualAddress-section.VirtualAddress) >= len(sectionData) {
+		return nil, fmt.Errorf("resource table is out of bounds")
+	}
+	resources := sectionData[resourceDir.VirtualAddress-section.VirtualAddress:]
+
+	// The resource tree has three levels: type, name and language. The first name and language of the version type
+	// are used.
+	entryOffset, found, err := findResourceEntry(resources, 0, rtVersion)
+	if err != nil || !found {
+		return nil, err
+	}
+	for level := 0; level < 2; level++ {
+		if entryOffset&resourceSubdirectoryFlag == 0 {
+			break
+		}
+		entryOffset, found, err = findResourceEntry(resources, entryOffset&^resourceSubdirectoryFlag, -1)
+		if err != nil || !found {
+			return nil, err
+		}
+	}
+	if entryOffset&resourceSubdirectoryFlag != 0 || int(entryOffset)+8 > len(resources) {
+		return nil, fmt.Errorf("invalid resource data entry")
+	}
+	// The data entry holds the RVA and size of the resource contents
+	dataRVA := binary.LittleEndian.Uint32(resources[entryOffset:])
+	dataSize := binary.LittleEndian.Uint32(resources[entryOffset+4:])
+	dataSection := sectionForRVA(f, dataRVA)
+	if dataSection == nil {
+		return nil, fmt.Errorf("version resource is not within any section")
+	}
+	if dataSection != section {
+		if sectionData, err = dataSection.Data(); err != nil {
+			return nil, fmt.Errorf("could not read section %s: %w", dataSection.Name, err)
+		}
+	}
+	start := dataRVA - dataSection.VirtualAddress
+	if uint64(start)+uint64(dataSize) > uint64(len(sectionData)) {
+		return nil, fmt.Errorf("version resource is out of bounds")
+	}
+	return sectionData[start : start+dataSize], nil
+}
+
+// findResourceEntry returns the OffsetToData field of the entry with the given ID within the resource directory at
+// the given offset. An ID of -1 matches the first entry.
+func findResourceEntry(resources []byte, dirOffset uint32, id int) (uint32, bool, error) {
+	if uint64(dirOffset)+16 > uint64(len(resources)) {
+		return 0, false, fmt.Errorf("resource directory is out of bounds")
+	}
+	named := int(binary.LittleEndian.Uint16(resources[dirOffset+12:]))
+	ids := int(binary.LittleEndian.Uint16(resources[dirOffset+14:]))
+	entries := resources[dirOffset+16:]
+	if (named+ids)*8 > len(entries) {
+		return 0, false, fmt.Errorf("resource directory entries are out of bounds")
+	}
+	for i := 0; i < named+ids; i++ {
+		entry := entries[i*8:]
+		// named entries precede ID entries, and can only be matched by the first entry wildcard
+		if id == -1 || (i >= named && binary.LittleEndian.Uint32(entry) == uint32(id)) {
+			return binary.LittleEndian.Uint32(entry[4:]), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// sectionForRVA returns the section containing the given relative virtual address
+func sectionForRVA(f *pe.File, rva uint32) *pe.Section {
+	for _, section := range f.Sections {
+		size := section.VirtualSize
+		if size == 0 {
+			size = section.Size
+		}
+		if rva >= section.VirtualAddress && rva < section.VirtualAddress+size {
+			return section
+		}
+	}
+	return nil
+}
+
+// versionBlock is a node of the VS_VERSIONINFO tree
+type versionBlock struct {
+	key      string
+	value    []byte
+	isText   bool
+	children []versionBlock
+}
+
+// parseVersionBlock parses the version block at the start of data, returning it along with its length
+func parseVersionBlock(data []byte) (versionBlock, int, error) {
+	if len(data) < 6 {
+		return versionBlock{}, 0, fmt.Errorf("version block is truncated")
+	}
+	length := int(binary.LittleEndian.Uint16(data))
+	valueLength := int(binary.LittleEndian.Uint16(data[2:]))
+	block := versionBlock{isText: binary.LittleEndian.Uint16(data[4:]) == versionBlockTypeText}
+	if length < 6 || length > len(data) {
+		return versionBlock{}, 0, fmt.Errorf("invalid version block length %d", length)
+	}
+	data = data[:length]
+
+	key, pos := readUTF16String(data, 6)
+	block.key = key
+	pos = align4(pos)
+	// the value length of text blocks is in characters rather than bytes
+	if block.isText {
+		valueLength *= 2
+	}
+	if pos+valueLength > length {
+		return versionBlock{}, 0, fmt.Errorf("value of version block %q is out of bounds", key)
+	}
+	block.value = data[pos : pos+valueLength]
+	for pos = align4(pos + valueLength); pos < length; {
+		child, childLength, err := parseVersionBlock(data[pos:])
+		if err != nil {
+			return versionBlock{}, 0, err
+		}
+		block.children = append(block.children, child)
+		pos = align4(pos + childLength)
+	}
+	return block, length, nil
+}
+
+// stringTable returns the text values of the first string table of the StringFileInfo block, keyed by name
+func (b versionBlock) stringTable() map[string]string {
+	strs := make(map[string]string)
+	for _, child := range b.children {
+		if child.key != "StringFileInfo" || len(child.children) == 0 {
+			continue
+		}
+		for _, str := range child.children[0].children {
+			if str.isText {
+				strs[str.key], _ = readUTF16String(str.value, 0)
+			}
+		}
+		break
+	}
+	return strs
+}
+
+// parseFixedFileInfo returns the file and product versions held by a VS_FIXEDFILEINFO structure
+func parseFixedFileInfo(value []byte) (string, string, bool) {
+	if len(value) < 24 || binary.LittleEndian.Uint32(value) != fixedFileInfoSignature {
+		return "", "", false
+	}
+	version := func(ms, ls uint32) string {
+		return fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xffff, ls>>16, ls&0xffff)
+	}
+	fileVersion := version(binary.LittleEndian.Uint32(value[8:]), binary.LittleEndian.Uint32(value[12:]))
+	productVersion := version(binary.LittleEndian.Uint32(value[16:]), binary.LittleEndian.Uint32(value[20:]))
+	return fileVersion, productVersion, true
+}
+
+// readUTF16String reads a null terminated UTF-16 string starting at the given offset, returning it and the offset
+// following the terminator
+func readUTF16String(data []byte, offset int) (string, int) {
+	var chars []uint16
+	for ; offset+1 < len(data); offset += 2 {
+		c := binary.LittleEndian.Uint16(data[offset:])
+		if c == 0 {
+			return string(utf16.Decode(chars)), offset + 2
+		}
+		chars = append(chars, c)
+	}
+	return string(utf16.Decode(chars)), len(data)
+}
+
+// align4 rounds the given offset up to a multiple of 4
+func align4(offset int) int {
+	return (offset + 3) &^ 3
+}
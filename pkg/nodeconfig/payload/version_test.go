@@ -0,0 +1,173 @@
+package payload
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"testing"
+	"testing/fstest"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testResourceRVA is the virtual address of the resource section of PE images built by newTestPEWithResources
+const testResourceRVA = 0x1000
+
+// newTestPEWithResources returns a minimal PE32+ image with a single resource section holding the given contents
+func newTestPEWithResources(t *testing.T, resources []byte) []byte {
+	const peOffset, headersSize = 0x40, 0x200
+	data := make([]byte, peOffset)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3c:], peOffset)
+	buf := bytes.NewBuffer(data)
+	buf.WriteString("PE\x00\x00")
+
+	optionalHeader := pe.OptionalHeader64{
+		Magic:               0x20b,
+		SizeOfHeaders:       headersSize,
+		NumberOfRvaAndSizes: 16,
+	}
+	optionalHeader.DataDirectory[resourceTableIndex] = pe.DataDirectory{
+		VirtualAddress: testResourceRVA,
+		Size:           uint32(len(resources)),
+	}
+	section := pe.SectionHeader32{
+		VirtualSize:      uint32(len(resources)),
+		VirtualAddress:   testResourceRVA,
+		SizeOfRawData:    uint32(len(resources)),
+		PointerToRawData: headersSize,
+	}
+	copy(section.Name[:], ".rsrc")
+	require.NoError(t, binary.Write(buf, binary.LittleEndian, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		NumberOfSections:     1,
+		SizeOfOptionalHeader: uint16(binary.Size(optionalHeader)),
+	}))
+	require.NoError(t, binary.Write(buf, binary.LittleEndian, optionalHeader))
+	require.NoError(t, binary.Write(buf, binary.LittleEndian, section))
+	buf.Write(make([]byte, headersSize-buf.Len()))
+	buf.Write(resources)
+	return buf.Bytes()
+}
+
+// newTestVersionResources returns a resource section holding a single version resource with the given contents
+func newTestVersionResources(versionInfo []byte) []byte {
+	const dirSize, dataEntryOffset, versionInfoOffset = 24, 72, 88
+	resources := make([]byte, versionInfoOffset)
+	// each directory has a single ID entry, pointing at the next level of the tree
+	for i, entry := range [][2]uint32{
+		{rtVersion, resourceSubdirectoryFlag | dirSize},
+		{1, resourceSubdirectoryFlag | 2*dirSize},
+		{0x409, dataEntryOffset},
+	} {
+		dir := resources[i*dirSize:]
+		binary.LittleEndian.PutUint16(dir[14:], 1)
+		binary.LittleEndian.PutUint32(dir[16:], entry[0])
+		binary.LittleEndian.PutUint32(dir[20:], entry[1])
+	}
+	binary.LittleEndian.PutUint32(resources[dataEntryOffset:], testResourceRVA+versionInfoOffset)
+	binary.LittleEndian.PutUint32(resources[dataEntryOffset+4:], uint32(len(versionInfo)))
+	return append(resources, versionInfo...)
+}
+
+// encodeVersionBlock returns the binary representation of a VS_VERSIONINFO block
+func encodeVersionBlock(key string, value []byte, isText bool, children ...[]byte) []byte {
+	pad := func(b []byte) []byte {
+		return append(b, make([]byte, align4(len(b))-len(b))...)
+	}
+	block := make([]byte, 6)
+	block = pad(append(block, encodeUTF16(key)...))
+	block = pad(append(block, value...))
+	for _, child := range children {
+		block = pad(append(block, child...))
+	}
+	valueLength := len(value)
+	if isText {
+		binary.LittleEndian.PutUint16(block[4:], versionBlockTypeText)
+		valueLength /= 2
+	}
+	binary.LittleEndian.PutUint16(block, uint16(len(block)))
+	binary.LittleEndian.PutUint16(block[2:], uint16(valueLength))
+	return block
+}
+
+// encodeUTF16 returns the null terminated UTF-16 encoding of the given string
+func encodeUTF16(s string) []byte {
+	var b []byte
+	for _, c := range append(utf16.Encode([]rune(s)), 0) {
+		b = binary.LittleEndian.AppendUint16(b, c)
+	}
+	return b
+}
+
+// encodeFixedFileInfo returns a VS_FIXEDFILEINFO structure with the given file and product versions
+func encodeFixedFileInfo(fileVersion, productVersion [4]uint16) []byte {
+	info := make([]byte, 52)
+	binary.LittleEndian.PutUint32(info, fixedFileInfoSignature)
+	for i, v := range [][4]uint16{fileVersion, productVersion} {
+		binary.LittleEndian.PutUint32(info[8+i*8:], uint32(v[0])<<16|uint32(v[1]))
+		binary.LittleEndian.PutUint32(info[12+i*8:], uint32(v[2])<<16|uint32(v[3]))
+	}
+	return info
+}
+
+func TestExtractVersion(t *testing.T) {
+	fixedInfo := encodeFixedFileInfo([4]uint16{1, 29, 2, 0}, [4]uint16{1, 29, 0, 0})
+	stringTable := encodeVersionBlock("StringFileInfo", nil, true,
+		encodeVersionBlock("040904b0", nil, true,
+			encodeVersionBlock("CompanyName", encodeUTF16("Red Hat"), true),
+			encodeVersionBlock("FileVersion", encodeUTF16("v1.29.2+a1b2c3"), true),
+			encodeVersionBlock("ProductVersion", encodeUTF16("v1.29.2"), true),
+		),
+	)
+
+	testCases := []struct {
+		name        string
+		contents    []byte
+		expected    *VersionInfo
+		expectedErr bool
+	}{
+		{
+			name: "string versions",
+			contents: newTestPEWithResources(t, newTestVersionResources(
+				encodeVersionBlock("VS_VERSION_INFO", fixedInfo, false, stringTable))),
+			expected: &VersionInfo{FileVersion: "v1.29.2+a1b2c3", ProductVersion: "v1.29.2"},
+		},
+		{
+			name: "numeric versions only",
+			contents: newTestPEWithResources(t, newTestVersionResources(
+				encodeVersionBlock("VS_VERSION_INFO", fixedInfo, false))),
+			expected: &VersionInfo{FileVersion: "1.29.2.0", ProductVersion: "1.29.0.0"},
+		},
+		{
+			name:     "no version resource",
+			contents: newTestPE(t, []byte("windows executable body")),
+			expected: &VersionInfo{FileVersion: UnknownVersion, ProductVersion: UnknownVersion},
+		},
+		{
+			name: "truncated version resource",
+			contents: newTestPEWithResources(t, newTestVersionResources(
+				encodeVersionBlock("VS_VERSION_INFO", fixedInfo, false, stringTable)[:40])),
+			expectedErr: true,
+		},
+		{
+			name:        "not a PE file",
+			contents:    []byte("not an executable"),
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			fsys := fstest.MapFS{"payload/kube-node/kubelet.exe": {Data: test.contents}}
+			info, err := ExtractVersion(KubeletPath, WithFS(fsys))
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, info)
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package payload
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// RemoteWICDPath is the path of the WICD executable on Windows nodes
+const RemoteWICDPath = RemoteK8sDir + "\\windows-instance-config-daemon.exe"
+
+// WICDBootstrapCmd holds the arguments of the WICD bootstrap command, which starts the services needed for the Node
+// object to be created
+type WICDBootstrapCmd struct {
+	// Namespace is the namespace the operator is deployed in, holding the services ConfigMap
+	Namespace string
+	// KubeconfigPath is the path on Windows nodes of the kubeconfig used by WICD
+	KubeconfigPath string
+	// DesiredVersion is the version of the services ConfigMap to configure the services from
+	DesiredVersion string
+}
+
+// Command returns the command line of the WICD bootstrap command, after validating its arguments
+func (c WICDBootstrapCmd) Command() (string, error) {
+	if c.DesiredVersion == "" || strings.ContainsAny(c.DesiredVersion, " \t\"'") {
+		return "", fmt.Errorf("invalid WICD bootstrap command: desired version %q must be non-empty, without "+
+			"whitespace or quotes", c.DesiredVersion)
+	}
+	flags, err := wicdFlags(c.Namespace, c.KubeconfigPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid WICD bootstrap command: %w", err)
+	}
+	return wicdCommand(append([]string{"bootstrap", "--desired-version", c.DesiredVersion}, flags...)), nil
+}
+
+// WICDCleanupCmd holds the arguments of the WICD cleanup command, which stops and removes the services configured by
+// WICD
+type WICDCleanupCmd struct {
+	// Namespace is the namespace the operator is deployed in, holding the services ConfigMap
+	Namespace string
+	// KubeconfigPath is the path on Windows nodes of the kubeconfig used by WICD
+	KubeconfigPath string
+}
+
+// Command returns the command line of the WICD cleanup command, after validating its arguments
+func (c WICDCleanupCmd) Command() (string, error) {
+	flags, err := wicdFlags(c.Namespace, c.KubeconfigPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid WICD cleanup command: %w", err)
+	}
+	return wicdCommand(append([]string{"cleanup"}, flags...)), nil
+}
+
+// WICDControllerCmd holds the arguments of the WICD controller command, which WICD runs as a Windows service
+type WICDControllerCmd struct {
+	// Namespace is the namespace the operator is deployed in, holding the services ConfigMap
+	Namespace string
+	// KubeconfigPath is the path on Windows nodes of the kubeconfig used by WICD
+	KubeconfigPath string
+	// LogDir is the directory on Windows nodes WICD logs to
+	LogDir string
+	// CABundlePath is the path on Windows nodes of the CA bundle trusted by the cluster. It is only set when a
+	// cluster-wide proxy is enabled.
+	CABundlePath string
+}
+
+// Args returns the arguments of the WICD Windows service, after validating them
+func (c WICDControllerCmd) Args() (string, error) {
+	if !windowsAbsPathRegex.MatchString(c.LogDir) {
+		return "", fmt.Errorf("invalid WICD controller command: log directory %q is not an absolute Windows path",
+			c.LogDir)
+	}
+	if c.CABundlePath != "" && !windowsAbsPathRegex.MatchString(c.CABundlePath) {
+		return "", fmt.Errorf("invalid WICD controller command: CA bundle path %q is not an absolute Windows path",
+			c.CABundlePath)
+	}
+	flags, err := wicdFlags(c.Namespace, c.KubeconfigPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid WICD controller command: %w", err)
+	}
+	args := append([]string{"controller", "--windows-service", "--log-dir", QuoteWindowsArg(c.LogDir)}, flags...)
+	if c.CABundlePath != "" {
+		args = append(args, "--ca-bundle", QuoteWindowsArg(c.CABundlePath))
+	}
+	return strings.Join(args, " "), nil
+}
+
+// Command returns the command line of the WICD controller command, after validating its arguments
+func (c WICDControllerCmd) Command() (string, error) {
+	args, err := c.Args()
+	if err != nil {
+		return "", err
+	}
+	return RemoteWICDPath + " " + args, nil
+}
+
+// wicdFlags returns the flags shared by every WICD command, after validating them
+func wicdFlags(namespace, kubeconfigPath string) ([]string, error) {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return nil, fmt.Errorf("namespace %q is invalid: %s", namespace, strings.Join(errs, ", "))
+	}
+	if !windowsAbsPathRegex.MatchString(kubeconfigPath) {
+		return nil, fmt.Errorf("kubeconfig path %q is not an absolute Windows path", kubeconfigPath)
+	}
+	return []string{"--kubeconfig", QuoteWindowsArg(kubeconfigPath), "--namespace", namespace}, nil
+}
+
+// wicdCommand returns the command line running WICD with the given arguments
+func wicdCommand(args []string) string {
+	return strings.Join(append([]string{RemoteWICDPath}, args...), " ")
+}
@@ -0,0 +1,151 @@
+package payload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWICDBootstrapCmd(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cmd         WICDBootstrapCmd
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name: "valid",
+			cmd: WICDBootstrapCmd{Namespace: "openshift-windows-machine-config-operator",
+				KubeconfigPath: `C:\k\wicd-kubeconfig`, DesiredVersion: "9.0.0-abcdef"},
+			expected: `C:\k\windows-instance-config-daemon.exe bootstrap --desired-version 9.0.0-abcdef ` +
+				`--kubeconfig C:\k\wicd-kubeconfig --namespace openshift-windows-machine-config-operator`,
+		},
+		{
+			name: "kubeconfig path with spaces",
+			cmd: WICDBootstrapCmd{Namespace: "wmco", KubeconfigPath: `C:\k\wicd kubeconfig`,
+				DesiredVersion: "9.0.0"},
+			expected: `C:\k\windows-instance-config-daemon.exe bootstrap --desired-version 9.0.0 ` +
+				`--kubeconfig "C:\k\wicd kubeconfig" --namespace wmco`,
+		},
+		{
+			name:        "no desired version",
+			cmd:         WICDBootstrapCmd{Namespace: "wmco", KubeconfigPath: `C:\k\wicd-kubeconfig`},
+			expectedErr: true,
+		},
+		{
+			name: "desired version with whitespace",
+			cmd: WICDBootstrapCmd{Namespace: "wmco", KubeconfigPath: `C:\k\wicd-kubeconfig`,
+				DesiredVersion: "9.0.0 --namespace other"},
+			expectedErr: true,
+		},
+		{
+			name:        "no namespace",
+			cmd:         WICDBootstrapCmd{KubeconfigPath: `C:\k\wicd-kubeconfig`, DesiredVersion: "9.0.0"},
+			expectedErr: true,
+		},
+		{
+			name:        "no kubeconfig",
+			cmd:         WICDBootstrapCmd{Namespace: "wmco", DesiredVersion: "9.0.0"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := test.cmd.Command()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, cmd)
+		})
+	}
+}
+
+func TestWICDCleanupCmd(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cmd         WICDCleanupCmd
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name: "valid",
+			cmd: WICDCleanupCmd{Namespace: "openshift-windows-machine-config-operator",
+				KubeconfigPath: `C:\k\wicd-kubeconfig`},
+			expected: `C:\k\windows-instance-config-daemon.exe cleanup --kubeconfig C:\k\wicd-kubeconfig ` +
+				`--namespace openshift-windows-machine-config-operator`,
+		},
+		{
+			name:        "invalid namespace",
+			cmd:         WICDCleanupCmd{Namespace: "WMCO", KubeconfigPath: `C:\k\wicd-kubeconfig`},
+			expectedErr: true,
+		},
+		{
+			name:        "relative kubeconfig path",
+			cmd:         WICDCleanupCmd{Namespace: "wmco", KubeconfigPath: "wicd-kubeconfig"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := test.cmd.Command()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, cmd)
+		})
+	}
+}
+
+func TestWICDControllerCmd(t *testing.T) {
+	testCases := []struct {
+		name         string
+		cmd          WICDControllerCmd
+		expectedArgs string
+		expectedErr  bool
+	}{
+		{
+			name: "without proxy",
+			cmd: WICDControllerCmd{Namespace: "wmco", KubeconfigPath: `C:\k\wicd-kubeconfig`,
+				LogDir: `C:\var\log\wicd`},
+			expectedArgs: `controller --windows-service --log-dir C:\var\log\wicd --kubeconfig C:\k\wicd-kubeconfig ` +
+				`--namespace wmco`,
+		},
+		{
+			name: "with CA bundle",
+			cmd: WICDControllerCmd{Namespace: "wmco", KubeconfigPath: `C:\k\wicd-kubeconfig`,
+				LogDir: `C:\var\log\wicd`, CABundlePath: `C:\Temp\ca-bundle.crt`},
+			expectedArgs: `controller --windows-service --log-dir C:\var\log\wicd --kubeconfig C:\k\wicd-kubeconfig ` +
+				`--namespace wmco --ca-bundle C:\Temp\ca-bundle.crt`,
+		},
+		{
+			name:        "no log directory",
+			cmd:         WICDControllerCmd{Namespace: "wmco", KubeconfigPath: `C:\k\wicd-kubeconfig`},
+			expectedErr: true,
+		},
+		{
+			name: "relative CA bundle path",
+			cmd: WICDControllerCmd{Namespace: "wmco", KubeconfigPath: `C:\k\wicd-kubeconfig`,
+				LogDir: `C:\var\log\wicd`, CABundlePath: "ca-bundle.crt"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			args, err := test.cmd.Args()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedArgs, args)
+			cmd, err := test.cmd.Command()
+			require.NoError(t, err)
+			assert.Equal(t, `C:\k\windows-instance-config-daemon.exe `+test.expectedArgs, cmd)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package nodeconfig
+
+import (
+	"strings"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/ignition"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+)
+
+// GenerateRegistryHostsFiles writes the containerd hosts.toml files configuring the given registries, as configured
+// by the containers registries configuration within the ignition spec, to the payload. The FileInfos of the written
+// files are returned, along with true if the contents of any of them changed.
+func GenerateRegistryHostsFiles(registries []ignition.Registry,
+	opts ...payload.Option) ([]*payload.FileInfo, bool, error) {
+	return payload.WriteRegistryHostsFiles(RegistryConfigs(registries), opts...)
+}
+
+// RegistryConfigs converts the given registries, as configured by the containers registries configuration within the
+// ignition spec, into the registry configurations the payload hosts.toml files are generated from. Registries selected
+// by a wildcard prefix are skipped, as containerd does not support them.
+func RegistryConfigs(registries []ignition.Registry) []payload.RegistryConfig {
+	configs := make([]payload.RegistryConfig, 0, len(registries))
+	for _, registry := range registries {
+		config := payload.RegistryConfig{
+			Source:             registry.Prefix,
+			MirrorByDigestOnly: registry.MirrorByDigestOnly,
+			Insecure:           registry.Insecure,
+		}
+		if config.Source == "" {
+			config.Source = registry.Location
+		}
+		if strings.HasPrefix(config.Source, "*.") {
+			continue
+		}
+		for _, mirror := range registry.Mirrors {
+			config.Mirrors = append(config.Mirrors, payload.RegistryMirror{
+				Location:   mirror.Location,
+				DigestOnly: mirror.PullFromMirror == ignition.PullFromMirrorDigestOnly,
+				Insecure:   mirror.Insecure,
+			})
+		}
+		configs = append(configs, config)
+	}
+	return configs
+}
@@ -0,0 +1,56 @@
+package nodeconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/ignition"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+)
+
+func TestRegistryConfigs(t *testing.T) {
+	testCases := []struct {
+		name       string
+		registries []ignition.Registry
+		expected   []payload.RegistryConfig
+	}{
+		{
+			name:     "no registries",
+			expected: []payload.RegistryConfig{},
+		},
+		{
+			name: "prefix and mirror settings",
+			registries: []ignition.Registry{
+				{Location: "registry.example.com:5000", Insecure: true},
+				{Prefix: "*.example.com", Location: "example.com",
+					Mirrors: []ignition.RegistryMirror{{Location: "mirror.example.com"}}},
+				{
+					Prefix:             "quay.io/openshift-release-dev/ocp-release",
+					Location:           "quay.io/ocp-release",
+					MirrorByDigestOnly: true,
+					Mirrors: []ignition.RegistryMirror{
+						{Location: "mirror.example.com/ocp", PullFromMirror: ignition.PullFromMirrorDigestOnly},
+						{Location: "mirror.example.com:5000", Insecure: true},
+					},
+				},
+			},
+			expected: []payload.RegistryConfig{
+				{Source: "registry.example.com:5000", Insecure: true},
+				{
+					Source:             "quay.io/openshift-release-dev/ocp-release",
+					MirrorByDigestOnly: true,
+					Mirrors: []payload.RegistryMirror{
+						{Location: "mirror.example.com/ocp", DigestOnly: true},
+						{Location: "mirror.example.com:5000", Insecure: true},
+					},
+				},
+			},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, RegistryConfigs(test.registries))
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+)
+
+// featureGate is a kube-proxy feature gate and whether it is enabled
+type featureGate struct {
+	name    string
+	enabled bool
+}
+
+// kubeProxyFlags holds the command line flags kube-proxy is started with. Building the command from typed fields,
+// rather than a hand maintained format string, ensures every flag is set consistently and only once. Node specific
+// values are placeholders resolved by WICD when the service is started.
+type kubeProxyFlags struct {
+	// windowsService runs kube-proxy as a Windows service
+	windowsService bool
+	// proxyMode is the proxy mode kube-proxy uses
+	proxyMode string
+	// featureGates are the feature gates passed to kube-proxy, in order
+	featureGates []featureGate
+	// hostnameOverride is the name kube-proxy identifies the node with
+	hostnameOverride string
+	// kubeconfig is the path of the kubeconfig kube-proxy authenticates with
+	kubeconfig string
+	// clusterCIDR is the CIDR range of the pods on the node
+	clusterCIDR string
+	// networkName is the name of the HNS network kube-proxy programs
+	networkName string
+	// sourceVIP is the IP address of the HNS endpoint used as the source VIP. It is omitted if empty.
+	sourceVIP string
+	// enableDSR enables direct server return for load balancers
+	enableDSR bool
+	// metricsBindAddress is the host:port the metrics server listens on, if set
+	metricsBindAddress string
+	// healthzBindAddress is the host:port the health check server listens on, if set
+	healthzBindAddress string
+	// conntrackMaxPerCore is the maximum number of NAT connections tracked per CPU core, if set
+	conntrackMaxPerCore *int32
+	// conntrackMin is the minimum number of connection tracking records allocated, if set
+	conntrackMin *int32
+	// conntrackTCPEstablishedTimeout is the idle timeout of established TCP connections, if set
+	conntrackTCPEstablishedTimeout *time.Duration
+	// conntrackTCPCloseWaitTimeout is the timeout of TCP connections in the CLOSE_WAIT state, if set
+	conntrackTCPCloseWaitTimeout *time.Duration
+	// oomScoreAdj is the oom-score-adj value of the kube-proxy process, if set
+	oomScoreAdj *int32
+	// forwardHealthCheckVIP forwards service VIP health check traffic to the health check server. It is omitted if
+	// false, the kube-proxy default.
+	forwardHealthCheckVIP bool
+	// rootHNSEndpointName is the name of the HNS endpoint attached to the root network namespace, if set
+	rootHNSEndpointName string
+	// nodePortAddresses are the CIDRs of the node addresses NodePort services are exposed on. If empty, NodePort
+	// services are exposed on all node addresses.
+	nodePortAddresses []string
+}
+
+// args returns the command line arguments for the flags
+func (f kubeProxyFlags) args() []string {
+	var args []string
+	if f.windowsService {
+		args = append(args, "--windows-service")
+	}
+	args = append(args, "--proxy-mode="+f.proxyMode)
+	if len(f.featureGates) > 0 {
+		var gates []string
+		for _, gate := range f.featureGates {
+			gates = append(gates, fmt.Sprintf("%s=%t", gate.name, gate.enabled))
+		}
+		args = append(args, "--feature-gates="+strings.Join(gates, ","))
+	}
+	args = append(args,
+		"--hostname-override="+f.hostnameOverride,
+		"--kubeconfig="+f.kubeconfig,
+		"--cluster-cidr="+f.clusterCIDR,
+		"--network-name="+f.networkName)
+	if f.sourceVIP != "" {
+		args = append(args, "--source-vip="+f.sourceVIP)
+	}
+	args = append(args, fmt.Sprintf("--enable-dsr=%t", f.enableDSR))
+	if f.metricsBindAddress != "" {
+		args = append(args, "--metrics-bind-address="+f.metricsBindAddress)
+	}
+	if f.healthzBindAddress != "" {
+		args = append(args, "--healthz-bind-address="+f.healthzBindAddress)
+	}
+	if f.conntrackMaxPerCore != nil {
+		args = append(args, fmt.Sprintf("--conntrack-max-per-core=%d", *f.conntrackMaxPerCore))
+	}
+	if f.conntrackMin != nil {
+		args = append(args, fmt.Sprintf("--conntrack-min=%d", *f.conntrackMin))
+	}
+	if f.conntrackTCPEstablishedTimeout != nil {
+		args = append(args, "--conntrack-tcp-timeout-established="+f.conntrackTCPEstablishedTimeout.String())
+	}
+	if f.conntrackTCPCloseWaitTimeout != nil {
+		args = append(args, "--conntrack-tcp-timeout-close-wait="+f.conntrackTCPCloseWaitTimeout.String())
+	}
+	if f.oomScoreAdj != nil {
+		args = append(args, fmt.Sprintf("--oom-score-adj=%d", *f.oomScoreAdj))
+	}
+	if f.forwardHealthCheckVIP {
+		args = append(args, fmt.Sprintf("--forward-healthcheck-vip=%t", f.forwardHealthCheckVIP))
+	}
+	if f.rootHNSEndpointName != "" {
+		args = append(args, "--root-hnsendpoint-name="+f.rootHNSEndpointName)
+	}
+	if len(f.nodePortAddresses) > 0 {
+		args = append(args, "--nodeport-addresses="+strings.Join(f.nodePortAddresses, ","))
+	}
+	return args
+}
+
+// commandLine returns the arguments for the flags as a single Windows command line, quoting any argument containing
+// whitespace or quotes so that it is passed to kube-proxy intact
+func (f kubeProxyFlags) commandLine() string {
+	var args []string
+	for _, arg := range f.args() {
+		args = append(args, payload.QuoteWindowsArg(arg))
+	}
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,385 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
+)
+
+// TestKubeProxyCommand ensures the default kube-proxy command is unchanged by building it from kubeProxyFlags
+func TestKubeProxyCommand(t *testing.T) {
+	expected := windows.KubeLogRunnerPath + " -log-file=" + windows.KubeProxyLog + " " + windows.KubeProxyPath +
+		" --windows-service --proxy-mode=kernelspace --feature-gates=WinOverlay=true,WinDSR=true " +
+		"--hostname-override=NODE_NAME --kubeconfig=" + windows.KubeconfigPath + " --cluster-cidr=NODE_SUBNET " +
+		"--network-name=" + windows.OVNKubeOverlayNetwork + " --source-vip=ENDPOINT_IP --enable-dsr=true --v=2"
+	svc := kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, DefaultKubeProxyOptions(), false)
+	assert.Equal(t, expected, svc.Command)
+}
+
+func TestKubeProxyDSR(t *testing.T) {
+	testCases := []struct {
+		name      string
+		enableDSR bool
+		expected  []string
+	}{
+		{
+			name:      "enabled",
+			enableDSR: true,
+			expected:  []string{"--feature-gates=WinOverlay=true,WinDSR=true ", "--enable-dsr=true "},
+		},
+		{
+			name:     "disabled",
+			expected: []string{"--feature-gates=WinOverlay=true,WinDSR=false ", "--enable-dsr=false "},
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			opts := DefaultKubeProxyOptions()
+			opts.EnableDSR = test.enableDSR
+			svc := kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, opts, false)
+			for _, arg := range test.expected {
+				assert.Contains(t, svc.Command, arg)
+			}
+			// the network scripts run before kube-proxy are unaffected
+			assert.Equal(t, kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, DefaultKubeProxyOptions(),
+				false).PowershellPreScripts, svc.PowershellPreScripts)
+		})
+	}
+}
+
+// TestKubeProxyNetworkType ensures kube-proxy on bridge networks runs without the overlay feature gate and the VIP
+// endpoint, which bridge networks do not have
+func TestKubeProxyNetworkType(t *testing.T) {
+	overlay := kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, DefaultKubeProxyOptions(), false)
+	explicit := DefaultKubeProxyOptions()
+	explicit.NetworkType = payload.OverlayNetwork
+	assert.Equal(t, overlay, kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, explicit, false))
+
+	opts := DefaultKubeProxyOptions()
+	opts.NetworkType = payload.BridgeNetwork
+	require.NoError(t, opts.validate())
+	bridge := kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, opts, false)
+	assert.Contains(t, bridge.Command, "--feature-gates=WinDSR=true ")
+	assert.NotContains(t, bridge.Command, "WinOverlay")
+	assert.NotContains(t, bridge.Command, "--source-vip")
+	// the CNI configuration is still generated, but there is no source VIP to resolve
+	assert.Equal(t, []servicescm.PowershellPreScript{{Path: windows.CNIConfScriptPath}}, bridge.PowershellPreScripts)
+
+	opts.NetworkType = "l2tunnel"
+	assert.Error(t, opts.validate())
+}
+
+func TestKubeProxyConntrack(t *testing.T) {
+	testCases := []struct {
+		name        string
+		conntrack   KubeProxyConntrackOptions
+		oomScoreAdj *int32
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name: "all set",
+			conntrack: KubeProxyConntrackOptions{
+				MaxPerCore:            ptr.To[int32](131072),
+				Min:                   ptr.To[int32](262144),
+				TCPEstablishedTimeout: ptr.To(12 * time.Hour),
+				TCPCloseWaitTimeout:   ptr.To(30 * time.Second),
+			},
+			oomScoreAdj: ptr.To[int32](0),
+			expected: []string{"--conntrack-max-per-core=131072", "--conntrack-min=262144",
+				"--conntrack-tcp-timeout-established=12h0m0s", "--conntrack-tcp-timeout-close-wait=30s",
+				"--oom-score-adj=0"},
+		},
+		{
+			name:      "zero disables the per core limit",
+			conntrack: KubeProxyConntrackOptions{MaxPerCore: ptr.To[int32](0)},
+			expected:  []string{"--conntrack-max-per-core=0"},
+		},
+		{
+			name:        "negative maxPerCore",
+			conntrack:   KubeProxyConntrackOptions{MaxPerCore: ptr.To[int32](-1)},
+			expectedErr: true,
+		},
+		{
+			name:        "negative min",
+			conntrack:   KubeProxyConntrackOptions{Min: ptr.To[int32](-1)},
+			expectedErr: true,
+		},
+		{
+			name:        "negative timeout",
+			conntrack:   KubeProxyConntrackOptions{TCPCloseWaitTimeout: ptr.To(-time.Second)},
+			expectedErr: true,
+		},
+		{
+			name:        "negative oomScoreAdj",
+			oomScoreAdj: ptr.To[int32](-999),
+			expectedErr: true,
+		},
+		{
+			name:        "oomScoreAdj out of range",
+			oomScoreAdj: ptr.To[int32](1001),
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			opts := DefaultKubeProxyOptions()
+			opts.Conntrack = test.conntrack
+			opts.OOMScoreAdj = test.oomScoreAdj
+			err := opts.validate()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			svc := kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, opts, false)
+			for _, arg := range test.expected {
+				assert.Contains(t, svc.Command, " "+arg+" ")
+			}
+			if len(test.expected) == 0 {
+				// unset values leave the command unchanged
+				assert.Equal(t, kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, DefaultKubeProxyOptions(),
+					false).Command, svc.Command)
+			}
+		})
+	}
+}
+
+func TestKubeProxyVerbosity(t *testing.T) {
+	testCases := []struct {
+		name        string
+		verbosity   *int
+		debug       bool
+		expected    string
+		expectedErr string
+	}{
+		{name: "unset", expected: "--v=2"},
+		{name: "unset with debug", debug: true, expected: "--v=4"},
+		{name: "minimum", verbosity: ptr.To(0), expected: "--v=0"},
+		{name: "overrides debug", verbosity: ptr.To(3), debug: true, expected: "--v=3"},
+		{name: "maximum", verbosity: ptr.To(10), expected: "--v=10"},
+		{name: "negative", verbosity: ptr.To(-1), expectedErr: "invalid verbosity -1"},
+		{name: "too large", verbosity: ptr.To(11), expectedErr: "invalid verbosity 11"},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			opts := DefaultKubeProxyOptions()
+			opts.Verbosity = test.verbosity
+			err := opts.validate()
+			if test.expectedErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			svc := kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, opts, test.debug)
+			assert.True(t, strings.HasSuffix(svc.Command, " "+test.expected), svc.Command)
+		})
+	}
+}
+
+func TestKubeProxyForwardHealthCheckVIP(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		forwardHealthCheckVIP bool
+		rootHNSEndpointName   string
+		expected              []string
+		notExpected           []string
+		expectedErr           bool
+	}{
+		{
+			name:        "disabled",
+			notExpected: []string{"--forward-healthcheck-vip", "--root-hnsendpoint-name"},
+		},
+		{
+			name:                  "enabled",
+			forwardHealthCheckVIP: true,
+			expected:              []string{" --forward-healthcheck-vip=true "},
+			notExpected:           []string{"--root-hnsendpoint-name"},
+		},
+		{
+			name:                  "enabled with root endpoint",
+			forwardHealthCheckVIP: true,
+			rootHNSEndpointName:   "cbr0",
+			expected:              []string{" --forward-healthcheck-vip=true ", " --root-hnsendpoint-name=cbr0 "},
+		},
+		{
+			name:                  "root endpoint with spaces",
+			forwardHealthCheckVIP: true,
+			rootHNSEndpointName:   "root endpoint",
+			expected:              []string{` "--root-hnsendpoint-name=root endpoint" `},
+		},
+		{
+			name:                "root endpoint without forwarding",
+			rootHNSEndpointName: "cbr0",
+			expectedErr:         true,
+		},
+		{
+			name:                  "root endpoint with trailing whitespace",
+			forwardHealthCheckVIP: true,
+			rootHNSEndpointName:   "cbr0 ",
+			expectedErr:           true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			opts := DefaultKubeProxyOptions()
+			opts.ForwardHealthCheckVIP = test.forwardHealthCheckVIP
+			opts.RootHNSEndpointName = test.rootHNSEndpointName
+			err := opts.validate()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			svc := kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, opts, false)
+			for _, arg := range test.expected {
+				assert.Contains(t, svc.Command, arg)
+			}
+			for _, arg := range test.notExpected {
+				assert.NotContains(t, svc.Command, arg)
+			}
+		})
+	}
+}
+
+func TestKubeProxyNodePortAddresses(t *testing.T) {
+	testCases := []struct {
+		name              string
+		nodePortAddresses []string
+		expected          string
+		expectedErr       bool
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name:              "single CIDR",
+			nodePortAddresses: []string{"10.0.0.0/16"},
+			expected:          "--nodeport-addresses=10.0.0.0/16",
+		},
+		{
+			name:              "sorted, canonical and deduplicated",
+			nodePortAddresses: []string{"fd00::1/64", "192.168.1.10/24", " 10.0.0.0/16", "192.168.1.0/24"},
+			expected:          "--nodeport-addresses=10.0.0.0/16,192.168.1.0/24,fd00::/64",
+		},
+		{
+			name:              "IP address",
+			nodePortAddresses: []string{"10.0.0.1"},
+			expectedErr:       true,
+		},
+		{
+			name:              "PowerShell variable",
+			nodePortAddresses: []string{"$env:CIDR"},
+			expectedErr:       true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			opts := DefaultKubeProxyOptions()
+			opts.NodePortAddresses = test.nodePortAddresses
+			err := opts.validate()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			svc := kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, opts, false)
+			if test.expected == "" {
+				assert.NotContains(t, svc.Command, "--nodeport-addresses")
+				return
+			}
+			assert.Contains(t, svc.Command, " "+test.expected+" ")
+		})
+	}
+}
+
+// TestKubeProxyFlagsRoundTrip ensures the rendered arguments parse back into the flags they were built from, with no
+// unknown flags
+func TestKubeProxyFlagsRoundTrip(t *testing.T) {
+	flags := kubeProxyFlags{
+		windowsService:                 true,
+		proxyMode:                      "kernelspace",
+		featureGates:                   []featureGate{{name: "WinOverlay", enabled: true}, {name: "WinDSR", enabled: false}},
+		hostnameOverride:               "NODE_NAME",
+		kubeconfig:                     windows.KubeconfigPath,
+		clusterCIDR:                    "NODE_SUBNET",
+		networkName:                    windows.OVNKubeOverlayNetwork,
+		sourceVIP:                      "ENDPOINT_IP",
+		metricsBindAddress:             "0.0.0.0:10249",
+		healthzBindAddress:             "[::]:10256",
+		conntrackMaxPerCore:            ptr.To[int32](65536),
+		conntrackMin:                   ptr.To[int32](0),
+		conntrackTCPEstablishedTimeout: ptr.To(24 * time.Hour),
+		conntrackTCPCloseWaitTimeout:   ptr.To(90 * time.Second),
+		oomScoreAdj:                    ptr.To[int32](999),
+		forwardHealthCheckVIP:          true,
+		rootHNSEndpointName:            "cbr0",
+		nodePortAddresses:              []string{"10.0.0.0/16", "fd00::/64"},
+	}
+
+	// the subset of kube-proxy flags set by WMCO
+	fs := pflag.NewFlagSet("kube-proxy", pflag.ContinueOnError)
+	windowsService := fs.Bool("windows-service", false, "")
+	proxyMode := fs.String("proxy-mode", "", "")
+	featureGates := fs.StringToString("feature-gates", nil, "")
+	hostnameOverride := fs.String("hostname-override", "", "")
+	kubeconfig := fs.String("kubeconfig", "", "")
+	clusterCIDR := fs.String("cluster-cidr", "", "")
+	networkName := fs.String("network-name", "", "")
+	sourceVIP := fs.String("source-vip", "", "")
+	enableDSR := fs.Bool("enable-dsr", true, "")
+	metricsBindAddress := fs.String("metrics-bind-address", "", "")
+	healthzBindAddress := fs.String("healthz-bind-address", "", "")
+	conntrackMaxPerCore := fs.Int32("conntrack-max-per-core", -1, "")
+	conntrackMin := fs.Int32("conntrack-min", -1, "")
+	conntrackTCPEstablishedTimeout := fs.Duration("conntrack-tcp-timeout-established", 0, "")
+	conntrackTCPCloseWaitTimeout := fs.Duration("conntrack-tcp-timeout-close-wait", 0, "")
+	oomScoreAdj := fs.Int32("oom-score-adj", -1, "")
+	forwardHealthCheckVIP := fs.Bool("forward-healthcheck-vip", false, "")
+	rootHNSEndpointName := fs.String("root-hnsendpoint-name", "", "")
+	nodePortAddresses := fs.StringSlice("nodeport-addresses", nil, "")
+	args := flags.args()
+	require.NoError(t, fs.Parse(args))
+	assert.Empty(t, fs.Args(), "unexpected positional arguments")
+
+	assert.Equal(t, flags.windowsService, *windowsService)
+	assert.Equal(t, flags.proxyMode, *proxyMode)
+	assert.Equal(t, map[string]string{"WinOverlay": "true", "WinDSR": "false"}, *featureGates)
+	assert.Equal(t, flags.hostnameOverride, *hostnameOverride)
+	assert.Equal(t, flags.kubeconfig, *kubeconfig)
+	assert.Equal(t, flags.clusterCIDR, *clusterCIDR)
+	assert.Equal(t, flags.networkName, *networkName)
+	assert.Equal(t, flags.sourceVIP, *sourceVIP)
+	assert.Equal(t, flags.enableDSR, *enableDSR)
+	assert.Equal(t, flags.metricsBindAddress, *metricsBindAddress)
+	assert.Equal(t, flags.healthzBindAddress, *healthzBindAddress)
+	assert.Equal(t, *flags.conntrackMaxPerCore, *conntrackMaxPerCore)
+	assert.Equal(t, *flags.conntrackMin, *conntrackMin)
+	assert.Equal(t, *flags.conntrackTCPEstablishedTimeout, *conntrackTCPEstablishedTimeout)
+	assert.Equal(t, *flags.conntrackTCPCloseWaitTimeout, *conntrackTCPCloseWaitTimeout)
+	assert.Equal(t, *flags.oomScoreAdj, *oomScoreAdj)
+	assert.Equal(t, flags.forwardHealthCheckVIP, *forwardHealthCheckVIP)
+	assert.Equal(t, flags.rootHNSEndpointName, *rootHNSEndpointName)
+	assert.Equal(t, flags.nodePortAddresses, *nodePortAddresses)
+
+	// every flag is only passed once
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		name := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)[0]
+		assert.False(t, seen[name], "flag %s passed more than once", name)
+		seen[name] = true
+	}
+}
@@ -2,14 +2,19 @@ package services
 
 import (
 	"fmt"
+	"net"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	config "github.com/openshift/api/config/v1"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/cluster"
 	"github.com/openshift/windows-machine-config-operator/pkg/ignition"
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig"
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 	"github.com/openshift/windows-machine-config-operator/pkg/servicescm"
 	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 )
@@ -24,17 +29,179 @@ const (
 	NodeIPVar           = "NODE_IP"
 )
 
+// KubeProxyOptions holds the configurable kube-proxy settings. Unset bind addresses leave the kube-proxy defaults in
+// place. DefaultKubeProxyOptions should be used as the starting point, rather than the zero value.
+type KubeProxyOptions struct {
+	// MetricsBindAddress is the IP address and port, in host:port form, the metrics server listens on
+	MetricsBindAddress string
+	// HealthzBindAddress is the IP address and port, in host:port form, the health check server listens on
+	HealthzBindAddress string
+	// EnableDSR toggles both the WinDSR feature gate and direct server return for load balancers. It is a cluster-wide
+	// setting, as every Windows node is configured from the same services ConfigMap. It should only be disabled on
+	// clusters whose Windows builds or platform have known DSR issues.
+	EnableDSR bool
+	// NetworkType is the type of the HNS network kube-proxy runs on. If empty, an overlay network is used. Bridge
+	// networks have no VIP endpoint, so kube-proxy is run without a source VIP.
+	NetworkType payload.NetworkType
+	// Conntrack holds the connection tracking settings. Unset fields leave the kube-proxy defaults in place.
+	Conntrack KubeProxyConntrackOptions
+	// OOMScoreAdj is the oom-score-adj value of the kube-proxy process, between 0 and 1000. If nil, the kube-proxy
+	// default is used.
+	OOMScoreAdj *int32
+	// Verbosity is the klog verbosity of kube-proxy, between 0 and 10. If nil, the verbosity follows the debug setting
+	// of the operator, as it does for the other services.
+	Verbosity *int
+	// ForwardHealthCheckVIP forwards the health checks of load balancer service VIPs to kube-proxy's health check
+	// server. It is needed for LoadBalancer services with externalTrafficPolicy: Local to pass health checks on some
+	// platforms. Disabled by default.
+	ForwardHealthCheckVIP bool
+	// RootHNSEndpointName is the name of the HNS endpoint attached to the root network namespace, which health checks
+	// are forwarded to. It can only be set along with ForwardHealthCheckVIP. If empty, the kube-proxy default is used.
+	RootHNSEndpointName string
+	// NodePortAddresses are the CIDRs of the node addresses NodePort services are exposed on, such as the machine
+	// network of multi-homed nodes. If empty, NodePort services are exposed on all node addresses.
+	NodePortAddresses []string
+}
+
+// KubeProxyConntrackOptions holds the kube-proxy connection tracking settings. Raising the limits avoids port
+// exhaustion on busy nodes.
+type KubeProxyConntrackOptions struct {
+	// MaxPerCore is the maximum number of NAT connections tracked per CPU core
+	MaxPerCore *int32
+	// Min is the minimum number of connection tracking records allocated, regardless of MaxPerCore
+	Min *int32
+	// TCPEstablishedTimeout is the idle timeout of established TCP connections
+	TCPEstablishedTimeout *time.Duration
+	// TCPCloseWaitTimeout is the timeout of TCP connections in the CLOSE_WAIT state
+	TCPCloseWaitTimeout *time.Duration
+}
+
+// DefaultKubeProxyOptions returns the default kube-proxy settings, with DSR enabled
+func DefaultKubeProxyOptions() KubeProxyOptions {
+	return KubeProxyOptions{EnableDSR: true}
+}
+
+const (
+	// maxOOMScoreAdj is the largest valid oom-score-adj value
+	maxOOMScoreAdj = 1000
+	// maxVerbosity is the largest klog verbosity in use
+	maxVerbosity = 10
+)
+
+// validate ensures the set bind addresses are valid IP address and port pairs, the set conntrack, oom-score-adj and
+// verbosity values are in range, the root HNS endpoint name is only set when health checks are forwarded, and the node
+// port addresses are valid CIDRs
+func (o KubeProxyOptions) validate() error {
+	for name, address := range map[string]string{
+		"metricsBindAddress": o.MetricsBindAddress,
+		"healthzBindAddress": o.HealthzBindAddress,
+	} {
+		if address == "" {
+			continue
+		}
+		if err := validateBindAddress(address); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", name, address, err)
+		}
+	}
+	if _, err := payload.ResolveNetworkType(o.NetworkType); err != nil {
+		return err
+	}
+	for name, value := range map[string]*int32{
+		"conntrack maxPerCore": o.Conntrack.MaxPerCore,
+		"conntrack min":        o.Conntrack.Min,
+	} {
+		if value != nil && *value < 0 {
+			return fmt.Errorf("invalid %s %d: must not be negative", name, *value)
+		}
+	}
+	for name, timeout := range map[string]*time.Duration{
+		"conntrack tcpEstablishedTimeout": o.Conntrack.TCPEstablishedTimeout,
+		"conntrack tcpCloseWaitTimeout":   o.Conntrack.TCPCloseWaitTimeout,
+	} {
+		if timeout != nil && *timeout < 0 {
+			return fmt.Errorf("invalid %s %s: must not be negative", name, *timeout)
+		}
+	}
+	if o.OOMScoreAdj != nil && (*o.OOMScoreAdj < 0 || *o.OOMScoreAdj > maxOOMScoreAdj) {
+		return fmt.Errorf("invalid oomScoreAdj %d: must be between 0 and %d", *o.OOMScoreAdj, maxOOMScoreAdj)
+	}
+	if o.Verbosity != nil && (*o.Verbosity < 0 || *o.Verbosity > maxVerbosity) {
+		return fmt.Errorf("invalid verbosity %d: must be between 0 and %d", *o.Verbosity, maxVerbosity)
+	}
+	if o.RootHNSEndpointName != "" {
+		if !o.ForwardHealthCheckVIP {
+			return fmt.Errorf("invalid rootHnsEndpointName %q: only applies when forwardHealthCheckVip is enabled",
+				o.RootHNSEndpointName)
+		}
+		if strings.TrimSpace(o.RootHNSEndpointName) != o.RootHNSEndpointName {
+			return fmt.Errorf("invalid rootHnsEndpointName %q: must not have leading or trailing whitespace",
+				o.RootHNSEndpointName)
+		}
+	}
+	if _, err := canonicalCIDRs(o.NodePortAddresses); err != nil {
+		return fmt.Errorf("invalid nodePortAddresses: %w", err)
+	}
+	return nil
+}
+
+// canonicalCIDRs returns the given CIDRs in canonical form, deduplicated and sorted, so that the kube-proxy command
+// does not depend on the order they were given in
+func canonicalCIDRs(cidrs []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var canonical []string
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid CIDR: %w", cidr, err)
+		}
+		if seen[ipNet.String()] {
+			continue
+		}
+		seen[ipNet.String()] = true
+		canonical = append(canonical, ipNet.String())
+	}
+	sort.Strings(canonical)
+	return canonical, nil
+}
+
+// validateBindAddress ensures the given address is an IP address and port pair
+func validateBindAddress(address string) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("%q is not an IP address", host)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("%q is not a valid port", port)
+	}
+	return nil
+}
+
 // GenerateManifest returns the expected state of the Windows service configmap. If debug is true, debug logging
 // will be enabled for services that support it.
-func GenerateManifest(kubeletArgsFromIgnition map[string]string, vxlanPort string, platform config.PlatformType,
-	ccmEnabled, debug bool) (*servicescm.Data, error) {
+func GenerateManifest(kubeletArgsFromIgnition map[string]string, vxlanPort string, kubeProxyOptions KubeProxyOptions,
+	exporterConfig payload.ExporterConfig, platform config.PlatformType, ccmEnabled,
+	debug bool) (*servicescm.Data, error) {
 	kubeletConfiguration, err := getKubeletServiceConfiguration(kubeletArgsFromIgnition, debug, platform)
 	if err != nil {
 		return nil, fmt.Errorf("could not determine kubelet service configuration spec: %w", err)
 	}
+	if err := kubeProxyOptions.validate(); err != nil {
+		return nil, fmt.Errorf("could not determine kube-proxy service configuration spec: %w", err)
+	}
+	exporterCommand, err := exporterConfig.Command()
+	if err != nil {
+		return nil, fmt.Errorf("could not determine windows_exporter service configuration spec: %w", err)
+	}
+	hybridOverlayService, err := hybridOverlayConfiguration(vxlanPort, debug)
+	if err != nil {
+		return nil, fmt.Errorf("could not determine hybrid-overlay service configuration spec: %w", err)
+	}
 	services := &[]servicescm.Service{{
 		Name:                   windows.WindowsExporterServiceName,
-		Command:                windows.WindowsExporterServiceCommand,
+		Command:                exporterCommand,
 		NodeVariablesInCommand: nil,
 		PowershellPreScripts:   nil,
 		Dependencies:           nil,
@@ -43,8 +210,8 @@ func GenerateManifest(kubeletArgsFromIgnition map[string]string, vxlanPort strin
 	},
 		containerdConfiguration(debug),
 		kubeletConfiguration,
-		hybridOverlayConfiguration(vxlanPort, debug),
-		kubeProxyConfiguration(debug),
+		hybridOverlayService,
+		kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, kubeProxyOptions, debug),
 		csiProxyConfiguration(debug),
 	}
 	if platform == config.AzurePlatformType && ccmEnabled {
@@ -72,8 +239,10 @@ func containerdConfiguration(debug bool) servicescm.Service {
 		Name:                   windows.ContainerdServiceName,
 		Command:                containerdServiceCmd,
 		NodeVariablesInCommand: nil,
+		// the Windows Defender exclusions are ensured before containerd is started, so that they are restored whenever
+		// the services are reconfigured. The script does nothing if Defender is not running.
 		PowershellPreScripts: []servicescm.PowershellPreScript{{
-			Path: fmt.Sprintf("%s -BinPath %s", windows.WinDefenderExclusionScriptRemotePath, windows.ContainerdPath),
+			Path: windows.WinDefenderExclusionScriptRemotePath + " " + payload.DefenderExclusionScriptArgs(),
 		}},
 		Dependencies: nil,
 		Bootstrap:    true,
@@ -83,8 +252,8 @@ func containerdConfiguration(debug bool) servicescm.Service {
 
 // azureCloudNodeManagerConfiguration returns the service specification for azure-cloud-node-manager.exe
 func azureCloudNodeManagerConfiguration() servicescm.Service {
-	cmd := fmt.Sprintf("%s --windows-service --node-name=NODE_NAME --wait-routes=false --kubeconfig=%s",
-		windows.AzureCloudNodeManagerPath, windows.KubeconfigPath)
+	// The node name is a node variable and the kubeconfig path a constant, so the command is always valid
+	cmd, _ := payload.AzureCloudNodeManagerArgs{NodeName: "NODE_NAME", KubeconfigPath: windows.KubeconfigPath}.Command()
 
 	return servicescm.Service{
 		Name:    windows.AzureCloudNodeManagerServiceName,
@@ -100,20 +269,29 @@ func azureCloudNodeManagerConfiguration() servicescm.Service {
 	}
 }
 
-// hybridOverlayConfiguration returns the Service definition for hybrid-overlay
-func hybridOverlayConfiguration(vxlanPort string, debug bool) servicescm.Service {
-	hybridOverlayServiceCmd := fmt.Sprintf("%s --node NODE_NAME --bootstrap-kubeconfig=%s --cert-dir=%s --cert-duration=24h "+
-		"--windows-service --logfile "+"%s\\hybrid-overlay.log", windows.HybridOverlayPath, windows.KubeconfigPath, windows.CniConfDir,
-		windows.HybridOverlayLogDir)
-	if len(vxlanPort) > 0 {
-		hybridOverlayServiceCmd = fmt.Sprintf("%s --hybrid-overlay-vxlan-port %s", hybridOverlayServiceCmd, vxlanPort)
+// hybridOverlayConfiguration returns the Service definition for hybrid-overlay, using the given VXLAN port of the
+// cluster network if it is set
+func hybridOverlayConfiguration(vxlanPort string, debug bool) (servicescm.Service, error) {
+	port, err := payload.ParseVXLANPort(vxlanPort)
+	if err != nil {
+		return servicescm.Service{}, err
+	}
+	args := payload.HybridOverlayArgs{
+		NodeName:       "NODE_NAME",
+		KubeconfigPath: windows.KubeconfigPath,
+		CertDir:        windows.CniConfDir,
+		LogFile:        windows.HybridOverlayLogDir + "\\hybrid-overlay.log",
+		VXLANPort:      port,
 	}
-
 	// check log level and increase hybrid-overlay verbosity if needed
 	if debug {
 		// append loglevel param using 5 for debug (default: 4)
 		// See https://github.com/openshift/ovn-kubernetes/blob/master/go-controller/pkg/config/config.go#L736
-		hybridOverlayServiceCmd = hybridOverlayServiceCmd + " --loglevel 5"
+		args.ExtraArgs = []string{"--loglevel", "5"}
+	}
+	hybridOverlayServiceCmd, err := args.Command()
+	if err != nil {
+		return servicescm.Service{}, err
 	}
 	return servicescm.Service{
 		Name:    windows.HybridOverlayServiceName,
@@ -128,18 +306,61 @@ func hybridOverlayConfiguration(vxlanPort string, debug bool) servicescm.Service
 		Dependencies:         []string{windows.KubeletServiceName},
 		Bootstrap:            false,
 		Priority:             2,
-	}
+	}, nil
 }
 
-// kubeProxyConfiguration returns the Service definition for kube-proxy
-func kubeProxyConfiguration(debug bool) servicescm.Service {
+// kubeProxyConfiguration returns the Service definition for kube-proxy, using the HNS network with the given name. The
+// name must match the one the network scripts run before kube-proxy were generated with.
+func kubeProxyConfiguration(hnsNetworkName string, opts KubeProxyOptions, debug bool) servicescm.Service {
 	sanitizedSubnetAnnotation := strings.ReplaceAll(nodeconfig.HybridOverlaySubnet, ".", "\\.")
-	cmd := fmt.Sprintf("%s -log-file=%s %s --windows-service --proxy-mode=kernelspace --feature-gates=WinOverlay=true,WinDSR=true "+
-		"--hostname-override=NODE_NAME --kubeconfig=%s --cluster-cidr=NODE_SUBNET "+
-		"--network-name=%s --source-vip=ENDPOINT_IP --enable-dsr=true", windows.KubeLogRunnerPath, windows.KubeProxyLog,
-		windows.KubeProxyPath, windows.KubeconfigPath, windows.OVNKubeOverlayNetwork)
+	// The options are validated before the configuration is generated, so the network type is known and the node port
+	// addresses are valid
+	networkType, _ := payload.ResolveNetworkType(opts.NetworkType)
+	nodePortAddresses, _ := canonicalCIDRs(opts.NodePortAddresses)
+	featureGates := []featureGate{{name: "WinDSR", enabled: opts.EnableDSR}}
+	preScripts := []servicescm.PowershellPreScript{{Path: windows.CNIConfScriptPath}}
+	sourceVIP := ""
+	if networkType == payload.OverlayNetwork {
+		featureGates = append([]featureGate{{name: "WinOverlay", enabled: true}}, featureGates...)
+		// the preflight script waits for the overlay network to be ready, so that the other scripts do not fail late
+		// on a missing precondition
+		preScripts = append([]servicescm.PowershellPreScript{{Path: windows.PreflightScriptPath}}, preScripts...)
+		preScripts = append(preScripts, servicescm.PowershellPreScript{
+			VariableName: "ENDPOINT_IP",
+			Path:         windows.KubeProxyPrepScriptPath,
+		})
+		sourceVIP = "ENDPOINT_IP"
+	}
+	// The bind addresses are validated IP address and port pairs, so they cannot contain characters needing quoting
+	flags := kubeProxyFlags{
+		windowsService:                 true,
+		proxyMode:                      "kernelspace",
+		featureGates:                   featureGates,
+		hostnameOverride:               "NODE_NAME",
+		kubeconfig:                     windows.KubeconfigPath,
+		clusterCIDR:                    "NODE_SUBNET",
+		networkName:                    hnsNetworkName,
+		sourceVIP:                      sourceVIP,
+		enableDSR:                      opts.EnableDSR,
+		metricsBindAddress:             opts.MetricsBindAddress,
+		healthzBindAddress:             opts.HealthzBindAddress,
+		conntrackMaxPerCore:            opts.Conntrack.MaxPerCore,
+		conntrackMin:                   opts.Conntrack.Min,
+		conntrackTCPEstablishedTimeout: opts.Conntrack.TCPEstablishedTimeout,
+		conntrackTCPCloseWaitTimeout:   opts.Conntrack.TCPCloseWaitTimeout,
+		oomScoreAdj:                    opts.OOMScoreAdj,
+		forwardHealthCheckVIP:          opts.ForwardHealthCheckVIP,
+		rootHNSEndpointName:            opts.RootHNSEndpointName,
+		nodePortAddresses:              nodePortAddresses,
+	}
 	// Set log level
-	cmd = fmt.Sprintf("%s %s", cmd, klogVerbosityArg(debug))
+	verbosityArg := klogVerbosityArg(debug)
+	if opts.Verbosity != nil {
+		verbosityArg = "--v=" + strconv.Itoa(*opts.Verbosity)
+	}
+	// The kube-proxy path and log file are constants, so the command is always valid
+	cmd, _ := payload.BuildLogRunnerCommand(windows.KubeProxyPath, []string{flags.commandLine(), verbosityArg},
+		payload.LogRunnerArgs{LogFile: windows.KubeProxyLog})
 	return servicescm.Service{
 		Name:    windows.KubeProxyServiceName,
 		Command: cmd,
@@ -153,20 +374,17 @@ func kubeProxyConfiguration(debug bool) servicescm.Service {
 				NodeObjectJsonPath: fmt.Sprintf("{.metadata.annotations.%s}", sanitizedSubnetAnnotation),
 			},
 		},
-		PowershellPreScripts: []servicescm.PowershellPreScript{{
-			VariableName: "ENDPOINT_IP",
-			Path:         windows.NetworkConfScriptPath,
-		}},
-		Dependencies: []string{windows.HybridOverlayServiceName},
-		Bootstrap:    false,
-		Priority:     3,
+		PowershellPreScripts: preScripts,
+		Dependencies:         []string{windows.HybridOverlayServiceName},
+		Bootstrap:            false,
+		Priority:             3,
 	}
 }
 
 // csiProxyConfiguration returns the Service definition for csi-proxy
 func csiProxyConfiguration(debug bool) servicescm.Service {
-	serviceCmd := fmt.Sprintf("%s -log_file=%s -logtostderr=false -windows-service", windows.CSIProxyPath,
-		windows.CSIProxyLog)
+	// The log file is a constant, so the command is always valid
+	serviceCmd, _ := payload.CSIProxyArgs{LogFile: windows.CSIProxyLog}.Command()
 	// Set log level
 	serviceCmd = fmt.Sprintf("%s %s", serviceCmd, klogVerbosityArg(debug))
 	return servicescm.Service{
@@ -200,11 +418,10 @@ func getKubeletServiceConfiguration(argsFromIginition map[string]string, debug b
 		preScripts = append(preScripts, hostnameOverridePowershellVar)
 	}
 
-	kubeletServiceCmd := fmt.Sprintf("%s -log-file=%s %s",
-		windows.KubeLogRunnerPath, windows.KubeletLog, windows.KubeletPath)
-
-	for _, arg := range kubeletArgs {
-		kubeletServiceCmd += fmt.Sprintf(" %s", arg)
+	kubeletServiceCmd, err := payload.BuildLogRunnerCommand(windows.KubeletPath, kubeletArgs,
+		payload.LogRunnerArgs{LogFile: windows.KubeletLog})
+	if err != nil {
+		return servicescm.Service{}, err
 	}
 
 	// explicitly set node ip and resolves to the first IPv4 address of the default gateway
@@ -225,35 +442,42 @@ func getKubeletServiceConfiguration(argsFromIginition map[string]string, debug b
 	}, nil
 }
 
-// generateKubeletArgs returns the kubelet args required during initial kubelet start up
+// generateKubeletArgs returns the kubelet args required during initial kubelet start up, sorted by name. An error is
+// returned if an argument from the ignition spec conflicts with the Windows defaults.
 func generateKubeletArgs(argsFromIgnition map[string]string, debug bool) ([]string, error) {
 	certDirectory := "c:\\var\\lib\\kubelet\\pki\\"
 	windowsPriorityClass := "ABOVE_NORMAL_PRIORITY_CLASS"
+	verbosity := standardLogLevel
+	if debug {
+		verbosity = debugLogLevel
+	}
+	kubeletArgs := ignition.NewKubeletArgs()
 	// TODO: Removal of deprecated flags to be done in https://issues.redhat.com/browse/WINC-924
-	kubeletArgs := []string{
-		"--config=" + windows.KubeletConfigPath,
-		"--bootstrap-kubeconfig=" + windows.BootstrapKubeconfigPath,
-		"--kubeconfig=" + windows.KubeconfigPath,
-		"--cert-dir=" + certDirectory,
-		"--windows-service",
-		"--node-labels=" + nodeconfig.WindowsOSLabel,
+	err := kubeletArgs.AddWindowsDefaults(map[string]string{
+		"config":               windows.KubeletConfigPath,
+		"bootstrap-kubeconfig": windows.BootstrapKubeconfigPath,
+		"kubeconfig":           windows.KubeconfigPath,
+		"cert-dir":             certDirectory,
+		"windows-service":      "",
+		"node-labels":          nodeconfig.WindowsOSLabel,
 		// Allows the kubelet process to get more CPU time slices when compared to other processes running on the
 		// Windows host.
 		// See: https://kubernetes.io/docs/concepts/configuration/windows-resource-management/#resource-management-cpu
-		"--windows-priorityclass=" + windowsPriorityClass,
-	}
-
-	kubeletArgs = append(kubeletArgs, klogVerbosityArg(debug))
-	if cloudProvider, ok := argsFromIgnition[ignition.CloudProviderOption]; ok {
-		kubeletArgs = append(kubeletArgs, fmt.Sprintf("--%s=%s", ignition.CloudProviderOption, cloudProvider))
+		"windows-priorityclass": windowsPriorityClass,
+		"v":                     verbosity,
+	})
+	if err != nil {
+		return nil, err
 	}
-	if cloudConfigValue, ok := argsFromIgnition[ignition.CloudConfigOption]; ok {
+	fromIgnition := ignition.FilterArgs(argsFromIgnition, ignition.KubeletArgsOfInterest...)
+	if cloudConfigValue, ok := fromIgnition[ignition.CloudConfigOption]; ok {
 		// cloud config is placed by WMCO in the c:\k directory with the same file name
-		cloudConfigPath := windows.K8sDir + "\\" + filepath.Base(cloudConfigValue)
-		kubeletArgs = append(kubeletArgs, fmt.Sprintf("--%s=%s", ignition.CloudConfigOption, cloudConfigPath))
+		fromIgnition[ignition.CloudConfigOption] = windows.K8sDir + "\\" + filepath.Base(cloudConfigValue)
 	}
-
-	return kubeletArgs, nil
+	if err := kubeletArgs.AddFromIgnition(fromIgnition); err != nil {
+		return nil, err
+	}
+	return kubeletArgs.Args(), nil
 }
 
 // klogVerbosityArg returns an argument to set the verbosity for any service that uses klog to log
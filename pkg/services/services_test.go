@@ -1,10 +1,16 @@
 package services
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
 	config "github.com/openshift/api/config/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
+	"github.com/openshift/windows-machine-config-operator/pkg/windows"
 )
 
 func TestGetHostnameCmd(t *testing.T) {
@@ -36,3 +42,148 @@ func TestGetHostnameCmd(t *testing.T) {
 		})
 	}
 }
+
+func TestContainerdConfiguration(t *testing.T) {
+	svc := containerdConfiguration(false)
+	// the Windows Defender exclusions of every payload executable are restored before containerd is started
+	require.Len(t, svc.PowershellPreScripts, 1)
+	script := svc.PowershellPreScripts[0]
+	assert.Empty(t, script.VariableName)
+	assert.True(t, strings.HasPrefix(script.Path, windows.WinDefenderExclusionScriptRemotePath+" -BinPaths "))
+	for _, exclusionPath := range payload.DefenderExclusionPaths() {
+		assert.Contains(t, script.Path, "'"+exclusionPath+"'")
+	}
+}
+
+func TestKubeProxyConfiguration(t *testing.T) {
+	svc := kubeProxyConfiguration("CustomHybridOverlayNetwork", DefaultKubeProxyOptions(), false)
+	assert.Contains(t, svc.Command, "--network-name=CustomHybridOverlayNetwork ")
+	assert.NotContains(t, svc.Command, windows.OVNKubeOverlayNetwork)
+	assert.NotContains(t, svc.Command, "bind-address")
+	// the network scripts must run before kube-proxy, with the endpoint IP coming from the kube-proxy preparation script
+	var preScriptPaths []string
+	for _, script := range svc.PowershellPreScripts {
+		preScriptPaths = append(preScriptPaths, script.Path)
+		if script.Path == windows.KubeProxyPrepScriptPath {
+			assert.Equal(t, "ENDPOINT_IP", script.VariableName)
+		}
+	}
+	assert.Equal(t, []string{windows.PreflightScriptPath, windows.CNIConfScriptPath, windows.KubeProxyPrepScriptPath},
+		preScriptPaths)
+}
+
+func TestHybridOverlayConfiguration(t *testing.T) {
+	tests := []struct {
+		name        string
+		vxlanPort   string
+		debug       bool
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "default port",
+			expected: "--logfile C:\\var\\log\\hybrid-overlay\\hybrid-overlay.log",
+		},
+		{
+			name:      "overridden port with debug logging",
+			vxlanPort: "9789",
+			debug:     true,
+			expected:  "hybrid-overlay.log --hybrid-overlay-vxlan-port 9789 --loglevel 5",
+		},
+		{
+			name:        "invalid port",
+			vxlanPort:   "70000",
+			expectedErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			svc, err := hybridOverlayConfiguration(test.vxlanPort, test.debug)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, strings.HasSuffix(svc.Command, test.expected), svc.Command)
+		})
+	}
+}
+
+func TestKubeProxyBindAddresses(t *testing.T) {
+	testCases := []struct {
+		name        string
+		opts        KubeProxyOptions
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			name:     "IPv4 addresses",
+			opts:     KubeProxyOptions{MetricsBindAddress: "0.0.0.0:10249", HealthzBindAddress: "0.0.0.0:10256"},
+			expected: []string{"--metrics-bind-address=0.0.0.0:10249", "--healthz-bind-address=0.0.0.0:10256"},
+		},
+		{
+			name:     "IPv6 metrics address only",
+			opts:     KubeProxyOptions{MetricsBindAddress: "[::]:10249"},
+			expected: []string{"--metrics-bind-address=[::]:10249"},
+		},
+		{
+			name:        "missing port",
+			opts:        KubeProxyOptions{MetricsBindAddress: "0.0.0.0"},
+			expectedErr: true,
+		},
+		{
+			name:        "hostname",
+			opts:        KubeProxyOptions{HealthzBindAddress: "localhost:10256"},
+			expectedErr: true,
+		},
+		{
+			name:        "port out of range",
+			opts:        KubeProxyOptions{HealthzBindAddress: "0.0.0.0:70000"},
+			expectedErr: true,
+		},
+		{
+			name:        "PowerShell variable",
+			opts:        KubeProxyOptions{MetricsBindAddress: "$env:IP:10249"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.opts.validate()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			svc := kubeProxyConfiguration(windows.OVNKubeOverlayNetwork, test.opts, false)
+			for _, arg := range test.expected {
+				assert.Contains(t, svc.Command, arg)
+			}
+		})
+	}
+}
+
+func TestGenerateKubeletArgs(t *testing.T) {
+	args, err := generateKubeletArgs(map[string]string{
+		"cloud-provider":  "external",
+		"cloud-config":    "/etc/kubernetes/cloud.conf",
+		"runtime-cgroups": "/system.slice/crio.service",
+		"node-ip":         "10.0.0.5",
+	}, false)
+	require.NoError(t, err)
+	assert.Contains(t, args, "--cloud-provider=external")
+	// the cloud config is copied to the Windows node with the same file name
+	assert.Contains(t, args, "--cloud-config="+windows.K8sDir+"\\cloud.conf")
+	assert.Contains(t, args, "--windows-service")
+	assert.Contains(t, args, "--v="+standardLogLevel)
+	assert.True(t, slices.IsSorted(args))
+	for _, arg := range args {
+		assert.NotContains(t, arg, "cgroups")
+		assert.NotContains(t, arg, "node-ip")
+	}
+
+	debugArgs, err := generateKubeletArgs(nil, true)
+	require.NoError(t, err)
+	assert.Contains(t, debugArgs, "--v="+debugLogLevel)
+	assert.NotContains(t, strings.Join(debugArgs, " "), "cloud")
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"strings"
 	"time"
 
@@ -36,7 +37,7 @@ type connectivity interface {
 	// run executes the given command on the remote system
 	run(cmd string) (string, error)
 	// transfer reads from reader and creates a file in the remote VM directory, creating the remote directory if needed
-	transfer(reader io.Reader, filename, remoteDir string) error
+	transfer(reader io.Reader, filename, remoteDir string, mode fs.FileMode) error
 	// init initialises the connectivity medium
 	init() error
 }
@@ -125,8 +126,10 @@ func (c *sshConnectivity) run(cmd string) (string, error) {
 	return string(out), err
 }
 
-// transfer uses FTP to copy from reader to the remote VM directory, creating the directory if needed
-func (c *sshConnectivity) transfer(reader io.Reader, filename, remoteDir string) error {
+// transfer uses FTP to copy from reader to the remote VM directory, creating the directory if needed. If mode is
+// non-zero, setting the file mode of the remote file to it is attempted. POSIX modes do not apply to Windows, where the
+// file ACLs are inherited from the remote directory, so failing to set the mode does not fail the transfer.
+func (c *sshConnectivity) transfer(reader io.Reader, filename, remoteDir string, mode fs.FileMode) error {
 	if c.sshClient == nil {
 		return fmt.Errorf("transfer cannot be called with nil SSH client")
 	}
@@ -159,5 +162,10 @@ func (c *sshConnectivity) transfer(reader io.Reader, filename, remoteDir string)
 	if err := dstFile.Close(); err != nil {
 		c.log.Error(err, "error closing remote file", "file", remoteFile)
 	}
+	if mode != 0 {
+		if err := ftp.Chmod(remoteFile, mode); err != nil {
+			c.log.Error(err, "error setting mode of remote file", "file", remoteFile, "mode", mode)
+		}
+	}
 	return nil
 }
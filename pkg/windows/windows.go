@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/go-logr/logr"
@@ -23,35 +25,37 @@ import (
 
 const (
 	// remoteDir is the remote temporary directory created on the Windows VM
-	remoteDir = "C:\\Temp"
+	remoteDir = payload.RemoteTempDir
 	// GcpGetHostnameScriptRemotePath is the remote location of the PowerShell script that resolves the hostname
 	// for GCP instances
 	GcpGetHostnameScriptRemotePath = remoteDir + "\\" + payload.GcpGetHostnameScriptName
-	// WinDefenderExclusionScriptRemotePath is the remote location of the PowerShell script that creates an exclusion
-	// for containerd if the Windows Defender Antivirus is active
+	// WinDefenderExclusionScriptRemotePath is the remote location of the PowerShell script that creates exclusions
+	// for the given executables if the Windows Defender Antivirus is active
 	WinDefenderExclusionScriptRemotePath = remoteDir + "\\" + payload.WinDefenderExclusionScriptName
 	// HNSPSModule is the remote location of the hns.psm1 module
 	HNSPSModule = remoteDir + "\\hns.psm1"
 	// K8sDir is the remote kubernetes executable directory
-	K8sDir = "C:\\k"
+	K8sDir = payload.RemoteK8sDir
 	// KubeconfigPath is the remote location of the kubelet's kubeconfig
 	KubeconfigPath = K8sDir + "\\kubeconfig"
 	// logDir is the remote kubernetes log directory
-	logDir = "C:\\var\\log"
+	logDir = payload.RemoteLogDir
 	// KubeletLogDir is the remote kubelet log directory
 	KubeletLogDir = logDir + "\\kubelet"
 	// KubeProxyLogDir is the remote kube-proxy log directory
 	KubeProxyLogDir = logDir + "\\kube-proxy"
 	// HybridOverlayLogDir is the remote hybrid-overlay log directory
 	HybridOverlayLogDir = logDir + "\\hybrid-overlay"
+	// NetworkScriptLogDir is the remote directory the network scripts run before kube-proxy log to
+	NetworkScriptLogDir = logDir + "\\"
 	// wicdLogDir is the remote wicd log directory
 	wicdLogDir = logDir + "\\wicd"
 	// cniDir is the directory for storing CNI binaries
-	cniDir = K8sDir + "\\cni"
+	cniDir = payload.RemoteCNIDir
 	// CniConfDir is the directory for storing CNI configuration
 	CniConfDir = cniDir + "\\config"
 	// ContainerdDir is the directory for storing Containerd binary
-	ContainerdDir = K8sDir + "\\containerd"
+	ContainerdDir = payload.RemoteContainerdDir
 	// ContainerdPath is the location of the containerd exe
 	ContainerdPath = ContainerdDir + "\\containerd.exe"
 	// ContainerdConfPath is the location of containerd config file
@@ -65,11 +69,15 @@ const (
 	// WicdServiceName is the Windows service name for WICD
 	WicdServiceName = "windows-instance-config-daemon"
 	// wicdPath is the path to the WICD executable
-	wicdPath = K8sDir + "\\windows-instance-config-daemon.exe"
-	// windowsExporterPath is the location of the windows_exporter.exe
-	windowsExporterPath = K8sDir + "\\windows_exporter.exe"
-	// NetworkConfScriptPath is the location of the network configuration script
-	NetworkConfScriptPath = remoteDir + "\\network-conf.ps1"
+	wicdPath = payload.RemoteWICDPath
+	// CNIConfScriptPath is the location of the script which renders the CNI configuration
+	CNIConfScriptPath = remoteDir + "\\cni-conf.ps1"
+	// KubeProxyPrepScriptPath is the location of the script which creates the kube-proxy source VIP endpoint
+	KubeProxyPrepScriptPath = remoteDir + "\\kube-proxy-prep.ps1"
+	// PreflightScriptPath is the location of the script which waits for the HNS network to be ready
+	PreflightScriptPath = remoteDir + "\\network-preflight.ps1"
+	// HNSCleanupScriptPath is the location of the script which removes the HNS endpoints created for the node
+	HNSCleanupScriptPath = remoteDir + "\\hns-cleanup.ps1"
 	// AzureCloudNodeManagerPath is the location of the azure-cloud-node-manager.exe
 	AzureCloudNodeManagerPath = K8sDir + "\\" + payload.AzureCloudNodeManager
 	// podManifestDirectory is the directory needed by kubelet for the static pods
@@ -111,12 +119,11 @@ const (
 	WindowsExporterServiceName = "windows_exporter"
 	// AzureCloudNodeManagerServiceName is the name of the azure cloud node manager service
 	AzureCloudNodeManagerServiceName = "cloud-node-manager"
-	// WindowsExporterServiceCommand specifies metrics for the windows_exporter service to collect
-	// and expose metrics at endpoint with default port :9182 and default URL path /metrics
-	WindowsExporterServiceCommand = windowsExporterPath + " --collectors.enabled " +
-		"cpu,cs,logical_disk,net,os,service,system,textfile,container,memory,cpu_info"
 	// serviceQueryCmd is the Windows command used to query a service
 	serviceQueryCmd = "sc.exe qc "
+	// defenderRunningCmd is the PowerShell command printing true if the process of the Windows Defender Antivirus is
+	// running, and false otherwise
+	defenderRunningCmd = "if(Get-Process -Name MsMpEng -ErrorAction SilentlyContinue) { 'true' } else { 'false' }"
 	// serviceNotFound is part of the error output returned when a service does not exist. 1060 is an error code
 	// representing ERROR_SERVICE_DOES_NOT_EXIST
 	// referenced: https://docs.microsoft.com/en-us/windows/win32/debug/system-error-codes--1000-1299-
@@ -166,45 +173,54 @@ var (
 	}
 )
 
+// payloadCache caches the FileInfo of payload files, which are shared by every instance being configured
+var payloadCache = payload.NewFileInfoCache()
+
 // createPayload returns the map of files to transfer with generated file info
-func createPayload(platform *config.PlatformType) (map[*payload.FileInfo]string, error) {
-	srcDestPairs := getFilesToTransfer(platform)
-	files := make(map[*payload.FileInfo]string)
+func createPayload(platform *config.PlatformType) (map[*payload.FileInfo]payload.FileDestination, error) {
+	srcDestPairs, err := getFilesToTransfer(platform)
+	if err != nil {
+		return nil, err
+	}
+	srcPaths := make([]string, 0, len(srcDestPairs))
+	for src := range srcDestPairs {
+		srcPaths = append(srcPaths, src)
+	}
+	fileInfos, err := payloadCache.FileInfos(srcPaths)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[*payload.FileInfo]payload.FileDestination)
 	for src, dest := range srcDestPairs {
-		f, err := payload.NewFileInfo(src)
-		if err != nil {
-			return nil, fmt.Errorf("could not create FileInfo object for file %s: %w", src, err)
-		}
-		files[f] = dest
+		files[fileInfos[src]] = dest
 	}
 	return files, nil
 }
 
-// getFilesToTransfer returns the properly populated filesToTransfer map. Note this does not include the WICD binary.
-func getFilesToTransfer(platform *config.PlatformType) map[string]string {
-	srcDestPairs := map[string]string{
-		payload.GcpGetValidHostnameScriptPath:  remoteDir,
-		payload.WinDefenderExclusionScriptPath: remoteDir,
-		payload.HybridOverlayPath:              K8sDir,
-		payload.HNSPSModule:                    remoteDir,
-		payload.WindowsExporterPath:            K8sDir,
-		payload.WinBridgeCNIPlugin:             cniDir,
-		payload.HostLocalCNIPlugin:             cniDir,
-		payload.WinOverlayCNIPlugin:            cniDir,
-		payload.KubeProxyPath:                  K8sDir,
-		payload.KubeletPath:                    K8sDir,
-		payload.KubeLogRunnerPath:              K8sDir,
-		payload.CSIProxyPath:                   K8sDir,
-		payload.ContainerdPath:                 ContainerdDir,
-		payload.HcsshimPath:                    ContainerdDir,
-		payload.ContainerdConfPath:             ContainerdDir,
-		payload.NetworkConfigurationScript:     remoteDir,
-	}
-
-	if platform != nil && *platform == config.AzurePlatformType {
-		srcDestPairs[payload.AzureCloudNodeManagerPath] = K8sDir
-	}
-	return srcDestPairs
+// getFilesToTransfer returns the properly populated filesToTransfer map, containing only the files required on the
+// given platform. Note this does not include the WICD binary.
+func getFilesToTransfer(platform *config.PlatformType) (map[string]payload.FileDestination, error) {
+	destinations := payload.Destinations()
+	platformType := config.NonePlatformType
+	if platform != nil {
+		platformType = *platform
+	}
+	requiredFiles, err := payload.RequiredFiles(platformType)
+	if err != nil {
+		return nil, err
+	}
+	srcDestPairs := make(map[string]payload.FileDestination)
+	for _, src := range requiredFiles {
+		if src == payload.WICDPath {
+			continue
+		}
+		dest, ok := destinations[src]
+		if !ok {
+			return nil, fmt.Errorf("no destination defined for payload file %s", src)
+		}
+		srcDestPairs[src] = dest
+	}
+	return srcDestPairs, nil
 }
 
 // GetK8sDir returns the location of the kubernetes executable directory
@@ -240,11 +256,18 @@ type Windows interface {
 	ConfigureWICD(string, string) error
 	// RemoveFilesAndNetworks removes all files and networks created by WMCO
 	RemoveFilesAndNetworks() error
+	// PayloadFiles returns the payload files transferred to the Windows VM, with their paths on the VM
+	PayloadFiles() []*payload.FileInfo
+	// RemoveFiles removes the files at the given paths from the Windows VM. Files which do not exist are ignored, and
+	// files outside of the directories managed by WMCO are never removed.
+	RemoveFiles([]string) error
 	// RunWICDCleanup ensures the WICD service is stopped and runs the cleanup command that ensures all WICD-managed
 	// services are also stopped
 	RunWICDCleanup(string, string) error
 	// RestoreAWSRoutes restores the default routes on AWS VMs. This function should not be called on non-AWS VMs
 	RestoreAWSRoutes() error
+	// IsDefenderRunning returns true if the Windows Defender Antivirus is running on the Windows VM
+	IsDefenderRunning() (bool, error)
 }
 
 // windows implements the Windows interface
@@ -262,7 +285,7 @@ type windows struct {
 	// defaultShellPowerShell indicates if the default SSH shell is PowerShell
 	defaultShellPowerShell bool
 	// filesToTransfer is the map of files needed for the windows VM
-	filesToTransfer map[*payload.FileInfo]string
+	filesToTransfer map[*payload.FileInfo]payload.FileDestination
 }
 
 // New returns a new Windows instance constructed from the given WindowsVM
@@ -310,6 +333,13 @@ func (vm *windows) GetIPv4Address() string {
 }
 
 func (vm *windows) EnsureFileContent(contents []byte, filename string, remoteDir string) error {
+	return vm.ensureFileContent(contents, filename, payload.FileDestination{Dir: remoteDir})
+}
+
+// ensureFileContent ensures the given filename and content exists at the given destination on the Windows VM, copying
+// it with the destination's file mode if it is not present or has incorrect contents
+func (vm *windows) ensureFileContent(contents []byte, filename string, dest payload.FileDestination) error {
+	remoteDir := dest.Dir
 	// build remote path
 	remotePath := remoteDir + "\\" + filepath.Base(filename)
 	// calc checksum
@@ -324,13 +354,25 @@ func (vm *windows) EnsureFileContent(contents []byte, filename string, remoteDir
 		return nil
 	}
 	vm.log.V(1).Info("copy", "file content", filename, "remote dir", remoteDir)
-	if err := vm.interact.transfer(bytes.NewReader(contents), filename, remoteDir); err != nil {
+	if err := vm.interact.transfer(bytes.NewReader(contents), filename, remoteDir, dest.Mode); err != nil {
 		return fmt.Errorf("unable to copy %s content to remote dir %s: %w", filename, remoteDir, err)
 	}
 	return nil
 }
 
 func (vm *windows) EnsureFile(file *payload.FileInfo, remoteDir string) error {
+	return vm.ensureFile(file, payload.FileDestination{Dir: remoteDir})
+}
+
+// ensureFile ensures the given file exists at the given destination on the Windows VM, copying it with the
+// destination's file mode if it is not present or has incorrect contents. Payload files embedded in the operator binary
+// are copied from their embedded contents.
+func (vm *windows) ensureFile(file *payload.FileInfo, dest payload.FileDestination) error {
+	// Prefer the contents embedded in the operator binary, which are not affected by a broken payload mount
+	if contents, ok := payload.EmbeddedContents(file.Path); ok {
+		return vm.ensureFileContent(contents, filepath.Base(file.Path), dest)
+	}
+	remoteDir := dest.Dir
 	// Only copy the file to the Windows VM if it does not already exist wth the desired content
 	remotePath := remoteDir + "\\" + filepath.Base(file.Path)
 	fileExists, err := vm.FileExists(remotePath, file.SHA256)
@@ -351,7 +393,7 @@ func (vm *windows) EnsureFile(file *payload.FileInfo, remoteDir string) error {
 		}
 	}()
 	vm.log.V(1).Info("copy", "local file", file.Path, "remote dir", remoteDir)
-	if err := vm.interact.transfer(f, filepath.Base(file.Path), remoteDir); err != nil {
+	if err := vm.interact.transfer(f, filepath.Base(file.Path), remoteDir, dest.Mode); err != nil {
 		return fmt.Errorf("unable to transfer %s to remote dir %s: %w", file.Path, remoteDir, err)
 	}
 	return nil
@@ -432,8 +474,10 @@ func (vm *windows) RunWICDCleanup(watchNamespace, wicdKubeconfig string) error {
 	if err := vm.ensureWICDFilesExist(wicdKubeconfig); err != nil {
 		return err
 	}
-	wicdCleanupCmd := fmt.Sprintf("%s cleanup --kubeconfig %s --namespace %s", wicdPath, wicdKubeconfigPath,
-		watchNamespace)
+	wicdCleanupCmd, err := payload.WICDCleanupCmd{Namespace: watchNamespace, KubeconfigPath: wicdKubeconfigPath}.Command()
+	if err != nil {
+		return err
+	}
 	if out, err := vm.Run(wicdCleanupCmd, true); err != nil {
 		vm.log.Info("failed to cleanup node", "command", wicdCleanupCmd, "output", out)
 		return err
@@ -442,6 +486,11 @@ func (vm *windows) RunWICDCleanup(watchNamespace, wicdKubeconfig string) error {
 }
 
 func (vm *windows) RemoveFilesAndNetworks() error {
+	// removing the endpoints is best effort, as the network removal below is retried and takes any remaining endpoint
+	// with it, so failing to run the cleanup script must not prevent the instance from being deconfigured
+	if err := vm.removeHNSEndpoints(); err != nil {
+		vm.log.Error(err, "unable to remove HNS endpoints, continuing with the removal of the HNS networks")
+	}
 	if err := vm.ensureHNSNetworksAreRemoved(); err != nil {
 		return fmt.Errorf("unable to ensure HNS networks are removed: %w", err)
 	}
@@ -451,6 +500,32 @@ func (vm *windows) RemoveFilesAndNetworks() error {
 	return nil
 }
 
+func (vm *windows) PayloadFiles() []*payload.FileInfo {
+	files := make([]*payload.FileInfo, 0, len(vm.filesToTransfer))
+	for src, dest := range vm.filesToTransfer {
+		files = append(files, &payload.FileInfo{
+			Path:   dest.Dir + "\\" + filepath.Base(src.Path),
+			SHA256: src.SHA256,
+			Size:   src.Size,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+func (vm *windows) RemoveFiles(paths []string) error {
+	for _, path := range paths {
+		if !isManagedPath(path) {
+			vm.log.Info("refusing to remove file outside of managed directories", "file", path)
+			continue
+		}
+		if out, err := vm.Run(rmFileCmd(path), true); err != nil {
+			return fmt.Errorf("unable to remove file %s, out: %s, err: %w", path, out, err)
+		}
+	}
+	return nil
+}
+
 func (vm *windows) Bootstrap(desiredVer, watchNamespace, wicdKubeconfigContents string) error {
 	vm.log.Info("configuring")
 
@@ -468,9 +543,17 @@ func (vm *windows) Bootstrap(desiredVer, watchNamespace, wicdKubeconfigContents
 	if err := vm.transferFiles(); err != nil {
 		return fmt.Errorf("error transferring files to Windows VM: %w", err)
 	}
+	// the exclusions are also ensured by WICD before containerd is started, so failing to create them here does not
+	// prevent the instance from being configured
+	if err := vm.ensureDefenderExclusions(); err != nil {
+		vm.log.Error(err, "unable to ensure Windows Defender exclusions, leaving them to be created by WICD")
+	}
 
-	wicdBootstrapCmd := fmt.Sprintf("%s bootstrap --desired-version %s --kubeconfig %s --namespace %s",
-		wicdPath, desiredVer, wicdKubeconfigPath, watchNamespace)
+	wicdBootstrapCmd, err := payload.WICDBootstrapCmd{Namespace: watchNamespace, KubeconfigPath: wicdKubeconfigPath,
+		DesiredVersion: desiredVer}.Command()
+	if err != nil {
+		return err
+	}
 	if out, err := vm.Run(wicdBootstrapCmd, true); err != nil {
 		vm.log.Info("failed to bootstrap node", "command", wicdBootstrapCmd, "output", out)
 		return err
@@ -478,15 +561,51 @@ func (vm *windows) Bootstrap(desiredVer, watchNamespace, wicdKubeconfigContents
 	return nil
 }
 
+// IsDefenderRunning returns true if the Windows Defender Antivirus is running on the Windows VM
+func (vm *windows) IsDefenderRunning() (bool, error) {
+	out, err := vm.Run(defenderRunningCmd, true)
+	if err != nil {
+		return false, fmt.Errorf("error checking if Windows Defender is running: %w", err)
+	}
+	running, err := strconv.ParseBool(strings.TrimSpace(out))
+	if err != nil {
+		return false, fmt.Errorf("unexpected output checking if Windows Defender is running: %s", out)
+	}
+	return running, nil
+}
+
+// ensureDefenderExclusions excludes every payload executable from Windows Defender scans, which otherwise slow down
+// pod starts. Instances without Windows Defender running are left as is. The same exclusions are ensured by the
+// containerd pre-script every time WICD reconfigures the services.
+func (vm *windows) ensureDefenderExclusions() error {
+	running, err := vm.IsDefenderRunning()
+	if err != nil {
+		return err
+	}
+	if !running {
+		vm.log.V(1).Info("Windows Defender is not running, skipping exclusions")
+		return nil
+	}
+	cmd := WinDefenderExclusionScriptRemotePath + " " + payload.DefenderExclusionScriptArgs()
+	if _, err := vm.Run(cmd, true); err != nil {
+		return fmt.Errorf("error creating Windows Defender exclusions: %w", err)
+	}
+	return nil
+}
+
 // ConfigureWICD starts the Windows Instance Config Daemon service
 func (vm *windows) ConfigureWICD(watchNamespace, wicdKubeconfigContents string) error {
 	if err := vm.ensureWICDFilesExist(wicdKubeconfigContents); err != nil {
 		return err
 	}
-	wicdServiceArgs := fmt.Sprintf("controller --windows-service --log-dir %s --kubeconfig %s --namespace %s",
-		wicdLogDir, wicdKubeconfigPath, watchNamespace)
+	controllerCmd := payload.WICDControllerCmd{Namespace: watchNamespace, KubeconfigPath: wicdKubeconfigPath,
+		LogDir: wicdLogDir}
 	if cluster.IsProxyEnabled() {
-		wicdServiceArgs = fmt.Sprintf("%s --ca-bundle %s", wicdServiceArgs, TrustedCABundlePath)
+		controllerCmd.CABundlePath = TrustedCABundlePath
+	}
+	wicdServiceArgs, err := controllerCmd.Args()
+	if err != nil {
+		return err
 	}
 	// if WICD crashes, attempt to restart WICD after 10, 30, and 60 seconds, and then every 2 minutes after that.
 	// reset this counter 5 min after a period with no crashes
@@ -558,12 +677,13 @@ func (vm *windows) ensureWICDFilesExist(wicdKubeconfig string) error {
 	if _, err := vm.Run(mkdirCmd(K8sDir), false); err != nil {
 		return fmt.Errorf("unable to create remote directory %s: %w", K8sDir, err)
 	}
-	wicdFileInfo, err := payload.NewFileInfo(payload.WICDPath)
+	wicdFileInfo, err := payloadCache.Get(payload.WICDPath, false)
 	if err != nil {
 		return fmt.Errorf("could not create FileInfo object for file %s: %w", payload.WICDPath, err)
 	}
-	if err := vm.EnsureFile(wicdFileInfo, K8sDir); err != nil {
-		return fmt.Errorf("error copying %s to %s: %w", wicdFileInfo.Path, K8sDir, err)
+	wicdDest := payload.Destinations()[payload.WICDPath]
+	if err := vm.ensureFile(wicdFileInfo, wicdDest); err != nil {
+		return fmt.Errorf("error copying %s to %s: %w", wicdFileInfo.Path, wicdDest.Dir, err)
 	}
 	return vm.ensureWICDKubeconfig(wicdKubeconfig)
 }
@@ -665,8 +785,8 @@ func (vm *windows) removeDirectories() error {
 func (vm *windows) transferFiles() error {
 	vm.log.Info("transferring files")
 	for src, dest := range vm.filesToTransfer {
-		if err := vm.EnsureFile(src, dest); err != nil {
-			return fmt.Errorf("error copying %s to %s: %w", src.Path, dest, err)
+		if err := vm.ensureFile(src, dest); err != nil {
+			return fmt.Errorf("error copying %s to %s: %w", src.Path, dest.Dir, err)
 		}
 	}
 	return nil
@@ -909,6 +1029,34 @@ func (vm *windows) newFileInfo(path string) (*payload.FileInfo, error) {
 	return &payload.FileInfo{Path: path, SHA256: sha}, nil
 }
 
+// removeHNSEndpoints runs the HNS cleanup script, removing the stale HNS endpoints which would otherwise interfere with
+// configuring the instance again. The script and the HNS module it imports are copied first, as the instance may have
+// been configured by an operator version which did not transfer them, or had them removed.
+func (vm *windows) removeHNSEndpoints() error {
+	for _, path := range []string{payload.HNSPSModule, payload.HNSCleanupScript} {
+		if err := vm.ensurePayloadFile(path); err != nil {
+			return err
+		}
+	}
+	vm.log.Info("removing HNS endpoints")
+	out, err := vm.Run(HNSCleanupScriptPath, true)
+	if err != nil {
+		return fmt.Errorf("error running %s, output: %s: %w", HNSCleanupScriptPath, out, err)
+	}
+	vm.log.V(1).Info("removed HNS endpoints", "output", out)
+	return nil
+}
+
+// ensurePayloadFile ensures the payload file at the given path is present on the instance with the expected contents
+func (vm *windows) ensurePayloadFile(path string) error {
+	for file, dest := range vm.filesToTransfer {
+		if file.Path == path {
+			return vm.ensureFile(file, dest)
+		}
+	}
+	return fmt.Errorf("%s is not in the payload", path)
+}
+
 // ensureHNSNetworksAreRemoved ensures the HNS networks created by the hybrid-overlay configuration process are removed
 // by repeatedly checking and retrying the removal of each network.
 func (vm *windows) ensureHNSNetworksAreRemoved() error {
@@ -1023,6 +1171,32 @@ func rmDirCmd(dirName string) string {
 	return fmt.Sprintf("if(Test-Path %s) {Remove-Item -Recurse -Force %s}", dirName, dirName)
 }
 
+// rmFileCmd returns the PowerShell command to remove a file if it exists
+func rmFileCmd(path string) string {
+	return fmt.Sprintf("if(Test-Path %s) {Remove-Item -Force %s}", path, path)
+}
+
+// isManagedPath returns true if the given Windows path is a file directly within one of the directories created by WMCO
+func isManagedPath(path string) bool {
+	dir, fileName := SplitPath(path)
+	if fileName == "" {
+		return false
+	}
+	// only allow plain file names, the path is interpolated into a PowerShell command
+	for _, c := range fileName {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._-", c)) {
+			return false
+		}
+	}
+	dir = strings.TrimSuffix(dir, "\\")
+	for _, managedDir := range RequiredDirectories {
+		if strings.EqualFold(dir, managedDir) {
+			return true
+		}
+	}
+	return false
+}
+
 // rmK8sFilesCmd() returns the PowerShell command to remove the k8sDir files excluding WICD files
 func rmK8sFilesCmd() string {
 	return fmt.Sprintf("if(Test-Path %s) {Get-ChildItem %s -Recurse -Exclude %s,%s | Remove-Item -Force -Recurse}",
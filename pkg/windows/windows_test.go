@@ -5,6 +5,7 @@ import (
 
 	config "github.com/openshift/api/config/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/openshift/windows-machine-config-operator/pkg/nodeconfig/payload"
 )
@@ -22,6 +23,10 @@ func TestGetFilesToTransfer(t *testing.T) {
 			name:     "test Azure",
 			platform: func() *config.PlatformType { t := config.AzurePlatformType; return &t }(),
 		},
+		{
+			name:     "test GCP",
+			platform: func() *config.PlatformType { t := config.GCPPlatformType; return &t }(),
+		},
 		{
 			name:     "test Nil",
 			platform: nil,
@@ -30,14 +35,17 @@ func TestGetFilesToTransfer(t *testing.T) {
 
 	for _, test := range testCases {
 		t.Run(test.name, func(t *testing.T) {
-			files := getFilesToTransfer(test.platform)
+			files, err := getFilesToTransfer(test.platform)
+			require.NoError(t, err)
 			if test.platform != nil && *test.platform == config.AzurePlatformType {
 				file := files[payload.AzureCloudNodeManagerPath]
-				assert.Equal(t, K8sDir, file)
+				assert.Equal(t, K8sDir, file.Dir)
 			} else {
 				_, exists := files[payload.AzureCloudNodeManagerPath]
 				assert.False(t, exists)
 			}
+			_, exists := files[payload.WICDPath]
+			assert.False(t, exists)
 		})
 	}
 }
@@ -89,3 +97,57 @@ func TestSplitPath(t *testing.T) {
 		})
 	}
 }
+
+func TestIsManagedPath(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		expected bool
+	}{
+		{
+			name:     "file in managed directory",
+			path:     K8sDir + "\\kubelet.exe",
+			expected: true,
+		},
+		{
+			name:     "file in managed subdirectory",
+			path:     cniDir + "\\win-bridge.exe",
+			expected: true,
+		},
+		{
+			name:     "directory casing differs",
+			path:     "c:\\K\\kubelet.exe",
+			expected: true,
+		},
+		{
+			name:     "file outside managed directories",
+			path:     "C:\\Windows\\System32\\kernel32.dll",
+			expected: false,
+		},
+		{
+			name:     "parent directory traversal",
+			path:     K8sDir + "\\..\\Windows\\explorer.exe",
+			expected: false,
+		},
+		{
+			name:     "managed directory itself",
+			path:     K8sDir + "\\",
+			expected: false,
+		},
+		{
+			name:     "command injection",
+			path:     K8sDir + "\\kubelet.exe;Restart-Computer",
+			expected: false,
+		},
+		{
+			name:     "wildcard",
+			path:     K8sDir + "\\*",
+			expected: false,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, isManagedPath(test.path))
+		})
+	}
+}